@@ -0,0 +1,140 @@
+use std::sync::Arc;
+
+use parking_lot::Mutex;
+use tokio::sync::SemaphorePermit;
+
+use crate::rate_limiter::ConcurrencyLimiter;
+
+/// 自适应时并发数的上限
+const MAX_CONCURRENCY: usize = 8;
+/// 关闭自适应时固定使用的并发数，与原来一张一张下载的行为一致
+const FIXED_CONCURRENCY: usize = 1;
+/// 传输速度低于基准速度的这个倍数分之一时，认为网络已经拥塞，不再增加并发
+const CONGESTED_SPEED_FACTOR: f64 = 2.0;
+/// 比基准慢的速度向基准靠拢的权重，基准因此会慢慢跟上网络变慢后的实际速度
+const BASE_SPEED_DECAY: f64 = 0.05;
+/// 统计失败率的窗口大小
+const FAILURE_WINDOW: u32 = 20;
+/// 窗口内的失败率超过这个值时减少并发
+const MAX_FAILURE_RATE: f64 = 0.2;
+
+/// 一次请求的结果
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub enum RequestOutcome {
+    /// 成功，附带这次请求传输响应体的速度(字节/秒)
+    Succeeded { bytes_per_sec: f64 },
+    /// 被限流(429)或风控(403)，需要立即退避
+    Throttled,
+    /// 其他失败
+    Failed,
+}
+
+struct ControllerState {
+    enabled: bool,
+    /// 网络没有拥塞时的传输速度，更快的速度直接作为新的基准，更慢的速度按`BASE_SPEED_DECAY`慢慢拉低基准，
+    /// 这样偶尔一次特别快的请求不会让之后的请求一直被当成拥塞
+    base_bytes_per_sec: Option<f64>,
+    /// 上次调整并发数后成功的请求数，凑够一轮(等于当前并发数)才增加并发
+    successes_since_change: usize,
+    /// 当前窗口内的请求数和失败数
+    window_requests: u32,
+    window_failures: u32,
+}
+
+/// 按最近的成功率和传输速度动态调整并发数，思路与TCP拥塞控制相同
+///
+/// - 每成功一轮且传输速度没有明显变慢时，并发数加1
+/// - 被限流或风控时，并发数减半
+/// - 最近的失败率过高时，并发数减1
+///
+/// 关闭后固定为`FIXED_CONCURRENCY`。克隆只是增加引用计数，所有副本共享同一个状态
+#[derive(Clone)]
+pub struct AdaptiveConcurrency {
+    limiter: ConcurrencyLimiter,
+    state: Arc<Mutex<ControllerState>>,
+}
+
+impl AdaptiveConcurrency {
+    pub fn new(enabled: bool) -> Self {
+        Self {
+            limiter: ConcurrencyLimiter::new(FIXED_CONCURRENCY),
+            state: Arc::new(Mutex::new(ControllerState {
+                enabled,
+                base_bytes_per_sec: None,
+                successes_since_change: 0,
+                window_requests: 0,
+                window_failures: 0,
+            })),
+        }
+    }
+
+    /// 开关自适应，关闭时恢复为固定并发数，重新开启时从固定并发数开始慢慢增加
+    pub fn set_enabled(&self, enabled: bool) {
+        let mut state = self.state.lock();
+        if state.enabled == enabled {
+            return;
+        }
+        state.enabled = enabled;
+        state.successes_since_change = 0;
+        state.window_requests = 0;
+        state.window_failures = 0;
+        self.limiter.set_limit(FIXED_CONCURRENCY);
+    }
+
+    /// 当前的并发数
+    pub fn limit(&self) -> usize {
+        self.limiter.limit()
+    }
+
+    /// 等待直到有空闲的并发名额，名额在返回值drop时归还
+    pub async fn acquire(&self) -> SemaphorePermit<'_> {
+        self.limiter.acquire().await
+    }
+
+    /// 报告一次请求的结果，据此调整并发数
+    pub fn report(&self, outcome: RequestOutcome) {
+        let mut state = self.state.lock();
+        if !state.enabled {
+            return;
+        }
+        let limit = self.limiter.limit();
+
+        state.window_requests += 1;
+        if !matches!(outcome, RequestOutcome::Succeeded { .. }) {
+            state.window_failures += 1;
+        }
+        let failure_rate = f64::from(state.window_failures) / f64::from(state.window_requests);
+        if state.window_requests >= FAILURE_WINDOW {
+            state.window_requests = 0;
+            state.window_failures = 0;
+        }
+
+        match outcome {
+            RequestOutcome::Throttled => {
+                state.successes_since_change = 0;
+                self.limiter.set_limit(limit / 2);
+            }
+            RequestOutcome::Failed => {
+                if failure_rate > MAX_FAILURE_RATE && limit > 1 {
+                    state.successes_since_change = 0;
+                    self.limiter.set_limit(limit - 1);
+                }
+            }
+            RequestOutcome::Succeeded { bytes_per_sec } => {
+                let base_bytes_per_sec = match state.base_bytes_per_sec {
+                    Some(base) if base > bytes_per_sec => {
+                        base + (bytes_per_sec - base) * BASE_SPEED_DECAY
+                    }
+                    _ => bytes_per_sec,
+                };
+                state.base_bytes_per_sec = Some(base_bytes_per_sec);
+                let congested = bytes_per_sec * CONGESTED_SPEED_FACTOR < base_bytes_per_sec;
+                state.successes_since_change += 1;
+                if state.successes_since_change >= limit && !congested && limit < MAX_CONCURRENCY {
+                    state.successes_since_change = 0;
+                    self.limiter.set_limit(limit + 1);
+                }
+            }
+        }
+    }
+}
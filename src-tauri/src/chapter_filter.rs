@@ -0,0 +1,209 @@
+use std::sync::LazyLock;
+
+use anyhow::Context;
+use regex::{Regex, RegexBuilder};
+use serde::{Deserialize, Serialize};
+use specta::Type;
+
+use crate::{
+    types::{parse_date, ChapterInfo, ChapterLanguage, Comic},
+    zh_convert,
+};
+
+/// 章节标题中的数字，例如`第12.5话`中的`12.5`
+static NUMBER_RE: LazyLock<Regex> = LazyLock::new(|| Regex::new(r"\d+(?:\.\d+)?").unwrap());
+
+/// 章节过滤规则
+///
+/// - 以`/`开头和结尾的规则是正则，例如`/^第\d+话$/`
+/// - 其他规则是关键词，只要包含就算匹配
+///
+/// 匹配时忽略大小写和简繁差异，组名(单话、单行本、番外篇)和章节标题任意一个匹配就算匹配
+enum Pattern {
+    Keyword(String),
+    Regex(Regex),
+}
+
+impl Pattern {
+    fn parse(pattern: &str) -> anyhow::Result<Option<Pattern>> {
+        let pattern = pattern.trim();
+        if pattern.is_empty() {
+            return Ok(None);
+        }
+        // 正则不能转小写，否则`\D`之类的转义会变成`\d`，大小写交给case_insensitive处理
+        let regex_body = pattern
+            .strip_prefix('/')
+            .and_then(|rest| rest.strip_suffix('/'))
+            .filter(|body| !body.is_empty());
+        let pattern = match regex_body {
+            Some(body) => {
+                let regex = RegexBuilder::new(&zh_convert::to_simplified(body))
+                    .case_insensitive(true)
+                    .build()
+                    .context(format!("`{pattern}`不是合法的正则表达式"))?;
+                Pattern::Regex(regex)
+            }
+            None => Pattern::Keyword(normalize(pattern)),
+        };
+        Ok(Some(pattern))
+    }
+
+    fn is_match(&self, text: &str) -> bool {
+        match self {
+            Pattern::Keyword(keyword) => text.contains(keyword.as_str()),
+            Pattern::Regex(regex) => regex.is_match(text),
+        }
+    }
+}
+
+/// 保留匹配`include`中任意一条规则且不匹配`exclude`中任何一条规则的章节，`include`为空时视为全部匹配
+///
+/// `languages`不为空时，只保留语言在其中的章节，没有标注语言的章节需要`languages`包含`Unknown`才会保留
+///
+/// `updated_after`和`updated_before`限定章节的更新日期范围(包括这两天)，为None时不限制这一端，
+/// 不知道更新日期的章节视为很久以前更新的。
+/// 章节的更新日期大多是从标题或漫画的更新时间推测出来的，不一定准确
+pub fn filter_chapters(
+    chapters: Vec<ChapterInfo>,
+    include: &[String],
+    exclude: &[String],
+    languages: &[ChapterLanguage],
+    updated_after: Option<&str>,
+    updated_before: Option<&str>,
+) -> anyhow::Result<Vec<ChapterInfo>> {
+    let include = parse_patterns(include).context("解析包含规则失败")?;
+    let exclude = parse_patterns(exclude).context("解析排除规则失败")?;
+    let parse_bound = |date: Option<&str>| match date {
+        Some(date) => parse_date(date)
+            .map(Some)
+            .context(format!("`{date}`不是合法的日期")),
+        None => Ok(None),
+    };
+    let updated_after = parse_bound(updated_after)?;
+    let updated_before = parse_bound(updated_before)?;
+
+    let chapters = chapters
+        .into_iter()
+        .filter(|chapter_info| languages.is_empty() || languages.contains(&chapter_info.language))
+        // 日期都是YYYY-MM-DD格式，可以直接按字符串比较
+        .filter(|chapter_info| match &updated_after {
+            Some(updated_after) => chapter_info
+                .updated_at
+                .as_ref()
+                .is_some_and(|updated_at| updated_at >= updated_after),
+            None => true,
+        })
+        .filter(
+            |chapter_info| match (&updated_before, &chapter_info.updated_at) {
+                (Some(updated_before), Some(updated_at)) => updated_at <= updated_before,
+                _ => true,
+            },
+        )
+        .filter(|chapter_info| {
+            let texts = [
+                normalize(&chapter_info.group_name),
+                normalize(&chapter_info.chapter_title),
+            ];
+            let matches = |pattern: &Pattern| texts.iter().any(|text| pattern.is_match(text));
+            (include.is_empty() || include.iter().any(matches)) && !exclude.iter().any(matches)
+        })
+        .collect();
+    Ok(chapters)
+}
+
+/// 一批章节排队下载的顺序
+#[derive(Default, Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize, Type)]
+pub enum DownloadOrder {
+    /// 按章节序号从旧到新
+    #[default]
+    OldestFirst,
+    /// 按章节序号从新到旧，追连载时可以先看到最新的
+    NewestFirst,
+}
+
+/// 按`order`排列`chapters`，章节组之间保持原来的先后顺序，只在组内按序号排列
+///
+/// 不同章节组的序号互不相关(例如单话的第10话和单行本的第10卷)，所以不跨组比较
+pub fn sort_for_download(chapters: Vec<ChapterInfo>, order: DownloadOrder) -> Vec<ChapterInfo> {
+    let mut group_names = Vec::new();
+    for chapter_info in &chapters {
+        if !group_names.contains(&chapter_info.group_name) {
+            group_names.push(chapter_info.group_name.clone());
+        }
+    }
+    let mut chapters = chapters;
+    chapters.sort_by(|a, b| {
+        let group_index = |chapter_info: &ChapterInfo| {
+            group_names
+                .iter()
+                .position(|group_name| group_name == &chapter_info.group_name)
+        };
+        let chapter_order = match order {
+            DownloadOrder::OldestFirst => a.order.total_cmp(&b.order),
+            DownloadOrder::NewestFirst => b.order.total_cmp(&a.order),
+        };
+        group_index(a).cmp(&group_index(b)).then(chapter_order)
+    });
+    chapters
+}
+
+/// 章节在漫画中的位置，从根到章节依次为组名和章节id，前端据此切换到对应的组并定位到章节
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize, Type)]
+#[serde(rename_all = "camelCase")]
+pub struct ChapterLocation {
+    pub group_name: String,
+    pub chapter_id: i64,
+}
+
+/// 在`comic`的所有章节中查找`keyword`，按组名、章节顺序排列
+///
+/// `keyword`是数字时按章节号匹配，标题中有这个数字的才算，`12`不会匹配`第112话`；
+/// 否则按标题关键词匹配，忽略大小写和简繁差异
+pub fn find_chapters(comic: &Comic, keyword: &str) -> Vec<ChapterLocation> {
+    let keyword = normalize(keyword.trim());
+    if keyword.is_empty() {
+        return Vec::new();
+    }
+    let number = keyword.parse::<f64>().ok();
+    let is_match = |chapter_info: &ChapterInfo| match number {
+        Some(number) => NUMBER_RE
+            .find_iter(&chapter_info.chapter_title)
+            .filter_map(|m| m.as_str().parse::<f64>().ok())
+            .any(|chapter_number| (chapter_number - number).abs() < f64::EPSILON),
+        None => normalize(&chapter_info.chapter_title).contains(&keyword),
+    };
+
+    let mut matched = comic
+        .groups
+        .values()
+        .flatten()
+        .filter(|chapter_info| is_match(chapter_info))
+        .collect::<Vec<_>>();
+    matched.sort_by(|a, b| {
+        a.group_name
+            .cmp(&b.group_name)
+            .then(a.order.total_cmp(&b.order))
+    });
+    matched
+        .into_iter()
+        .map(|chapter_info| ChapterLocation {
+            group_name: chapter_info.group_name.clone(),
+            chapter_id: chapter_info.chapter_id,
+        })
+        .collect()
+}
+
+fn parse_patterns(patterns: &[String]) -> anyhow::Result<Vec<Pattern>> {
+    let mut parsed = Vec::new();
+    for pattern in patterns {
+        if let Some(pattern) = Pattern::parse(pattern)? {
+            parsed.push(pattern);
+        }
+    }
+    Ok(parsed)
+}
+
+/// 统一转换为简体小写，消除大小写和简繁差异
+fn normalize(s: &str) -> String {
+    zh_convert::to_simplified(s).to_lowercase()
+}
@@ -0,0 +1,169 @@
+use std::path::Path;
+
+use anyhow::{anyhow, Context};
+use serde::{Deserialize, Serialize};
+use sha2::{Digest, Sha256};
+
+use crate::{
+    downloaded_checker::{image_paths, page_num_of},
+    utils::write_atomic,
+};
+
+/// 清单的文件名，位于章节目录中
+pub const MANIFEST_FILENAME: &str = "manifest.json";
+
+/// 清单格式的版本，格式变化时递增
+const MANIFEST_VERSION: u32 = 1;
+
+/// 章节清单中的一页
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ManifestPage {
+    /// 页码，从1开始
+    pub page_num: u32,
+    /// 图片在章节目录中的文件名
+    pub filename: String,
+    /// 图片的字节数
+    pub size: u64,
+    /// 图片内容的sha256，十六进制小写
+    pub sha256: String,
+    /// 图片的下载地址
+    pub url: String,
+}
+
+/// 章节的校验和清单，每话下载完成后写入章节目录
+///
+/// 记录每一页的文件名、大小、内容哈希和下载地址，
+/// 校验已下载的章节、续传和判断章节是否已下载都以它为准
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ChapterManifest {
+    pub version: u32,
+    pub chapter_id: i64,
+    /// 章节的页数
+    pub chapter_size: u32,
+    /// 生成清单的时间，RFC 3339格式
+    pub created_at: String,
+    /// 按页码排序
+    pub pages: Vec<ManifestPage>,
+}
+
+impl ChapterManifest {
+    /// 为`dir`中已下载的图片生成清单，`urls`是每一页的下载地址，下标即页码减1
+    ///
+    /// 每一页都必须有对应的图片，缺页时返回错误
+    pub fn build(chapter_id: i64, dir: &Path, urls: &[String]) -> anyhow::Result<Self> {
+        let chapter_size = u32::try_from(urls.len()).context("页数超出范围")?;
+        let mut pages = Vec::with_capacity(urls.len());
+        for path in image_paths(dir) {
+            let Some(page_num) =
+                page_num_of(&path).filter(|page_num| (1..=chapter_size).contains(page_num))
+            else {
+                continue;
+            };
+            let Some(filename) = path.file_name().and_then(|name| name.to_str()) else {
+                continue;
+            };
+            let data = std::fs::read(&path).context(format!("读取`{path:?}`失败"))?;
+            pages.push(ManifestPage {
+                page_num,
+                filename: filename.to_string(),
+                size: data.len() as u64,
+                sha256: sha256_hex(&data),
+                url: urls[page_num as usize - 1].clone(),
+            });
+        }
+        pages.sort_by_key(|page| page.page_num);
+        pages.dedup_by_key(|page| page.page_num);
+        if pages.len() != urls.len() {
+            return Err(anyhow!(
+                "`{dir:?}`中只有{}页，章节总共有{chapter_size}页",
+                pages.len()
+            ));
+        }
+
+        Ok(Self {
+            version: MANIFEST_VERSION,
+            chapter_id,
+            chapter_size,
+            created_at: chrono::Local::now().to_rfc3339(),
+            pages,
+        })
+    }
+
+    /// 读取`dir`中的清单，没有清单时返回None
+    pub fn load(dir: &Path) -> anyhow::Result<Option<Self>> {
+        let manifest_path = dir.join(MANIFEST_FILENAME);
+        if !manifest_path.is_file() {
+            return Ok(None);
+        }
+        let manifest_json = std::fs::read_to_string(&manifest_path)
+            .context(format!("读取`{manifest_path:?}`失败"))?;
+        let manifest = serde_json::from_str::<Self>(&manifest_json).context(format!(
+            "将`{manifest_path:?}`反序列化为ChapterManifest失败"
+        ))?;
+        Ok(Some(manifest))
+    }
+
+    /// 把清单写入`dir`，保证清单存在时一定是完整的
+    pub fn save(&self, dir: &Path) -> anyhow::Result<()> {
+        let manifest_json = serde_json::to_string_pretty(self).context("将清单序列化失败")?;
+        write_atomic(&dir.join(MANIFEST_FILENAME), manifest_json)
+    }
+
+    /// 对照清单检查`dir`中的图片，返回缺失、大小不符或哈希不符的页码
+    pub fn verify(&self, dir: &Path) -> Vec<u32> {
+        self.pages
+            .iter()
+            .filter(|page| !page.matches(dir))
+            .map(|page| page.page_num)
+            .collect()
+    }
+
+    /// 清单中的页数与章节页数一致，且每一页都校验通过
+    pub fn is_complete(&self, dir: &Path, chapter_size: i64) -> bool {
+        i64::from(self.chapter_size) == chapter_size
+            && self.pages.len() == self.chapter_size as usize
+            && self.verify(dir).is_empty()
+    }
+}
+
+/// 续传前按`dir`中的清单删除校验不通过的页，让它们重新下载，返回被删除的页码
+///
+/// 清单只在整话下载完成后生成，临时目录中有清单说明这是补下缺页或者复用了其他目录中的图片，
+/// 这时已有的图片可能已经损坏，不能只看文件是否存在。章节下载完成后会生成新的清单，所以旧清单同时删除
+pub fn discard_mismatched_pages(dir: &Path) -> anyhow::Result<Vec<u32>> {
+    let Some(manifest) = ChapterManifest::load(dir)? else {
+        return Ok(Vec::new());
+    };
+    let mut discarded = Vec::new();
+    for page in &manifest.pages {
+        // 缺失的页本来就会下载，不需要处理
+        let path = dir.join(&page.filename);
+        if !path.exists() || page.matches(dir) {
+            continue;
+        }
+        std::fs::remove_file(&path).context(format!("删除`{path:?}`失败"))?;
+        discarded.push(page.page_num);
+    }
+    let manifest_path = dir.join(MANIFEST_FILENAME);
+    std::fs::remove_file(&manifest_path).context(format!("删除`{manifest_path:?}`失败"))?;
+    Ok(discarded)
+}
+
+impl ManifestPage {
+    /// 先比较大小，大小一致才计算哈希
+    fn matches(&self, dir: &Path) -> bool {
+        let path = dir.join(&self.filename);
+        let size_matches =
+            std::fs::metadata(&path).is_ok_and(|metadata| metadata.len() == self.size);
+        size_matches && std::fs::read(&path).is_ok_and(|data| sha256_hex(&data) == self.sha256)
+    }
+}
+
+fn sha256_hex(data: &[u8]) -> String {
+    Sha256::digest(data)
+        .iter()
+        .map(|byte| format!("{byte:02x}"))
+        .collect()
+}
@@ -0,0 +1,208 @@
+use std::{
+    collections::HashMap,
+    path::{Path, PathBuf},
+};
+
+use anyhow::Context;
+use parking_lot::RwLock;
+use tauri::{AppHandle, Manager};
+use tauri_specta::Event;
+
+use crate::{
+    config::{AcceptLanguage, ChapterDedupeScope, Config},
+    events::LogEvent,
+    extensions::AnyhowErrorToStringChain,
+    site::Site,
+    types::{ChapterInfo, Comic},
+    utils::write_atomic,
+};
+
+/// 漫画缓存目录名，位于`cache_dir`下
+const CACHE_DIR_NAME: &str = "漫画";
+
+/// 缓存中的`update_time`与页面上的`update_time`相同时返回缓存
+///
+/// 更新时间没变说明章节列表也没变，可以省掉补抓懒加载的分页、解析和合并。
+/// 返回前按本地的文件重新计算目录名和是否已下载，没有缓存或读取失败时返回None
+pub fn load_unchanged(
+    app: &AppHandle,
+    comic_id: i64,
+    site: Site,
+    accept_language: AcceptLanguage,
+    update_time: &str,
+) -> Option<Comic> {
+    let cache_path = get_cache_path(app, comic_id, site, accept_language);
+    let mut comic = load_cache(&cache_path).ok().flatten()?;
+    if comic.update_time != update_time {
+        return None;
+    }
+    comic.apply_local_state(app);
+    Some(comic)
+}
+
+/// 按当前的站点和`Accept-Language`读取`comic_id`的缓存，即最近一次获取这本漫画的结果
+///
+/// 只用来在本地查章节，不重新计算是否已下载，没有缓存或读取失败时返回None
+pub fn load_latest(app: &AppHandle, comic_id: i64) -> Option<Comic> {
+    let (site, accept_language) = {
+        let config = app.state::<RwLock<Config>>();
+        let config = config.read();
+        (config.site, config.accept_language)
+    };
+    let cache_path = get_cache_path(app, comic_id, site, accept_language);
+    load_cache(&cache_path).ok().flatten()
+}
+
+/// 把新解析的`comic`与上次缓存的结果合并，并用合并结果更新缓存
+///
+/// 同一本漫画在不同站点、不同`Accept-Language`下的标题不同，不同去重范围下的章节列表也不同，所以分开缓存。
+/// 缓存读写失败不影响获取漫画，最多是这次没有合并
+pub fn merge_with_cache(
+    app: &AppHandle,
+    comic: Comic,
+    site: Site,
+    accept_language: AcceptLanguage,
+) -> Comic {
+    let cache_path = get_cache_path(app, comic.id, site, accept_language);
+    let comic = match load_cache(&cache_path) {
+        Ok(Some(cached)) => {
+            let (download_dir, downloaded_checker) = {
+                let config = app.state::<RwLock<Config>>();
+                let config = config.read();
+                (
+                    config.download_dir.clone(),
+                    config.downloaded_check_strategy.checker(),
+                )
+            };
+            let is_downloaded = |chapter_info: &ChapterInfo| {
+                chapter_info.get_is_downloaded(&download_dir, downloaded_checker.as_ref())
+            };
+            merge(app, cached, comic, is_downloaded)
+        }
+        Ok(None) => comic,
+        Err(err) => {
+            let _ = LogEvent::Warn {
+                msg: format!("读取漫画缓存失败，本次不合并: {}", err.to_string_chain()),
+            }
+            .emit(app);
+            comic
+        }
+    };
+    if let Err(err) = save_cache(&cache_path, &comic) {
+        let _ = LogEvent::Warn {
+            msg: format!("更新漫画缓存失败: {}", err.to_string_chain()),
+        }
+        .emit(app);
+    }
+    comic
+}
+
+/// 以`fresh`为准合并`cached`，章节以(组名, 章节id)区分
+///
+/// - 网站给章节改了名，但旧名字的目录已经下载好了时，沿用旧名字，避免重复下载
+/// - 网站上已经没有、但已经下载了的章节保留下来，其他的以`fresh`为准
+fn merge(
+    app: &AppHandle,
+    cached: Comic,
+    mut fresh: Comic,
+    is_downloaded: impl Fn(&ChapterInfo) -> bool,
+) -> Comic {
+    for (group_name, cached_chapters) in cached.groups {
+        let fresh_chapters = fresh.groups.entry(group_name).or_default();
+        // 章节id -> 在`fresh_chapters`中的位置，之后只会在末尾追加，已有的位置不会变
+        let fresh_indexes = fresh_chapters
+            .iter()
+            .enumerate()
+            .map(|(index, chapter_info)| (chapter_info.chapter_id, index))
+            .collect::<HashMap<_, _>>();
+        for cached_chapter in cached_chapters {
+            let Some(&index) = fresh_indexes.get(&cached_chapter.chapter_id) else {
+                if is_downloaded(&cached_chapter) {
+                    let mut chapter_info = cached_chapter;
+                    chapter_info.is_downloaded = Some(true);
+                    fresh_chapters.push(chapter_info);
+                }
+                continue;
+            };
+            let fresh_chapter = &mut fresh_chapters[index];
+            if fresh_chapter.chapter_title == cached_chapter.chapter_title
+                || fresh_chapter.is_downloaded == Some(true)
+            {
+                continue;
+            }
+            // 除了标题，其他信息(页数、顺序等)依然以新解析的为准
+            let mut renamed = fresh_chapter.clone();
+            renamed
+                .chapter_title
+                .clone_from(&cached_chapter.chapter_title);
+            renamed
+                .prefixed_chapter_title
+                .clone_from(&cached_chapter.prefixed_chapter_title);
+            if !is_downloaded(&renamed) {
+                continue;
+            }
+            let _ = LogEvent::Info {
+                msg: format!(
+                    "章节`{}`已被网站改名为`{}`，沿用已下载的目录",
+                    cached_chapter.chapter_title, fresh_chapter.chapter_title
+                ),
+            }
+            .emit(app);
+            renamed.is_downloaded = Some(true);
+            *fresh_chapter = renamed;
+        }
+    }
+
+    fresh.groups.retain(|_, chapters| !chapters.is_empty());
+    for chapters in fresh.groups.values_mut() {
+        chapters.sort_by(|a, b| a.order.total_cmp(&b.order));
+    }
+    fresh
+}
+
+fn get_cache_path(
+    app: &AppHandle,
+    comic_id: i64,
+    site: Site,
+    accept_language: AcceptLanguage,
+) -> PathBuf {
+    let (cache_dir, dedupe_scope) = {
+        let config = app.state::<RwLock<Config>>();
+        let config = config.read();
+        (config.cache_dir.clone(), config.chapter_dedupe_scope)
+    };
+    // 用固定的字符串拼文件名，不依赖枚举的Debug输出，改名枚举成员不会让缓存失效
+    let site = match site {
+        Site::Www => "www",
+        Site::Tw => "tw",
+    };
+    let accept_language = match accept_language {
+        AcceptLanguage::Auto => "auto",
+        AcceptLanguage::Simplified => "zh-cn",
+        AcceptLanguage::Traditional => "zh-tw",
+    };
+    let dedupe_scope = match dedupe_scope {
+        ChapterDedupeScope::Disabled => "no-dedupe",
+        ChapterDedupeScope::Page => "dedupe-page",
+        ChapterDedupeScope::Group => "dedupe-group",
+        ChapterDedupeScope::Comic => "dedupe-comic",
+    };
+    cache_dir.join(CACHE_DIR_NAME).join(format!(
+        "{comic_id}-{site}-{accept_language}-{dedupe_scope}.json"
+    ))
+}
+
+fn load_cache(cache_path: &Path) -> anyhow::Result<Option<Comic>> {
+    if !cache_path.exists() {
+        return Ok(None);
+    }
+    let comic_json =
+        std::fs::read_to_string(cache_path).context(format!("读取`{cache_path:?}`失败"))?;
+    let comic = serde_json::from_str::<Comic>(&comic_json)
+        .context(format!("将`{cache_path:?}`反序列化为Comic失败"))?;
+    Ok(Some(comic))
+}
+
+fn save_cache(cache_path: &Path, comic: &Comic) -> anyhow::Result<()> {
+    write_atomic(cache_path, comic.to_json()?)
+}
@@ -0,0 +1,122 @@
+use std::{
+    collections::HashMap,
+    path::{Path, PathBuf},
+};
+
+use anyhow::Context;
+use parking_lot::Mutex;
+use serde::{Deserialize, Serialize};
+
+use crate::utils::write_atomic;
+
+/// 映射文件的文件名，位于`download_dir`下，跟着下载目录走，换电脑或换下载目录后依然有效
+const MAP_FILENAME: &str = ".comic_dirs.json";
+
+/// 读写映射文件时加锁，避免同时获取多本漫画时互相覆盖
+static MAP_LOCK: Mutex<()> = Mutex::new(());
+
+/// 一本漫画的目录以及它在网站上用过的标题
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+struct ComicDirEntry {
+    /// 下载目录中漫画目录的名字，第一次保存元数据时的标题，原目录还在时不再变化
+    dir_name: String,
+    /// 网站上当前的标题
+    current_title: String,
+    /// 网站上用过的标题，按改名的先后顺序排列
+    #[serde(default)]
+    former_titles: Vec<String>,
+}
+
+/// 漫画在下载目录中的目录名，以及它在网站上用过的标题
+pub struct ComicDir {
+    pub dir_name: String,
+    pub former_titles: Vec<String>,
+}
+
+/// 以漫画id为准，查出标题为`title`的漫画应该使用的目录，只读取映射，不会修改它
+///
+/// 漫画在网站上改了标题(包括切换简繁)后，以标题拼出的目录就对不上了，
+/// 这时沿用映射中记录的原目录。没有记录或原目录已经不在了时使用`title`
+pub fn lookup(download_dir: &Path, comic_id: i64, title: &str) -> anyhow::Result<ComicDir> {
+    let map = {
+        let _guard = MAP_LOCK.lock();
+        load_map(download_dir)?
+    };
+    let Some(entry) = map.get(&comic_id) else {
+        return Ok(ComicDir {
+            dir_name: title.to_string(),
+            former_titles: Vec::new(),
+        });
+    };
+    // 原目录被删除或手动改名了，以后就用新标题
+    let dir_name = if download_dir.join(&entry.dir_name).is_dir() {
+        entry.dir_name.clone()
+    } else {
+        title.to_string()
+    };
+    // 映射中的当前标题还没更新，说明这次才发现改名
+    let mut former_titles = entry.former_titles.clone();
+    if entry.current_title != title && !former_titles.contains(&entry.current_title) {
+        former_titles.push(entry.current_title.clone());
+    }
+    former_titles.retain(|former_title| former_title != title);
+    Ok(ComicDir {
+        dir_name,
+        former_titles,
+    })
+}
+
+/// 保存漫画`comic_id`的元数据时记录它的目录和网站上的标题`title`，标题变了时把旧标题记为曾用名
+pub fn record(
+    download_dir: &Path,
+    comic_id: i64,
+    dir_name: &str,
+    title: &str,
+) -> anyhow::Result<()> {
+    let _guard = MAP_LOCK.lock();
+    let mut map = load_map(download_dir)?;
+    let Some(entry) = map.get_mut(&comic_id) else {
+        map.insert(
+            comic_id,
+            ComicDirEntry {
+                dir_name: dir_name.to_string(),
+                current_title: title.to_string(),
+                former_titles: Vec::new(),
+            },
+        );
+        return save_map(download_dir, &map);
+    };
+    if entry.dir_name == dir_name && entry.current_title == title {
+        return Ok(());
+    }
+
+    entry.dir_name = dir_name.to_string();
+    if entry.current_title != title {
+        let former_title = std::mem::replace(&mut entry.current_title, title.to_string());
+        if !entry.former_titles.contains(&former_title) {
+            entry.former_titles.push(former_title);
+        }
+    }
+    save_map(download_dir, &map)
+}
+
+fn get_map_path(download_dir: &Path) -> PathBuf {
+    download_dir.join(MAP_FILENAME)
+}
+
+fn load_map(download_dir: &Path) -> anyhow::Result<HashMap<i64, ComicDirEntry>> {
+    let map_path = get_map_path(download_dir);
+    if !map_path.exists() {
+        return Ok(HashMap::new());
+    }
+    let map_json = std::fs::read_to_string(&map_path).context(format!("读取`{map_path:?}`失败"))?;
+    let map = serde_json::from_str::<HashMap<i64, ComicDirEntry>>(&map_json)
+        .context(format!("将`{map_path:?}`反序列化为漫画目录映射失败"))?;
+    Ok(map)
+}
+
+fn save_map(download_dir: &Path, map: &HashMap<i64, ComicDirEntry>) -> anyhow::Result<()> {
+    let map_json = serde_json::to_string_pretty(map).context("将漫画目录映射序列化为json失败")?;
+    write_atomic(&get_map_path(download_dir), map_json)
+}
@@ -1,18 +1,41 @@
-use std::collections::HashMap;
+use std::{
+    collections::{HashMap, HashSet},
+    path::PathBuf,
+};
 
-use anyhow::Context;
+use anyhow::{anyhow, Context};
 use parking_lot::RwLock;
-use tauri::{AppHandle, Manager, State};
+use tauri::{ipc::Channel, AppHandle, Manager, State};
 use tauri_specta::Event;
 
 use crate::{
+    chapter_filter::{self, ChapterLocation, DownloadOrder},
+    comic_cache, comic_dirs,
     config::Config,
-    download_manager::DownloadManager,
+    diagnose::{self, DiagnoseReport},
+    download_history::{DownloadHistory, DownloadHistoryEntry, DownloadHistoryFilter},
+    download_manager::{DownloadManager, DownloadPlan, DownloadTaskState},
+    download_queue::{DownloadPriority, DownloadQueueState},
     errors::CommandResult,
-    events::UpdateDownloadedComicsEvent,
+    events::{LogEvent, UpdateDownloadedComicsEvent},
     export,
+    extensions::AnyhowErrorToStringChain,
+    image_host::ServerSpeed,
+    image_proxy::ImageProxy,
+    library::{self, DedupeReport, ReorganizeAction, ReorganizeReport, VerifyReport},
+    library_index::{self, LibraryIndex},
     manhuagui_client::ManhuaguiClient,
-    types::{ChapterInfo, Comic, GetFavoriteResult, SearchResult, UserProfile},
+    metrics::MetricsSnapshot,
+    netscape_cookies,
+    proxy_detect::{self, ProxyCandidate},
+    reader::{self, ImagePage, ReaderSessions},
+    search_history::SearchHistory,
+    task_list::{self, DownloadTask, ExternalEntry, ExternalImportReport, ExternalTaskFormat},
+    types::{
+        ChapterInfo, ChapterLanguage, Comic, ComicDiff, GetFavoriteResult, LatestUpdateResult,
+        RankType, SearchFilter, SearchResult, SearchSort, UserProfile,
+    },
+    webdav_sync::{self, WebDavSyncReport},
 };
 
 #[tauri::command]
@@ -34,11 +57,37 @@ pub fn get_config(config: tauri::State<RwLock<Config>>) -> Config {
 pub fn save_config(
     app: AppHandle,
     config_state: State<RwLock<Config>>,
-    config: Config,
+    manhuagui_client: State<ManhuaguiClient>,
+    download_manager: State<DownloadManager>,
+    mut config: Config,
 ) -> CommandResult<()> {
+    {
+        // 证书设置只由专门的命令修改，前端的配置可能是修改前读取的，以当前的为准
+        let config_state = config_state.read();
+        config.accept_invalid_certs = config_state.accept_invalid_certs;
+        config.root_ca_pems = config_state.root_ca_pems.clone();
+    }
+    manhuagui_client.set_accept_language(config.accept_language);
+    manhuagui_client.set_http2_enabled(config.http2_enabled);
+    download_manager.set_max_active_comics(config.max_active_comics);
+    download_manager.set_adaptive_image_concurrency(config.adaptive_image_concurrency);
+    manhuagui_client.set_parse_concurrency(config.parse_concurrency);
+    let proxy_changed = config_state.read().proxy != config.proxy;
     let mut config_state = config_state.write();
     *config_state = config;
     config_state.save(&app)?;
+    config_state
+        .allow_asset_access(&app)
+        .context("允许前端读取下载目录和缓存目录失败")?;
+    // 先保存再设置代理和自定义header，这样即使它们不合法，配置也不会丢
+    if proxy_changed {
+        manhuagui_client
+            .set_proxy(&config_state.proxy)
+            .context("设置代理失败")?;
+    }
+    manhuagui_client
+        .set_extra_headers(&config_state.extra_headers)
+        .context("设置自定义header失败")?;
     Ok(())
 }
 
@@ -56,6 +105,83 @@ pub async fn login(
     Ok(cookie)
 }
 
+/// 从浏览器扩展导出的Netscape格式cookies.txt中读取漫画柜的cookie，返回可以直接填入配置的cookie
+#[tauri::command(async)]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn import_netscape_cookies(path: PathBuf) -> CommandResult<String> {
+    let content = std::fs::read_to_string(&path).context(format!("读取`{path:?}`失败"))?;
+    let now_secs = chrono::Utc::now().timestamp();
+    let cookies =
+        netscape_cookies::parse(&content, now_secs).context(format!("解析`{path:?}`失败"))?;
+    if cookies.is_empty() {
+        return Err(anyhow!("`{path:?}`中没有漫画柜的有效cookie").into());
+    }
+    Ok(netscape_cookies::to_cookie_header(&cookies))
+}
+
+/// 把配置中的cookie以Netscape格式导出到`path`，方便导入浏览器
+#[tauri::command(async)]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn export_netscape_cookies(config: State<RwLock<Config>>, path: PathBuf) -> CommandResult<()> {
+    let cookie = config.read().cookie.clone();
+    if cookie.is_empty() {
+        return Err(anyhow!("还没有设置cookie").into());
+    }
+    let content = netscape_cookies::from_cookie_header(&cookie);
+    std::fs::write(&path, content).context(format!("写入`{path:?}`失败"))?;
+    Ok(())
+}
+
+#[tauri::command]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn set_host_rate_limit(manhuagui_client: State<ManhuaguiClient>, host: String, qps: f64) {
+    manhuagui_client.set_host_rate_limit(&host, qps);
+}
+
+/// 设置是否跳过TLS证书校验，同时保存到配置中，重启后仍然生效
+#[tauri::command]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn set_accept_invalid_certs(
+    app: AppHandle,
+    config: State<RwLock<Config>>,
+    manhuagui_client: State<ManhuaguiClient>,
+    accept_invalid_certs: bool,
+) -> CommandResult<()> {
+    manhuagui_client.set_accept_invalid_certs(accept_invalid_certs);
+    let mut config = config.write();
+    config.accept_invalid_certs = accept_invalid_certs;
+    config.save(&app)?;
+    Ok(())
+}
+
+/// 额外信任`path`中PEM格式的根证书，证书内容保存到配置中，重启后仍然生效
+#[tauri::command(async)]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn add_root_ca(
+    app: AppHandle,
+    config: State<RwLock<Config>>,
+    manhuagui_client: State<ManhuaguiClient>,
+    path: PathBuf,
+) -> CommandResult<()> {
+    let pem = std::fs::read_to_string(&path).context(format!("读取根证书`{path:?}`失败"))?;
+    let mut config = config.write();
+    // 同一个证书添加多次只保留一份
+    if config.root_ca_pems.contains(&pem) {
+        return Ok(());
+    }
+    manhuagui_client
+        .add_root_ca(pem.as_bytes())
+        .context(format!("添加根证书`{path:?}`失败"))?;
+    config.root_ca_pems.push(pem);
+    config.save(&app)?;
+    Ok(())
+}
+
 #[tauri::command(async)]
 #[specta::specta]
 pub async fn get_user_profile(
@@ -68,20 +194,97 @@ pub async fn get_user_profile(
     Ok(user_profile)
 }
 
+/// 搜索`keyword`的第`page_num`页，并在本地按`filter`过滤这一页的结果
 #[tauri::command(async)]
 #[specta::specta]
+#[allow(clippy::too_many_arguments)]
 pub async fn search(
+    app: AppHandle,
+    config: State<'_, RwLock<Config>>,
     manhuagui_client: State<'_, ManhuaguiClient>,
+    search_history: State<'_, SearchHistory>,
     keyword: String,
     page_num: i64,
+    sort: SearchSort,
+    filter: SearchFilter,
 ) -> CommandResult<SearchResult> {
-    let search_result = manhuagui_client
-        .search(&keyword, page_num)
+    let mut search_result = manhuagui_client
+        .search(&keyword, page_num, sort)
         .await
-        .context("搜索失败")?;
+        .context("搜索失败")?
+        .filter(&filter);
+    // 记录搜索历史失败不影响搜索结果
+    if let Err(err) = search_history.record(&keyword) {
+        let err = err.context(format!("记录搜索历史`{keyword}`失败"));
+        let _ = LogEvent::Warn {
+            msg: err.to_string_chain(),
+        }
+        .emit(&app);
+    }
+    let download_dir = config.read().download_dir.clone();
+    search_result.mark_local_downloaded(&download_dir);
     Ok(search_result)
 }
 
+#[tauri::command]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn get_search_history(search_history: State<SearchHistory>) -> Vec<String> {
+    search_history.list()
+}
+
+#[tauri::command(async)]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn clear_search_history(search_history: State<SearchHistory>) -> CommandResult<()> {
+    search_history.clear().context("清空搜索历史失败")?;
+    Ok(())
+}
+
+#[tauri::command(async)]
+#[specta::specta]
+pub async fn get_rank(
+    config: State<'_, RwLock<Config>>,
+    manhuagui_client: State<'_, ManhuaguiClient>,
+    rank_type: RankType,
+    page_num: i64,
+) -> CommandResult<SearchResult> {
+    let mut rank_result = manhuagui_client
+        .get_rank(rank_type, page_num)
+        .await
+        .context("获取排行榜失败")?;
+    let download_dir = config.read().download_dir.clone();
+    rank_result.mark_local_downloaded(&download_dir);
+    Ok(rank_result)
+}
+
+#[tauri::command(async)]
+#[specta::specta]
+pub async fn get_latest_updates(
+    manhuagui_client: State<'_, ManhuaguiClient>,
+    page_num: i64,
+) -> CommandResult<LatestUpdateResult> {
+    let latest_update_result = manhuagui_client
+        .get_latest_updates(page_num)
+        .await
+        .context("获取最近更新失败")?;
+    Ok(latest_update_result)
+}
+
+#[tauri::command(async)]
+#[specta::specta]
+pub async fn get_comics_by_genre(
+    manhuagui_client: State<'_, ManhuaguiClient>,
+    slug: String,
+    page_num: i64,
+) -> CommandResult<LatestUpdateResult> {
+    let latest_update_result = manhuagui_client
+        .get_comics_by_genre(&slug, page_num)
+        .await
+        .context(format!("获取类型`{slug}`的漫画列表失败"))?;
+    Ok(latest_update_result)
+}
+
 #[tauri::command(async)]
 #[specta::specta]
 pub async fn get_comic(
@@ -95,18 +298,305 @@ pub async fn get_comic(
     Ok(comic)
 }
 
+/// 通过章节链接获取章节所属的漫画，例如历史记录中只有章节链接时
+#[tauri::command(async)]
+#[specta::specta]
+pub async fn get_comic_by_chapter_href(
+    manhuagui_client: State<'_, ManhuaguiClient>,
+    href: String,
+) -> CommandResult<Comic> {
+    let Some(ExternalEntry {
+        comic_id,
+        chapter_id: Some(_),
+    }) = task_list::parse_manhuagui_url(&href)
+    else {
+        return Err(anyhow!("`{href}`不是章节链接").into());
+    };
+    let comic = manhuagui_client
+        .get_comic(comic_id)
+        .await
+        .context(format!("获取章节`{href}`所属的漫画`{comic_id}`失败"))?;
+    Ok(comic)
+}
+
+/// 重新获取漫画`comic_id`的信息，以json格式保存到`path`，方便备份或者给其他工具使用
+#[tauri::command(async)]
+#[specta::specta]
+pub async fn export_comic_json(
+    manhuagui_client: State<'_, ManhuaguiClient>,
+    comic_id: i64,
+    path: PathBuf,
+) -> CommandResult<()> {
+    let comic = manhuagui_client
+        .get_comic(comic_id)
+        .await
+        .context(format!("获取漫画`{comic_id}`的信息失败"))?;
+    let comic_json = comic
+        .to_json()
+        .context(format!("漫画`{}`导出json失败", comic.title))?;
+    std::fs::write(&path, comic_json).context(format!("写入`{path:?}`失败"))?;
+    Ok(())
+}
+
+/// 重新获取漫画，只返回与`old_comic`相比有变化的部分
+#[tauri::command(async)]
+#[specta::specta]
+pub async fn get_comic_diff(
+    manhuagui_client: State<'_, ManhuaguiClient>,
+    old_comic: Comic,
+) -> CommandResult<ComicDiff> {
+    let id = old_comic.id;
+    let new_comic = manhuagui_client
+        .get_comic(id)
+        .await
+        .context(format!("获取漫画`{id}`的信息失败"))?;
+    Ok(ComicDiff::diff(&old_comic, &new_comic))
+}
+
+#[tauri::command(async)]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn filter_chapters(
+    chapters: Vec<ChapterInfo>,
+    include: Vec<String>,
+    exclude: Vec<String>,
+    languages: Vec<ChapterLanguage>,
+    updated_after: Option<String>,
+    updated_before: Option<String>,
+) -> CommandResult<Vec<ChapterInfo>> {
+    let chapters = chapter_filter::filter_chapters(
+        chapters,
+        &include,
+        &exclude,
+        &languages,
+        updated_after.as_deref(),
+        updated_before.as_deref(),
+    )
+    .context("过滤章节失败")?;
+    Ok(chapters)
+}
+
+/// 在漫画`comic_id`中按标题关键词或章节号查找章节，返回每个匹配章节所在的组和章节id
+///
+/// 章节列表取自获取漫画时保存的缓存，没有缓存时取自已下载漫画的元数据，不需要前端把整个漫画传过来
+#[tauri::command(async)]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn find_chapters(
+    app: AppHandle,
+    comic_id: i64,
+    keyword: String,
+) -> CommandResult<Vec<ChapterLocation>> {
+    let comic = match comic_cache::load_latest(&app, comic_id) {
+        Some(comic) => comic,
+        None => {
+            let comic_dir = library_index::find_comic_dir(&app, comic_id)
+                .context("查找已下载的漫画失败")?
+                .ok_or_else(|| anyhow!("漫画`{comic_id}`没有缓存，也没有下载过，请重新加载漫画"))?;
+            Comic::from_metadata(&app, &comic_dir.join("元数据.json"))?
+        }
+    };
+    Ok(chapter_filter::find_chapters(&comic, &keyword))
+}
+
 #[tauri::command(async)]
 #[specta::specta]
 pub async fn download_chapters(
     download_manager: State<'_, DownloadManager>,
     chapters: Vec<ChapterInfo>,
+    priority: DownloadPriority,
+    order: DownloadOrder,
 ) -> CommandResult<()> {
-    for ep in chapters {
-        download_manager.submit_chapter(ep).await?;
+    download_manager
+        .check_disk_space(&chapters)
+        .context("下载前检查磁盘空间失败")?;
+    // 同一本漫画的章节按提交的先后顺序开始下载
+    for ep in chapter_filter::sort_for_download(chapters, order) {
+        download_manager.submit_chapter(ep, priority).await?;
     }
     Ok(())
 }
 
+#[tauri::command(async)]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn estimate_size(download_manager: State<DownloadManager>, chapters: Vec<ChapterInfo>) -> u64 {
+    download_manager.estimate_size(&chapters)
+}
+
+#[tauri::command(async)]
+#[specta::specta]
+pub async fn preview_download(
+    download_manager: State<'_, DownloadManager>,
+    chapters: Vec<ChapterInfo>,
+) -> CommandResult<DownloadPlan> {
+    let download_plan = download_manager
+        .preview(chapters)
+        .await
+        .context("预览下载失败")?;
+    Ok(download_plan)
+}
+
+#[tauri::command]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn cancel_download(
+    download_manager: State<DownloadManager>,
+    comic_id: i64,
+    delete_temp_dirs: bool,
+) {
+    download_manager.cancel_comic(comic_id, delete_temp_dirs);
+}
+
+#[tauri::command]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn pause_download(download_manager: State<DownloadManager>) {
+    download_manager.pause();
+}
+
+#[tauri::command]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn resume_download(download_manager: State<DownloadManager>) {
+    download_manager.resume();
+}
+
+#[tauri::command]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn is_download_paused(download_manager: State<DownloadManager>) -> bool {
+    download_manager.is_paused()
+}
+
+/// 单独暂停、恢复或取消一些章节，`chapter_ids`为空时什么都不做
+#[tauri::command]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn pause_chapters(download_manager: State<DownloadManager>, chapter_ids: Vec<i64>) {
+    download_manager.pause_chapters(&chapter_ids);
+}
+
+#[tauri::command]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn resume_chapters(download_manager: State<DownloadManager>, chapter_ids: Vec<i64>) {
+    download_manager.resume_chapters(&chapter_ids);
+}
+
+#[tauri::command]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn cancel_chapters(
+    download_manager: State<DownloadManager>,
+    chapter_ids: Vec<i64>,
+    delete_temp_dirs: bool,
+) {
+    download_manager.cancel_chapters(&chapter_ids, delete_temp_dirs);
+}
+
+/// 暂停漫画`comic_id`所有未完成的章节，之后再提交的章节不受影响
+#[tauri::command]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn pause_comic(download_manager: State<DownloadManager>, comic_id: i64) {
+    let chapter_ids = download_manager.chapter_ids_of_comic(comic_id);
+    download_manager.pause_chapters(&chapter_ids);
+}
+
+#[tauri::command]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn resume_comic(download_manager: State<DownloadManager>, comic_id: i64) {
+    let chapter_ids = download_manager.chapter_ids_of_comic(comic_id);
+    download_manager.resume_chapters(&chapter_ids);
+}
+
+#[tauri::command]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn get_paused_chapter_ids(download_manager: State<DownloadManager>) -> Vec<i64> {
+    download_manager.paused_chapter_ids()
+}
+
+#[tauri::command]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn get_download_task_states(
+    download_manager: State<DownloadManager>,
+) -> Vec<DownloadTaskState> {
+    download_manager.task_states()
+}
+
+#[tauri::command]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn get_download_queue_state(download_manager: State<DownloadManager>) -> DownloadQueueState {
+    download_manager.queue_state()
+}
+
+/// 本地图片代理服务的地址，前端把图片url编码后拼成`{地址}/img?url=...`即可绕过防盗链显示图片
+#[tauri::command]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn get_image_proxy_url(image_proxy: State<ImageProxy>) -> Option<String> {
+    image_proxy.base_url()
+}
+
+/// OPDS目录的地址，开启局域网访问时是本机的局域网地址，本地服务没有启动时为None
+#[tauri::command]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn get_opds_url(image_proxy: State<ImageProxy>) -> Option<String> {
+    image_proxy.opds_url()
+}
+
+#[tauri::command]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn list_download_history(
+    download_history: State<DownloadHistory>,
+    filter: DownloadHistoryFilter,
+) -> Vec<DownloadHistoryEntry> {
+    download_history.list(&filter)
+}
+
+#[tauri::command(async)]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn clear_download_history(download_history: State<DownloadHistory>) -> CommandResult<()> {
+    download_history.clear().context("清空下载历史失败")?;
+    Ok(())
+}
+
+#[tauri::command]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn get_restored_download_tasks(
+    download_manager: State<DownloadManager>,
+) -> Vec<DownloadTaskState> {
+    download_manager.restored_task_states()
+}
+
+#[tauri::command(async)]
+#[specta::specta]
+pub async fn resume_restored_download_tasks(
+    download_manager: State<'_, DownloadManager>,
+) -> CommandResult<()> {
+    download_manager
+        .resume_restored_tasks()
+        .await
+        .context("继续下载未完成的任务失败")?;
+    Ok(())
+}
+
+#[tauri::command]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn discard_restored_download_tasks(download_manager: State<DownloadManager>) {
+    download_manager.discard_restored_tasks();
+}
+
 #[tauri::command(async)]
 #[specta::specta]
 pub async fn get_favorite(
@@ -123,21 +613,18 @@ pub async fn get_favorite(
 #[tauri::command(async)]
 #[specta::specta]
 #[allow(clippy::needless_pass_by_value)]
-pub fn save_metadata(config: State<RwLock<Config>>, mut comic: Comic) -> CommandResult<()> {
-    // 将所有章节的is_downloaded字段设置为None，这样能使is_downloaded字段在序列化时被忽略
-    for chapter_infos in comic.groups.values_mut() {
-        for chapter_info in chapter_infos.iter_mut() {
-            chapter_info.is_downloaded = None;
-        }
-    }
-
+pub fn save_metadata(
+    config: State<RwLock<Config>>,
+    download_manager: State<DownloadManager>,
+    comic: Comic,
+) -> CommandResult<()> {
     let comic_title = &comic.title;
-    let comic_json = serde_json::to_string_pretty(&comic).context(format!(
-        "`{comic_title}`的元数据保存失败，将Comic序列化为json失败"
-    ))?;
+    let comic_json = comic
+        .to_json()
+        .context(format!("`{comic_title}`的元数据保存失败"))?;
 
     let download_dir = config.read().download_dir.clone();
-    let metadata_dir = download_dir.join(comic_title);
+    let metadata_dir = download_dir.join(&comic.dir_name);
     let metadata_path = metadata_dir.join("元数据.json");
 
     std::fs::create_dir_all(&metadata_dir).context(format!(
@@ -147,6 +634,11 @@ pub fn save_metadata(config: State<RwLock<Config>>, mut comic: Comic) -> Command
     std::fs::write(&metadata_path, comic_json).context(format!(
         "`{comic_title}`的元数据保存失败，写入文件`{metadata_path:?}`失败"
     ))?;
+    // 记下漫画id对应的目录，之后漫画改名了也能找到
+    comic_dirs::record(&download_dir, comic.id, &comic.dir_name, comic_title)
+        .context(format!("记录`{comic_title}`的目录失败"))?;
+    // 顺便下载封面，在后台进行，失败也不影响后续的章节下载
+    download_manager.download_cover(&comic);
 
     Ok(())
 }
@@ -186,6 +678,108 @@ pub fn get_downloaded_comics(
     Ok(downloaded_comics)
 }
 
+#[tauri::command(async)]
+#[specta::specta]
+pub async fn stream_chapter_images(
+    app: AppHandle,
+    session_id: String,
+    chapter_info: ChapterInfo,
+    prefetch: u32,
+    on_page: Channel<ImagePage>,
+) -> CommandResult<()> {
+    let chapter_title = &chapter_info.chapter_title;
+    reader::stream_chapter_images(&app, &session_id, &chapter_info, prefetch, &on_page)
+        .await
+        .context(format!("读取章节`{chapter_title}`的图片失败"))?;
+    Ok(())
+}
+
+/// 告诉后端阅读器当前阅读到第几页(从0开始)，边下载边阅读时只预取这一页之后的几页
+#[tauri::command(async)]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn set_reader_position(reader_sessions: State<ReaderSessions>, session_id: String, index: u32) {
+    reader_sessions.set_position(&session_id, index);
+}
+
+/// 关闭阅读器，取消还在下载的页
+#[tauri::command(async)]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn close_reader(reader_sessions: State<ReaderSessions>, session_id: String) {
+    reader_sessions.close(&session_id);
+}
+
+#[tauri::command(async)]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn plan_reorganize_library(config: State<RwLock<Config>>) -> CommandResult<ReorganizeReport> {
+    let download_dir = config.read().download_dir.clone();
+    let report = library::plan_reorganize(&download_dir).context("生成整理计划失败")?;
+    Ok(report)
+}
+
+/// 执行`plan_reorganize_library`生成、用户确认过的操作
+#[tauri::command(async)]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn reorganize_library(
+    config: State<RwLock<Config>>,
+    actions: Vec<ReorganizeAction>,
+) -> ReorganizeReport {
+    let download_dir = config.read().download_dir.clone();
+    library::execute_reorganize(&download_dir, actions)
+}
+
+/// 校验`comic_dir`中已下载章节是否缺页
+#[tauri::command(async)]
+#[specta::specta]
+pub async fn verify_library(
+    manhuagui_client: State<'_, ManhuaguiClient>,
+    comic_dir: PathBuf,
+) -> CommandResult<VerifyReport> {
+    let report = library::verify(&manhuagui_client, &comic_dir)
+        .await
+        .context(format!("校验`{comic_dir:?}`失败"))?;
+    Ok(report)
+}
+
+/// 把`verify_library`找出的缺页章节提交到下载队列补下，不会再校验一遍
+#[tauri::command(async)]
+#[specta::specta]
+pub async fn repair_chapters(
+    download_manager: State<'_, DownloadManager>,
+    chapters: Vec<ChapterInfo>,
+) -> CommandResult<()> {
+    for chapter_info in chapters {
+        let chapter_title = chapter_info.chapter_title.clone();
+        download_manager
+            .submit_repair(chapter_info)
+            .await
+            .context(format!("提交`{chapter_title}`的补下任务失败"))?;
+    }
+    Ok(())
+}
+
+/// 找出`comic_dir`中内容完全相同的图片，`hard_link`为true时用硬链接替换重复的图片
+#[tauri::command(async)]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn dedupe_images(comic_dir: PathBuf, hard_link: bool) -> CommandResult<DedupeReport> {
+    let report = library::dedupe_images(&comic_dir, hard_link)
+        .context(format!("对`{comic_dir:?}`中的图片去重失败"))?;
+    Ok(report)
+}
+
+/// 扫描下载目录，生成「我的书架」用的索引
+#[tauri::command(async)]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn build_library_index(app: AppHandle) -> CommandResult<LibraryIndex> {
+    let index = library_index::build(&app).context("生成书架索引失败")?;
+    Ok(index)
+}
+
 #[tauri::command(async)]
 #[specta::specta]
 #[allow(clippy::needless_pass_by_value)]
@@ -204,6 +798,69 @@ pub fn export_pdf(app: AppHandle, comic: Comic) -> CommandResult<()> {
     Ok(())
 }
 
+#[tauri::command(async)]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn export_epub(app: AppHandle, comic: Comic, long_strip: bool) -> CommandResult<()> {
+    let comic_title = comic.title.clone();
+    export::epub(&app, comic, long_strip).context(format!("漫画`{comic_title}`导出epub失败"))?;
+    Ok(())
+}
+
+/// 按配置中的规则，把不同的章节组导出为不同的格式
+#[tauri::command(async)]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn export_by_rules(
+    app: AppHandle,
+    config: State<RwLock<Config>>,
+    comic: Comic,
+    long_strip: bool,
+) -> CommandResult<()> {
+    let (rules, default_format) = {
+        let config = config.read();
+        (
+            config.export_format_rules.clone(),
+            config.default_export_format,
+        )
+    };
+    let comic_title = comic.title.clone();
+    export::by_rules(&app, comic, &rules, default_format, long_strip)
+        .context(format!("漫画`{comic_title}`按规则导出失败"))?;
+    Ok(())
+}
+
+/// 把`comic_dir`中已下载的章节打包成普通zip，`per_chapter`为true时每话一个zip，`out_path`为保存zip的目录，
+/// 否则整本一个zip，`out_path`为zip的路径
+#[tauri::command(async)]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn export_zip(
+    app: AppHandle,
+    comic_dir: PathBuf,
+    out_path: PathBuf,
+    per_chapter: bool,
+) -> CommandResult<()> {
+    export::zip(&app, &comic_dir, &out_path, per_chapter)
+        .context(format!("将`{comic_dir:?}`导出为zip失败"))?;
+    Ok(())
+}
+
+#[tauri::command(async)]
+#[specta::specta]
+pub async fn sync_to_webdav(
+    app: AppHandle,
+    local_dir: PathBuf,
+    remote_url: String,
+    username: String,
+    password: String,
+) -> CommandResult<WebDavSyncReport> {
+    let report = webdav_sync::sync_to_webdav(&app, &local_dir, &remote_url, &username, &password)
+        .await
+        .context(format!("将`{local_dir:?}`同步到`{remote_url}`失败"))?;
+    Ok(report)
+}
+
 #[allow(clippy::cast_possible_wrap)]
 #[tauri::command(async)]
 #[specta::specta]
@@ -223,7 +880,11 @@ pub async fn update_downloaded_comics(
         // 获取最新的漫画信息
         let comic = get_comic(app.state::<ManhuaguiClient>(), downloaded_comic.id).await?;
         // 将最新的漫画信息保存到元数据文件
-        save_metadata(app.state::<RwLock<Config>>(), comic.clone())?;
+        save_metadata(
+            app.state::<RwLock<Config>>(),
+            app.state::<DownloadManager>(),
+            comic.clone(),
+        )?;
 
         latest_comics.push(comic);
         // 发送获取到漫画事件
@@ -265,9 +926,177 @@ pub async fn update_downloaded_comics(
         })
         .collect::<Vec<_>>();
     // 下载未下载章节
-    download_chapters(download_manager, chapters_to_download).await?;
+    download_chapters(
+        download_manager,
+        chapters_to_download,
+        DownloadPriority::Normal,
+        DownloadOrder::OldestFirst,
+    )
+    .await?;
     // 发送下载任务创建完成事件
     let _ = UpdateDownloadedComicsEvent::DownloadTaskCreated.emit(&app);
 
     Ok(())
 }
+
+#[tauri::command(async)]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn export_task_list(
+    config: State<RwLock<Config>>,
+    chapters: Vec<ChapterInfo>,
+    path: PathBuf,
+) -> CommandResult<()> {
+    let download_dir = config.read().download_dir.clone();
+    let tasks = task_list::create_tasks(chapters, &download_dir);
+    let bytes = task_list::export(tasks).context("导出任务清单失败")?;
+    std::fs::write(&path, bytes).context(format!("写入任务清单`{path:?}`失败"))?;
+    Ok(())
+}
+
+#[tauri::command(async)]
+#[specta::specta]
+pub async fn import_task_list(
+    download_manager: State<'_, DownloadManager>,
+    path: PathBuf,
+) -> CommandResult<Vec<DownloadTask>> {
+    let bytes = std::fs::read(&path).context(format!("读取任务清单`{path:?}`失败"))?;
+    let tasks = task_list::import(&bytes).context(format!("解析任务清单`{path:?}`失败"))?;
+    // 导入后直接恢复到下载队列
+    let chapters = tasks
+        .iter()
+        .flat_map(|task| task.chapters.clone())
+        .collect::<Vec<_>>();
+    download_chapters(
+        download_manager,
+        chapters,
+        DownloadPriority::Normal,
+        DownloadOrder::OldestFirst,
+    )
+    .await?;
+    Ok(tasks)
+}
+
+/// 导入其他工具的任务，把其中漫画柜的链接转换为下载任务并加入下载队列
+///
+/// 漫画链接会下载整本漫画中未下载的章节，章节链接只下载对应的章节，
+/// 为了防止被封IP，逐个获取漫画信息，获取失败的漫画跳过，不影响其他漫画
+#[tauri::command(async)]
+#[specta::specta]
+pub async fn import_external_tasks(
+    app: AppHandle,
+    format: ExternalTaskFormat,
+    path: PathBuf,
+) -> CommandResult<ExternalImportReport> {
+    let bytes = std::fs::read(&path).context(format!("读取`{path:?}`失败"))?;
+    let text = String::from_utf8_lossy(&bytes);
+    let (entries, mut skipped) = task_list::parse_external(format, &text);
+
+    // 按漫画分组，保持链接在文件中的顺序
+    let mut comic_ids = Vec::new();
+    let mut chapter_ids_by_comic: HashMap<i64, Option<HashSet<i64>>> = HashMap::new();
+    for entry in entries {
+        let chapter_ids = chapter_ids_by_comic
+            .entry(entry.comic_id)
+            .or_insert_with(|| {
+                comic_ids.push(entry.comic_id);
+                Some(HashSet::new())
+            });
+        match (chapter_ids.as_mut(), entry.chapter_id) {
+            (Some(chapter_ids), Some(chapter_id)) => {
+                chapter_ids.insert(chapter_id);
+            }
+            // 只要有一个链接指向整本漫画，就下载整本
+            (_, None) => *chapter_ids = None,
+            (None, Some(_)) => {}
+        }
+    }
+
+    let mut chapters = Vec::new();
+    for comic_id in comic_ids {
+        let comic = match get_comic(app.state::<ManhuaguiClient>(), comic_id).await {
+            Ok(comic) => comic,
+            Err(err) => {
+                skipped.push(format!("获取漫画`{comic_id}`失败: {}", err.message));
+                continue;
+            }
+        };
+        if let Err(err) = save_metadata(
+            app.state::<RwLock<Config>>(),
+            app.state::<DownloadManager>(),
+            comic.clone(),
+        ) {
+            skipped.push(err.message);
+            continue;
+        }
+        let wanted_chapter_ids = chapter_ids_by_comic.remove(&comic_id).flatten();
+        let comic_chapters = comic.flatten_chapters();
+        if let Some(wanted_chapter_ids) = &wanted_chapter_ids {
+            let missing = wanted_chapter_ids
+                .iter()
+                .filter(|chapter_id| {
+                    !comic_chapters
+                        .iter()
+                        .any(|chapter_info| chapter_info.chapter_id == **chapter_id)
+                })
+                .map(ToString::to_string)
+                .collect::<Vec<_>>();
+            if !missing.is_empty() {
+                let comic_title = &comic.title;
+                let missing = missing.join(", ");
+                skipped.push(format!("漫画`{comic_title}`中没有章节`{missing}`"));
+            }
+        }
+        chapters.extend(comic_chapters.into_iter().filter(|chapter_info| {
+            let is_wanted = wanted_chapter_ids
+                .as_ref()
+                .is_none_or(|chapter_ids| chapter_ids.contains(&chapter_info.chapter_id));
+            is_wanted && !chapter_info.is_downloaded.unwrap_or(false)
+        }));
+    }
+
+    let download_dir = app.state::<RwLock<Config>>().read().download_dir.clone();
+    let tasks = task_list::create_tasks(chapters.clone(), &download_dir);
+    download_chapters(
+        app.state::<DownloadManager>(),
+        chapters,
+        DownloadPriority::Normal,
+        DownloadOrder::OldestFirst,
+    )
+    .await?;
+    Ok(ExternalImportReport { tasks, skipped })
+}
+
+#[tauri::command(async)]
+#[specta::specta]
+pub async fn diagnose(app: AppHandle) -> DiagnoseReport {
+    diagnose::diagnose(&app).await
+}
+
+#[tauri::command(async)]
+#[specta::specta]
+pub async fn benchmark_image_servers(
+    manhuagui_client: State<'_, ManhuaguiClient>,
+) -> CommandResult<Vec<ServerSpeed>> {
+    let speeds = manhuagui_client
+        .benchmark_image_servers()
+        .await
+        .context("图片服务器测速失败")?;
+    Ok(speeds)
+}
+
+#[tauri::command(async)]
+#[specta::specta]
+#[allow(clippy::needless_pass_by_value)]
+pub fn get_request_metrics(manhuagui_client: State<ManhuaguiClient>) -> MetricsSnapshot {
+    manhuagui_client.metrics()
+}
+
+#[tauri::command(async)]
+#[specta::specta]
+pub async fn auto_detect_proxy() -> CommandResult<Vec<ProxyCandidate>> {
+    let candidates = proxy_detect::auto_detect_proxy()
+        .await
+        .context("探测本地代理失败")?;
+    Ok(candidates)
+}
@@ -1,15 +1,231 @@
-use std::path::PathBuf;
+use std::{collections::HashMap, path::PathBuf};
 
+use anyhow::Context;
 use serde::{Deserialize, Serialize};
 use specta::Type;
 use tauri::{AppHandle, Manager};
 
+use crate::{downloaded_checker::DownloadedCheckStrategy, image_format::ImageCrop, site::Site};
+
+/// 请求时携带的`Accept-Language`，漫画柜会据此返回简体或繁体的标题
+#[derive(Default, Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize, Type)]
+pub enum AcceptLanguage {
+    /// 不携带，由漫画柜自己决定
+    #[default]
+    Auto,
+    /// 简体
+    Simplified,
+    /// 繁体
+    Traditional,
+}
+
+impl AcceptLanguage {
+    pub fn header_value(self) -> Option<&'static str> {
+        match self {
+            AcceptLanguage::Auto => None,
+            AcceptLanguage::Simplified => Some("zh-CN,zh;q=0.9"),
+            AcceptLanguage::Traditional => Some("zh-TW,zh;q=0.9"),
+        }
+    }
+}
+
+/// 章节列表中href相同的章节的去重范围，重复时保留第一次出现的
+#[derive(Default, Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize, Type)]
+pub enum ChapterDedupeScope {
+    /// 不去重
+    Disabled,
+    /// 只在同一个分页内去重
+    Page,
+    /// 在同一个章节组(单话、单行本...)的所有分页间去重
+    #[default]
+    Group,
+    /// 在所有章节组间去重，同一话同时出现在单话和单行本里时只会保留一个
+    Comic,
+}
+
+/// 要下载的章节已经在其他目录下载过时(以下载历史为准)的处理方式
+#[derive(Default, Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize, Type)]
+pub enum CrossDirDedupe {
+    /// 照常重新下载
+    #[default]
+    Disabled,
+    /// 跳过这一话
+    Skip,
+    /// 把已下载的图片硬链接到新目录，不在同一个磁盘上时改为复制
+    HardLink,
+}
+
+/// 章节目录在漫画目录下的结构
+#[derive(Default, Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize, Type)]
+pub enum ChapterDirLayout {
+    /// `漫画标题/组名/序号 章节标题`，每个章节组(单话、单行本...)一个子目录
+    #[default]
+    ByGroup,
+    /// `漫画标题/组名 序号 章节标题`，所有章节平铺在漫画目录下
+    Flat,
+}
+
+/// 下载图片的质量
+#[derive(Default, Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize, Type)]
+pub enum ImageQuality {
+    /// 原图
+    #[default]
+    Original,
+    /// 压缩图，优先使用漫画柜提供的webp压缩图，没有时下载原图后在本地按比例缩小
+    Compressed,
+}
+
+/// 导出的格式
+#[derive(
+    Default, Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, Serialize, Deserialize, Type,
+)]
+pub enum ExportFormat {
+    #[default]
+    Cbz,
+    Pdf,
+    Epub,
+}
+
 #[derive(Debug, Clone, Serialize, Deserialize, Type)]
 #[serde(rename_all = "camelCase")]
 pub struct Config {
     pub cookie: String,
     pub download_dir: PathBuf,
     pub export_dir: PathBuf,
+    /// 缓存目录，存放下载中的章节等临时数据，可以和下载目录放在不同的磁盘上
+    #[serde(default)]
+    pub cache_dir: PathBuf,
+    /// 下载到webp动图时是否转换为gif动图，gif的兼容性更好，但体积更大
+    #[serde(default)]
+    pub convert_animated_webp_to_gif: bool,
+    /// 调试模式，解析漫画详情页失败时把原始html保存到调试目录
+    #[serde(default)]
+    pub debug_mode: bool,
+    /// 每下载完一个章节就立即导出为cbz
+    #[serde(default)]
+    pub export_cbz_on_chapter_completed: bool,
+    /// 自动导出cbz后删除原图，章节目录会保留，这样章节仍被视为已下载，但之后手动导出时会跳过这些章节
+    #[serde(default)]
+    pub delete_images_after_auto_export: bool,
+    /// 请求时携带的`Accept-Language`，固定下来可以避免同一本漫画在不同时间下载时标题简繁不一
+    #[serde(default)]
+    pub accept_language: AcceptLanguage,
+    /// 章节列表中href相同的章节的去重范围
+    #[serde(default)]
+    pub chapter_dedupe_scope: ChapterDedupeScope,
+    /// 新下载的漫画的章节目录结构，已有元数据的漫画沿用元数据中记录的结构
+    #[serde(default)]
+    pub chapter_dir_layout: ChapterDirLayout,
+    /// 代理地址，例如`http://127.0.0.1:7890`、`socks5://127.0.0.1:1080`，为空时使用系统代理
+    #[serde(default)]
+    pub proxy: String,
+    /// 下载图片的质量
+    #[serde(default)]
+    pub image_quality: ImageQuality,
+    /// 网站没有提供压缩图时，在本地把图片缩小到原来的百分之多少
+    #[serde(default = "default_compressed_image_scale")]
+    pub compressed_image_scale: u32,
+    /// 判断章节是否已下载的策略
+    #[serde(default)]
+    pub downloaded_check_strategy: DownloadedCheckStrategy,
+    /// WebDAV服务器上用于存放漫画的目录，例如`https://nas.local/dav/漫画`
+    #[serde(default)]
+    pub webdav_url: String,
+    /// WebDAV用户名，为空时不认证
+    #[serde(default)]
+    pub webdav_username: String,
+    /// WebDAV密码，只保存在内存中，不会写入配置文件，重启后需要重新输入
+    #[serde(default)]
+    pub webdav_password: String,
+    /// 按规则导出时，章节组名(单话、单行本...)->导出格式
+    #[serde(default)]
+    pub export_format_rules: HashMap<String, ExportFormat>,
+    /// 按规则导出时，`export_format_rules`中没有的章节组使用的导出格式
+    #[serde(default)]
+    pub default_export_format: ExportFormat,
+    /// 导出pdf时是否把条漫的长图切成多页，方便在电子书阅读器上翻页阅读
+    #[serde(default)]
+    pub split_long_images_in_pdf: bool,
+    /// 导出cbz、pdf时是否用标注了页码的占位图代替缺失的页，保证页码连续、阅读不错位
+    #[serde(default = "default_placeholder_for_missing_pages")]
+    pub placeholder_for_missing_pages: bool,
+    /// 下载时是否裁掉图片顶部和底部的水印条，动图不会被裁剪
+    #[serde(default)]
+    pub crop_watermark: bool,
+    /// 图片宽度为`crop_reference_width`时，顶部要裁掉的像素数
+    #[serde(default)]
+    pub crop_top: u32,
+    /// 图片宽度为`crop_reference_width`时，底部要裁掉的像素数
+    #[serde(default)]
+    pub crop_bottom: u32,
+    /// `crop_top`和`crop_bottom`对应的图片宽度，其他宽度的图片按比例换算
+    #[serde(default = "default_crop_reference_width")]
+    pub crop_reference_width: u32,
+    /// 最多保留多少条搜索历史
+    #[serde(default = "default_search_history_limit")]
+    pub search_history_limit: u32,
+    /// 最多同时下载多少本漫画，0表示不限制，同一本漫画的章节之间不受影响
+    #[serde(default)]
+    pub max_active_comics: u32,
+    /// 本地图片代理服务监听的端口，0表示由系统分配，修改后重启软件生效
+    #[serde(default)]
+    pub image_proxy_port: u16,
+    /// 是否允许局域网内的其他设备访问OPDS目录，开启后本地服务监听所有网卡，修改后重启软件生效
+    #[serde(default)]
+    pub opds_lan_access: bool,
+    /// 获取漫画、搜索和章节时使用的站点，切换后按对应站点的页面结构解析
+    #[serde(default)]
+    pub site: Site,
+    /// 最多同时请求并解析多少个网页，例如获取漫画时补抓的章节分页，修改后对之后的解析生效
+    #[serde(default = "default_parse_concurrency")]
+    pub parse_concurrency: u32,
+    /// 附加在所有请求上的自定义header，不会覆盖User-Agent、Referer等请求本身已有的header
+    #[serde(default)]
+    pub extra_headers: HashMap<String, String>,
+    /// 要下载的章节已经在其他目录下载过时的处理方式，默认关闭以免意外跳过
+    #[serde(default)]
+    pub cross_dir_dedupe: CrossDirDedupe,
+    /// 按最近的成功率和传输速度自动调整同时下载的图片数，关闭时一张一张下载
+    #[serde(default = "default_adaptive_image_concurrency")]
+    pub adaptive_image_concurrency: bool,
+    /// 是否允许使用HTTP/2，关闭时强制使用HTTP/1.1，有些代理对HTTP/2支持不好，连接异常时可以关掉试试
+    #[serde(default = "default_http2_enabled")]
+    pub http2_enabled: bool,
+    /// 是否跳过TLS证书校验，只能通过`set_accept_invalid_certs`修改，保存后重启仍然生效
+    #[serde(default)]
+    pub accept_invalid_certs: bool,
+    /// 额外信任的PEM格式根证书，只能通过`add_root_ca`添加，保存的是证书内容，原文件移走后仍然生效
+    #[serde(default)]
+    pub root_ca_pems: Vec<String>,
+}
+
+fn default_compressed_image_scale() -> u32 {
+    50
+}
+
+fn default_placeholder_for_missing_pages() -> bool {
+    true
+}
+
+fn default_search_history_limit() -> u32 {
+    20
+}
+
+fn default_crop_reference_width() -> u32 {
+    800
+}
+
+fn default_adaptive_image_concurrency() -> bool {
+    true
+}
+
+fn default_http2_enabled() -> bool {
+    true
+}
+
+/// 默认保守一些，同时解析的网页太多容易触发风控
+fn default_parse_concurrency() -> u32 {
+    2
 }
 
 impl Config {
@@ -20,22 +236,86 @@ impl Config {
             cookie: String::new(),
             download_dir: app_data_dir.join("漫画下载"),
             export_dir: app_data_dir.join("漫画导出"),
+            cache_dir: app_data_dir.join("缓存"),
+            convert_animated_webp_to_gif: false,
+            debug_mode: false,
+            export_cbz_on_chapter_completed: false,
+            delete_images_after_auto_export: false,
+            accept_language: AcceptLanguage::Auto,
+            chapter_dedupe_scope: ChapterDedupeScope::Group,
+            chapter_dir_layout: ChapterDirLayout::default(),
+            proxy: String::new(),
+            image_quality: ImageQuality::Original,
+            compressed_image_scale: default_compressed_image_scale(),
+            downloaded_check_strategy: DownloadedCheckStrategy::PathExists,
+            webdav_url: String::new(),
+            webdav_username: String::new(),
+            webdav_password: String::new(),
+            export_format_rules: HashMap::new(),
+            default_export_format: ExportFormat::default(),
+            split_long_images_in_pdf: false,
+            placeholder_for_missing_pages: default_placeholder_for_missing_pages(),
+            crop_watermark: false,
+            crop_top: 0,
+            crop_bottom: 0,
+            crop_reference_width: default_crop_reference_width(),
+            search_history_limit: default_search_history_limit(),
+            max_active_comics: 0,
+            image_proxy_port: 0,
+            opds_lan_access: false,
+            site: Site::default(),
+            parse_concurrency: default_parse_concurrency(),
+            extra_headers: HashMap::new(),
+            cross_dir_dedupe: CrossDirDedupe::Disabled,
+            adaptive_image_concurrency: default_adaptive_image_concurrency(),
+            http2_enabled: default_http2_enabled(),
+            accept_invalid_certs: false,
+            root_ca_pems: Vec::new(),
         };
         // 如果配置文件存在且能够解析，则使用配置文件中的配置，否则使用默认配置
-        let config = if config_path.exists() {
+        let mut config = if config_path.exists() {
             let config_string = std::fs::read_to_string(config_path)?;
             serde_json::from_str(&config_string).unwrap_or(default_config)
         } else {
             default_config
         };
+        // 旧版本的配置文件中没有缓存目录
+        if config.cache_dir.as_os_str().is_empty() {
+            config.cache_dir = app_data_dir.join("缓存");
+        }
         config.save(app)?;
         Ok(config)
     }
 
+    /// 没有开启裁剪水印或者不需要裁剪时返回None
+    pub fn image_crop(&self) -> Option<ImageCrop> {
+        let need_crop = self.crop_watermark && (self.crop_top > 0 || self.crop_bottom > 0);
+        need_crop.then_some(ImageCrop {
+            top: self.crop_top,
+            bottom: self.crop_bottom,
+            reference_width: self.crop_reference_width,
+        })
+    }
+
+    /// 前端只能通过asset协议读取下载目录和缓存目录中的文件(阅读器中的图片、本地封面)，
+    /// 配置文件中不开放任何目录，换了目录后在这里开放新的目录
+    pub fn allow_asset_access(&self, app: &AppHandle) -> anyhow::Result<()> {
+        let scope = app.asset_protocol_scope();
+        for dir in [&self.download_dir, &self.cache_dir] {
+            scope
+                .allow_directory(dir, true)
+                .context(format!("允许前端读取`{dir:?}`失败"))?;
+        }
+        Ok(())
+    }
+
     pub fn save(&self, app: &AppHandle) -> anyhow::Result<()> {
         let app_data_dir = app.path().app_data_dir()?;
         let config_path = app_data_dir.join("config.json");
-        let config_string = serde_json::to_string_pretty(self)?;
+        // 不把密码明文写到磁盘上，旧版本配置文件中的密码也会在这里被清掉
+        let mut config = self.clone();
+        config.webdav_password.clear();
+        let config_string = serde_json::to_string_pretty(&config)?;
         std::fs::write(config_path, config_string)?;
         Ok(())
     }
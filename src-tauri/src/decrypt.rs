@@ -1,9 +1,11 @@
 use std::collections::HashMap;
 
-use anyhow::{anyhow, Context};
+use anyhow::Context;
 use regex::Regex;
 use serde::{Deserialize, Serialize};
 
+use crate::lz_cache;
+
 #[derive(Default, Debug, Clone, PartialEq, Serialize, Deserialize)]
 #[serde(rename_all = "camelCase")]
 #[allow(clippy::module_name_repetitions)]
@@ -48,6 +50,13 @@ pub struct Sl {
     m: String,
 }
 
+impl Sl {
+    /// 移动端的图片url需要带上这个查询字符串，否则图片服务器会拒绝
+    pub fn query_string(&self) -> String {
+        format!("?e={}&m={}", self.e, self.m)
+    }
+}
+
 pub fn decrypt(html: &str) -> anyhow::Result<DecryptResult> {
     let (function, a, c, data) = extract_decryption_data(html)?;
 
@@ -91,10 +100,7 @@ fn extract_decryption_data(html: &str) -> anyhow::Result<(String, i32, i32, Vec<
         .context("匹配到的内容没有compressed_data部分")?
         .as_str();
 
-    let decompressed_data =
-        lz_str::decompress_from_base64(compressed_data).ok_or(anyhow!("lzstring解压缩失败"))?;
-    let decompressed =
-        String::from_utf16(&decompressed_data).context("lzstring解压缩后的数据不是utf-16字符串")?;
+    let decompressed = lz_cache::decompress_from_base64(compressed_data)?;
 
     let data = decompressed
         .split('|')
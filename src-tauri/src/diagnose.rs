@@ -0,0 +1,154 @@
+use std::{
+    path::{Path, PathBuf},
+    time::Instant,
+};
+
+use anyhow::Context;
+use parking_lot::RwLock;
+use serde::{Deserialize, Serialize};
+use specta::Type;
+use tauri::{AppHandle, Manager};
+
+use crate::{
+    config::Config, extensions::AnyhowErrorToStringChain, image_host::DEFAULT_IMAGE_HOSTS,
+    manhuagui_client::ManhuaguiClient, types::PARSER_VERSION, utils::get_available_space,
+};
+
+/// reqwest默认会读取的代理环境变量
+const PROXY_ENV_KEYS: [&str; 6] = [
+    "HTTP_PROXY",
+    "http_proxy",
+    "HTTPS_PROXY",
+    "https_proxy",
+    "ALL_PROXY",
+    "all_proxy",
+];
+
+/// 诊断报告，用户可以直接贴到issue里，所以不包含cookie等敏感信息
+#[derive(Debug, Clone, Serialize, Deserialize, Type)]
+#[serde(rename_all = "camelCase")]
+pub struct DiagnoseReport {
+    /// 软件版本
+    app_version: String,
+    /// 解析规则版本
+    parser_version: u32,
+    /// 配置中的代理，代理的用户名和密码已隐去，为空时使用系统代理
+    proxy: String,
+    /// 环境变量中的代理设置，代理的用户名和密码已隐去
+    proxy_envs: Vec<String>,
+    /// 是否跳过TLS证书校验
+    accept_invalid_certs: bool,
+    /// 额外信任的根证书数量
+    root_ca_count: u32,
+    /// 是否设置了cookie
+    has_cookie: bool,
+    /// 对漫画柜首页和各个图片服务器的连通性
+    connectivities: Vec<Connectivity>,
+    /// 下载目录
+    download_dir: PathBuf,
+    /// 下载目录不可写的原因，可写时为None
+    download_dir_err_msg: Option<String>,
+    /// 下载目录所在磁盘的剩余空间(字节)，获取失败时为None
+    available_space: Option<u64>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, Type)]
+#[serde(rename_all = "camelCase")]
+pub struct Connectivity {
+    url: String,
+    /// 响应的状态码，连接失败时为None
+    status: Option<u16>,
+    /// 从发出请求到收到响应的耗时(毫秒)
+    latency_ms: u32,
+    /// 连接失败的原因
+    err_msg: Option<String>,
+}
+
+#[allow(clippy::cast_possible_truncation)]
+pub async fn diagnose(app: &AppHandle) -> DiagnoseReport {
+    let manhuagui_client = app.state::<ManhuaguiClient>().inner().clone();
+    let (has_cookie, download_dir, proxy) = {
+        let config = app.state::<RwLock<Config>>();
+        let config = config.read();
+        (
+            !config.cookie.is_empty(),
+            config.download_dir.clone(),
+            hide_proxy_credentials(&config.proxy),
+        )
+    };
+
+    let proxy_envs = PROXY_ENV_KEYS
+        .iter()
+        .filter_map(|key| {
+            let value = std::env::var(key).ok()?;
+            Some(format!("{key}={}", hide_proxy_credentials(&value)))
+        })
+        .collect();
+
+    let mut urls = vec!["https://www.manhuagui.com/".to_string()];
+    urls.extend(
+        DEFAULT_IMAGE_HOSTS
+            .iter()
+            .map(|host| format!("https://{host}/")),
+    );
+    let mut connectivities = Vec::new();
+    for url in urls {
+        let start = Instant::now();
+        let result = manhuagui_client.ping(&url).await;
+        let latency_ms = start.elapsed().as_millis() as u32;
+        let connectivity = match result {
+            Ok(status) => Connectivity {
+                url,
+                status: Some(status.as_u16()),
+                latency_ms,
+                err_msg: None,
+            },
+            Err(err) => Connectivity {
+                url,
+                status: None,
+                latency_ms,
+                err_msg: Some(err.to_string_chain()),
+            },
+        };
+        connectivities.push(connectivity);
+    }
+
+    let download_dir_err_msg = check_dir_writable(&download_dir)
+        .err()
+        .map(|err| err.to_string_chain());
+    let available_space = get_available_space(&download_dir).ok();
+
+    DiagnoseReport {
+        app_version: app.package_info().version.to_string(),
+        parser_version: PARSER_VERSION,
+        proxy,
+        proxy_envs,
+        accept_invalid_certs: manhuagui_client.accept_invalid_certs(),
+        root_ca_count: manhuagui_client.root_ca_count() as u32,
+        has_cookie,
+        connectivities,
+        download_dir,
+        download_dir_err_msg,
+        available_space,
+    }
+}
+
+/// 把代理地址中的用户名和密码替换为`***`
+fn hide_proxy_credentials(proxy: &str) -> String {
+    let Some((scheme, rest)) = proxy.split_once("://") else {
+        return proxy.to_string();
+    };
+    match rest.rsplit_once('@') {
+        Some((_, host)) => format!("{scheme}://***@{host}"),
+        None => proxy.to_string(),
+    }
+}
+
+/// 通过实际写入并删除一个文件来检查`dir`是否可写
+fn check_dir_writable(dir: &Path) -> anyhow::Result<()> {
+    std::fs::create_dir_all(dir).context(format!("创建目录`{dir:?}`失败"))?;
+    let test_path = dir.join(".diagnose");
+    std::fs::write(&test_path, b"").context(format!("写入`{test_path:?}`失败"))?;
+    std::fs::remove_file(&test_path).context(format!("删除`{test_path:?}`失败"))?;
+    Ok(())
+}
@@ -0,0 +1,125 @@
+use std::{collections::HashMap, sync::Arc, time::Instant};
+
+use parking_lot::Mutex;
+
+use crate::types::ChapterInfo;
+
+/// 一个章节下载任务的结果
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum ChapterOutcome {
+    Succeeded,
+    /// 下载失败、已被删除或下架
+    Failed,
+    Canceled,
+}
+
+/// 一本漫画或一个批次的统计
+#[derive(Debug, Clone, Copy, Default)]
+pub struct BatchStats {
+    pub succeeded: u32,
+    pub failed: u32,
+    pub canceled: u32,
+    /// 从第一个章节提交到最后一个章节结束的秒数
+    pub elapsed_secs: u64,
+}
+
+impl BatchStats {
+    fn record(&mut self, outcome: ChapterOutcome) {
+        match outcome {
+            ChapterOutcome::Succeeded => self.succeeded += 1,
+            ChapterOutcome::Failed => self.failed += 1,
+            ChapterOutcome::Canceled => self.canceled += 1,
+        }
+    }
+}
+
+/// 章节结束后，刚刚完成的漫画和批次
+#[derive(Debug, Default)]
+pub struct Finished {
+    /// 所有章节都结束了的漫画，依次为漫画id、标题和统计
+    pub comic: Option<(i64, String, BatchStats)>,
+    /// 队列中所有章节都结束了时的整批统计，依次为漫画数和统计
+    pub batch: Option<(u32, BatchStats)>,
+}
+
+struct ComicProgress {
+    comic_title: String,
+    /// 还没结束的章节数
+    pending: u32,
+    stats: BatchStats,
+    started_at: Instant,
+}
+
+#[derive(Default)]
+struct TrackerInner {
+    /// key为漫画id
+    comics: HashMap<i64, ComicProgress>,
+    /// 批次的开始时间，队列空闲时提交第一个章节就开始一个新的批次
+    started_at: Option<Instant>,
+    comic_count: u32,
+    stats: BatchStats,
+}
+
+/// 判断一本漫画、一批任务什么时候全部结束
+///
+/// 章节在提交时计入，结束(成功、失败、取消都算)时减去，
+/// 一本漫画的章节全部结束时这本漫画就完成了，所有漫画都完成时这一批就完成了，
+/// 批次完成前继续提交的章节算在同一批里
+#[derive(Clone, Default)]
+pub struct BatchTracker {
+    inner: Arc<Mutex<TrackerInner>>,
+}
+
+impl BatchTracker {
+    pub fn begin(&self, chapter_info: &ChapterInfo) {
+        let mut inner = self.inner.lock();
+        let now = Instant::now();
+        inner.started_at.get_or_insert(now);
+        if !inner.comics.contains_key(&chapter_info.comic_id) {
+            inner.comic_count += 1;
+        }
+        inner
+            .comics
+            .entry(chapter_info.comic_id)
+            .or_insert_with(|| ComicProgress {
+                comic_title: chapter_info.comic_title.clone(),
+                pending: 0,
+                stats: BatchStats::default(),
+                started_at: now,
+            })
+            .pending += 1;
+    }
+
+    pub fn finish(&self, chapter_info: &ChapterInfo, outcome: ChapterOutcome) -> Finished {
+        let mut inner = self.inner.lock();
+        let mut finished = Finished::default();
+        let comic_id = chapter_info.comic_id;
+        let Some(comic) = inner.comics.get_mut(&comic_id) else {
+            return finished;
+        };
+        comic.pending = comic.pending.saturating_sub(1);
+        comic.stats.record(outcome);
+        let comic_done = comic.pending == 0;
+        inner.stats.record(outcome);
+        if !comic_done {
+            return finished;
+        }
+
+        if let Some(mut comic) = inner.comics.remove(&comic_id) {
+            comic.stats.elapsed_secs = comic.started_at.elapsed().as_secs();
+            finished.comic = Some((comic_id, comic.comic_title, comic.stats));
+        }
+        if !inner.comics.is_empty() {
+            return finished;
+        }
+
+        let mut stats = std::mem::take(&mut inner.stats);
+        stats.elapsed_secs = inner
+            .started_at
+            .take()
+            .map(|started_at| started_at.elapsed().as_secs())
+            .unwrap_or_default();
+        finished.batch = Some((std::mem::take(&mut inner.comic_count), stats));
+        finished
+    }
+}
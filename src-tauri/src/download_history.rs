@@ -0,0 +1,156 @@
+use std::path::{Path, PathBuf};
+
+use anyhow::Context;
+use parking_lot::RwLock;
+use serde::{Deserialize, Serialize};
+use specta::Type;
+use tauri::{AppHandle, Manager};
+
+use crate::{config::Config, types::ChapterInfo, utils::write_atomic};
+
+/// 下载历史文件的文件名，位于`cache_dir`下
+const HISTORY_FILENAME: &str = "download_history.json";
+
+/// 一条下载历史，章节下载完成时记录，删除下载的文件后仍然保留
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize, Type)]
+#[serde(rename_all = "camelCase")]
+pub struct DownloadHistoryEntry {
+    /// 漫画id
+    pub comic_id: i64,
+    /// 漫画标题
+    pub comic_title: String,
+    /// 组名(单话、单行本、番外篇)
+    pub group_name: String,
+    /// 章节id
+    pub chapter_id: i64,
+    /// 章节标题
+    pub chapter_title: String,
+    /// 下载完成时的保存目录
+    pub download_dir: PathBuf,
+    /// 下载完成的时间，RFC 3339格式
+    pub downloaded_at: String,
+}
+
+/// 查询下载历史的条件，为None的条件不参与过滤
+#[derive(Default, Debug, Clone, Serialize, Deserialize, Type)]
+#[serde(rename_all = "camelCase")]
+pub struct DownloadHistoryFilter {
+    /// 只返回这本漫画的历史
+    pub comic_id: Option<i64>,
+    /// 漫画标题或章节标题包含这个关键词
+    pub keyword: Option<String>,
+    /// 最多返回多少条
+    pub limit: Option<u32>,
+}
+
+/// 全局的下载历史，持久化到`cache_dir/download_history.json`
+pub struct DownloadHistory {
+    app: AppHandle,
+    entries: RwLock<Vec<DownloadHistoryEntry>>,
+}
+
+impl DownloadHistory {
+    pub fn new(app: &AppHandle) -> Self {
+        // 历史文件损坏时不应该影响软件启动，直接当作没有历史
+        let entries = load_entries(app).unwrap_or_default();
+        Self {
+            app: app.clone(),
+            entries: RwLock::new(entries),
+        }
+    }
+
+    /// 记录`chapter_info`已下载到`download_dir`，同一章节重复下载时只保留最新的一条
+    pub fn record(&self, chapter_info: &ChapterInfo, download_dir: &Path) -> anyhow::Result<()> {
+        let entry = DownloadHistoryEntry {
+            comic_id: chapter_info.comic_id,
+            comic_title: chapter_info.comic_title.clone(),
+            group_name: chapter_info.group_name.clone(),
+            chapter_id: chapter_info.chapter_id,
+            chapter_title: chapter_info.chapter_title.clone(),
+            download_dir: download_dir.to_path_buf(),
+            downloaded_at: chrono::Local::now().to_rfc3339(),
+        };
+        let mut entries = self.entries.write();
+        entries.retain(|e| !(e.comic_id == entry.comic_id && e.chapter_id == entry.chapter_id));
+        entries.push(entry);
+        save_entries(&self.app, &entries)
+    }
+
+    /// 查找章节的下载记录，没有下载过时返回None
+    pub fn find(&self, comic_id: i64, chapter_id: i64) -> Option<DownloadHistoryEntry> {
+        self.entries
+            .read()
+            .iter()
+            .find(|entry| entry.comic_id == comic_id && entry.chapter_id == chapter_id)
+            .cloned()
+    }
+
+    /// 按条件查询下载历史，最近下载的排在前面
+    pub fn list(&self, filter: &DownloadHistoryFilter) -> Vec<DownloadHistoryEntry> {
+        let keyword = filter.keyword.as_deref().map(str::trim).unwrap_or_default();
+        let limit = filter.limit.map_or(usize::MAX, |limit| limit as usize);
+        self.entries
+            .read()
+            .iter()
+            .rev()
+            .filter(|entry| {
+                filter
+                    .comic_id
+                    .is_none_or(|comic_id| entry.comic_id == comic_id)
+            })
+            .filter(|entry| {
+                keyword.is_empty()
+                    || entry.comic_title.contains(keyword)
+                    || entry.chapter_title.contains(keyword)
+            })
+            .take(limit)
+            .cloned()
+            .collect()
+    }
+
+    pub fn clear(&self) -> anyhow::Result<()> {
+        let mut entries = self.entries.write();
+        entries.clear();
+        save_entries(&self.app, &entries)
+    }
+}
+
+fn get_history_path(app: &AppHandle) -> PathBuf {
+    let cache_dir = app.state::<RwLock<Config>>().read().cache_dir.clone();
+    cache_dir.join(HISTORY_FILENAME)
+}
+
+/// 旧版本把下载历史放在`app_data_dir`下，新位置还没有历史文件时把它搬过来
+fn migrate_legacy_history(app: &AppHandle, history_path: &Path) -> anyhow::Result<()> {
+    let app_data_dir = app
+        .path()
+        .app_data_dir()
+        .context("获取app_data_dir目录失败")?;
+    let legacy_path = app_data_dir.join(HISTORY_FILENAME);
+    if history_path.exists() || !legacy_path.exists() || legacy_path == history_path {
+        return Ok(());
+    }
+    let history_json = std::fs::read(&legacy_path).context(format!("读取`{legacy_path:?}`失败"))?;
+    write_atomic(history_path, history_json)?;
+    std::fs::remove_file(&legacy_path).context(format!("删除`{legacy_path:?}`失败"))?;
+    Ok(())
+}
+
+fn load_entries(app: &AppHandle) -> anyhow::Result<Vec<DownloadHistoryEntry>> {
+    let history_path = get_history_path(app);
+    migrate_legacy_history(app, &history_path)?;
+    if !history_path.exists() {
+        return Ok(Vec::new());
+    }
+    let history_string =
+        std::fs::read_to_string(&history_path).context(format!("读取`{history_path:?}`失败"))?;
+    let entries = serde_json::from_str::<Vec<DownloadHistoryEntry>>(&history_string)
+        .context(format!("将`{history_path:?}`反序列化为下载历史失败"))?;
+    Ok(entries)
+}
+
+fn save_entries(app: &AppHandle, entries: &[DownloadHistoryEntry]) -> anyhow::Result<()> {
+    let history_json =
+        serde_json::to_string_pretty(entries).context("将下载历史序列化为json失败")?;
+    write_atomic(&get_history_path(app), history_json)
+}
@@ -1,26 +1,165 @@
 use std::{
+    collections::{HashMap, HashSet},
     path::{Path, PathBuf},
     sync::{
-        atomic::{AtomicU32, AtomicU64, Ordering},
+        atomic::{AtomicBool, AtomicU32, AtomicU64, Ordering},
         Arc,
     },
-    time::Duration,
+    time::{Duration, Instant},
 };
 
 use anyhow::{anyhow, Context};
 use parking_lot::RwLock;
+use serde::{Deserialize, Serialize};
+use specta::Type;
 use tauri::{AppHandle, Manager};
 use tauri_specta::Event;
 use tokio::{
-    sync::{mpsc, Semaphore},
+    sync::{mpsc, watch, OnceCell},
     task::JoinSet,
 };
 
 use crate::{
-    config::Config, events::DownloadEvent, extensions::AnyhowErrorToStringChain,
-    manhuagui_client::ManhuaguiClient, types::ChapterInfo,
+    adaptive_concurrency::{AdaptiveConcurrency, RequestOutcome},
+    chapter_manifest::{self, ChapterManifest, MANIFEST_FILENAME},
+    config::{Config, CrossDirDedupe, ImageQuality},
+    download_batch::{BatchTracker, ChapterOutcome},
+    download_history::DownloadHistory,
+    download_queue::{ChapterQueue, ComicQueue, DownloadPriority, DownloadQueueState},
+    downloaded_checker::{image_paths, normalize_page_filenames, page_file_stem},
+    events::{DownloadEvent, LogEvent},
+    extensions::AnyhowErrorToStringChain,
+    image_format::{self, ImageCrop, IMAGE_EXTENSIONS},
+    manhuagui_client::{
+        BlockedError, ChapterUnavailableError, DownloadedImage, ManhuaguiClient,
+        TooManyRequestsError,
+    },
+    types::{ChapterInfo, Comic},
+    utils::{get_available_space, move_dir, sub_dirs, write_atomic},
 };
 
+/// 没有已下载的图片可以参考时使用的平均图片大小(300KB)
+const DEFAULT_AVG_IMAGE_SIZE: u64 = 300 * 1024;
+/// 估算所需空间时，最多读取这本漫画已下载的多少张图片的大小作为样本
+const LOCAL_SAMPLE_IMAGE_COUNT: usize = 50;
+/// 超过这个大小(16MB)的图片下载后不读进内存，见`save_downloaded_image`
+const LARGE_IMAGE_SIZE: u64 = 16 * 1024 * 1024;
+/// 封面的文件名(不含扩展名)，位于漫画根目录下
+pub const COVER_FILENAME: &str = "cover";
+/// 下载任务状态文件的文件名，位于`app_data_dir`下
+const TASK_STATES_FILENAME: &str = "download_tasks.json";
+/// 每隔多久把下载任务状态写入状态文件，避免每下载一张图片就写一次盘
+const SAVE_TASK_STATES_INTERVAL: Duration = Duration::from_secs(3);
+/// 用来探测网络是否连通的url
+const NETWORK_PROBE_URL: &str = "https://www.manhuagui.com/";
+/// 等待网络恢复时，每隔多久探测一次
+const NETWORK_PROBE_INTERVAL: Duration = Duration::from_secs(10);
+
+/// 图片服务器返回429但没有给出`Retry-After`时，暂停图片请求的时长
+const DEFAULT_THROTTLE_DURATION: Duration = Duration::from_secs(30);
+/// 暂停图片请求的最长时长，避免`Retry-After`给得太大导致下载长时间卡住
+const MAX_THROTTLE_DURATION: Duration = Duration::from_secs(600);
+/// 同一张图片最多因为429等待多少次，超过后按下载失败处理
+const MAX_THROTTLED_RETRIES: u32 = 5;
+
+/// 章节下载完成后触发的回调，参数为刚下载完成的章节和它的下载目录
+pub type ChapterCompletedCallback = Arc<dyn Fn(&ChapterInfo, &Path) + Send + Sync>;
+
+/// 未完成的下载任务状态，会被持久化到状态文件中，软件重启后可以从中断处继续下载
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize, Type)]
+#[serde(rename_all = "camelCase")]
+pub struct DownloadTaskState {
+    pub chapter_info: ChapterInfo,
+    /// 已下载的图片数量
+    pub downloaded_count: u32,
+    /// 总共需要下载的图片数量，还没开始下载时为0
+    pub total: u32,
+    /// 恢复任务时沿用提交时的优先级
+    #[serde(default)]
+    pub priority: DownloadPriority,
+}
+
+/// 下载前的预览，不会实际下载任何图片
+#[derive(Debug, Clone, Serialize, Deserialize, Type)]
+#[serde(rename_all = "camelCase")]
+pub struct DownloadPlan {
+    pub chapters: Vec<ChapterDownloadPlan>,
+    /// 总页数
+    pub total_pages: u64,
+    /// 预估总大小(字节)
+    pub estimated_size: u64,
+    /// 下载目录所在磁盘的剩余空间(字节)，获取失败时为None
+    pub available_space: Option<u64>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, Type)]
+#[serde(rename_all = "camelCase")]
+pub struct ChapterDownloadPlan {
+    pub chapter_info: ChapterInfo,
+    /// 下载完成后图片所在的目录
+    pub download_dir: PathBuf,
+    /// 页数
+    pub page_count: u64,
+    /// 预估大小(字节)，按默认的平均图片大小估算
+    pub estimated_size: u64,
+    /// 按当前的已下载判断策略，此章节是否已经下载过
+    pub exists: bool,
+}
+
+/// 章节的下载进度，由同一章节的所有图片下载任务共用
+#[derive(Default)]
+struct ChapterProgress {
+    /// 成功下载的图片数量
+    downloaded_count: AtomicU32,
+    /// 成功下载的图片的总字节数，包括上次已经下载好的图片
+    downloaded_bytes: AtomicU64,
+}
+
+impl ChapterProgress {
+    /// 记录一张图片下载成功，返回记录后的图片数量和总字节数
+    fn record(&self, bytes: u64) -> (u32, u64) {
+        let downloaded_count = self.downloaded_count.fetch_add(1, Ordering::Relaxed) + 1;
+        let downloaded_bytes = self.downloaded_bytes.fetch_add(bytes, Ordering::Relaxed) + bytes;
+        (downloaded_count, downloaded_bytes)
+    }
+}
+
+/// 章节在移动端的图片链接，有图片从PC端的图片链接下载失败时才获取，同一章节的所有图片共用
+struct MobileImageUrls {
+    chapter_info: ChapterInfo,
+    urls: OnceCell<Vec<String>>,
+}
+
+impl MobileImageUrls {
+    fn new(chapter_info: ChapterInfo) -> Self {
+        Self {
+            chapter_info,
+            urls: OnceCell::new(),
+        }
+    }
+
+    /// 从移动端的图片链接下载第`index`页(从0开始)到`download_path`
+    async fn download(
+        &self,
+        manhuagui_client: &ManhuaguiClient,
+        index: usize,
+        download_path: &Path,
+    ) -> anyhow::Result<DownloadedImage> {
+        let urls = self
+            .urls
+            .get_or_try_init(|| manhuagui_client.get_mobile_image_urls(&self.chapter_info))
+            .await
+            .context("获取移动端图片链接失败")?;
+        let url = urls
+            .get(index)
+            .ok_or_else(|| anyhow!("移动端只有{}张图片，没有第{}张", urls.len(), index + 1))?;
+        manhuagui_client
+            .download_image_to(url, download_path)
+            .await
+            .context(format!("下载图片`{url}`失败"))
+    }
+}
+
 /// 用于管理下载任务
 ///
 /// 克隆 `DownloadManager` 的开销极小，性能开销几乎可以忽略不计。
@@ -32,32 +171,563 @@ use crate::{
 #[derive(Clone)]
 pub struct DownloadManager {
     app: AppHandle,
-    sender: Arc<mpsc::Sender<ChapterInfo>>,
-    chapter_sem: Arc<Semaphore>,
-    img_sem: Arc<Semaphore>,
+    /// 待处理的章节、优先级以及`ChapterQueue`分配的提交顺序
+    sender: Arc<mpsc::Sender<(ChapterInfo, DownloadPriority, u64)>>,
+    /// 限制同时下载的章节数量，高优先级的章节先开始
+    chapter_queue: ChapterQueue,
+    /// 限制同时下载的图片数，开启自适应时按成功率和传输速度动态调整
+    img_concurrency: AdaptiveConcurrency,
+    /// 限制同时下载的漫画数量
+    comic_queue: ComicQueue,
+    /// 判断漫画和整批任务什么时候下载完成
+    batch_tracker: BatchTracker,
     byte_per_sec: Arc<AtomicU64>,
+    /// 所有未完成的下载任务状态，key为章节id
+    task_states: Arc<RwLock<HashMap<i64, DownloadTaskState>>>,
+    /// `task_states`自上次写入状态文件后是否有变化
+    task_states_dirty: Arc<AtomicBool>,
+    /// 软件启动时从状态文件中加载的未完成任务，等待用户选择继续或放弃
+    restored_task_states: Arc<RwLock<Vec<DownloadTaskState>>>,
+    chapter_completed_callbacks: Arc<RwLock<Vec<ChapterCompletedCallback>>>,
+    /// 已经触发过下载完成回调的章节id，保证同一次下载只触发一次，重新提交时移除
+    completed_chapter_ids: Arc<RwLock<HashSet<i64>>>,
+    /// 用于取消下载的信号，key为漫画id，value为Some时表示已取消，Some中的值表示是否删除临时下载目录
+    cancel_senders: Arc<RwLock<HashMap<i64, watch::Sender<Option<bool>>>>>,
+    /// 用于取消单个章节的信号，key为章节id，value的含义与`cancel_senders`相同
+    chapter_cancel_senders: Arc<RwLock<HashMap<i64, watch::Sender<Option<bool>>>>>,
+    /// 本次运行中检测到已被删除或下架的章节，key为章节id，value为原因，再次提交时直接跳过
+    unavailable_chapters: Arc<RwLock<HashMap<i64, String>>>,
+    /// 是否已暂停，暂停后已开始的图片请求会继续完成，但不会再开始新的请求
+    paused: Arc<watch::Sender<bool>>,
+    /// 单独暂停的章节id，效果与`paused`相同，只是只对这些章节生效
+    paused_chapter_ids: Arc<watch::Sender<HashSet<i64>>>,
+    /// 是否正在等待网络恢复，等待期间遇到网络错误的请求不会失败，而是等网络恢复后重试
+    network_waiting: Arc<watch::Sender<bool>>,
+    /// 图片服务器返回429后，在这个时间之前所有的图片请求都暂停
+    throttled_until: Arc<RwLock<Option<Instant>>>,
+    /// 本次运行中下载的图片的总字节数和张数，用于估算所需空间
+    downloaded_image_stats: Arc<RwLock<(u64, u64)>>,
 }
 
 impl DownloadManager {
     pub fn new(app: &AppHandle) -> Self {
-        let (sender, receiver) = mpsc::channel::<ChapterInfo>(32);
+        let (sender, receiver) = mpsc::channel::<(ChapterInfo, DownloadPriority, u64)>(32);
+        let (max_active_comics, adaptive_image_concurrency) = {
+            let config = app.state::<RwLock<Config>>();
+            let config = config.read();
+            (config.max_active_comics, config.adaptive_image_concurrency)
+        };
+        // 状态文件损坏时不应该影响软件启动，直接当作没有未完成的任务
+        let restored_task_states = load_task_states(app).unwrap_or_default();
+        let task_states = restored_task_states
+            .iter()
+            .map(|task_state| (task_state.chapter_info.chapter_id, task_state.clone()))
+            .collect::<HashMap<_, _>>();
 
         let manager = DownloadManager {
             app: app.clone(),
             sender: Arc::new(sender),
-            chapter_sem: Arc::new(Semaphore::new(1)),
-            img_sem: Arc::new(Semaphore::new(1)),
+            chapter_queue: ChapterQueue::new(1),
+            img_concurrency: AdaptiveConcurrency::new(adaptive_image_concurrency),
+            comic_queue: ComicQueue::new(max_active_comics),
+            batch_tracker: BatchTracker::default(),
             byte_per_sec: Arc::new(AtomicU64::new(0)),
+            task_states: Arc::new(RwLock::new(task_states)),
+            task_states_dirty: Arc::new(AtomicBool::new(false)),
+            restored_task_states: Arc::new(RwLock::new(restored_task_states)),
+            chapter_completed_callbacks: Arc::new(RwLock::new(Vec::new())),
+            completed_chapter_ids: Arc::new(RwLock::new(HashSet::new())),
+            cancel_senders: Arc::new(RwLock::new(HashMap::new())),
+            chapter_cancel_senders: Arc::new(RwLock::new(HashMap::new())),
+            unavailable_chapters: Arc::new(RwLock::new(HashMap::new())),
+            paused: Arc::new(watch::channel(false).0),
+            paused_chapter_ids: Arc::new(watch::channel(HashSet::new()).0),
+            network_waiting: Arc::new(watch::channel(false).0),
+            throttled_until: Arc::new(RwLock::new(None)),
+            downloaded_image_stats: Arc::new(RwLock::new((0, 0))),
         };
 
         tauri::async_runtime::spawn(Self::log_download_speed(app.clone()));
         tauri::async_runtime::spawn(Self::receiver_loop(app.clone(), receiver));
+        tauri::async_runtime::spawn(Self::save_task_states_loop(app.clone()));
 
         manager
     }
 
-    pub async fn submit_chapter(&self, chapter_info: ChapterInfo) -> anyhow::Result<()> {
-        self.sender.send(chapter_info).await?;
+    pub async fn submit_chapter(
+        &self,
+        chapter_info: ChapterInfo,
+        priority: DownloadPriority,
+    ) -> anyhow::Result<()> {
+        let task_state = DownloadTaskState {
+            chapter_info: chapter_info.clone(),
+            downloaded_count: 0,
+            total: 0,
+            priority,
+        };
+        self.task_states
+            .write()
+            .insert(chapter_info.chapter_id, task_state);
+        self.task_states_dirty.store(true, Ordering::Relaxed);
+        // 重新提交(例如删除后重新下载)的章节下载完成后要再次触发回调
+        self.completed_chapter_ids
+            .write()
+            .remove(&chapter_info.chapter_id);
+        // 在提交时就计入批次，避免前面的章节很快结束时被误判为整批完成
+        self.batch_tracker.begin(&chapter_info);
+        // 按提交的先后(也就是`sort_for_download`排好的顺序)领取章节名额
+        let seq = self.chapter_queue.next_seq();
+        self.sender.send((chapter_info, priority, seq)).await?;
+        Ok(())
+    }
+
+    /// 补下已下载章节中缺失的图片
+    ///
+    /// 已有的图片会先复制到临时下载目录，下载时会跳过它们，全部下载完成后整个章节目录被替换。
+    /// 章节有校验和清单时清单也一起复制，续传前会按清单删掉损坏的图片
+    pub async fn submit_repair(&self, chapter_info: ChapterInfo) -> anyhow::Result<()> {
+        let download_dir = self
+            .app
+            .state::<RwLock<Config>>()
+            .read()
+            .download_dir
+            .clone();
+        let chapter_download_dir = get_chapter_download_dir(&download_dir, &chapter_info);
+        let temp_download_dir = get_temp_download_dir(&self.app, &chapter_info);
+        std::fs::create_dir_all(&temp_download_dir)
+            .context(format!("创建目录`{temp_download_dir:?}`失败"))?;
+        for path in image_paths(&chapter_download_dir) {
+            let Some(file_name) = path.file_name() else {
+                continue;
+            };
+            let target = temp_download_dir.join(file_name);
+            if !target.exists() {
+                std::fs::copy(&path, &target)
+                    .context(format!("将`{path:?}`复制到`{target:?}`失败"))?;
+            }
+        }
+        let manifest_path = chapter_download_dir.join(MANIFEST_FILENAME);
+        if manifest_path.is_file() {
+            let target = temp_download_dir.join(MANIFEST_FILENAME);
+            std::fs::copy(&manifest_path, &target)
+                .context(format!("将`{manifest_path:?}`复制到`{target:?}`失败"))?;
+        }
+        self.submit_chapter(chapter_info, DownloadPriority::High)
+            .await
+    }
+
+    /// 设置最多同时下载多少本漫画，0表示不限制
+    pub fn set_max_active_comics(&self, max_active_comics: u32) {
+        self.comic_queue.set_max_active_comics(max_active_comics);
+    }
+
+    /// 开关图片下载的自适应并发
+    pub fn set_adaptive_image_concurrency(&self, enabled: bool) {
+        self.img_concurrency.set_enabled(enabled);
+    }
+
+    pub fn queue_state(&self) -> DownloadQueueState {
+        self.comic_queue.state()
+    }
+
+    /// 暂停所有下载任务，已提交的章节保留在队列中，恢复后从暂停处继续
+    pub fn pause(&self) {
+        self.paused.send_replace(true);
+    }
+
+    pub fn resume(&self) {
+        self.paused.send_replace(false);
+    }
+
+    pub fn is_paused(&self) -> bool {
+        *self.paused.borrow()
+    }
+
+    /// 单独暂停这些章节，不在任务列表中的章节会被忽略，每个被暂停的章节都会发送`ChapterPaused`事件
+    pub fn pause_chapters(&self, chapter_ids: &[i64]) {
+        let chapter_ids = self.filter_task_chapter_ids(chapter_ids);
+        self.paused_chapter_ids
+            .send_modify(|paused_chapter_ids| paused_chapter_ids.extend(&chapter_ids));
+        for chapter_id in chapter_ids {
+            let _ = DownloadEvent::ChapterPaused { chapter_id }.emit(&self.app);
+        }
+    }
+
+    /// 恢复单独暂停的章节，全局暂停时要等全局恢复后才会继续
+    pub fn resume_chapters(&self, chapter_ids: &[i64]) {
+        let mut resumed_ids = Vec::new();
+        self.paused_chapter_ids
+            .send_if_modified(|paused_chapter_ids| {
+                resumed_ids = chapter_ids
+                    .iter()
+                    .copied()
+                    .filter(|chapter_id| paused_chapter_ids.remove(chapter_id))
+                    .collect();
+                !resumed_ids.is_empty()
+            });
+        for chapter_id in resumed_ids {
+            let _ = DownloadEvent::ChapterResumed { chapter_id }.emit(&self.app);
+        }
+    }
+
+    /// 单独暂停的章节id
+    pub fn paused_chapter_ids(&self) -> Vec<i64> {
+        self.paused_chapter_ids.borrow().iter().copied().collect()
+    }
+
+    /// 漫画`comic_id`所有未完成的章节id
+    pub fn chapter_ids_of_comic(&self, comic_id: i64) -> Vec<i64> {
+        self.task_states
+            .read()
+            .values()
+            .filter(|task_state| task_state.chapter_info.comic_id == comic_id)
+            .map(|task_state| task_state.chapter_info.chapter_id)
+            .collect()
+    }
+
+    fn filter_task_chapter_ids(&self, chapter_ids: &[i64]) -> Vec<i64> {
+        let task_states = self.task_states.read();
+        chapter_ids
+            .iter()
+            .copied()
+            .filter(|chapter_id| task_states.contains_key(chapter_id))
+            .collect()
+    }
+
+    /// 所有未完成的下载任务状态，包括排队中、下载中和暂停中的任务
+    pub fn task_states(&self) -> Vec<DownloadTaskState> {
+        self.task_states.read().values().cloned().collect()
+    }
+
+    /// 是否全局暂停或章节`chapter_id`被单独暂停了
+    fn is_paused(&self, chapter_id: i64) -> bool {
+        *self.paused.borrow() || self.paused_chapter_ids.borrow().contains(&chapter_id)
+    }
+
+    /// 全局暂停或章节`chapter_id`被单独暂停时一直等待，直到两者都恢复
+    async fn wait_until_resumed(&self, chapter_id: i64) {
+        let mut paused_receiver = self.paused.subscribe();
+        let mut paused_chapter_ids_receiver = self.paused_chapter_ids.subscribe();
+        loop {
+            // sender和manager同生共死，wait_for不会因为sender被drop而失败
+            let _ = paused_receiver.wait_for(|paused| !paused).await;
+            let _ = paused_chapter_ids_receiver
+                .wait_for(|paused_chapter_ids| !paused_chapter_ids.contains(&chapter_id))
+                .await;
+            // 等待章节恢复期间可能又全局暂停了
+            if !*paused_receiver.borrow() {
+                break;
+            }
+        }
+    }
+
+    /// 请求遇到网络错误时调用，返回true表示网络确实断开了，调用方应该等网络恢复后重试，
+    /// 返回false表示网络是通的，只是这个请求失败了，调用方应该按失败处理
+    ///
+    /// 第一个发现网络断开的任务会启动后台探测，网络恢复后所有等待中的任务一起继续
+    async fn should_wait_for_network(&self) -> bool {
+        if *self.network_waiting.borrow() {
+            return true;
+        }
+        if self
+            .manhuagui_client()
+            .ping(NETWORK_PROBE_URL)
+            .await
+            .is_ok()
+        {
+            return false;
+        }
+        // send_replace返回旧值，保证只有一个任务启动探测
+        if !self.network_waiting.send_replace(true) {
+            let _ = DownloadEvent::NetworkWaiting.emit(&self.app);
+            tauri::async_runtime::spawn(self.clone().probe_network_until_restored());
+        }
+        true
+    }
+
+    async fn probe_network_until_restored(self) {
+        loop {
+            tokio::time::sleep(NETWORK_PROBE_INTERVAL).await;
+            if self
+                .manhuagui_client()
+                .ping(NETWORK_PROBE_URL)
+                .await
+                .is_ok()
+            {
+                break;
+            }
+        }
+        self.network_waiting.send_replace(false);
+        let _ = DownloadEvent::NetworkRestored.emit(&self.app);
+    }
+
+    /// 图片服务器返回429时调用，让所有图片请求都暂停到限流结束，返回暂停的时长
+    ///
+    /// 多个请求同时遇到429时，以最晚的结束时间为准。
+    /// 同时图片下载的并发数已经在报告`RequestOutcome::Throttled`时减半，限流结束后不会一下子恢复原来的并发
+    fn throttle(&self, retry_after: Option<Duration>) -> Duration {
+        let duration = retry_after
+            .unwrap_or(DEFAULT_THROTTLE_DURATION)
+            .min(MAX_THROTTLE_DURATION);
+        let until = Instant::now() + duration;
+        let mut throttled_until = self.throttled_until.write();
+        if throttled_until.map_or(true, |throttled_until| throttled_until < until) {
+            *throttled_until = Some(until);
+        }
+        duration
+    }
+
+    /// 等到429的限流结束，没有被限流时立即返回
+    async fn wait_until_unthrottled(&self) {
+        let throttled_until = *self.throttled_until.read();
+        if let Some(throttled_until) = throttled_until {
+            tokio::time::sleep_until(throttled_until.into()).await;
+        }
+    }
+
+    /// 按下载历史查找这一话在其他目录中下载完成的位置，返回处理方式和那个目录
+    ///
+    /// 没有开启全局去重、没有下载记录、记录的就是这次的下载目录或者那个目录中的下载已经不完整了时返回None
+    fn find_downloaded_elsewhere(
+        &self,
+        chapter_info: &ChapterInfo,
+    ) -> Option<(CrossDirDedupe, PathBuf)> {
+        let (cross_dir_dedupe, chapter_download_dir) = {
+            let config = self.app.state::<RwLock<Config>>();
+            let config = config.read();
+            (
+                config.cross_dir_dedupe,
+                get_chapter_download_dir(&config.download_dir, chapter_info),
+            )
+        };
+        if cross_dir_dedupe == CrossDirDedupe::Disabled {
+            return None;
+        }
+        let entry = self
+            .app
+            .state::<DownloadHistory>()
+            .find(chapter_info.comic_id, chapter_info.chapter_id)?;
+        if entry.download_dir == chapter_download_dir
+            || !is_complete_download(&entry.download_dir, chapter_info.chapter_size)
+        {
+            return None;
+        }
+        Some((cross_dir_dedupe, entry.download_dir))
+    }
+
+    /// 等待网络断开时一直等待，直到网络恢复
+    async fn wait_until_network_restored(&self) {
+        let mut network_waiting_receiver = self.network_waiting.subscribe();
+        // sender和manager同生共死，wait_for不会因为sender被drop而失败
+        let _ = network_waiting_receiver
+            .wait_for(|network_waiting| !network_waiting)
+            .await;
+    }
+
+    /// 注册章节下载完成的回调
+    ///
+    /// 回调在章节的所有图片都通过完整性校验、并从临时目录移动到下载目录后才会触发，
+    /// 同一章节只触发一次，回调在阻塞线程池中执行，可以在其中做打包等耗时操作
+    pub fn on_chapter_completed(
+        &self,
+        callback: impl Fn(&ChapterInfo, &Path) + Send + Sync + 'static,
+    ) {
+        self.chapter_completed_callbacks
+            .write()
+            .push(Arc::new(callback));
+    }
+
+    /// 获取软件启动时从状态文件中加载的未完成任务
+    pub fn restored_task_states(&self) -> Vec<DownloadTaskState> {
+        self.restored_task_states.read().clone()
+    }
+
+    /// 继续下载软件启动时加载的未完成任务，已下载的图片会被跳过
+    pub async fn resume_restored_tasks(&self) -> anyhow::Result<()> {
+        let restored_task_states = std::mem::take(&mut *self.restored_task_states.write());
+        for task_state in restored_task_states {
+            self.submit_chapter(task_state.chapter_info, task_state.priority)
+                .await?;
+        }
+        Ok(())
+    }
+
+    /// 放弃软件启动时加载的未完成任务
+    pub fn discard_restored_tasks(&self) {
+        let restored_task_states = std::mem::take(&mut *self.restored_task_states.write());
+        let mut task_states = self.task_states.write();
+        for task_state in restored_task_states {
+            task_states.remove(&task_state.chapter_info.chapter_id);
+        }
+        drop(task_states);
+        self.task_states_dirty.store(true, Ordering::Relaxed);
+    }
+
+    /// 估算下载`chapters`需要多少磁盘空间(字节)，即平均图片大小乘以总页数，不会发出任何请求
+    #[allow(clippy::cast_sign_loss)]
+    pub fn estimate_size(&self, chapters: &[ChapterInfo]) -> u64 {
+        let total_pages = chapters
+            .iter()
+            .map(|chapter_info| chapter_info.chapter_size.max(0) as u64)
+            .sum::<u64>();
+        total_pages * self.avg_image_size(chapters)
+    }
+
+    /// 平均图片大小，优先用这本漫画已下载的图片，其次用本次运行中下载过的图片，都没有时用默认值
+    fn avg_image_size(&self, chapters: &[ChapterInfo]) -> u64 {
+        let download_dir = self
+            .app
+            .state::<RwLock<Config>>()
+            .read()
+            .download_dir
+            .clone();
+        if let Some(avg_image_size) = chapters.first().and_then(|chapter_info| {
+            local_avg_image_size(&download_dir.join(&chapter_info.comic_title))
+        }) {
+            return avg_image_size;
+        }
+        let (total_bytes, count) = *self.downloaded_image_stats.read();
+        if count > 0 {
+            return total_bytes / count;
+        }
+        DEFAULT_AVG_IMAGE_SIZE
+    }
+
+    /// 在后台把`comic`的封面下载到漫画根目录，保存为`cover.{扩展名}`，已经存在时跳过
+    ///
+    /// 媒体库(Komga、Kavita等)会读取漫画目录下的cover图片作为封面，下载失败不影响章节下载，只记录日志
+    pub fn download_cover(&self, comic: &Comic) {
+        let manager = self.clone();
+        let comic_title = comic.title.clone();
+        let comic_dir_name = comic.dir_name.clone();
+        let cover_url = comic.cover.clone();
+        tauri::async_runtime::spawn(async move {
+            if let Err(err) = manager
+                .download_cover_inner(&comic_dir_name, &cover_url)
+                .await
+            {
+                let err = err.context(format!("下载`{comic_title}`的封面失败"));
+                let _ = LogEvent::Warn {
+                    msg: err.to_string_chain(),
+                }
+                .emit(&manager.app);
+            }
+        });
+    }
+
+    async fn download_cover_inner(
+        &self,
+        comic_dir_name: &str,
+        cover_url: &str,
+    ) -> anyhow::Result<()> {
+        let comic_dir = self
+            .app
+            .state::<RwLock<Config>>()
+            .read()
+            .download_dir
+            .join(comic_dir_name);
+        let save_path = comic_dir.join(COVER_FILENAME);
+        if IMAGE_EXTENSIONS
+            .iter()
+            .any(|extension| save_path.with_extension(extension).exists())
+        {
+            return Ok(());
+        }
+
+        let image_data = self
+            .manhuagui_client()
+            .get_image_bytes(cover_url)
+            .await
+            .context(format!("下载封面`{cover_url}`失败"))?;
+        std::fs::create_dir_all(&comic_dir).context(format!("创建目录`{comic_dir:?}`失败"))?;
+        save_image(&self.app, &save_path, &image_data, false, None, None)
+            .context(format!("保存封面`{save_path:?}`失败"))?;
+        Ok(())
+    }
+
+    /// 预览下载`chapters`时每个章节的下载目录、页数、预估大小和是否已下载
+    ///
+    /// 不下载任何图片，只有章节信息里没有页数时才会请求章节页获取页数
+    #[allow(clippy::cast_sign_loss, clippy::cast_possible_truncation)]
+    pub async fn preview(&self, chapters: Vec<ChapterInfo>) -> anyhow::Result<DownloadPlan> {
+        let (download_dir, downloaded_checker) = {
+            let config = self.app.state::<RwLock<Config>>();
+            let config = config.read();
+            (
+                config.download_dir.clone(),
+                config.downloaded_check_strategy.checker(),
+            )
+        };
+
+        let avg_image_size = self.avg_image_size(&chapters);
+        let mut chapter_plans = Vec::new();
+        for chapter_info in chapters {
+            let page_count = if chapter_info.chapter_size > 0 {
+                chapter_info.chapter_size as u64
+            } else {
+                let urls = self
+                    .manhuagui_client()
+                    .get_image_urls(&chapter_info)
+                    .await
+                    .context(format!(
+                        "获取`{} - {}`的页数失败",
+                        chapter_info.comic_title, chapter_info.chapter_title
+                    ))?;
+                urls.len() as u64
+            };
+            let exists = chapter_info.get_is_downloaded(&download_dir, downloaded_checker.as_ref());
+            let chapter_download_dir = get_chapter_download_dir(&download_dir, &chapter_info);
+            chapter_plans.push(ChapterDownloadPlan {
+                chapter_info,
+                download_dir: chapter_download_dir,
+                page_count,
+                estimated_size: page_count * avg_image_size,
+                exists,
+            });
+        }
+
+        let total_pages = chapter_plans.iter().map(|plan| plan.page_count).sum();
+        let estimated_size = chapter_plans.iter().map(|plan| plan.estimated_size).sum();
+        Ok(DownloadPlan {
+            chapters: chapter_plans,
+            total_pages,
+            estimated_size,
+            available_space: get_available_space(&download_dir).ok(),
+        })
+    }
+
+    /// 检查下载目录所在磁盘的剩余空间是否足够下载`chapters`
+    ///
+    /// 只是尽力而为，获取不到剩余空间(例如网络驱动器)时只记录警告，不阻止下载
+    #[allow(clippy::cast_precision_loss)]
+    pub fn check_disk_space(&self, chapters: &[ChapterInfo]) -> anyhow::Result<()> {
+        let estimated_size = self.estimate_size(chapters);
+        let download_dir = self
+            .app
+            .state::<RwLock<Config>>()
+            .read()
+            .download_dir
+            .clone();
+        let available_space = match get_available_space(&download_dir) {
+            Ok(available_space) => available_space,
+            Err(err) => {
+                let err = err.context(format!(
+                    "获取`{download_dir:?}`所在磁盘的剩余空间失败，跳过磁盘空间检查"
+                ));
+                let _ = LogEvent::Warn {
+                    msg: err.to_string_chain(),
+                }
+                .emit(&self.app);
+                return Ok(());
+            }
+        };
+        if estimated_size > available_space {
+            let estimated_mb = estimated_size as f64 / 1024.0 / 1024.0;
+            let available_mb = available_space as f64 / 1024.0 / 1024.0;
+            return Err(anyhow!(
+                "磁盘空间不足，预计需要`{estimated_mb:.2} MB`，但`{download_dir:?}`所在磁盘只剩`{available_mb:.2} MB`"
+            ));
+        }
         Ok(())
     }
 
@@ -76,15 +746,201 @@ impl DownloadManager {
         }
     }
 
-    async fn receiver_loop(app: AppHandle, mut receiver: mpsc::Receiver<ChapterInfo>) {
-        while let Some(chapter_info) = receiver.recv().await {
+    async fn save_task_states_loop(app: AppHandle) {
+        let mut interval = tokio::time::interval(SAVE_TASK_STATES_INTERVAL);
+
+        loop {
+            interval.tick().await;
+            let manager = app.state::<DownloadManager>();
+            // 只有任务状态有变化时才写盘
+            if !manager.task_states_dirty.swap(false, Ordering::Relaxed) {
+                continue;
+            }
+            let task_states = manager
+                .task_states
+                .read()
+                .values()
+                .cloned()
+                .collect::<Vec<_>>();
+            if save_task_states(&app, &task_states).is_err() {
+                // 写入失败，下次再试
+                manager.task_states_dirty.store(true, Ordering::Relaxed);
+            }
+        }
+    }
+
+    fn run_chapter_completed_callbacks(&self, chapter_info: ChapterInfo, download_dir: PathBuf) {
+        if !self
+            .completed_chapter_ids
+            .write()
+            .insert(chapter_info.chapter_id)
+        {
+            return;
+        }
+        let callbacks = self.chapter_completed_callbacks.read().clone();
+        if callbacks.is_empty() {
+            return;
+        }
+        tauri::async_runtime::spawn_blocking(move || {
+            for callback in callbacks {
+                callback(&chapter_info, &download_dir);
+            }
+        });
+    }
+
+    /// 更新章节`chapter_id`的下载任务状态
+    fn update_task_state(&self, chapter_id: i64, update: impl FnOnce(&mut DownloadTaskState)) {
+        if let Some(task_state) = self.task_states.write().get_mut(&chapter_id) {
+            update(task_state);
+        }
+        self.task_states_dirty.store(true, Ordering::Relaxed);
+    }
+
+    async fn receiver_loop(
+        app: AppHandle,
+        mut receiver: mpsc::Receiver<(ChapterInfo, DownloadPriority, u64)>,
+    ) {
+        while let Some((chapter_info, priority, seq)) = receiver.recv().await {
             let manager = app.state::<DownloadManager>().inner().clone();
-            tauri::async_runtime::spawn(manager.process_chapter(chapter_info));
+            tauri::async_runtime::spawn(manager.process_chapter_cancellable(
+                chapter_info,
+                priority,
+                seq,
+            ));
+        }
+    }
+
+    /// 取消漫画`comic_id`所有未完成的下载任务，正在进行的请求会被立即中断
+    ///
+    /// `delete_temp_dirs`为true时，同时删除这些章节的临时下载目录，否则保留已下载的图片，下次下载时可以跳过
+    pub fn cancel_comic(&self, comic_id: i64, delete_temp_dirs: bool) {
+        // 移除后，之后再提交的同一漫画的章节会使用新的信号，不会被这次取消影响
+        if let Some(cancel_sender) = self.cancel_senders.write().remove(&comic_id) {
+            let _ = cancel_sender.send(Some(delete_temp_dirs));
+        }
+    }
+
+    /// 取消这些章节，正在进行的请求会被立即中断，不在任务列表中的章节会被忽略
+    ///
+    /// `delete_temp_dirs`的含义与`cancel_comic`相同
+    pub fn cancel_chapters(&self, chapter_ids: &[i64], delete_temp_dirs: bool) {
+        let chapter_ids = self.filter_task_chapter_ids(chapter_ids);
+        let mut chapter_cancel_senders = self.chapter_cancel_senders.write();
+        for chapter_id in chapter_ids {
+            // 章节还在排队、没有订阅信号时也能取消，开始处理时会立即收到
+            chapter_cancel_senders
+                .entry(chapter_id)
+                .or_insert_with(|| watch::channel(None).0)
+                .send_replace(Some(delete_temp_dirs));
         }
     }
 
+    /// 处理章节，收到漫画或章节的取消信号时丢弃`process_chapter`的future，这样它创建的所有下载任务都会被中断
+    async fn process_chapter_cancellable(
+        self,
+        chapter_info: ChapterInfo,
+        priority: DownloadPriority,
+        seq: u64,
+    ) {
+        let chapter_id = chapter_info.chapter_id;
+        let mut cancel_receiver = self
+            .cancel_senders
+            .write()
+            .entry(chapter_info.comic_id)
+            .or_insert_with(|| watch::channel(None).0)
+            .subscribe();
+        let mut chapter_cancel_receiver = self
+            .chapter_cancel_senders
+            .write()
+            .entry(chapter_id)
+            .or_insert_with(|| watch::channel(None).0)
+            .subscribe();
+        // 返回是否删除临时下载目录
+        let canceled = async {
+            tokio::select! {
+                Ok(canceled) = cancel_receiver.wait_for(Option::is_some) => (*canceled).unwrap_or(false),
+                Ok(canceled) = chapter_cancel_receiver.wait_for(Option::is_some) => (*canceled).unwrap_or(false),
+                else => std::future::pending::<bool>().await,
+            }
+        };
+
+        let outcome = tokio::select! {
+            () = self.clone().process_chapter(chapter_info.clone(), priority, seq) => {
+                // 下载成功或下架时任务状态会被移除，失败时保留下来用于恢复
+                let chapter_id = chapter_info.chapter_id;
+                let has_task_state = self.task_states.read().contains_key(&chapter_id);
+                let is_unavailable = self.unavailable_chapters.read().contains_key(&chapter_id);
+                if has_task_state || is_unavailable {
+                    ChapterOutcome::Failed
+                } else {
+                    ChapterOutcome::Succeeded
+                }
+            }
+            delete_temp_dir = canceled => {
+                self.on_chapter_canceled(&chapter_info, delete_temp_dir);
+                ChapterOutcome::Canceled
+            }
+        };
+        self.chapter_cancel_senders.write().remove(&chapter_id);
+        self.paused_chapter_ids
+            .send_if_modified(|paused_chapter_ids| paused_chapter_ids.remove(&chapter_id));
+        self.on_chapter_finished(&chapter_info, outcome);
+    }
+
+    /// 漫画的章节全部结束时发送漫画完成事件，队列中的章节全部结束时发送批次完成事件
+    fn on_chapter_finished(&self, chapter_info: &ChapterInfo, outcome: ChapterOutcome) {
+        let finished = self.batch_tracker.finish(chapter_info, outcome);
+        if let Some((comic_id, comic_title, stats)) = finished.comic {
+            let _ = DownloadEvent::ComicCompleted {
+                comic_id,
+                comic_title,
+                succeeded: stats.succeeded,
+                failed: stats.failed,
+                canceled: stats.canceled,
+                elapsed_secs: stats.elapsed_secs,
+            }
+            .emit(&self.app);
+        }
+        if let Some((comic_count, stats)) = finished.batch {
+            let _ = DownloadEvent::BatchCompleted {
+                comic_count,
+                succeeded: stats.succeeded,
+                failed: stats.failed,
+                canceled: stats.canceled,
+                elapsed_secs: stats.elapsed_secs,
+            }
+            .emit(&self.app);
+        }
+    }
+
+    fn on_chapter_canceled(&self, chapter_info: &ChapterInfo, delete_temp_dir: bool) {
+        let chapter_id = chapter_info.chapter_id;
+        // 取消的任务不需要恢复
+        self.task_states.write().remove(&chapter_id);
+        self.task_states_dirty.store(true, Ordering::Relaxed);
+        if delete_temp_dir {
+            let temp_download_dir = get_temp_download_dir(&self.app, chapter_info);
+            if temp_download_dir.exists() {
+                if let Err(err) = std::fs::remove_dir_all(&temp_download_dir) {
+                    let err = anyhow::Error::from(err)
+                        .context(format!("删除临时下载目录`{temp_download_dir:?}`失败"));
+                    let _ = LogEvent::Warn {
+                        msg: err.to_string_chain(),
+                    }
+                    .emit(&self.app);
+                }
+            }
+        }
+        let _ = DownloadEvent::ChapterCanceled { chapter_id }.emit(&self.app);
+    }
+
     #[allow(clippy::cast_possible_truncation)]
-    async fn process_chapter(self, chapter_info: ChapterInfo) {
+    async fn process_chapter(
+        self,
+        chapter_info: ChapterInfo,
+        priority: DownloadPriority,
+        seq: u64,
+    ) {
         let chapter_id = chapter_info.chapter_id;
         let comic_title = &chapter_info.comic_title;
         let group_name = &chapter_info.group_name;
@@ -93,47 +949,90 @@ impl DownloadManager {
         // 发送章节排队事件
         let _ = DownloadEvent::ChapterPending {
             chapter_id,
+            comic_id: chapter_info.comic_id,
             comic_title: comic_title.clone(),
             chapter_title: chapter_title.clone(),
         }
         .emit(&self.app);
-        // 限制同时下载的章节数量
-        let permit = match self
-            .chapter_sem
-            .acquire()
-            .await
-            .map_err(anyhow::Error::from)
-        {
-            Ok(permit) => permit,
-            Err(err) => {
-                let err = err.context(format!("{err_prefix}获取下载章节的semaphore失败"));
-                // 发送下载章节结束事件
-                let _ = DownloadEvent::ChapterEnd {
-                    chapter_id,
-                    err_msg: Some(err.to_string_chain()),
-                }
-                .emit(&self.app);
-                return;
+        // 已知下架的章节重试也不会成功，不再发请求
+        let unavailable_reason = self.unavailable_chapters.read().get(&chapter_id).cloned();
+        if let Some(reason) = unavailable_reason {
+            self.on_chapter_unavailable(chapter_id, reason);
+            return;
+        }
+        // 需要登录的章节在未登录时只能拿到残缺的内容，直接报错而不是下载错误的页面
+        let has_cookie = !self.app.state::<RwLock<Config>>().read().cookie.is_empty();
+        if chapter_info.is_locked && !has_cookie {
+            let err_msg = format!("{err_prefix}需要付费或登录才能查看，请先登录");
+            // 发送下载章节结束事件
+            let _ = DownloadEvent::ChapterEnd {
+                chapter_id,
+                err_msg: Some(err_msg),
+            }
+            .emit(&self.app);
+            return;
+        }
+        // 这一话已经下载到了其他目录时，按配置跳过或者复用已下载的图片
+        let downloaded_elsewhere = self.find_downloaded_elsewhere(&chapter_info);
+        if let Some((CrossDirDedupe::Skip, other_dir)) = &downloaded_elsewhere {
+            self.task_states.write().remove(&chapter_id);
+            self.task_states_dirty.store(true, Ordering::Relaxed);
+            let _ = LogEvent::Info {
+                msg: format!("{err_prefix}已经下载到了`{other_dir:?}`，跳过"),
+            }
+            .emit(&self.app);
+            // 发送下载章节结束事件
+            let _ = DownloadEvent::ChapterEnd {
+                chapter_id,
+                err_msg: None,
+            }
+            .emit(&self.app);
+            return;
+        }
+        // 暂停中的章节不占用名额，先等恢复再领取，领取期间又被暂停时让出名额重新等待
+        let (_comic_slot, permit) = loop {
+            self.wait_until_resumed(chapter_id).await;
+            // 限制同时下载的漫画数量，名额在这个章节结束(包括被取消)时释放
+            let comic_slot = self.comic_queue.acquire(&chapter_info, priority).await;
+            // 限制同时下载的章节数量，不限制同时下载的漫画数量时优先级也在这里生效
+            let permit = self.chapter_queue.acquire(priority, seq).await;
+            if !self.is_paused(chapter_id) {
+                break (comic_slot, permit);
             }
         };
         // 获取此章节每张图片的下载链接
-        let urls = match self.manhuagui_client().get_image_urls(&chapter_info).await {
-            Ok(urls) => urls,
-            Err(err) => {
-                let err = err.context(format!("{err_prefix}获取图片链接失败"));
-                // 发送下载章节结束事件
-                let _ = DownloadEvent::ChapterEnd {
-                    chapter_id,
-                    err_msg: Some(err.to_string_chain()),
-                }
-                .emit(&self.app);
+        let urls = loop {
+            self.wait_until_network_restored().await;
+            self.wait_until_resumed(chapter_id).await;
+            let err = match self.manhuagui_client().get_image_urls(&chapter_info).await {
+                Ok(urls) => break urls,
+                Err(err) => err,
+            };
+            if err.is::<ChapterUnavailableError>() {
+                let reason = err.to_string_chain();
+                self.unavailable_chapters
+                    .write()
+                    .insert(chapter_id, reason.clone());
+                self.on_chapter_unavailable(chapter_id, reason);
                 return;
             }
+            // 网络断开时不算失败，等网络恢复后重新获取
+            if ManhuaguiClient::is_network_error(&err) && self.should_wait_for_network().await {
+                continue;
+            }
+            let err = err.context(format!("{err_prefix}获取图片链接失败"));
+            // 发送下载章节结束事件
+            let _ = DownloadEvent::ChapterEnd {
+                chapter_id,
+                err_msg: Some(err.to_string_chain()),
+            }
+            .emit(&self.app);
+            return;
         };
         // 总共需要下载的图片数量
         let total = urls.len() as u32;
-        // 记录成功下载的图片数量
-        let downloaded_count = Arc::new(AtomicU32::new(0));
+        // 记录成功下载的图片数量和字节数
+        let progress = Arc::new(ChapterProgress::default());
         let mut join_set = JoinSet::new();
         // 创建临时下载目录
         let temp_download_dir = get_temp_download_dir(&self.app, &chapter_info);
@@ -148,22 +1047,92 @@ impl DownloadManager {
             .emit(&self.app);
             return;
         }
+        self.update_task_state(chapter_id, |task_state| task_state.total = total);
+        // 按首张图片的大小估算整话的字节数，让进度按字节计算，估算失败时前端按页数计算进度
+        let total_bytes = self.estimate_chapter_bytes(&urls).await;
         // 发送下载开始事件
-        let _ = DownloadEvent::ChapterStart { chapter_id, total }.emit(&self.app);
+        let _ = DownloadEvent::ChapterStart {
+            chapter_id,
+            total,
+            total_bytes,
+        }
+        .emit(&self.app);
+        // 把其他目录中已下载的图片放进临时目录，下载时会跳过这些页
+        if let Some((CrossDirDedupe::HardLink, other_dir)) = &downloaded_elsewhere {
+            match link_or_copy_images(other_dir, &temp_download_dir) {
+                Ok(()) => {
+                    let _ = LogEvent::Info {
+                        msg: format!("{err_prefix}复用了`{other_dir:?}`中已下载的图片"),
+                    }
+                    .emit(&self.app);
+                }
+                Err(err) => {
+                    let err =
+                        err.context(format!("{err_prefix}复用已下载的图片失败，改为重新下载"));
+                    let _ = LogEvent::Warn {
+                        msg: err.to_string_chain(),
+                    }
+                    .emit(&self.app);
+                }
+            }
+        }
+        // 续传前先统一已下载的页的文件名，保证每一页只对应一个文件
+        if let Err(err) = normalize_page_filenames(&temp_download_dir, total) {
+            let err = err.context(format!("{err_prefix}整理已下载的图片的文件名失败"));
+            let _ = LogEvent::Warn {
+                msg: err.to_string_chain(),
+            }
+            .emit(&self.app);
+        }
+        match chapter_manifest::discard_mismatched_pages(&temp_download_dir) {
+            Ok(discarded) if !discarded.is_empty() => {
+                let _ = LogEvent::Warn {
+                    msg: format!(
+                        "{err_prefix}第{}页与校验和清单不符，将重新下载",
+                        discarded
+                            .iter()
+                            .map(u32::to_string)
+                            .collect::<Vec<_>>()
+                            .join(", ")
+                    ),
+                }
+                .emit(&self.app);
+            }
+            Ok(_) => {}
+            Err(err) => {
+                let err = err.context(format!("{err_prefix}按校验和清单检查已下载的图片失败"));
+                let _ = LogEvent::Warn {
+                    msg: err.to_string_chain(),
+                }
+                .emit(&self.app);
+            }
+        }
+        // 下载完成后用来生成校验和清单
+        let page_urls = urls.clone();
+        let mobile_urls = Arc::new(MobileImageUrls::new(chapter_info.clone()));
         // 逐一创建下载任务
         for (i, url) in urls.into_iter().enumerate() {
             let manager = self.clone();
-            let save_path = temp_download_dir.join(format!("{:03}.jpg", i + 1));
+            // 每页的文件名由页码唯一确定，并发下载时不会互相覆盖
+            let save_path = temp_download_dir.join(page_file_stem(i, total));
             let url = url.clone();
-            let downloaded_count = downloaded_count.clone();
+            let progress = progress.clone();
+            let mobile_urls = mobile_urls.clone();
             // 创建下载任务
-            join_set.spawn(manager.download_image(url, save_path, chapter_id, downloaded_count));
+            join_set.spawn(manager.download_image(
+                url,
+                save_path,
+                chapter_id,
+                progress,
+                i,
+                mobile_urls,
+            ));
         }
         // 等待所有下载任务完成
         join_set.join_all().await;
         drop(permit);
         // 检查此章节的图片是否全部下载成功
-        let downloaded_count = downloaded_count.load(Ordering::Relaxed);
+        let downloaded_count = progress.downloaded_count.load(Ordering::Relaxed);
         // 此章节的图片未全部下载成功
         if downloaded_count != total {
             let err_msg =
@@ -174,10 +1143,26 @@ impl DownloadManager {
                 err_msg: Some(err_msg),
             }
             .emit(&self.app);
+            return;
         }
-        // 此章节的图片全部下载成功
-        let err_msg = match rename_temp_download_dir(&chapter_info, &temp_download_dir) {
-            Ok(()) => None,
+        // 此章节的图片全部下载成功，生成校验和清单，生成失败不影响章节下载完成
+        if let Err(err) = ChapterManifest::build(chapter_id, &temp_download_dir, &page_urls)
+            .and_then(|manifest| manifest.save(&temp_download_dir))
+        {
+            let err = err.context(format!("{err_prefix}生成校验和清单失败"));
+            let _ = LogEvent::Warn {
+                msg: err.to_string_chain(),
+            }
+            .emit(&self.app);
+        }
+        let err_msg = match rename_temp_download_dir(&self.app, &chapter_info, &temp_download_dir) {
+            Ok(download_dir) => {
+                // 下载完成，不再需要恢复
+                self.task_states.write().remove(&chapter_id);
+                self.task_states_dirty.store(true, Ordering::Relaxed);
+                self.run_chapter_completed_callbacks(chapter_info.clone(), download_dir);
+                None
+            }
             Err(err) => Some(
                 err.context(format!("{err_prefix}重命名临时下载目录失败"))
                     .to_string_chain(),
@@ -191,45 +1176,123 @@ impl DownloadManager {
         .emit(&self.app);
     }
 
+    /// 章节已被删除或下架，不需要恢复也不需要重试
+    fn on_chapter_unavailable(&self, chapter_id: i64, reason: String) {
+        self.task_states.write().remove(&chapter_id);
+        self.task_states_dirty.store(true, Ordering::Relaxed);
+        let _ = DownloadEvent::ChapterUnavailable { chapter_id, reason }.emit(&self.app);
+    }
+
     async fn download_image(
         self,
         url: String,
         save_path: PathBuf,
         chapter_id: i64,
-        current: Arc<AtomicU32>,
+        progress: Arc<ChapterProgress>,
+        index: usize,
+        mobile_urls: Arc<MobileImageUrls>,
     ) {
-        // 下载图片
-        let permit = match self.img_sem.acquire().await.map_err(anyhow::Error::from) {
-            Ok(permit) => permit,
-            Err(err) => {
-                let err = err.context("获取下载图片的semaphore失败");
-                // 发送下载图片失败事件
-                let _ = DownloadEvent::ImageError {
-                    chapter_id,
-                    url: url.clone(),
-                    err_msg: err.to_string_chain(),
-                }
-                .emit(&self.app);
-                return;
+        // 上次下载这个章节时已经下载好了这张图片，不需要重新下载
+        if let Some(existing_path) = IMAGE_EXTENSIONS
+            .iter()
+            .map(|extension| save_path.with_extension(extension))
+            .find(|path| path.exists())
+        {
+            let bytes = std::fs::metadata(&existing_path).map_or(0, |metadata| metadata.len());
+            let (current, downloaded_bytes) = progress.record(bytes);
+            self.update_task_state(chapter_id, |task_state| {
+                task_state.downloaded_count = current;
+            });
+            // 发送下载图片成功事件
+            let _ = DownloadEvent::ImageSuccess {
+                chapter_id,
+                url,
+                current,
+                downloaded_bytes,
             }
-        };
-        let image_data = match self.manhuagui_client().get_image_bytes(&url).await {
-            Ok(data) => data,
-            Err(err) => {
-                let err = err.context(format!("下载图片`{url}`失败"));
-                // 发送下载图片失败事件
-                let _ = DownloadEvent::ImageError {
-                    chapter_id,
-                    url: url.clone(),
-                    err_msg: err.to_string_chain(),
+            .emit(&self.app);
+            return;
+        }
+        // 下载图片，先边下载边写入临时文件，超长图也不会占用大量内存
+        let download_path = save_path.with_extension("download");
+        let mut throttled_count = 0;
+        let image_size = loop {
+            self.wait_until_network_restored().await;
+            self.wait_until_resumed(chapter_id).await;
+            self.wait_until_unthrottled().await;
+            let permit = self.img_concurrency.acquire().await;
+            let result = self
+                .download_image_file(&url, &mobile_urls, index, &download_path)
+                .await;
+            drop(permit);
+            self.img_concurrency.report(request_outcome(&result));
+            let err = match result {
+                Ok(image) => break image.size,
+                Err(err) => err,
+            };
+            // 网络断开时不算失败，等网络恢复后重新下载这张图片
+            if ManhuaguiClient::is_network_error(&err) && self.should_wait_for_network().await {
+                continue;
+            }
+            // 被限流时所有图片请求一起暂停，限流结束后重新下载这张图片
+            if let Some(too_many_requests) = err.downcast_ref::<TooManyRequestsError>() {
+                if throttled_count < MAX_THROTTLED_RETRIES {
+                    throttled_count += 1;
+                    let duration = self.throttle(too_many_requests.retry_after);
+                    let _ = LogEvent::Warn {
+                        msg: format!(
+                            "{too_many_requests}，所有图片请求暂停{}秒，同时下载的图片数降为{}",
+                            duration.as_secs(),
+                            self.img_concurrency.limit()
+                        ),
+                    }
+                    .emit(&self.app);
+                    let _ = DownloadEvent::ChapterControlRisk {
+                        chapter_id,
+                        retry_after: u32::try_from(duration.as_secs()).unwrap_or(u32::MAX),
+                    }
+                    .emit(&self.app);
+                    self.wait_until_unthrottled().await;
+                    let _ = DownloadEvent::ChapterControlRisk {
+                        chapter_id,
+                        retry_after: 0,
+                    }
+                    .emit(&self.app);
+                    continue;
                 }
-                .emit(&self.app);
-                return;
             }
+            // 发送下载图片失败事件
+            let _ = DownloadEvent::ImageError {
+                chapter_id,
+                url: url.clone(),
+                err_msg: err.to_string_chain(),
+            }
+            .emit(&self.app);
+            return;
         };
-        drop(permit);
         // 保存图片
-        if let Err(err) = std::fs::write(&save_path, &image_data).map_err(anyhow::Error::from) {
+        let (convert_animated_webp_to_gif, downscale_percent, crop) = {
+            let config = self.app.state::<RwLock<Config>>();
+            let config = config.read();
+            // 要压缩图但网站没有提供时，在本地缩小
+            let downscale_percent = (config.image_quality == ImageQuality::Compressed
+                && !ManhuaguiClient::is_compressed_image_url(&url))
+            .then_some(config.compressed_image_scale);
+            (
+                config.convert_animated_webp_to_gif,
+                downscale_percent,
+                config.image_crop(),
+            )
+        };
+        if let Err(err) = save_downloaded_image(
+            &self.app,
+            &save_path,
+            &download_path,
+            image_size,
+            convert_animated_webp_to_gif,
+            downscale_percent,
+            crop,
+        ) {
             let err = err.context(format!("保存图片`{save_path:?}`失败"));
             // 发送下载图片失败事件
             let _ = DownloadEvent::ImageError {
@@ -241,51 +1304,315 @@ impl DownloadManager {
             return;
         }
         // 记录下载字节数
-        self.byte_per_sec
-            .fetch_add(image_data.len() as u64, Ordering::Relaxed);
+        self.byte_per_sec.fetch_add(image_size, Ordering::Relaxed);
+        {
+            let mut downloaded_image_stats = self.downloaded_image_stats.write();
+            downloaded_image_stats.0 += image_size;
+            downloaded_image_stats.1 += 1;
+        }
         // 更新章节下载进度
-        let current = current.fetch_add(1, Ordering::Relaxed) + 1;
+        let (current, downloaded_bytes) = progress.record(image_size);
+        self.update_task_state(chapter_id, |task_state| {
+            task_state.downloaded_count = current;
+        });
         // 发送下载图片成功事件
         let _ = DownloadEvent::ImageSuccess {
             chapter_id,
             url,
             current,
+            downloaded_bytes,
         }
         .emit(&self.app);
     }
 
+    /// 用HEAD请求获取首张图片的大小，乘以页数作为整话的预估字节数
+    ///
+    /// 图片服务器不支持HEAD或者响应中没有`Content-Length`时返回None
+    async fn estimate_chapter_bytes(&self, urls: &[String]) -> Option<u64> {
+        let first_url = urls.first()?;
+        let first_size = self
+            .manhuagui_client()
+            .get_image_size(first_url)
+            .await
+            .ok()
+            .flatten()?;
+        Some(first_size * urls.len() as u64)
+    }
+
+    /// 从`url`下载图片到`download_path`
+    ///
+    /// PC端的图片在所有镜像上都下载失败时，改用移动端的图片链接重新下载这一页，成功时只记录日志
+    async fn download_image_file(
+        &self,
+        url: &str,
+        mobile_urls: &MobileImageUrls,
+        index: usize,
+        download_path: &Path,
+    ) -> anyhow::Result<DownloadedImage> {
+        let manhuagui_client = self.manhuagui_client();
+        let err = match manhuagui_client.download_image_to(url, download_path).await {
+            Ok(image) => return Ok(image),
+            // 被限流时改用移动端也是在加剧限流
+            Err(err) if err.downcast_ref::<TooManyRequestsError>().is_some() => return Err(err),
+            Err(err) => err,
+        };
+        match mobile_urls
+            .download(&manhuagui_client, index, download_path)
+            .await
+        {
+            Ok(image) => {
+                let _ = LogEvent::Info {
+                    msg: format!(
+                        "图片`{url}`下载失败，已改用移动端图片源下载: {}",
+                        err.to_string_chain()
+                    ),
+                }
+                .emit(&self.app);
+                Ok(image)
+            }
+            Err(mobile_err) => Err(err.context(format!(
+                "下载图片`{url}`失败，改用移动端图片源也失败({})",
+                mobile_err.to_string_chain()
+            ))),
+        }
+    }
+
     fn manhuagui_client(&self) -> ManhuaguiClient {
         self.app.state::<ManhuaguiClient>().inner().clone()
     }
 }
 
+fn get_task_states_path(app: &AppHandle) -> anyhow::Result<PathBuf> {
+    let app_data_dir = app
+        .path()
+        .app_data_dir()
+        .context("获取app_data_dir目录失败")?;
+    Ok(app_data_dir.join(TASK_STATES_FILENAME))
+}
+
+fn load_task_states(app: &AppHandle) -> anyhow::Result<Vec<DownloadTaskState>> {
+    let task_states_path = get_task_states_path(app)?;
+    if !task_states_path.exists() {
+        return Ok(Vec::new());
+    }
+    let task_states_string = std::fs::read_to_string(&task_states_path)
+        .context(format!("读取`{task_states_path:?}`失败"))?;
+    let task_states = serde_json::from_str::<Vec<DownloadTaskState>>(&task_states_string).context(
+        format!("将`{task_states_path:?}`反序列化为下载任务状态失败"),
+    )?;
+    Ok(task_states)
+}
+
+fn save_task_states(app: &AppHandle, task_states: &[DownloadTaskState]) -> anyhow::Result<()> {
+    let task_states_json =
+        serde_json::to_string_pretty(task_states).context("将下载任务状态序列化为json失败")?;
+    write_atomic(&get_task_states_path(app)?, task_states_json)
+}
+
+/// 按图片请求的结果判断是否被限流或风控，用来调整图片下载的并发数
+fn request_outcome(result: &anyhow::Result<DownloadedImage>) -> RequestOutcome {
+    match result {
+        Ok(image) => RequestOutcome::Succeeded {
+            bytes_per_sec: image.bytes_per_sec(),
+        },
+        Err(err)
+            if err.downcast_ref::<TooManyRequestsError>().is_some()
+                || err.downcast_ref::<BlockedError>().is_some() =>
+        {
+            RequestOutcome::Throttled
+        }
+        Err(_) => RequestOutcome::Failed,
+    }
+}
+
+/// 把`from`中的图片硬链接到`to`，`to`中已有的同名图片保留不动
+///
+/// 硬链接不能跨磁盘，失败时改为复制
+fn link_or_copy_images(from: &Path, to: &Path) -> anyhow::Result<()> {
+    for path in image_paths(from) {
+        let Some(filename) = path.file_name() else {
+            continue;
+        };
+        let target_path = to.join(filename);
+        if target_path.exists() {
+            continue;
+        }
+        if std::fs::hard_link(&path, &target_path).is_err() {
+            std::fs::copy(&path, &target_path)
+                .context(format!("将`{path:?}`复制到`{target_path:?}`失败"))?;
+        }
+    }
+    Ok(())
+}
+
+/// 临时下载目录位于缓存目录下，下载完成后才移动到下载目录
+///
+/// 旧版本把临时目录放在下载目录中，以`.下载中-`开头，如果存在这样的目录则继续使用，以免丢失已下载的图片
 fn get_temp_download_dir(app: &AppHandle, chapter_info: &ChapterInfo) -> PathBuf {
-    app.state::<RwLock<Config>>()
-        .read()
-        .download_dir
-        .join(&chapter_info.comic_title)
-        .join(&chapter_info.group_name)
-        .join(format!(".下载中-{}", chapter_info.prefixed_chapter_title)) // 以 `.下载中-` 开头，表示是临时目录
+    let config = app.state::<RwLock<Config>>();
+    let config = config.read();
+    let legacy_temp_download_dir = get_chapter_download_dir(&config.download_dir, chapter_info)
+        .with_file_name(format!(".下载中-{}", chapter_info.prefixed_chapter_title));
+    if legacy_temp_download_dir.exists() {
+        return legacy_temp_download_dir;
+    }
+    get_chapter_download_dir(&config.cache_dir.join("下载中"), chapter_info)
 }
 
-fn rename_temp_download_dir(
-    chapter_info: &ChapterInfo,
-    temp_download_dir: &Path,
+/// 读取`comic_dir`下已下载的图片(最多`LOCAL_SAMPLE_IMAGE_COUNT`张)的平均大小，没有已下载的图片时返回None
+///
+/// 章节目录可能直接位于漫画目录下，也可能位于章节组目录下，所以查找两层
+fn local_avg_image_size(comic_dir: &Path) -> Option<u64> {
+    let sizes = sub_dirs(comic_dir)
+        .into_iter()
+        .flat_map(|dir| {
+            let mut dirs = sub_dirs(&dir);
+            dirs.push(dir);
+            dirs
+        })
+        .flat_map(|dir| image_paths(&dir))
+        .filter_map(|path| Some(path.metadata().ok()?.len()))
+        .take(LOCAL_SAMPLE_IMAGE_COUNT)
+        .collect::<Vec<_>>();
+    if sizes.is_empty() {
+        return None;
+    }
+    Some(sizes.iter().sum::<u64>() / sizes.len() as u64)
+}
+
+/// `dir`中是否是完整的一话，用来判断其他目录中的下载能否跳过或复用
+///
+/// 有校验和清单时以清单校验为准，没有清单(旧版本下载的)时图片数量要与章节页数一致
+fn is_complete_download(dir: &Path, chapter_size: i64) -> bool {
+    match ChapterManifest::load(dir) {
+        Ok(Some(manifest)) => manifest.is_complete(dir, chapter_size),
+        _ => usize::try_from(chapter_size).is_ok_and(|size| image_paths(dir).len() == size),
+    }
+}
+
+/// 章节在`root_dir`下的目录，结构由章节的`dir_layout`决定
+fn get_chapter_download_dir(root_dir: &Path, chapter_info: &ChapterInfo) -> PathBuf {
+    root_dir.join(chapter_info.relative_dir())
+}
+
+/// 把下载到`download_path`的图片保存到`save_path`，扩展名按图片的实际格式决定，最后删除`download_path`
+///
+/// 超过`LARGE_IMAGE_SIZE`又不需要裁剪、缩小的图片(通常是条漫一话一张的超长图)直接重命名，
+/// 只按文件头判断格式，不会读进内存，完整性由下载时核对的`Content-Length`保证，webp动图也不会转换为gif。
+/// 其他图片读进内存后交给`save_image`校验和处理
+fn save_downloaded_image(
+    app: &AppHandle,
+    save_path: &Path,
+    download_path: &Path,
+    image_size: u64,
+    convert_animated_webp_to_gif: bool,
+    downscale_percent: Option<u32>,
+    crop: Option<ImageCrop>,
 ) -> anyhow::Result<()> {
-    let Some(parent) = temp_download_dir.parent() else {
-        return Err(anyhow!("无法获取`{temp_download_dir:?}`的父目录"));
+    let result = if image_size > LARGE_IMAGE_SIZE && downscale_percent.is_none() && crop.is_none() {
+        image_format::guess_file_format(download_path).and_then(|format| {
+            let path = save_path.with_extension(image_format::extension_of(format));
+            std::fs::rename(download_path, &path)
+                .context(format!("将`{download_path:?}`重命名为`{path:?}`失败"))
+        })
+    } else {
+        std::fs::read(download_path)
+            .context(format!("读取`{download_path:?}`失败"))
+            .and_then(|image_data| {
+                save_image(
+                    app,
+                    save_path,
+                    &image_data,
+                    convert_animated_webp_to_gif,
+                    downscale_percent,
+                    crop,
+                )
+            })
     };
+    let _ = std::fs::remove_file(download_path);
+    result
+}
 
-    let download_dir = parent.join(&chapter_info.prefixed_chapter_title);
+/// 校验`image_data`确实是完整的图片后再保存到`save_path`，扩展名按图片的实际格式决定
+///
+/// `crop`不为None时，静态图片会先裁掉顶部和底部的水印条，裁剪失败时保留原图
+///
+/// `downscale_percent`不为None时，静态图片会被缩小到原来的`downscale_percent`%并转换为jpeg，动图不受影响
+///
+/// 先写入临时文件再重命名，保证`save_path`存在时图片一定是完整的，这样断点续传时才能放心跳过它
+fn save_image(
+    app: &AppHandle,
+    save_path: &Path,
+    image_data: &[u8],
+    convert_animated_webp_to_gif: bool,
+    downscale_percent: Option<u32>,
+    crop: Option<ImageCrop>,
+) -> anyhow::Result<()> {
+    let image_info =
+        image_format::inspect_image(image_data).context("下载到的数据不是完整的图片")?;
+    let cropped_data;
+    let image_data = match crop {
+        Some(crop) if !image_info.is_animated() => {
+            match image_format::crop_image(image_data, image_info.format, crop) {
+                Ok(data) => {
+                    cropped_data = data;
+                    &cropped_data
+                }
+                Err(err) => {
+                    let err = err.context(format!("裁剪`{save_path:?}`的水印失败，已保留原图"));
+                    let _ = LogEvent::Warn {
+                        msg: err.to_string_chain(),
+                    }
+                    .emit(app);
+                    image_data
+                }
+            }
+        }
+        _ => image_data,
+    };
+    let gif_data;
+    let jpeg_data;
+    let (image_data, extension): (&[u8], &str) =
+        if let (Some(scale_percent), false) = (downscale_percent, image_info.is_animated()) {
+            jpeg_data = image_format::downscale_to_jpeg(image_data, scale_percent)
+                .context(format!("将图片缩小到{scale_percent}%失败"))?;
+            (&jpeg_data, "jpg")
+        } else if convert_animated_webp_to_gif
+            && image_info.format == image::ImageFormat::WebP
+            && image_info.is_animated()
+        {
+            gif_data = image_format::webp_to_gif(image_data).context("将webp动图转换为gif失败")?;
+            let _ = LogEvent::Info {
+                msg: format!(
+                    "`{save_path:?}`是有{}帧的webp动图，已转换为gif动图",
+                    image_info.frame_count
+                ),
+            }
+            .emit(app);
+            (&gif_data, "gif")
+        } else {
+            (image_data, image_info.extension())
+        };
+    write_atomic(&save_path.with_extension(extension), image_data)
+}
+
+/// 把临时下载目录移动为正式的下载目录，返回正式的下载目录
+fn rename_temp_download_dir(
+    app: &AppHandle,
+    chapter_info: &ChapterInfo,
+    temp_download_dir: &Path,
+) -> anyhow::Result<PathBuf> {
+    let download_dir = app.state::<RwLock<Config>>().read().download_dir.clone();
+    let download_dir = get_chapter_download_dir(&download_dir, chapter_info);
 
     if download_dir.exists() {
         std::fs::remove_dir_all(&download_dir)
             .context(format!("删除目录`{download_dir:?}`失败"))?;
     }
 
-    std::fs::rename(temp_download_dir, &download_dir).context(format!(
-        "将`{temp_download_dir:?}`重命名为`{download_dir:?}`失败"
+    move_dir(temp_download_dir, &download_dir).context(format!(
+        "将`{temp_download_dir:?}`移动到`{download_dir:?}`失败"
     ))?;
 
-    Ok(())
+    Ok(download_dir)
 }
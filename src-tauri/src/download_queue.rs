@@ -0,0 +1,361 @@
+use std::{
+    cmp::Reverse,
+    collections::{BTreeSet, HashMap},
+    sync::Arc,
+};
+
+use parking_lot::Mutex;
+use serde::{Deserialize, Serialize};
+use specta::Type;
+use tokio::sync::watch;
+
+use crate::types::ChapterInfo;
+
+/// 下载任务的优先级，排队时高优先级的漫画和章节先开始下载
+#[derive(
+    Default, Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, Serialize, Deserialize, Type,
+)]
+pub enum DownloadPriority {
+    #[default]
+    Normal,
+    /// 手动触发的下载，会插到自动更新、导入清单等任务前面
+    High,
+}
+
+/// 下载队列的状态
+#[derive(Debug, Clone, Serialize, Deserialize, Type)]
+#[serde(rename_all = "camelCase")]
+pub struct DownloadQueueState {
+    /// 同时下载的漫画数量上限，0表示不限制
+    pub max_active_comics: u32,
+    /// 正在下载的漫画
+    pub active_comics: Vec<QueuedComic>,
+    /// 排队中的漫画，按开始下载的先后顺序排列
+    pub waiting_comics: Vec<QueuedComic>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, Type)]
+#[serde(rename_all = "camelCase")]
+pub struct QueuedComic {
+    pub comic_id: i64,
+    pub comic_title: String,
+    pub priority: DownloadPriority,
+    /// 这本漫画正在下载或排队中的章节数
+    pub chapter_count: u32,
+}
+
+struct WaitingChapter {
+    comic_id: i64,
+    comic_title: String,
+    priority: DownloadPriority,
+    /// 提交的顺序，同优先级时先提交的先开始
+    seq: u64,
+}
+
+struct ActiveComic {
+    comic_title: String,
+    priority: DownloadPriority,
+    chapter_count: u32,
+}
+
+#[derive(Default)]
+struct QueueInner {
+    max_active_comics: u32,
+    /// key为漫画id
+    active: HashMap<i64, ActiveComic>,
+    /// key为章节id
+    waiting: HashMap<i64, WaitingChapter>,
+    next_seq: u64,
+}
+
+impl QueueInner {
+    fn has_free_slot(&self) -> bool {
+        self.max_active_comics == 0 || self.active.len() < self.max_active_comics as usize
+    }
+
+    /// 排队中的章节按`(优先级, 提交顺序)`排序，同一漫画以其中最靠前的章节为准
+    fn waiting_comic_ids(&self) -> Vec<i64> {
+        let mut chapters = self
+            .waiting
+            .values()
+            .filter(|chapter| !self.active.contains_key(&chapter.comic_id))
+            .collect::<Vec<_>>();
+        chapters.sort_by_key(|chapter| (Reverse(chapter.priority), chapter.seq));
+        let mut comic_ids: Vec<i64> = Vec::new();
+        for chapter in chapters {
+            if !comic_ids.contains(&chapter.comic_id) {
+                comic_ids.push(chapter.comic_id);
+            }
+        }
+        comic_ids
+    }
+}
+
+/// 以漫画为单位限制同时下载的数量
+///
+/// 同一漫画的章节共用一个名额，漫画的所有章节都结束后才会让出名额，
+/// 名额不够时排队，高优先级的漫画先领取名额，同优先级按提交顺序
+#[derive(Clone)]
+pub struct ComicQueue {
+    inner: Arc<Mutex<QueueInner>>,
+    /// 有漫画开始或结束下载、或者上限变化时通知排队中的章节重新检查
+    changed: Arc<watch::Sender<()>>,
+}
+
+impl ComicQueue {
+    pub fn new(max_active_comics: u32) -> Self {
+        let inner = QueueInner {
+            max_active_comics,
+            ..Default::default()
+        };
+        Self {
+            inner: Arc::new(Mutex::new(inner)),
+            changed: Arc::new(watch::channel(()).0),
+        }
+    }
+
+    /// 调小上限时，已经在下载的漫画不受影响，只是之后排队的漫画要等更久
+    pub fn set_max_active_comics(&self, max_active_comics: u32) {
+        self.inner.lock().max_active_comics = max_active_comics;
+        self.changed.send_replace(());
+    }
+
+    #[allow(clippy::cast_possible_truncation)]
+    pub fn state(&self) -> DownloadQueueState {
+        let inner = self.inner.lock();
+        let active_comics = inner
+            .active
+            .iter()
+            .map(|(comic_id, active)| QueuedComic {
+                comic_id: *comic_id,
+                comic_title: active.comic_title.clone(),
+                priority: active.priority,
+                chapter_count: active.chapter_count,
+            })
+            .collect();
+        let waiting_comics = inner
+            .waiting_comic_ids()
+            .into_iter()
+            .filter_map(|comic_id| {
+                let chapters = inner
+                    .waiting
+                    .values()
+                    .filter(|chapter| chapter.comic_id == comic_id)
+                    .collect::<Vec<_>>();
+                let first = chapters.first()?;
+                Some(QueuedComic {
+                    comic_id,
+                    comic_title: first.comic_title.clone(),
+                    priority: chapters.iter().map(|chapter| chapter.priority).max()?,
+                    chapter_count: chapters.len() as u32,
+                })
+            })
+            .collect();
+        DownloadQueueState {
+            max_active_comics: inner.max_active_comics,
+            active_comics,
+            waiting_comics,
+        }
+    }
+
+    /// 等待`chapter_info`所属的漫画领取到名额，返回的`ComicSlot`被drop时释放
+    ///
+    /// 等待期间future被drop(例如任务被取消)也会把章节从队列中移除
+    pub async fn acquire(
+        &self,
+        chapter_info: &ChapterInfo,
+        priority: DownloadPriority,
+    ) -> ComicSlot {
+        let chapter_id = chapter_info.chapter_id;
+        let comic_id = chapter_info.comic_id;
+        {
+            let mut inner = self.inner.lock();
+            let seq = inner.next_seq;
+            inner.next_seq += 1;
+            inner.waiting.insert(
+                chapter_id,
+                WaitingChapter {
+                    comic_id,
+                    comic_title: chapter_info.comic_title.clone(),
+                    priority,
+                    seq,
+                },
+            );
+        }
+        let mut slot = ComicSlot {
+            queue: self.clone(),
+            chapter_id,
+            comic_id,
+            admitted: false,
+        };
+
+        let mut changed_receiver = self.changed.subscribe();
+        loop {
+            if self.try_admit(chapter_id) {
+                slot.admitted = true;
+                return slot;
+            }
+            // sender和queue同生共死，changed不会因为sender被drop而失败
+            let _ = changed_receiver.changed().await;
+        }
+    }
+
+    fn try_admit(&self, chapter_id: i64) -> bool {
+        let mut inner = self.inner.lock();
+        let Some(comic_id) = inner
+            .waiting
+            .get(&chapter_id)
+            .map(|chapter| chapter.comic_id)
+        else {
+            return false;
+        };
+        let is_active = inner.active.contains_key(&comic_id);
+        let is_next = inner.has_free_slot() && inner.waiting_comic_ids().first() == Some(&comic_id);
+        if !is_active && !is_next {
+            return false;
+        }
+        let Some(chapter) = inner.waiting.remove(&chapter_id) else {
+            return false;
+        };
+        let active = inner.active.entry(comic_id).or_insert_with(|| ActiveComic {
+            comic_title: chapter.comic_title,
+            priority: chapter.priority,
+            chapter_count: 0,
+        });
+        active.chapter_count += 1;
+        drop(inner);
+        // 漫画刚领取到名额时，通知同一漫画排队中的其他章节
+        if !is_active {
+            self.changed.send_replace(());
+        }
+        true
+    }
+}
+
+/// 章节在下载队列中占的位置，drop时离开队列，漫画的最后一个章节离开时让出名额
+pub struct ComicSlot {
+    queue: ComicQueue,
+    chapter_id: i64,
+    comic_id: i64,
+    admitted: bool,
+}
+
+impl Drop for ComicSlot {
+    fn drop(&mut self) {
+        let mut inner = self.queue.inner.lock();
+        if self.admitted {
+            if let Some(active) = inner.active.get_mut(&self.comic_id) {
+                active.chapter_count = active.chapter_count.saturating_sub(1);
+                if active.chapter_count == 0 {
+                    inner.active.remove(&self.comic_id);
+                }
+            }
+        } else {
+            inner.waiting.remove(&self.chapter_id);
+        }
+        drop(inner);
+        self.queue.changed.send_replace(());
+    }
+}
+
+/// 限制同时下载的章节数量
+///
+/// 名额不够时排队，高优先级的章节先领取名额，同优先级按提交的先后顺序，
+/// 这样即使不限制同时下载的漫画数量，手动触发的下载也能插到前面
+#[derive(Clone)]
+pub struct ChapterQueue {
+    inner: Arc<Mutex<ChapterQueueInner>>,
+    /// 有章节开始或结束下载时通知排队中的章节重新检查
+    changed: Arc<watch::Sender<()>>,
+}
+
+struct ChapterQueueInner {
+    max_active_chapters: usize,
+    active_count: usize,
+    /// 排队中的章节，按`(优先级, 提交顺序)`排序，第一个最先领取名额
+    waiting: BTreeSet<(Reverse<DownloadPriority>, u64)>,
+    next_seq: u64,
+}
+
+impl ChapterQueue {
+    pub fn new(max_active_chapters: usize) -> Self {
+        let inner = ChapterQueueInner {
+            max_active_chapters: max_active_chapters.max(1),
+            active_count: 0,
+            waiting: BTreeSet::new(),
+            next_seq: 0,
+        };
+        Self {
+            inner: Arc::new(Mutex::new(inner)),
+            changed: Arc::new(watch::channel(()).0),
+        }
+    }
+
+    /// 分配提交顺序，提交章节时调用，之后传给`acquire`
+    ///
+    /// 章节从提交到领取名额之间还要等待漫画名额、检查其他目录等，
+    /// 到达`acquire`的先后不一定是提交的先后，所以顺序要在提交时就确定下来
+    pub fn next_seq(&self) -> u64 {
+        let mut inner = self.inner.lock();
+        let seq = inner.next_seq;
+        inner.next_seq += 1;
+        seq
+    }
+
+    /// 等待领取到名额，返回的`ChapterPermit`被drop时释放，`seq`是`next_seq`分配的提交顺序
+    ///
+    /// 等待期间future被drop(例如任务被取消)也会把章节从队列中移除
+    pub async fn acquire(&self, priority: DownloadPriority, seq: u64) -> ChapterPermit {
+        let key = (Reverse(priority), seq);
+        self.inner.lock().waiting.insert(key);
+        let mut permit = ChapterPermit {
+            queue: self.clone(),
+            key,
+            admitted: false,
+        };
+
+        let mut changed_receiver = self.changed.subscribe();
+        loop {
+            if self.try_admit(key) {
+                permit.admitted = true;
+                return permit;
+            }
+            // sender和queue同生共死，changed不会因为sender被drop而失败
+            let _ = changed_receiver.changed().await;
+        }
+    }
+
+    fn try_admit(&self, key: (Reverse<DownloadPriority>, u64)) -> bool {
+        let mut inner = self.inner.lock();
+        let has_free_slot = inner.active_count < inner.max_active_chapters;
+        if !has_free_slot || inner.waiting.first() != Some(&key) {
+            return false;
+        }
+        inner.waiting.remove(&key);
+        inner.active_count += 1;
+        drop(inner);
+        // 还有空闲名额时让下一个排队的章节也检查一下
+        self.changed.send_replace(());
+        true
+    }
+}
+
+/// 章节在`ChapterQueue`中占的位置，drop时离开队列或让出名额
+pub struct ChapterPermit {
+    queue: ChapterQueue,
+    key: (Reverse<DownloadPriority>, u64),
+    admitted: bool,
+}
+
+impl Drop for ChapterPermit {
+    fn drop(&mut self) {
+        let mut inner = self.queue.inner.lock();
+        if self.admitted {
+            inner.active_count = inner.active_count.saturating_sub(1);
+        } else {
+            inner.waiting.remove(&self.key);
+        }
+        drop(inner);
+        self.queue.changed.send_replace(());
+    }
+}
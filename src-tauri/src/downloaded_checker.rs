@@ -0,0 +1,153 @@
+use std::path::{Path, PathBuf};
+
+use anyhow::Context;
+use serde::{Deserialize, Serialize};
+use specta::Type;
+
+use crate::{
+    chapter_manifest::ChapterManifest,
+    image_format::{self, IMAGE_EXTENSIONS},
+};
+
+/// 判断章节是否已下载
+///
+/// `chapter_download_dir`是章节的下载目录，`chapter_size`是章节的页数
+pub trait DownloadedChecker: Send + Sync {
+    fn is_downloaded(&self, chapter_download_dir: &Path, chapter_size: i64) -> bool;
+}
+
+/// 下载目录存在就算已下载
+///
+/// 章节下载完成后才会把临时目录重命名为下载目录，所以目录存在时通常是完整的
+pub struct PathExistsChecker;
+
+impl DownloadedChecker for PathExistsChecker {
+    fn is_downloaded(&self, chapter_download_dir: &Path, _chapter_size: i64) -> bool {
+        chapter_download_dir.exists()
+    }
+}
+
+/// 下载目录中的图片数量不少于章节页数才算已下载
+pub struct ImageCountChecker;
+
+impl DownloadedChecker for ImageCountChecker {
+    fn is_downloaded(&self, chapter_download_dir: &Path, chapter_size: i64) -> bool {
+        let image_count = image_paths(chapter_download_dir).len();
+        i64::try_from(image_count).is_ok_and(|image_count| image_count >= chapter_size)
+    }
+}
+
+/// 在`ImageCountChecker`的基础上，还要求每张图片都能完整解码才算已下载，比较慢
+pub struct ImageIntegrityChecker;
+
+impl DownloadedChecker for ImageIntegrityChecker {
+    fn is_downloaded(&self, chapter_download_dir: &Path, chapter_size: i64) -> bool {
+        if !ImageCountChecker.is_downloaded(chapter_download_dir, chapter_size) {
+            return false;
+        }
+        image_paths(chapter_download_dir).iter().all(|path| {
+            std::fs::read(path).is_ok_and(|data| image_format::inspect_image(&data).is_ok())
+        })
+    }
+}
+
+/// 章节目录中有校验和清单，且清单中的每一页都存在、大小和哈希都一致才算已下载
+///
+/// 需要读取每张图片计算哈希，比较慢，没有清单的章节(旧版本下载的)会被视为未下载
+pub struct ManifestChecker;
+
+impl DownloadedChecker for ManifestChecker {
+    fn is_downloaded(&self, chapter_download_dir: &Path, chapter_size: i64) -> bool {
+        ChapterManifest::load(chapter_download_dir)
+            .ok()
+            .flatten()
+            .is_some_and(|manifest| manifest.is_complete(chapter_download_dir, chapter_size))
+    }
+}
+
+/// 判断章节是否已下载的策略
+#[derive(Default, Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize, Type)]
+pub enum DownloadedCheckStrategy {
+    /// 下载目录存在就算已下载
+    #[default]
+    PathExists,
+    /// 下载目录中的图片数量不少于章节页数才算已下载，导出后删除了原图的章节会被视为未下载
+    ImageCount,
+    /// 图片数量足够且每张都能完整解码才算已下载，导出后删除了原图的章节会被视为未下载
+    ImageIntegrity,
+    /// 校验和清单存在且每一页都校验通过才算已下载，旧版本下载的没有清单的章节会被视为未下载
+    Manifest,
+}
+
+impl DownloadedCheckStrategy {
+    pub fn checker(self) -> Box<dyn DownloadedChecker> {
+        match self {
+            DownloadedCheckStrategy::PathExists => Box::new(PathExistsChecker),
+            DownloadedCheckStrategy::ImageCount => Box::new(ImageCountChecker),
+            DownloadedCheckStrategy::ImageIntegrity => Box::new(ImageIntegrityChecker),
+            DownloadedCheckStrategy::Manifest => Box::new(ManifestChecker),
+        }
+    }
+}
+
+/// 获取`dir`中所有图片的路径，按文件名(即页码)排序，读取失败时返回空
+pub fn image_paths(dir: &Path) -> Vec<PathBuf> {
+    let Ok(entries) = std::fs::read_dir(dir) else {
+        return Vec::new();
+    };
+    let mut paths = entries
+        .filter_map(Result::ok)
+        .map(|entry| entry.path())
+        .filter(|path| {
+            path.extension()
+                .and_then(|extension| extension.to_str())
+                .is_some_and(|extension| IMAGE_EXTENSIONS.contains(&extension))
+        })
+        .collect::<Vec<_>>();
+    paths.sort();
+    paths
+}
+
+/// 第`index`页(从0开始)的文件名，不含扩展名，扩展名由图片的实际格式决定
+///
+/// 文件名只由页码决定，与网站上的文件名无关，并发下载时每页写入各自的文件，
+/// 断点续传和完整性校验也按页码找到对应的文件。
+/// 页码按总页数的位数零填充(至少3位)，保证按文件名排序就是正确的页码顺序
+pub fn page_file_stem(index: usize, total: u32) -> String {
+    let width = total.to_string().len().max(3);
+    format!("{:0width$}", index + 1)
+}
+
+/// 从文件名解析出页码(从1开始)，文件名不是页码时返回None
+pub fn page_num_of(path: &Path) -> Option<u32> {
+    path.file_stem()?.to_str()?.parse().ok()
+}
+
+/// 把`dir`中已下载的页重命名为`page_file_stem`对应的文件名
+///
+/// 总页数的位数变了(例如从999页变成1000页)时，按旧位数命名的页会对不上，
+/// 续传时同一页就会存在两个文件。同一页已经有按新位数命名的文件时，删除旧的那个
+pub fn normalize_page_filenames(dir: &Path, total: u32) -> anyhow::Result<()> {
+    for path in image_paths(dir) {
+        let Some(page_num) = page_num_of(&path).filter(|page_num| (1..=total).contains(page_num))
+        else {
+            continue;
+        };
+        let Some(extension) = path.extension() else {
+            continue;
+        };
+        let expected_path = dir
+            .join(page_file_stem(page_num as usize - 1, total))
+            .with_extension(extension);
+        if expected_path == path {
+            continue;
+        }
+        if expected_path.exists() {
+            std::fs::remove_file(&path).context(format!("删除重复的`{path:?}`失败"))?;
+        } else {
+            std::fs::rename(&path, &expected_path)
+                .context(format!("将`{path:?}`重命名为`{expected_path:?}`失败"))?;
+        }
+    }
+    Ok(())
+}
@@ -1,25 +1,73 @@
 use serde::Serialize;
 use specta::Type;
 
-use crate::extensions::AnyhowErrorToStringChain;
+use crate::{
+    extensions::AnyhowErrorToStringChain,
+    manhuagui_client::{
+        BlockedError, ChapterUnavailableError, ComicNotFoundError, ManhuaguiClient,
+        ParseFailedError, SiteChangedError,
+    },
+};
 
 pub type CommandResult<T> = Result<T, CommandError>;
 
-#[derive(Debug, Type)]
-pub struct CommandError(String);
-impl Serialize for CommandError {
-    fn serialize<S>(&self, serializer: S) -> Result<S::Ok, S::Error>
-    where
-        S: serde::Serializer,
-    {
-        serializer.serialize_str(&format!("{:#}", self.0))
+/// 错误的类型，前端据此给出不同的提示和重试方式
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Type)]
+pub enum ErrorKind {
+    /// 漫画或章节不存在、已被删除或下架，重试也不会成功
+    ComicNotFound,
+    /// 被网站风控拦截，需要换代理、稍后再试或登录
+    Blocked,
+    /// 页面的关键区块全都找不到，网站结构可能已变更，需要更新解析规则
+    SiteChanged,
+    /// 网页无法解析，通常是网站改版了
+    ParseFailed,
+    /// 代理要求认证，但用户名或密码不对，需要修改代理地址
+    ProxyAuthFailed,
+    /// 连接失败或超时，网络恢复后重试即可
+    Network,
+    /// 其他错误
+    Other,
+}
+
+impl ErrorKind {
+    /// 按`err`的错误链判断错误类型，链上同时有多种时以风控、不存在、结构变更、解析失败、代理认证、网络的顺序为准
+    pub fn of(err: &anyhow::Error) -> Self {
+        if err.downcast_ref::<BlockedError>().is_some() {
+            Self::Blocked
+        } else if err.downcast_ref::<ComicNotFoundError>().is_some()
+            || err.downcast_ref::<ChapterUnavailableError>().is_some()
+        {
+            Self::ComicNotFound
+        } else if err.downcast_ref::<SiteChangedError>().is_some() {
+            Self::SiteChanged
+        } else if err.downcast_ref::<ParseFailedError>().is_some() {
+            Self::ParseFailed
+        } else if ManhuaguiClient::is_proxy_auth_error(err) {
+            Self::ProxyAuthFailed
+        } else if ManhuaguiClient::is_network_error(err) {
+            Self::Network
+        } else {
+            Self::Other
+        }
     }
 }
+
+#[derive(Debug, Serialize, Type)]
+#[serde(rename_all = "camelCase")]
+pub struct CommandError {
+    pub kind: ErrorKind,
+    pub message: String,
+}
 impl<E> From<E> for CommandError
 where
     E: Into<anyhow::Error>,
 {
     fn from(err: E) -> Self {
-        Self(err.into().to_string_chain())
+        let err = err.into();
+        Self {
+            kind: ErrorKind::of(&err),
+            message: err.to_string_chain(),
+        }
     }
 }
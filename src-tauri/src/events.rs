@@ -8,6 +8,7 @@ pub enum DownloadEvent {
     #[serde(rename_all = "camelCase")]
     ChapterPending {
         chapter_id: i64,
+        comic_id: i64,
         comic_title: String,
         chapter_title: String,
     },
@@ -15,8 +16,13 @@ pub enum DownloadEvent {
     #[serde(rename_all = "camelCase")]
     ChapterControlRisk { chapter_id: i64, retry_after: u32 },
 
+    /// `total_bytes`是按首张图片的大小估算的整话字节数，估算失败时为None，此时按页数计算进度
     #[serde(rename_all = "camelCase")]
-    ChapterStart { chapter_id: i64, total: u32 },
+    ChapterStart {
+        chapter_id: i64,
+        total: u32,
+        total_bytes: Option<u64>,
+    },
 
     #[serde(rename_all = "camelCase")]
     ChapterEnd {
@@ -24,11 +30,27 @@ pub enum DownloadEvent {
         err_msg: Option<String>,
     },
 
+    #[serde(rename_all = "camelCase")]
+    ChapterCanceled { chapter_id: i64 },
+
+    /// 章节被单独暂停
+    #[serde(rename_all = "camelCase")]
+    ChapterPaused { chapter_id: i64 },
+
+    /// 单独暂停的章节恢复了，全局暂停时要等全局恢复后才会继续下载
+    #[serde(rename_all = "camelCase")]
+    ChapterResumed { chapter_id: i64 },
+
+    #[serde(rename_all = "camelCase")]
+    ChapterUnavailable { chapter_id: i64, reason: String },
+
     #[serde(rename_all = "camelCase")]
     ImageSuccess {
         chapter_id: i64,
         url: String,
         current: u32,
+        /// 此章节已下载图片的总字节数
+        downloaded_bytes: u64,
     },
 
     #[serde(rename_all = "camelCase")]
@@ -38,10 +60,47 @@ pub enum DownloadEvent {
         err_msg: String,
     },
 
+    /// 一本漫画提交的章节全部结束了，包括失败和取消的，`elapsed_secs`从第一个章节提交时算起
+    #[serde(rename_all = "camelCase")]
+    ComicCompleted {
+        comic_id: i64,
+        comic_title: String,
+        succeeded: u32,
+        failed: u32,
+        canceled: u32,
+        elapsed_secs: u64,
+    },
+
+    /// 队列中所有的章节都结束了，期间陆续提交的章节都算在这一批里
+    #[serde(rename_all = "camelCase")]
+    BatchCompleted {
+        comic_count: u32,
+        succeeded: u32,
+        failed: u32,
+        canceled: u32,
+        elapsed_secs: u64,
+    },
+
+    /// 网络断开，下载任务暂停，等网络恢复后自动继续
+    NetworkWaiting,
+
+    /// 网络已恢复，下载任务继续
+    NetworkRestored,
+
     #[serde(rename_all = "camelCase")]
     Speed { speed: String },
 }
 
+#[derive(Debug, Clone, Serialize, Deserialize, Type, Event)]
+#[serde(tag = "event", content = "data")]
+pub enum LogEvent {
+    #[serde(rename_all = "camelCase")]
+    Info { msg: String },
+
+    #[serde(rename_all = "camelCase")]
+    Warn { msg: String },
+}
+
 #[derive(Debug, Clone, Serialize, Deserialize, Type, Event)]
 #[serde(tag = "event", content = "data")]
 pub enum ExportCbzEvent {
@@ -89,6 +148,23 @@ pub enum ExportPdfEvent {
     MergeEnd { uuid: String },
 }
 
+#[derive(Debug, Clone, Serialize, Deserialize, Type, Event)]
+#[serde(tag = "event", content = "data")]
+pub enum ExportEpubEvent {
+    #[serde(rename_all = "camelCase")]
+    Start {
+        uuid: String,
+        comic_title: String,
+        total: u32,
+    },
+
+    #[serde(rename_all = "camelCase")]
+    Progress { uuid: String, current: u32 },
+
+    #[serde(rename_all = "camelCase")]
+    End { uuid: String },
+}
+
 #[derive(Debug, Clone, Serialize, Deserialize, Type, Event)]
 #[serde(tag = "event", content = "data")]
 pub enum UpdateDownloadedComicsEvent {
@@ -101,3 +177,20 @@ pub enum UpdateDownloadedComicsEvent {
     #[serde(rename_all = "camelCase")]
     DownloadTaskCreated,
 }
+
+#[derive(Debug, Clone, Serialize, Deserialize, Type, Event)]
+#[serde(tag = "event", content = "data")]
+pub enum WebDavSyncEvent {
+    #[serde(rename_all = "camelCase")]
+    Start {
+        uuid: String,
+        dir_name: String,
+        total: u32,
+    },
+
+    #[serde(rename_all = "camelCase")]
+    Progress { uuid: String, current: u32 },
+
+    #[serde(rename_all = "camelCase")]
+    End { uuid: String },
+}
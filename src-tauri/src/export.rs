@@ -1,6 +1,6 @@
 use std::{
-    collections::{BTreeMap, HashMap},
-    io::{Read, Write},
+    collections::{BTreeMap, HashMap, HashSet},
+    io::{BufWriter, Read, Seek, Write},
     path::{Path, PathBuf},
     sync::{atomic::AtomicU32, Arc},
 };
@@ -8,53 +8,58 @@ use std::{
 use anyhow::{anyhow, Context};
 use lopdf::{
     content::{Content, Operation},
-    dictionary, Bookmark, Document, Object, Stream,
+    dictionary, Bookmark, Document, Object, ObjectId, Stream,
 };
 use parking_lot::RwLock;
 use rayon::iter::{IntoParallelIterator, ParallelIterator};
 use tauri::{AppHandle, Manager};
 use tauri_specta::Event;
-use zip::{write::SimpleFileOptions, ZipWriter};
+use zip::{write::SimpleFileOptions, CompressionMethod, ZipWriter};
 
 use crate::{
-    config::Config,
-    events::{ExportCbzEvent, ExportPdfEvent},
+    config::{Config, ExportFormat},
+    downloaded_checker::{image_paths, page_file_stem, page_num_of},
+    events::{ExportCbzEvent, ExportEpubEvent, ExportPdfEvent, LogEvent},
+    extensions::AnyhowErrorToStringChain,
+    image_format,
     types::{ChapterInfo, Comic, ComicInfo},
+    utils::write_atomic_with,
 };
 
+/// A4纸的高宽比，切割长图时每页的高度为宽度的这个倍数
+const A4_PAGE_RATIO: f64 = 1.414;
+
+/// 题材中带有这些文本的漫画是条漫
+const LONG_STRIP_GENRE_MARKERS: [&str; 2] = ["条漫", "韩漫"];
+/// 判断是否为条漫时，最多检查多少张已下载的图片
+const LONG_STRIP_SAMPLE_COUNT: usize = 5;
+/// 占位图上标注的缺页原因，点阵字体只支持英文
+const PLACEHOLDER_REASON: &str = "DOWNLOAD FAILED";
+
 enum Archive {
     Cbz,
     Pdf,
+    Epub,
 }
 impl Archive {
     pub fn extension(&self) -> &str {
         match self {
             Archive::Cbz => "cbz",
             Archive::Pdf => "pdf",
+            Archive::Epub => "epub",
         }
     }
 }
 
-#[allow(clippy::cast_possible_wrap)]
 #[allow(clippy::cast_possible_truncation)]
-pub fn cbz(app: &AppHandle, comic: Comic) -> anyhow::Result<()> {
+pub fn cbz(app: &AppHandle, mut comic: Comic) -> anyhow::Result<()> {
     // 获取已下载的章节
-    let downloaded_chapters = comic
-        .groups
-        .into_iter()
-        .flat_map(|(_, chapters)| chapters)
-        .filter(|chapter| chapter.is_downloaded.unwrap_or(false))
-        .collect::<Vec<_>>();
-    // 生成格式化的xml
-    let cfg = yaserde::ser::Config {
-        perform_indent: true,
-        ..Default::default()
-    };
+    let downloaded_chapters = get_downloaded_chapters(app, std::mem::take(&mut comic.groups));
     let event_uuid = uuid::Uuid::new_v4().to_string();
     // 发送开始导出cbz事件
     let _ = ExportCbzEvent::Start {
         uuid: event_uuid.clone(),
-        comic_title: comic.title,
+        comic_title: comic.title.clone(),
         total: downloaded_chapters.len() as u32,
     }
     .emit(app);
@@ -63,71 +68,7 @@ pub fn cbz(app: &AppHandle, comic: Comic) -> anyhow::Result<()> {
     // 并发处理
     let downloaded_chapters = downloaded_chapters.into_par_iter();
     downloaded_chapters.try_for_each(|chapter_info| -> anyhow::Result<()> {
-        let chapter_title = chapter_info.chapter_title.clone();
-        let prefixed_chapter_title = chapter_info.prefixed_chapter_title.clone();
-        let group_name = chapter_info.group_name.clone();
-        let chapter_download_dir = get_chapter_download_dir(app, &chapter_info);
-        let chapter_export_dir = get_chapter_export_dir(app, &chapter_info, &Archive::Cbz);
-        let comic_info_path = chapter_export_dir.join("ComicInfo.xml");
-        let err_prefix = format!("`{group_name} - {chapter_title}`");
-        // 生成ComicInfo
-        let comic_info = ComicInfo::from(
-            chapter_info,
-            &comic.authors,
-            &comic.genres,
-            comic.intro.clone(),
-        );
-        // 序列化ComicInfo为xml
-        let comic_info_xml = yaserde::ser::to_string_with_config(&comic_info, &cfg)
-            .map_err(|err_msg| anyhow!("{err_prefix}序列化`{comic_info_path:?}`失败: {err_msg}"))?;
-        // 保证导出目录存在
-        std::fs::create_dir_all(&chapter_export_dir)
-            .context(format!("{err_prefix}创建目录`{chapter_export_dir:?}`失败"))?;
-        // 创建cbz文件
-        let extension = Archive::Cbz.extension();
-        let zip_path = chapter_export_dir.join(format!("{prefixed_chapter_title}.{extension}"));
-        let zip_file = std::fs::File::create(&zip_path)
-            .context(format!("{err_prefix}创建文件`{zip_path:?}`失败"))?;
-        let mut zip_writer = ZipWriter::new(zip_file);
-        // 把ComicInfo.xml写入cbz
-        zip_writer
-            .start_file("ComicInfo.xml", SimpleFileOptions::default())
-            .context(format!(
-                "{err_prefix}在`{zip_path:?}`创建`ComicInfo.xml`失败"
-            ))?;
-        zip_writer
-            .write_all(comic_info_xml.as_bytes())
-            .context("{err_prefix}写入`ComicInfo.xml`失败")?;
-        // 遍历下载目录，将文件写入cbz
-        let entries = std::fs::read_dir(&chapter_download_dir)
-            .context(format!(
-                "{err_prefix}读取目录`{chapter_download_dir:?}`失败"
-            ))?
-            .filter_map(Result::ok);
-        for entry in entries {
-            let path = entry.path();
-            if !path.is_file() {
-                continue;
-            }
-
-            let filename = match path.file_name() {
-                Some(name) => name.to_string_lossy(),
-                None => continue,
-            };
-            // 将文件写入cbz
-            zip_writer
-                .start_file(&filename, SimpleFileOptions::default())
-                .context(format!(
-                    "{err_prefix}在`{zip_path:?}`创建`{filename:?}`失败"
-                ))?;
-            let mut file = std::fs::File::open(&path).context(format!("打开 {path:?} 失败"))?;
-            std::io::copy(&mut file, &mut zip_writer)
-                .context(format!("{err_prefix}将`{path:?}`写入`{zip_path:?}`失败"))?;
-        }
-
-        zip_writer
-            .finish()
-            .context(format!("{err_prefix}关闭`{zip_path:?}`失败"))?;
+        create_cbz(app, chapter_info, &comic)?;
         // 更新导出cbz的进度
         let current = current.fetch_add(1, std::sync::atomic::Ordering::Relaxed) + 1;
         // 发送导出cbz进度事件
@@ -144,10 +85,249 @@ pub fn cbz(app: &AppHandle, comic: Comic) -> anyhow::Result<()> {
     Ok(())
 }
 
+/// 章节下载完成后，根据配置把它导出为cbz，并删除原图
+///
+/// 作为下载完成的回调使用，所以出错时通过`LogEvent`告知前端而不是返回错误
+pub fn on_chapter_completed(
+    app: &AppHandle,
+    chapter_info: &ChapterInfo,
+    chapter_download_dir: &Path,
+) {
+    let (export_cbz, delete_images) = {
+        let config = app.state::<RwLock<Config>>();
+        let config = config.read();
+        (
+            config.export_cbz_on_chapter_completed,
+            config.delete_images_after_auto_export,
+        )
+    };
+    if !export_cbz {
+        return;
+    }
+
+    let comic_title = &chapter_info.comic_title;
+    let chapter_title = &chapter_info.chapter_title;
+    let result =
+        export_completed_chapter_cbz(app, chapter_info, chapter_download_dir, delete_images);
+    if let Err(err) = result {
+        let err = err.context(format!("`{comic_title} - {chapter_title}`自动导出cbz失败"));
+        let _ = LogEvent::Warn {
+            msg: err.to_string_chain(),
+        }
+        .emit(app);
+    }
+}
+
+fn export_completed_chapter_cbz(
+    app: &AppHandle,
+    chapter_info: &ChapterInfo,
+    chapter_download_dir: &Path,
+    delete_images: bool,
+) -> anyhow::Result<()> {
+    // 下载前已经保存了元数据，从中读取作者、类型等信息
+    // 章节目录的层数由`dir_layout`决定，去掉`dir_in_comic`的那几层才是漫画目录
+    let depth_in_comic = chapter_info.dir_in_comic().components().count();
+    let metadata_path = chapter_download_dir
+        .ancestors()
+        .nth(depth_in_comic)
+        .context(format!("获取`{chapter_download_dir:?}`所在的漫画目录失败"))?
+        .join("元数据.json");
+    let comic = Comic::from_metadata(app, &metadata_path)?;
+    create_cbz(app, chapter_info.clone(), &comic)?;
+
+    if delete_images {
+        // 只删除图片，保留章节目录，否则这个章节会被当作未下载
+        let entries = std::fs::read_dir(chapter_download_dir)
+            .context(format!("读取目录`{chapter_download_dir:?}`失败"))?
+            .filter_map(Result::ok);
+        for entry in entries {
+            let path = entry.path();
+            if path.is_file() {
+                std::fs::remove_file(&path).context(format!("删除`{path:?}`失败"))?;
+            }
+        }
+    }
+
+    Ok(())
+}
+
+/// 把`chapter_info`对应的已下载章节打包成cbz
+#[allow(clippy::cast_possible_wrap)]
+fn create_cbz(app: &AppHandle, chapter_info: ChapterInfo, comic: &Comic) -> anyhow::Result<()> {
+    // 生成格式化的xml
+    let cfg = yaserde::ser::Config {
+        perform_indent: true,
+        ..Default::default()
+    };
+    let chapter_title = chapter_info.chapter_title.clone();
+    let prefixed_chapter_title = chapter_info.prefixed_chapter_title.clone();
+    let group_name = chapter_info.group_name.clone();
+    let chapter_download_dir = get_chapter_download_dir(app, &chapter_info);
+    let chapter_export_dir = get_chapter_export_dir(app, &chapter_info, &Archive::Cbz);
+    let comic_info_path = chapter_export_dir.join("ComicInfo.xml");
+    let err_prefix = format!("`{group_name} - {chapter_title}`");
+    // 生成ComicInfo
+    let comic_info = ComicInfo::from(
+        chapter_info.clone(),
+        &comic.authors,
+        &comic.genres,
+        comic.intro.clone(),
+    );
+    // 序列化ComicInfo为xml
+    let comic_info_xml = yaserde::ser::to_string_with_config(&comic_info, &cfg)
+        .map_err(|err_msg| anyhow!("{err_prefix}序列化`{comic_info_path:?}`失败: {err_msg}"))?;
+    // 保证导出目录存在
+    std::fs::create_dir_all(&chapter_export_dir)
+        .context(format!("{err_prefix}创建目录`{chapter_export_dir:?}`失败"))?;
+    // 创建cbz文件
+    let extension = Archive::Cbz.extension();
+    let zip_path = chapter_export_dir.join(format!("{prefixed_chapter_title}.{extension}"));
+    let zip_file = std::fs::File::create(&zip_path)
+        .context(format!("{err_prefix}创建文件`{zip_path:?}`失败"))?;
+    let mut zip_writer = ZipWriter::new(zip_file);
+    // 把ComicInfo.xml写入cbz
+    zip_writer
+        .start_file("ComicInfo.xml", SimpleFileOptions::default())
+        .context(format!(
+            "{err_prefix}在`{zip_path:?}`创建`ComicInfo.xml`失败"
+        ))?;
+    zip_writer
+        .write_all(comic_info_xml.as_bytes())
+        .context("{err_prefix}写入`ComicInfo.xml`失败")?;
+    // 按页码顺序将图片写入cbz
+    write_chapter_pages(
+        app,
+        &mut zip_writer,
+        &chapter_info,
+        &chapter_download_dir,
+        "",
+    )
+    .context(format!("{err_prefix}将图片写入`{zip_path:?}`失败"))?;
+
+    zip_writer
+        .finish()
+        .context(format!("{err_prefix}关闭`{zip_path:?}`失败"))?;
+
+    Ok(())
+}
+
+/// 按页码顺序把章节的图片写入`zip_writer`，缺页用占位图代替，`entry_dir`为图片在压缩包内所在的目录
+///
+/// 图片逐个以流的方式复制进去，不会把整话读进内存
+pub fn write_chapter_pages<W: Write + Seek>(
+    app: &AppHandle,
+    zip_writer: &mut ZipWriter<W>,
+    chapter_info: &ChapterInfo,
+    chapter_download_dir: &Path,
+    entry_dir: &str,
+) -> anyhow::Result<()> {
+    let total = u32::try_from(chapter_info.chapter_size).unwrap_or_default();
+    for page in get_export_pages(app, chapter_info, chapter_download_dir) {
+        match page {
+            ExportPage::Image(path) => {
+                let filename = match path.file_name() {
+                    Some(name) => format!("{entry_dir}{}", name.to_string_lossy()),
+                    None => continue,
+                };
+                zip_writer
+                    .start_file(&filename, SimpleFileOptions::default())
+                    .context(format!("创建`{filename}`失败"))?;
+                let mut file = std::fs::File::open(&path).context(format!("打开 {path:?} 失败"))?;
+                std::io::copy(&mut file, zip_writer).context(format!("写入`{path:?}`失败"))?;
+            }
+            ExportPage::Placeholder(page_num) => {
+                // 文件名与下载时的命名规则一致，保证按文件名排序就是页码顺序
+                let file_stem = page_file_stem(page_num as usize - 1, total);
+                let filename = format!("{entry_dir}{file_stem}.jpg");
+                let placeholder = image_format::placeholder_jpeg(page_num, PLACEHOLDER_REASON)
+                    .context(format!("生成第{page_num}页的占位图失败"))?;
+                zip_writer
+                    .start_file(&filename, SimpleFileOptions::default())
+                    .context(format!("创建`{filename}`失败"))?;
+                zip_writer
+                    .write_all(&placeholder.data)
+                    .context(format!("写入`{filename}`失败"))?;
+            }
+        }
+    }
+    Ok(())
+}
+
+/// 把`comic_dir`中已下载的章节打包成普通的zip，不带`ComicInfo.xml`
+///
+/// `per_chapter`为true时每话一个zip，保存到`out_path`目录下的`章节组/章节.zip`，
+/// 否则整本打包成`out_path`这一个zip，压缩包内为`章节组/章节/图片`的结构。
+/// 都先写入临时文件再重命名，导出到一半失败时不会留下不完整的zip
+pub fn zip(
+    app: &AppHandle,
+    comic_dir: &Path,
+    out_path: &Path,
+    per_chapter: bool,
+) -> anyhow::Result<()> {
+    let metadata_path = comic_dir.join("元数据.json");
+    let comic = Comic::from_metadata(app, &metadata_path)?;
+    let mut downloaded_chapters = get_downloaded_chapters(app, comic.groups);
+    downloaded_chapters.sort_by(|a, b| {
+        a.group_name
+            .cmp(&b.group_name)
+            .then(a.order.total_cmp(&b.order))
+    });
+
+    if per_chapter {
+        downloaded_chapters
+            .into_par_iter()
+            .try_for_each(|chapter_info| -> anyhow::Result<()> {
+                let group_name = &chapter_info.group_name;
+                let chapter_title = &chapter_info.chapter_title;
+                let zip_path = out_path
+                    .join(group_name)
+                    .join(format!("{}.zip", chapter_info.prefixed_chapter_title));
+                let chapter_download_dir = comic_dir.join(chapter_info.dir_in_comic());
+                write_zip_file(&zip_path, |zip_writer| {
+                    write_chapter_pages(app, zip_writer, &chapter_info, &chapter_download_dir, "")
+                })
+                .context(format!("`{group_name} - {chapter_title}`导出zip失败"))
+            })?;
+        return Ok(());
+    }
+
+    write_zip_file(out_path, |zip_writer| {
+        for chapter_info in &downloaded_chapters {
+            let group_name = &chapter_info.group_name;
+            let chapter_title = &chapter_info.chapter_title;
+            let prefixed_chapter_title = &chapter_info.prefixed_chapter_title;
+            let chapter_download_dir = comic_dir.join(chapter_info.dir_in_comic());
+            let entry_dir = format!("{group_name}/{prefixed_chapter_title}/");
+            write_chapter_pages(
+                app,
+                zip_writer,
+                chapter_info,
+                &chapter_download_dir,
+                &entry_dir,
+            )
+            .context(format!("写入`{group_name} - {chapter_title}`失败"))?;
+        }
+        Ok(())
+    })
+}
+
+/// 用`write`往临时文件中写入zip的内容，完成后重命名为`zip_path`
+fn write_zip_file(
+    zip_path: &Path,
+    write: impl FnOnce(&mut ZipWriter<&mut BufWriter<std::fs::File>>) -> anyhow::Result<()>,
+) -> anyhow::Result<()> {
+    write_atomic_with(zip_path, |buf_writer| {
+        let mut zip_writer = ZipWriter::new(buf_writer);
+        write(&mut zip_writer)?;
+        zip_writer.finish().context("结束zip写入失败")?;
+        Ok(())
+    })
+}
+
 #[allow(clippy::cast_possible_truncation)]
 pub fn pdf(app: &AppHandle, comic: Comic) -> anyhow::Result<()> {
     let comic_title = comic.title.clone();
-    let downloaded_chapters = get_downloaded_chapters(comic.groups);
+    let downloaded_chapters = get_downloaded_chapters(app, comic.groups);
     let event_uuid = uuid::Uuid::new_v4().to_string();
     // 发送开始创建pdf事件
     let _ = ExportPdfEvent::CreateStart {
@@ -163,9 +343,9 @@ pub fn pdf(app: &AppHandle, comic: Comic) -> anyhow::Result<()> {
     downloaded_chapters.try_for_each(|chapter_info| -> anyhow::Result<()> {
         let chapter_download_dir = get_chapter_download_dir(app, &chapter_info);
         let chapter_export_dir = get_chapter_export_dir(app, &chapter_info, &Archive::Pdf);
-        let group_name = chapter_info.group_name;
-        let chapter_title = chapter_info.chapter_title;
-        let prefixed_chapter_title = chapter_info.prefixed_chapter_title;
+        let group_name = &chapter_info.group_name;
+        let chapter_title = &chapter_info.chapter_title;
+        let prefixed_chapter_title = &chapter_info.prefixed_chapter_title;
         // 保证导出目录存在
         std::fs::create_dir_all(&chapter_export_dir).context(format!(
             "`{group_name} - {chapter_title}`创建目录`{chapter_export_dir:?}`失败"
@@ -173,7 +353,7 @@ pub fn pdf(app: &AppHandle, comic: Comic) -> anyhow::Result<()> {
         // 创建pdf
         let extension = Archive::Pdf.extension();
         let pdf_path = chapter_export_dir.join(format!("{prefixed_chapter_title}.{extension}"));
-        create_pdf(&chapter_download_dir, &pdf_path)
+        create_pdf(app, &chapter_info, &chapter_download_dir, &pdf_path)
             .context(format!("`{group_name} - {chapter_title}`创建pdf失败"))?;
         // 更新创建pdf的进度
         let current = current.fetch_add(1, std::sync::atomic::Ordering::Relaxed) + 1;
@@ -188,7 +368,7 @@ pub fn pdf(app: &AppHandle, comic: Comic) -> anyhow::Result<()> {
     // 发送创建pdf完成事件
     let _ = ExportPdfEvent::CreateEnd { uuid: event_uuid }.emit(app);
 
-    let group_export_dir = get_group_export_dir(app, &comic_title, &Archive::Pdf);
+    let group_export_dir = get_group_export_dir(app, &comic.dir_name, &Archive::Pdf);
     let chapter_export_dirs = std::fs::read_dir(&group_export_dir)
         .context(format!("读取目录`{group_export_dir:?}`失败"))?
         .filter_map(Result::ok)
@@ -230,67 +410,460 @@ pub fn pdf(app: &AppHandle, comic: Comic) -> anyhow::Result<()> {
     Ok(())
 }
 
-/// 用`chapter_download_dir`中的图片创建PDF，保存到`pdf_path`中
-#[allow(clippy::similar_names)]
+/// 每个组导出为一个epub，组内每个章节在目录中对应一项
+///
+/// `long_strip`为true时，每个章节的所有图片放在同一个页面里连续显示，适合条漫
 #[allow(clippy::cast_possible_truncation)]
-fn create_pdf(chapter_download_dir: &Path, pdf_path: &Path) -> anyhow::Result<()> {
-    let mut image_paths = std::fs::read_dir(chapter_download_dir)
-        .context(format!("读取目录`{chapter_download_dir:?}`失败"))?
-        .filter_map(Result::ok)
-        .map(|entry| entry.path())
+pub fn epub(app: &AppHandle, comic: Comic, long_strip: bool) -> anyhow::Result<()> {
+    let comic_title = comic.title.clone();
+    // 将已下载的章节按组分类
+    let mut groups = BTreeMap::new();
+    for chapter_info in get_downloaded_chapters(app, comic.groups) {
+        groups
+            .entry(chapter_info.group_name.clone())
+            .or_insert_with(Vec::new)
+            .push(chapter_info);
+    }
+    let group_export_dir = get_group_export_dir(app, &comic.dir_name, &Archive::Epub);
+    std::fs::create_dir_all(&group_export_dir)
+        .context(format!("创建目录`{group_export_dir:?}`失败"))?;
+    let event_uuid = uuid::Uuid::new_v4().to_string();
+    // 发送开始导出epub事件
+    let _ = ExportEpubEvent::Start {
+        uuid: event_uuid.clone(),
+        comic_title: comic_title.clone(),
+        total: groups.len() as u32,
+    }
+    .emit(app);
+    // 图片都已经是压缩过的，为了减少内存占用，逐个组导出
+    for (i, (group_name, mut chapters)) in groups.into_iter().enumerate() {
+        chapters.sort_by(|a, b| a.order.total_cmp(&b.order));
+        let extension = Archive::Epub.extension();
+        let epub_path = group_export_dir.join(format!("{group_name}.{extension}"));
+        let book_title = format!("{comic_title} - {group_name}");
+        create_epub(
+            app,
+            &book_title,
+            &comic.authors,
+            &chapters,
+            long_strip,
+            &epub_path,
+        )
+        .context(format!("`{group_name}`创建epub失败"))?;
+        // 发送导出epub进度事件
+        let _ = ExportEpubEvent::Progress {
+            uuid: event_uuid.clone(),
+            current: (i + 1) as u32,
+        }
+        .emit(app);
+    }
+    // 发送导出epub完成事件
+    let _ = ExportEpubEvent::End { uuid: event_uuid }.emit(app);
+    Ok(())
+}
+
+/// 按章节组分别导出为不同的格式，`rules`中没有的章节组导出为`default_format`
+///
+/// 每种格式内部已经是并发导出的，为了避免同时导出多种格式时内存占用过高，不同格式之间逐个导出
+pub fn by_rules(
+    app: &AppHandle,
+    mut comic: Comic,
+    rules: &HashMap<String, ExportFormat>,
+    default_format: ExportFormat,
+    long_strip: bool,
+) -> anyhow::Result<()> {
+    // 条漫默认用长条模式导出epub，否则每页只显示长图的一小部分
+    let long_strip = long_strip || is_long_strip(app, &comic);
+    let mut format_groups: BTreeMap<ExportFormat, HashMap<String, Vec<ChapterInfo>>> =
+        BTreeMap::new();
+    for (group_name, chapters) in std::mem::take(&mut comic.groups) {
+        let format = rules.get(&group_name).copied().unwrap_or(default_format);
+        format_groups
+            .entry(format)
+            .or_default()
+            .insert(group_name, chapters);
+    }
+
+    for (format, groups) in format_groups {
+        let comic = Comic {
+            groups,
+            ..comic.clone()
+        };
+        match format {
+            ExportFormat::Cbz => cbz(app, comic).context("导出cbz失败")?,
+            ExportFormat::Pdf => pdf(app, comic).context("导出pdf失败")?,
+            ExportFormat::Epub => epub(app, comic, long_strip).context("导出epub失败")?,
+        }
+    }
+    Ok(())
+}
+
+/// 题材中有条漫相关的标签，或者已下载的图片大多是长图时，认为`comic`是条漫
+fn is_long_strip(app: &AppHandle, comic: &Comic) -> bool {
+    let has_long_strip_genre = comic.genres.iter().any(|genre| {
+        LONG_STRIP_GENRE_MARKERS
+            .iter()
+            .any(|marker| genre.contains(marker))
+    });
+    if has_long_strip_genre {
+        return true;
+    }
+
+    let Some(chapter_info) = comic
+        .groups
+        .values()
+        .flatten()
+        .find(|chapter| chapter.is_downloaded.unwrap_or(false))
+    else {
+        return false;
+    };
+    let chapter_download_dir = get_chapter_download_dir(app, chapter_info);
+    // image_dimensions只读取文件头，不会解码整张图片
+    let sample_dimensions = image_paths(&chapter_download_dir)
+        .into_iter()
+        .take(LONG_STRIP_SAMPLE_COUNT)
+        .filter_map(|path| image::image_dimensions(path).ok())
         .collect::<Vec<_>>();
-    image_paths.sort_by(|a, b| a.file_name().cmp(&b.file_name()));
+    let long_count = sample_dimensions
+        .iter()
+        .filter(|(width, height)| image_format::is_long_image(*width, *height))
+        .count();
+    long_count * 2 > sample_dimensions.len()
+}
+
+/// epub中的一个资源文件，对应opf中manifest的一项
+struct EpubItem {
+    id: String,
+    href: String,
+    media_type: &'static str,
+    properties: Option<&'static str>,
+}
 
+/// 用`chapters`的图片创建epub，保存到`epub_path`中
+#[allow(clippy::too_many_lines)]
+fn create_epub(
+    app: &AppHandle,
+    book_title: &str,
+    authors: &[String],
+    chapters: &[ChapterInfo],
+    long_strip: bool,
+    epub_path: &Path,
+) -> anyhow::Result<()> {
+    let epub_file =
+        std::fs::File::create(epub_path).context(format!("创建文件`{epub_path:?}`失败"))?;
+    let mut zip_writer = ZipWriter::new(epub_file);
+    // mimetype必须是第一个文件，而且不能压缩
+    let stored_options = SimpleFileOptions::default().compression_method(CompressionMethod::Stored);
+    zip_writer
+        .start_file("mimetype", stored_options)
+        .context(format!("在`{epub_path:?}`创建`mimetype`失败"))?;
+    zip_writer
+        .write_all(b"application/epub+zip")
+        .context("写入`mimetype`失败")?;
+    zip_writer
+        .start_file("META-INF/container.xml", SimpleFileOptions::default())
+        .context(format!("在`{epub_path:?}`创建`container.xml`失败"))?;
+    zip_writer
+        .write_all(EPUB_CONTAINER_XML.as_bytes())
+        .context("写入`container.xml`失败")?;
+
+    let mut items = Vec::new();
+    let mut spine_ids = Vec::new();
+    // 目录中的每一项，(章节标题, 章节第一页的链接)
+    let mut nav_points = Vec::new();
+    for (chapter_index, chapter_info) in chapters.iter().enumerate() {
+        let chapter_title = &chapter_info.chapter_title;
+        let chapter_download_dir = get_chapter_download_dir(app, chapter_info);
+        let mut image_paths = std::fs::read_dir(&chapter_download_dir)
+            .context(format!("读取目录`{chapter_download_dir:?}`失败"))?
+            .filter_map(Result::ok)
+            .map(|entry| entry.path())
+            .filter(|path| path.is_file())
+            .collect::<Vec<_>>();
+        image_paths.sort_by(|a, b| a.file_name().cmp(&b.file_name()));
+        // 将图片写入epub
+        let mut image_hrefs = Vec::new();
+        for (image_index, image_path) in image_paths.iter().enumerate() {
+            let extension = image_path
+                .extension()
+                .and_then(|ext| ext.to_str())
+                .unwrap_or("jpg")
+                .to_lowercase();
+            let image_href = format!("images/{chapter_index}/{image_index:04}.{extension}");
+            zip_writer
+                .start_file(format!("OEBPS/{image_href}"), SimpleFileOptions::default())
+                .context(format!("在`{epub_path:?}`创建`{image_href}`失败"))?;
+            let mut file =
+                std::fs::File::open(image_path).context(format!("打开`{image_path:?}`失败"))?;
+            std::io::copy(&mut file, &mut zip_writer)
+                .context(format!("将`{image_path:?}`写入`{epub_path:?}`失败"))?;
+            // 第一张图片作为封面
+            let properties = items.is_empty().then_some("cover-image");
+            items.push(EpubItem {
+                id: format!("img-{chapter_index}-{image_index}"),
+                href: image_href.clone(),
+                media_type: get_image_media_type(&extension),
+                properties,
+            });
+            image_hrefs.push(image_href);
+        }
+        // 条漫的所有图片放在同一页，否则每张图片一页
+        let pages = if long_strip {
+            vec![image_hrefs]
+        } else {
+            image_hrefs.into_iter().map(|href| vec![href]).collect()
+        };
+        for (page_index, page_image_hrefs) in pages.iter().enumerate() {
+            if page_image_hrefs.is_empty() {
+                continue;
+            }
+            let page_id = format!("page-{chapter_index}-{page_index}");
+            let page_href = format!("pages/{chapter_index}-{page_index:04}.xhtml");
+            let page_xhtml = create_epub_page_xhtml(chapter_title, page_image_hrefs);
+            zip_writer
+                .start_file(format!("OEBPS/{page_href}"), SimpleFileOptions::default())
+                .context(format!("在`{epub_path:?}`创建`{page_href}`失败"))?;
+            zip_writer
+                .write_all(page_xhtml.as_bytes())
+                .context(format!("写入`{page_href}`失败"))?;
+            if page_index == 0 {
+                nav_points.push((chapter_title.clone(), page_href.clone()));
+            }
+            items.push(EpubItem {
+                id: page_id.clone(),
+                href: page_href,
+                media_type: "application/xhtml+xml",
+                properties: None,
+            });
+            spine_ids.push(page_id);
+        }
+    }
+
+    if nav_points.is_empty() {
+        return Err(anyhow!("没有可以导出的图片"));
+    }
+    // 写入目录
+    let nav_xhtml = create_epub_nav_xhtml(&nav_points);
+    zip_writer
+        .start_file("OEBPS/nav.xhtml", SimpleFileOptions::default())
+        .context(format!("在`{epub_path:?}`创建`nav.xhtml`失败"))?;
+    zip_writer
+        .write_all(nav_xhtml.as_bytes())
+        .context("写入`nav.xhtml`失败")?;
+    // 写入元数据
+    let opf = create_epub_opf(book_title, authors, &items, &spine_ids);
+    zip_writer
+        .start_file("OEBPS/content.opf", SimpleFileOptions::default())
+        .context(format!("在`{epub_path:?}`创建`content.opf`失败"))?;
+    zip_writer
+        .write_all(opf.as_bytes())
+        .context("写入`content.opf`失败")?;
+
+    zip_writer
+        .finish()
+        .context(format!("关闭`{epub_path:?}`失败"))?;
+    Ok(())
+}
+
+const EPUB_CONTAINER_XML: &str = r#"<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+"#;
+
+fn create_epub_page_xhtml(chapter_title: &str, image_hrefs: &[String]) -> String {
+    use std::fmt::Write;
+
+    let title = escape_xml(chapter_title);
+    let imgs = image_hrefs
+        .iter()
+        .enumerate()
+        .fold(String::new(), |mut output, (i, href)| {
+            let _ = writeln!(output, r#"<img src="../{href}" alt="{}"/>"#, i + 1);
+            output
+        });
+    format!(
+        r#"<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head>
+<title>{title}</title>
+<style>body {{ margin: 0; padding: 0; }} img {{ display: block; width: 100%; }}</style>
+</head>
+<body>
+{imgs}</body>
+</html>
+"#
+    )
+}
+
+fn create_epub_nav_xhtml(nav_points: &[(String, String)]) -> String {
+    use std::fmt::Write;
+
+    let lis = nav_points
+        .iter()
+        .fold(String::new(), |mut output, (title, href)| {
+            let title = escape_xml(title);
+            let _ = writeln!(output, r#"<li><a href="{href}">{title}</a></li>"#);
+            output
+        });
+    format!(
+        r#"<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head>
+<title>目录</title>
+</head>
+<body>
+<nav epub:type="toc" id="toc">
+<h1>目录</h1>
+<ol>
+{lis}</ol>
+</nav>
+</body>
+</html>
+"#
+    )
+}
+
+fn create_epub_opf(
+    book_title: &str,
+    authors: &[String],
+    items: &[EpubItem],
+    spine_ids: &[String],
+) -> String {
+    use std::fmt::Write;
+
+    let identifier = uuid::Uuid::new_v4();
+    let title = escape_xml(book_title);
+    let creators = authors.iter().fold(String::new(), |mut output, author| {
+        let author = escape_xml(author);
+        let _ = writeln!(output, "<dc:creator>{author}</dc:creator>");
+        output
+    });
+    let modified = chrono::Utc::now().format("%Y-%m-%dT%H:%M:%SZ");
+    let manifest = items.iter().fold(String::new(), |mut output, item| {
+        let EpubItem {
+            id,
+            href,
+            media_type,
+            properties,
+        } = item;
+        let properties = properties
+            .map(|properties| format!(r#" properties="{properties}""#))
+            .unwrap_or_default();
+        let _ = writeln!(
+            output,
+            r#"<item id="{id}" href="{href}" media-type="{media_type}"{properties}/>"#
+        );
+        output
+    });
+    let spine = spine_ids.iter().fold(String::new(), |mut output, id| {
+        let _ = writeln!(output, r#"<itemref idref="{id}"/>"#);
+        output
+    });
+    format!(
+        r#"<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+<dc:identifier id="book-id">urn:uuid:{identifier}</dc:identifier>
+<dc:title>{title}</dc:title>
+{creators}<dc:publisher>漫画柜</dc:publisher>
+<dc:language>zh</dc:language>
+<meta property="dcterms:modified">{modified}</meta>
+</metadata>
+<manifest>
+<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+{manifest}</manifest>
+<spine>
+{spine}</spine>
+</package>
+"#
+    )
+}
+
+fn get_image_media_type(extension: &str) -> &'static str {
+    match extension {
+        "png" => "image/png",
+        "gif" => "image/gif",
+        "webp" => "image/webp",
+        _ => "image/jpeg",
+    }
+}
+
+pub fn escape_xml(s: &str) -> String {
+    s.replace('&', "&amp;")
+        .replace('<', "&lt;")
+        .replace('>', "&gt;")
+        .replace('"', "&quot;")
+        .replace('\'', "&apos;")
+}
+
+/// 用`chapter_download_dir`中的图片创建PDF，保存到`pdf_path`中
+#[allow(clippy::similar_names)]
+#[allow(clippy::cast_possible_truncation)]
+fn create_pdf(
+    app: &AppHandle,
+    chapter_info: &ChapterInfo,
+    chapter_download_dir: &Path,
+    pdf_path: &Path,
+) -> anyhow::Result<()> {
+    let split_long_images = app
+        .state::<RwLock<Config>>()
+        .read()
+        .split_long_images_in_pdf;
     let mut doc = Document::with_version("1.5");
     let pages_id = doc.new_object_id();
     let mut page_ids = vec![];
+    // 被降级为第一帧的动图数量
+    let mut degraded_count = 0;
 
-    for image_path in image_paths {
-        if !image_path.is_file() {
-            continue;
-        }
+    for page in get_export_pages(app, chapter_info, chapter_download_dir) {
+        let image_path = match page {
+            ExportPage::Image(path) => path,
+            ExportPage::Placeholder(page_num) => {
+                let placeholder = image_format::placeholder_jpeg(page_num, PLACEHOLDER_REASON)
+                    .context(format!("生成第{page_num}页的占位图失败"))?;
+                let page_id = add_image_page(&mut doc, pages_id, placeholder)
+                    .context(format!("将第{page_num}页的占位图添加到pdf失败"))?;
+                page_ids.push(page_id);
+                continue;
+            }
+        };
 
-        let buffer = read_image_to_buffer(&image_path)
+        let mut buffer = read_image_to_buffer(&image_path)
             .context(format!("将`{image_path:?}`读取到buffer失败"))?;
+        // PDF中只能嵌入jpeg，其他格式需要先转换为jpeg，动图只保留第一帧
+        let image_info = image_format::inspect_image(&buffer)
+            .context(format!("`{image_path:?}`不是完整的图片"))?;
+        if image_info.format != image::ImageFormat::Jpeg {
+            buffer = image_format::to_jpeg(&buffer)
+                .context(format!("将`{image_path:?}`转换为jpeg失败"))?;
+        }
+        if image_info.is_animated() {
+            degraded_count += 1;
+        }
         let (width, height) = image::image_dimensions(&image_path)
             .context(format!("获取`{image_path:?}`的尺寸失败"))?;
-        let image_stream = lopdf::xobject::image_from(buffer)
-            .context(format!("创建`{image_path:?}`的图片流失败"))?;
-        // 将图片流添加到doc中
-        let img_id = doc.add_object(image_stream);
-        // 图片的名称，用于 Do 操作在页面上显示图片
-        let img_name = format!("X{}", img_id.0);
-        // 用于设置图片在页面上的位置和大小
-        let cm_operation = Operation::new(
-            "cm",
-            vec![
-                width.into(),
-                0.into(),
-                0.into(),
-                height.into(),
-                0.into(),
-                0.into(),
-            ],
-        );
-        // 用于显示图片
-        let do_operation = Operation::new("Do", vec![Object::Name(img_name.as_bytes().to_vec())]);
-        // 创建页面，设置图片的位置和大小，然后显示图片
-        // 因为是从零开始创建PDF，所以没必要用 q 和 Q 操作保存和恢复图形状态
-        let content = Content {
-            operations: vec![cm_operation, do_operation],
+        // 长图按A4纸的比例切成多页，否则整张图片作为一页
+        let pages = if split_long_images && image_format::is_long_image(width, height) {
+            image_format::split_long_image(&buffer, A4_PAGE_RATIO)
+                .context(format!("切割长图`{image_path:?}`失败"))?
+        } else {
+            vec![image_format::JpegPage {
+                data: buffer,
+                width,
+                height,
+            }]
         };
-        let content_id = doc.add_object(Stream::new(dictionary! {}, content.encode()?));
-        let page_id = doc.add_object(dictionary! {
-            "Type" => "Page",
-            "Parent" => pages_id,
-            "Contents" => content_id,
-            "MediaBox" => vec![0.into(), 0.into(), width.into(), height.into()],
-        });
-        // 将图片以 XObject 的形式添加到文档中
-        // Do 操作只能引用 XObject(所以前面定义的 Do 操作的参数是 img_name, 而不是 img_id)
-        doc.add_xobject(page_id, img_name.as_bytes(), img_id)?;
-        // 记录新创建的页面的 ID
-        page_ids.push(page_id);
+        for page in pages {
+            let page_id = add_image_page(&mut doc, pages_id, page)
+                .context(format!("将`{image_path:?}`添加到pdf失败"))?;
+            // 记录新创建的页面的 ID
+            page_ids.push(page_id);
+        }
     }
     // 将"Pages"添加到doc中
     let pages_dict = dictionary! {
@@ -306,6 +879,15 @@ fn create_pdf(chapter_download_dir: &Path, pdf_path: &Path) -> anyhow::Result<()
     });
     doc.trailer.set("Root", catalog_id);
 
+    if degraded_count > 0 {
+        let _ = LogEvent::Warn {
+            msg: format!(
+                "PDF不支持动图，`{chapter_download_dir:?}`中的{degraded_count}张动图只保留了第一帧"
+            ),
+        }
+        .emit(app);
+    }
+
     doc.compress();
 
     doc.save(pdf_path)
@@ -313,6 +895,124 @@ fn create_pdf(chapter_download_dir: &Path, pdf_path: &Path) -> anyhow::Result<()
     Ok(())
 }
 
+/// 章节中要导出的一页
+enum ExportPage {
+    /// 已下载的图片
+    Image(PathBuf),
+    /// 缺失的页，导出时用占位图代替，值为页码(从1开始)
+    Placeholder(u32),
+}
+
+/// 按页码顺序返回章节中要导出的页
+///
+/// 开启`placeholder_for_missing_pages`时，对照元数据中记录的页数找出缺失的页，用占位图补上，
+/// 并通过`LogEvent`告知前端，页数未知(为0)时不补
+#[allow(clippy::cast_possible_truncation, clippy::cast_sign_loss)]
+fn get_export_pages(
+    app: &AppHandle,
+    chapter_info: &ChapterInfo,
+    chapter_download_dir: &Path,
+) -> Vec<ExportPage> {
+    let image_paths = image_paths(chapter_download_dir);
+    let placeholder_enabled = app
+        .state::<RwLock<Config>>()
+        .read()
+        .placeholder_for_missing_pages;
+    if !placeholder_enabled || chapter_info.chapter_size <= 0 {
+        return image_paths.into_iter().map(ExportPage::Image).collect();
+    }
+
+    let local_page_nums = image_paths
+        .iter()
+        .map(PathBuf::as_path)
+        .filter_map(page_num_of)
+        .collect::<HashSet<_>>();
+    let missing_page_nums = (1..=chapter_info.chapter_size as u32)
+        .filter(|page_num| !local_page_nums.contains(page_num))
+        .collect::<Vec<_>>();
+    if !missing_page_nums.is_empty() {
+        let comic_title = &chapter_info.comic_title;
+        let chapter_title = &chapter_info.chapter_title;
+        let missing = missing_page_nums
+            .iter()
+            .map(ToString::to_string)
+            .collect::<Vec<_>>()
+            .join(", ");
+        let _ = LogEvent::Warn {
+            msg: format!(
+                "`{comic_title} - {chapter_title}`缺少第{missing}页，导出时已用占位图代替"
+            ),
+        }
+        .emit(app);
+    }
+
+    // 文件名不是页码的图片排在最后，保持原来的顺序
+    let mut pages = image_paths
+        .into_iter()
+        .map(|path| {
+            (
+                page_num_of(&path).unwrap_or(u32::MAX),
+                ExportPage::Image(path),
+            )
+        })
+        .chain(
+            missing_page_nums
+                .into_iter()
+                .map(|page_num| (page_num, ExportPage::Placeholder(page_num))),
+        )
+        .collect::<Vec<_>>();
+    pages.sort_by_key(|(page_num, _)| *page_num);
+    pages.into_iter().map(|(_, page)| page).collect()
+}
+
+/// 创建一个只显示`page`的页面，返回页面的 ID
+fn add_image_page(
+    doc: &mut Document,
+    pages_id: ObjectId,
+    page: image_format::JpegPage,
+) -> anyhow::Result<ObjectId> {
+    let image_format::JpegPage {
+        data,
+        width,
+        height,
+    } = page;
+    let image_stream = lopdf::xobject::image_from(data).context("创建图片流失败")?;
+    // 将图片流添加到doc中
+    let img_id = doc.add_object(image_stream);
+    // 图片的名称，用于 Do 操作在页面上显示图片
+    let img_name = format!("X{}", img_id.0);
+    // 用于设置图片在页面上的位置和大小
+    let cm_operation = Operation::new(
+        "cm",
+        vec![
+            width.into(),
+            0.into(),
+            0.into(),
+            height.into(),
+            0.into(),
+            0.into(),
+        ],
+    );
+    // 用于显示图片
+    let do_operation = Operation::new("Do", vec![Object::Name(img_name.as_bytes().to_vec())]);
+    // 创建页面，设置图片的位置和大小，然后显示图片
+    // 因为是从零开始创建PDF，所以没必要用 q 和 Q 操作保存和恢复图形状态
+    let content = Content {
+        operations: vec![cm_operation, do_operation],
+    };
+    let content_id = doc.add_object(Stream::new(dictionary! {}, content.encode()?));
+    let page_id = doc.add_object(dictionary! {
+        "Type" => "Page",
+        "Parent" => pages_id,
+        "Contents" => content_id,
+        "MediaBox" => vec![0.into(), 0.into(), width.into(), height.into()],
+    });
+    // 将图片以 XObject 的形式添加到文档中
+    // Do 操作只能引用 XObject(所以前面定义的 Do 操作的参数是 img_name, 而不是 img_id)
+    doc.add_xobject(page_id, img_name.as_bytes(), img_id)?;
+    Ok(page_id)
+}
+
 /// 读取`image_path`中的图片数据到buffer中
 fn read_image_to_buffer(image_path: &Path) -> anyhow::Result<Vec<u8>> {
     let file = std::fs::File::open(image_path).context(format!("打开`{image_path:?}`失败"))?;
@@ -455,11 +1155,11 @@ fn get_chapter_export_dir(
         .join(&chapter_info.group_name)
 }
 
-fn get_group_export_dir(app: &AppHandle, comic_title: &str, archive: &Archive) -> PathBuf {
+fn get_group_export_dir(app: &AppHandle, comic_dir_name: &str, archive: &Archive) -> PathBuf {
     app.state::<RwLock<Config>>()
         .read()
         .export_dir
-        .join(comic_title)
+        .join(comic_dir_name)
         .join(archive.extension())
 }
 
@@ -467,16 +1167,35 @@ fn get_chapter_download_dir(app: &AppHandle, chapter_info: &ChapterInfo) -> Path
     app.state::<RwLock<Config>>()
         .read()
         .download_dir
-        .join(&chapter_info.comic_title)
-        .join(&chapter_info.group_name)
-        .join(&chapter_info.prefixed_chapter_title)
+        .join(chapter_info.relative_dir())
 }
 
-/// 获取已下载的章节
-fn get_downloaded_chapters(groups: HashMap<String, Vec<ChapterInfo>>) -> Vec<ChapterInfo> {
+/// 获取已下载且原图还在的章节
+///
+/// 自动导出cbz后删除了原图的章节只剩空目录，再导出只会得到空的或全是占位图的文件，
+/// 还会覆盖自动导出的cbz，所以跳过这些章节并提示
+fn get_downloaded_chapters(
+    app: &AppHandle,
+    groups: HashMap<String, Vec<ChapterInfo>>,
+) -> Vec<ChapterInfo> {
     groups
         .into_iter()
         .flat_map(|(_, chapters)| chapters)
         .filter(|chapter| chapter.is_downloaded.unwrap_or(false))
+        .filter(|chapter_info| {
+            let chapter_download_dir = get_chapter_download_dir(app, chapter_info);
+            if !image_paths(&chapter_download_dir).is_empty() {
+                return true;
+            }
+            let comic_title = &chapter_info.comic_title;
+            let chapter_title = &chapter_info.chapter_title;
+            let _ = LogEvent::Warn {
+                msg: format!(
+                    "`{comic_title} - {chapter_title}`的目录中没有图片(可能已在自动导出cbz后删除)，已跳过"
+                ),
+            }
+            .emit(app);
+            false
+        })
         .collect::<Vec<_>>()
 }
@@ -1,5 +1,10 @@
-use anyhow::anyhow;
-use reqwest::Response;
+use std::{io::Write, sync::LazyLock};
+
+use anyhow::{anyhow, Context};
+use bytes::Bytes;
+use encoding_rs::{Encoding, GBK, UTF_8};
+use regex::bytes::Regex;
+use reqwest::{header::CONTENT_TYPE, Response};
 use reqwest_middleware::RequestBuilder;
 use scraper::error::SelectorErrorKind;
 
@@ -55,3 +60,151 @@ impl SendWithTimeoutMsg for RequestBuilder {
         })
     }
 }
+
+pub trait ReadBodyWithLimit {
+    /// 读取响应体，超过`limit`字节时返回错误，避免代理或网站返回的异常大响应吃满内存
+    async fn bytes_with_limit(self, limit: usize) -> anyhow::Result<Bytes>;
+    /// 读取响应体并转为字符串，超过`limit`字节时返回错误
+    ///
+    /// 编码依次以`Content-Type`中的charset、网页`<meta>`中的charset为准，都没有时按utf-8解码，
+    /// 不是合法的utf-8时退回到gbk，部分镜像站和异常响应是gbk编码的
+    async fn text_with_limit(self, limit: usize) -> anyhow::Result<String>;
+    /// 边读边把响应体写入`writer`，整个响应体不会同时放在内存中，返回写入的字节数
+    ///
+    /// 超过`limit`字节，或者实际读到的字节数与`Content-Length`不一致时返回错误
+    async fn write_body_with_limit(
+        self,
+        writer: &mut impl Write,
+        limit: u64,
+    ) -> anyhow::Result<u64>;
+}
+
+impl ReadBodyWithLimit for Response {
+    async fn bytes_with_limit(mut self, limit: usize) -> anyhow::Result<Bytes> {
+        let url = self.url().clone();
+        // 有Content-Length时可以提前拒绝，没有时只能边读边数
+        if let Some(content_length) = self.content_length() {
+            if content_length > limit as u64 {
+                return Err(anyhow!(
+                    "`{url}`的响应体大小为{content_length}字节，超过了{limit}字节的上限"
+                ));
+            }
+        }
+        let mut body = Vec::new();
+        while let Some(chunk) = self.chunk().await? {
+            if body.len() + chunk.len() > limit {
+                return Err(anyhow!("`{url}`的响应体超过了{limit}字节的上限"));
+            }
+            body.extend_from_slice(&chunk);
+        }
+        Ok(Bytes::from(body))
+    }
+
+    async fn text_with_limit(self, limit: usize) -> anyhow::Result<String> {
+        let content_type = self
+            .headers()
+            .get(CONTENT_TYPE)
+            .and_then(|value| value.to_str().ok())
+            .map(ToString::to_string);
+        let body = self.bytes_with_limit(limit).await?;
+        Ok(decode_body(&body, content_type.as_deref()))
+    }
+
+    async fn write_body_with_limit(
+        mut self,
+        writer: &mut impl Write,
+        limit: u64,
+    ) -> anyhow::Result<u64> {
+        let url = self.url().clone();
+        let content_length = self.content_length();
+        if let Some(content_length) = content_length {
+            if content_length > limit {
+                return Err(anyhow!(
+                    "`{url}`的响应体大小为{content_length}字节，超过了{limit}字节的上限"
+                ));
+            }
+        }
+        let mut written = 0;
+        while let Some(chunk) = self.chunk().await? {
+            written += chunk.len() as u64;
+            if written > limit {
+                return Err(anyhow!("`{url}`的响应体超过了{limit}字节的上限"));
+            }
+            writer
+                .write_all(&chunk)
+                .context(format!("写入`{url}`的响应体失败"))?;
+        }
+        if let Some(content_length) = content_length {
+            if written != content_length {
+                return Err(anyhow!(
+                    "`{url}`的响应体不完整，只读到了{written}/{content_length}字节"
+                ));
+            }
+        }
+        Ok(written)
+    }
+}
+
+/// `<meta charset="gbk">`或`<meta http-equiv="Content-Type" content="text/html; charset=gbk">`
+static META_CHARSET_RE: LazyLock<Regex> =
+    LazyLock::new(|| Regex::new(r#"(?i)<meta[^>]+charset\s*=\s*["']?([\w-]+)"#).unwrap());
+/// 只在网页开头找`<meta>`，charset按规范必须出现在前1024个字节中
+const META_SNIFF_BYTES: usize = 1024;
+
+/// 从`text/html; Charset="GBK"; foo=bar`这样的`Content-Type`中取出`GBK`，参数名不区分大小写
+fn charset_of_content_type(content_type: &str) -> Option<&str> {
+    content_type.split(';').skip(1).find_map(|param| {
+        let (name, value) = param.split_once('=')?;
+        if !name.trim().eq_ignore_ascii_case("charset") {
+            return None;
+        }
+        Some(value.trim().trim_matches(|c: char| c == '"' || c == '\''))
+    })
+}
+
+fn decode_body(body: &[u8], content_type: Option<&str>) -> String {
+    let declared_encoding = content_type
+        .and_then(charset_of_content_type)
+        .and_then(|charset| Encoding::for_label(charset.as_bytes()))
+        .or_else(|| {
+            let head = &body[..body.len().min(META_SNIFF_BYTES)];
+            let charset = META_CHARSET_RE.captures(head)?.get(1)?;
+            Encoding::for_label(charset.as_bytes())
+        });
+    let encoding = match declared_encoding {
+        Some(encoding) => encoding,
+        None if std::str::from_utf8(body).is_ok() => UTF_8,
+        None => GBK,
+    };
+    // 开头有BOM时以BOM为准
+    let (text, _, _) = encoding.decode(body);
+    text.into_owned()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn decode_body_with_declared_charset() {
+        let (gbk_body, _, _) = GBK.encode("<html>漫画柜</html>");
+        let decoded = decode_body(&gbk_body, Some("text/html; Charset=\"GBK\"; foo=bar"));
+        assert_eq!(decoded, "<html>漫画柜</html>");
+
+        let html = b"<html><head><meta charset=\"gbk\"></head>".to_vec();
+        let body = [html, gbk_body.to_vec()].concat();
+        let decoded = decode_body(&body, Some("text/html"));
+        assert!(decoded.ends_with("<html>漫画柜</html>"));
+    }
+
+    #[test]
+    fn decode_body_without_charset() {
+        let decoded = decode_body("漫画柜".as_bytes(), None);
+        assert_eq!(decoded, "漫画柜");
+
+        // 不是合法的utf-8时退回到gbk
+        let (gbk_body, _, _) = GBK.encode("漫画柜");
+        let decoded = decode_body(&gbk_body, None);
+        assert_eq!(decoded, "漫画柜");
+    }
+}
@@ -0,0 +1,396 @@
+use std::{
+    fs::File,
+    io::{Cursor, Read},
+    path::Path,
+};
+
+use anyhow::{anyhow, Context};
+use image::{
+    codecs::{
+        gif::{GifDecoder, GifEncoder, Repeat},
+        webp::WebPDecoder,
+    },
+    imageops::FilterType,
+    AnimationDecoder, DynamicImage, ImageFormat, Rgb, RgbImage,
+};
+
+/// 图片的格式和帧数
+///
+/// 各种格式的处理方式如下：
+/// - jpeg：下载、导出cbz、epub、pdf时都原样保存
+/// - png、静态webp：下载、导出cbz、epub时原样保存，导出pdf时转换为jpeg
+/// - gif动图：下载、导出cbz、epub时原样保存(保留动画)，导出pdf时**降级**为第一帧
+/// - webp动图：下载时默认原样保存，开启`convert_animated_webp_to_gif`后转换为gif动图，
+///   导出cbz、epub时原样保存(保留动画)，导出pdf时**降级**为第一帧
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct ImageInfo {
+    pub format: ImageFormat,
+    /// 帧数，静态图片为1
+    pub frame_count: usize,
+}
+
+impl ImageInfo {
+    pub fn is_animated(&self) -> bool {
+        self.frame_count > 1
+    }
+
+    /// 保存图片时使用的扩展名
+    pub fn extension(&self) -> &'static str {
+        extension_of(self.format)
+    }
+}
+
+/// `format`格式的图片保存时使用的扩展名
+pub fn extension_of(format: ImageFormat) -> &'static str {
+    match format {
+        ImageFormat::Png => "png",
+        ImageFormat::Gif => "gif",
+        ImageFormat::WebP => "webp",
+        _ => "jpg",
+    }
+}
+
+/// 只读取`path`开头的几十个字节判断图片格式，不会把整个文件读进内存，也不校验图片是否完整
+pub fn guess_file_format(path: &Path) -> anyhow::Result<ImageFormat> {
+    let mut header = Vec::new();
+    File::open(path)
+        .context(format!("打开`{path:?}`失败"))?
+        .take(64)
+        .read_to_end(&mut header)
+        .context(format!("读取`{path:?}`失败"))?;
+    image::guess_format(&header).context("数据不是图片")
+}
+
+/// 保存图片时可能使用的所有扩展名
+pub const IMAGE_EXTENSIONS: [&str; 4] = ["jpg", "png", "gif", "webp"];
+
+/// 检测`image_data`的格式，gif和webp会解码所有帧来校验图片是否完整
+pub fn inspect_image(image_data: &[u8]) -> anyhow::Result<ImageInfo> {
+    let format = image::guess_format(image_data).context("数据不是图片")?;
+    let frame_count = match format {
+        ImageFormat::Gif => {
+            let decoder = GifDecoder::new(Cursor::new(image_data)).context("创建gif解码器失败")?;
+            count_frames(decoder)?
+        }
+        ImageFormat::WebP => {
+            let decoder =
+                WebPDecoder::new(Cursor::new(image_data)).context("创建webp解码器失败")?;
+            if decoder.has_animation() {
+                count_frames(decoder)?
+            } else {
+                DynamicImage::from_decoder(decoder).context("解码webp失败")?;
+                1
+            }
+        }
+        _ => 1,
+    };
+    Ok(ImageInfo {
+        format,
+        frame_count,
+    })
+}
+
+/// 把webp动图转换为无限循环的gif动图
+pub fn webp_to_gif(image_data: &[u8]) -> anyhow::Result<Vec<u8>> {
+    let decoder = WebPDecoder::new(Cursor::new(image_data)).context("创建webp解码器失败")?;
+    let frames = decoder
+        .into_frames()
+        .collect_frames()
+        .context("解码webp动图失败")?;
+    let mut gif_data = Vec::new();
+    {
+        let mut encoder = GifEncoder::new(&mut gif_data);
+        encoder
+            .set_repeat(Repeat::Infinite)
+            .context("设置gif循环播放失败")?;
+        encoder.encode_frames(frames).context("编码gif动图失败")?;
+    }
+    Ok(gif_data)
+}
+
+/// 把图片转换为jpeg，动图只保留第一帧
+pub fn to_jpeg(image_data: &[u8]) -> anyhow::Result<Vec<u8>> {
+    // 对于动图，load_from_memory只会解码第一帧
+    let image = image::load_from_memory(image_data).context("解码图片失败")?;
+    let mut jpeg_data = Cursor::new(Vec::new());
+    // jpeg不支持透明通道，需要先转换为rgb
+    DynamicImage::ImageRgb8(image.to_rgb8())
+        .write_to(&mut jpeg_data, ImageFormat::Jpeg)
+        .context("编码jpeg失败")?;
+    Ok(jpeg_data.into_inner())
+}
+
+/// 把图片的宽高缩小到原来的`scale_percent`%，并编码为jpeg，动图只保留第一帧
+pub fn downscale_to_jpeg(image_data: &[u8], scale_percent: u32) -> anyhow::Result<Vec<u8>> {
+    let image = image::load_from_memory(image_data).context("解码图片失败")?;
+    let scale_percent = scale_percent.clamp(1, 100);
+    let width = (image.width() * scale_percent / 100).max(1);
+    let height = (image.height() * scale_percent / 100).max(1);
+    let image = image.resize(width, height, FilterType::Triangle);
+    let mut jpeg_data = Cursor::new(Vec::new());
+    // jpeg不支持透明通道，需要先转换为rgb
+    DynamicImage::ImageRgb8(image.to_rgb8())
+        .write_to(&mut jpeg_data, ImageFormat::Jpeg)
+        .context("编码jpeg失败")?;
+    Ok(jpeg_data.into_inner())
+}
+
+/// 高度超过宽度的这个倍数时，认为是条漫的长图
+const LONG_IMAGE_RATIO: u32 = 3;
+
+/// 切割后的一页jpeg
+pub struct JpegPage {
+    pub data: Vec<u8>,
+    pub width: u32,
+    pub height: u32,
+}
+
+pub fn is_long_image(width: u32, height: u32) -> bool {
+    height > width.saturating_mul(LONG_IMAGE_RATIO)
+}
+
+/// 把长图从上到下切成高为宽的`page_ratio`倍的多页jpeg，最后一页可能比较矮
+#[allow(
+    clippy::cast_possible_truncation,
+    clippy::cast_sign_loss,
+    clippy::cast_precision_loss
+)]
+pub fn split_long_image(image_data: &[u8], page_ratio: f64) -> anyhow::Result<Vec<JpegPage>> {
+    let image = image::load_from_memory(image_data).context("解码图片失败")?;
+    let (width, height) = (image.width(), image.height());
+    let page_height = ((f64::from(width) * page_ratio).round() as u32).max(1);
+    let mut pages = Vec::new();
+    let mut y = 0;
+    while y < height {
+        let segment_height = page_height.min(height - y);
+        let segment = image.crop_imm(0, y, width, segment_height);
+        let mut jpeg_data = Cursor::new(Vec::new());
+        // jpeg不支持透明通道，需要先转换为rgb
+        DynamicImage::ImageRgb8(segment.to_rgb8())
+            .write_to(&mut jpeg_data, ImageFormat::Jpeg)
+            .context(format!("编码第{}页jpeg失败", pages.len() + 1))?;
+        pages.push(JpegPage {
+            data: jpeg_data.into_inner(),
+            width,
+            height: segment_height,
+        });
+        y += segment_height;
+    }
+    Ok(pages)
+}
+
+/// 裁掉图片顶部和底部的固定高度，用来去掉网站加在图片上的水印条
+///
+/// `top`和`bottom`是图片宽度为`reference_width`时的像素数，实际裁剪的像素按图片宽度等比例换算，
+/// 这样同一套配置对不同分辨率的图片都能裁到相同的位置
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct ImageCrop {
+    pub top: u32,
+    pub bottom: u32,
+    pub reference_width: u32,
+}
+
+/// 按`crop`裁剪静态图片，裁剪后以原格式重新编码
+///
+/// 要裁掉的高度超过图片的一半时返回错误，避免配置不当把正文也裁掉
+#[allow(
+    clippy::cast_possible_truncation,
+    clippy::cast_sign_loss,
+    clippy::cast_precision_loss
+)]
+pub fn crop_image(
+    image_data: &[u8],
+    format: ImageFormat,
+    crop: ImageCrop,
+) -> anyhow::Result<Vec<u8>> {
+    let image = image::load_from_memory(image_data).context("解码图片失败")?;
+    let (width, height) = (image.width(), image.height());
+    let scale = f64::from(width) / f64::from(crop.reference_width.max(1));
+    let top = (f64::from(crop.top) * scale).round() as u32;
+    let bottom = (f64::from(crop.bottom) * scale).round() as u32;
+    if (top + bottom) * 2 >= height {
+        return Err(anyhow!(
+            "图片高度为{height}，要裁掉顶部{top}像素和底部{bottom}像素，超过了图片高度的一半"
+        ));
+    }
+    let image = image.crop_imm(0, top, width, height - top - bottom);
+
+    let mut cropped_data = Cursor::new(Vec::new());
+    let image = match format {
+        // jpeg不支持透明通道，需要先转换为rgb
+        ImageFormat::Jpeg => DynamicImage::ImageRgb8(image.to_rgb8()),
+        _ => image,
+    };
+    image
+        .write_to(&mut cropped_data, format)
+        .context(format!("将裁剪后的图片编码为{format:?}失败"))?;
+    Ok(cropped_data.into_inner())
+}
+
+/// 占位图的宽高，与A4纸的比例相同
+const PLACEHOLDER_WIDTH: u32 = 800;
+const PLACEHOLDER_HEIGHT: u32 = 1131;
+
+/// 生成一张标注了页码和原因的jpeg占位图，用来在导出时代替下载失败的页，保证页码连续
+///
+/// 没有内置中文字体，所以用点阵字体画英文，第一行是`PAGE {page}`，第二行是`reason`，
+/// 点阵字体只包含数字和少数大写字母，`reason`中不支持的字符会画成空白
+pub fn placeholder_jpeg(page: u32, reason: &str) -> anyhow::Result<JpegPage> {
+    let mut image =
+        RgbImage::from_pixel(PLACEHOLDER_WIDTH, PLACEHOLDER_HEIGHT, Rgb([240, 240, 240]));
+    // 画一圈边框，和正常的页区分开
+    let border = Rgb([200, 60, 60]);
+    for (x, y, pixel) in image.enumerate_pixels_mut() {
+        let on_border = x < 8 || y < 8 || x >= PLACEHOLDER_WIDTH - 8 || y >= PLACEHOLDER_HEIGHT - 8;
+        if on_border {
+            *pixel = border;
+        }
+    }
+    let page_text = format!("PAGE {page}");
+    draw_text_centered(
+        &mut image,
+        &page_text,
+        PLACEHOLDER_HEIGHT / 2 - 120,
+        12,
+        border,
+    );
+    draw_text_centered(
+        &mut image,
+        &reason.to_uppercase(),
+        PLACEHOLDER_HEIGHT / 2 + 40,
+        6,
+        Rgb([80, 80, 80]),
+    );
+
+    let mut jpeg_data = Cursor::new(Vec::new());
+    DynamicImage::ImageRgb8(image)
+        .write_to(&mut jpeg_data, ImageFormat::Jpeg)
+        .context("编码占位图失败")?;
+    Ok(JpegPage {
+        data: jpeg_data.into_inner(),
+        width: PLACEHOLDER_WIDTH,
+        height: PLACEHOLDER_HEIGHT,
+    })
+}
+
+/// 点阵字体的字宽和字高
+const GLYPH_WIDTH: u32 = 5;
+const GLYPH_HEIGHT: u32 = 7;
+
+/// 以`top`为顶部水平居中画一行文字，每个点放大为`scale`x`scale`的方块，超出图片的部分不画
+#[allow(clippy::cast_possible_truncation)]
+fn draw_text_centered(image: &mut RgbImage, text: &str, top: u32, scale: u32, color: Rgb<u8>) {
+    // 字与字之间空一个点
+    let advance = (GLYPH_WIDTH + 1) * scale;
+    let text_width = advance * text.chars().count() as u32;
+    let left = image.width().saturating_sub(text_width) / 2;
+    for (i, c) in text.chars().enumerate() {
+        let Some(rows) = glyph(c) else {
+            continue;
+        };
+        let glyph_left = left + advance * i as u32;
+        for (row, bits) in rows.iter().enumerate() {
+            for col in 0..GLYPH_WIDTH {
+                if bits & (1 << (GLYPH_WIDTH - 1 - col)) == 0 {
+                    continue;
+                }
+                let x0 = glyph_left + col * scale;
+                let y0 = top + row as u32 * scale;
+                for y in y0..(y0 + scale).min(image.height()) {
+                    for x in x0..(x0 + scale).min(image.width()) {
+                        image.put_pixel(x, y, color);
+                    }
+                }
+            }
+        }
+    }
+}
+
+/// 5x7的点阵字体，每个元素是一行，低5位从左到右对应5个点
+fn glyph(c: char) -> Option<[u8; 7]> {
+    let rows = match c {
+        '0' => [
+            0b01110, 0b10001, 0b10011, 0b10101, 0b11001, 0b10001, 0b01110,
+        ],
+        '1' => [
+            0b00100, 0b01100, 0b00100, 0b00100, 0b00100, 0b00100, 0b01110,
+        ],
+        '2' => [
+            0b01110, 0b10001, 0b00001, 0b00010, 0b00100, 0b01000, 0b11111,
+        ],
+        '3' => [
+            0b11111, 0b00010, 0b00100, 0b00010, 0b00001, 0b10001, 0b01110,
+        ],
+        '4' => [
+            0b00010, 0b00110, 0b01010, 0b10010, 0b11111, 0b00010, 0b00010,
+        ],
+        '5' => [
+            0b11111, 0b10000, 0b11110, 0b00001, 0b00001, 0b10001, 0b01110,
+        ],
+        '6' => [
+            0b00110, 0b01000, 0b10000, 0b11110, 0b10001, 0b10001, 0b01110,
+        ],
+        '7' => [
+            0b11111, 0b00001, 0b00010, 0b00100, 0b01000, 0b01000, 0b01000,
+        ],
+        '8' => [
+            0b01110, 0b10001, 0b10001, 0b01110, 0b10001, 0b10001, 0b01110,
+        ],
+        '9' => [
+            0b01110, 0b10001, 0b10001, 0b01111, 0b00001, 0b00010, 0b01100,
+        ],
+        'A' => [
+            0b01110, 0b10001, 0b10001, 0b11111, 0b10001, 0b10001, 0b10001,
+        ],
+        'D' => [
+            0b11110, 0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b11110,
+        ],
+        'E' => [
+            0b11111, 0b10000, 0b10000, 0b11110, 0b10000, 0b10000, 0b11111,
+        ],
+        'F' => [
+            0b11111, 0b10000, 0b10000, 0b11110, 0b10000, 0b10000, 0b10000,
+        ],
+        'G' => [
+            0b01110, 0b10001, 0b10000, 0b10111, 0b10001, 0b10001, 0b01111,
+        ],
+        'I' => [
+            0b01110, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100, 0b01110,
+        ],
+        'L' => [
+            0b10000, 0b10000, 0b10000, 0b10000, 0b10000, 0b10000, 0b11111,
+        ],
+        'M' => [
+            0b10001, 0b11011, 0b10101, 0b10101, 0b10001, 0b10001, 0b10001,
+        ],
+        'N' => [
+            0b10001, 0b11001, 0b10101, 0b10011, 0b10001, 0b10001, 0b10001,
+        ],
+        'O' => [
+            0b01110, 0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b01110,
+        ],
+        'P' => [
+            0b11110, 0b10001, 0b10001, 0b11110, 0b10000, 0b10000, 0b10000,
+        ],
+        'S' => [
+            0b01111, 0b10000, 0b10000, 0b01110, 0b00001, 0b00001, 0b11110,
+        ],
+        'W' => [
+            0b10001, 0b10001, 0b10001, 0b10101, 0b10101, 0b10101, 0b01010,
+        ],
+        _ => return None,
+    };
+    Some(rows)
+}
+
+fn count_frames<'a>(decoder: impl AnimationDecoder<'a>) -> anyhow::Result<usize> {
+    let mut frame_count = 0;
+    for frame in decoder.into_frames() {
+        frame.context(format!("解码第{}帧失败", frame_count + 1))?;
+        frame_count += 1;
+    }
+    if frame_count == 0 {
+        return Err(anyhow!("图片中没有任何帧"));
+    }
+    Ok(frame_count)
+}
@@ -0,0 +1,169 @@
+use std::{
+    sync::{
+        atomic::{AtomicUsize, Ordering},
+        Arc,
+    },
+    time::Duration,
+};
+
+use parking_lot::Mutex;
+use serde::{Deserialize, Serialize};
+use specta::Type;
+
+/// 漫画柜图片服务器的镜像
+///
+/// 章节页解密出来的只有图片路径，镜像列表写死在阅读器的js里，所以这里同样写死
+pub const DEFAULT_IMAGE_HOSTS: [&str; 3] = ["i.hamreus.com", "eu.hamreus.com", "us.hamreus.com"];
+/// 连续失败这么多次的host会被排到最后，除非其他host也都不可用，否则不会再被选中
+const MAX_CONSECUTIVE_FAILURES: u32 = 3;
+/// 每连续失败一次，相当于响应时间增加多少毫秒
+const FAILURE_PENALTY_MS: f64 = 1000.0;
+/// 计算平均响应时间时新样本所占的权重
+const LATENCY_SMOOTHING: f64 = 0.3;
+
+/// 在多个图片服务器之间做负载均衡
+///
+/// 优先选择平均响应时间最短的host，分数相同的host之间轮询，
+/// 连续失败的host会被降权，成功一次后恢复。
+/// 克隆 `ImageHostSelector` 只是增加引用计数，所有克隆副本共享同一份统计数据。
+#[derive(Clone)]
+pub struct ImageHostSelector {
+    hosts: Arc<Mutex<Vec<HostStats>>>,
+    /// 轮询的起始位置
+    next: Arc<AtomicUsize>,
+    /// 最近一次下载成功的图片的路径(包括query)，测速时用它在每个host上各下载一次
+    sample_path: Arc<Mutex<Option<String>>>,
+}
+
+/// 一个图片服务器的测速结果
+#[derive(Debug, Clone, Serialize, Deserialize, Type)]
+#[serde(rename_all = "camelCase")]
+pub struct ServerSpeed {
+    pub host: String,
+    /// 从发出请求到收到响应头的时间(毫秒)，失败时为None
+    pub latency_ms: Option<u64>,
+    /// 下载完整张图片的时间(毫秒)，失败时为None
+    pub total_ms: Option<u64>,
+    /// 平均下载速度(KB/s)，失败时为None
+    pub speed_kbps: Option<u64>,
+    /// 失败的原因
+    pub error: Option<String>,
+}
+
+#[derive(Debug, Clone)]
+struct HostStats {
+    host: String,
+    /// 平均响应时间(毫秒)，还没有成功过时为None
+    avg_latency_ms: Option<f64>,
+    /// 连续失败次数
+    consecutive_failures: u32,
+}
+
+impl HostStats {
+    fn new(host: &str) -> Self {
+        Self {
+            host: host.to_string(),
+            avg_latency_ms: None,
+            consecutive_failures: 0,
+        }
+    }
+
+    /// 分数越低越优先
+    fn score(&self) -> f64 {
+        let failures = f64::from(self.consecutive_failures);
+        let mut score =
+            self.avg_latency_ms.unwrap_or(0.0) * (1.0 + failures) + failures * FAILURE_PENALTY_MS;
+        if self.consecutive_failures >= MAX_CONSECUTIVE_FAILURES {
+            score += f64::from(u32::MAX);
+        }
+        score
+    }
+}
+
+impl ImageHostSelector {
+    pub fn new(hosts: &[&str]) -> Self {
+        let hosts = hosts.iter().map(|host| HostStats::new(host)).collect();
+        Self {
+            hosts: Arc::new(Mutex::new(hosts)),
+            next: Arc::new(AtomicUsize::new(0)),
+            sample_path: Arc::new(Mutex::new(None)),
+        }
+    }
+
+    pub fn hosts(&self) -> Vec<String> {
+        self.hosts
+            .lock()
+            .iter()
+            .map(|stats| stats.host.clone())
+            .collect()
+    }
+
+    pub fn sample_path(&self) -> Option<String> {
+        self.sample_path.lock().clone()
+    }
+
+    /// 用测速结果覆盖统计数据，最快的host会排在最前面，测速失败的host会被排到最后
+    #[allow(clippy::cast_precision_loss)]
+    pub fn apply_benchmark(&self, speeds: &[ServerSpeed]) {
+        let mut hosts = self.hosts.lock();
+        for speed in speeds {
+            let Some(stats) = hosts.iter_mut().find(|stats| stats.host == speed.host) else {
+                continue;
+            };
+            if let Some(total_ms) = speed.total_ms {
+                stats.avg_latency_ms = Some(total_ms as f64);
+                stats.consecutive_failures = 0;
+            } else {
+                stats.consecutive_failures = MAX_CONSECUTIVE_FAILURES;
+            }
+        }
+    }
+
+    /// 是否是参与负载均衡的图片服务器
+    pub fn contains(&self, host: &str) -> bool {
+        self.hosts.lock().iter().any(|stats| stats.host == host)
+    }
+
+    /// 按优先级从高到低返回所有host
+    pub fn ranked_hosts(&self) -> Vec<String> {
+        let mut hosts = self.hosts.lock().clone();
+        if hosts.is_empty() {
+            return Vec::new();
+        }
+        // 先轮转再稳定排序，这样分数相同的host会被轮流排在前面
+        let next = self.next.fetch_add(1, Ordering::Relaxed) % hosts.len();
+        hosts.rotate_left(next);
+        hosts.sort_by(|a, b| a.score().total_cmp(&b.score()));
+        hosts.into_iter().map(|stats| stats.host).collect()
+    }
+
+    /// `url`是成功下载的图片的地址，会被记下来用于测速
+    pub fn report_success(&self, url: &reqwest::Url, latency: Duration) {
+        let Some(host) = url.host_str() else {
+            return;
+        };
+        let sample_path = match url.query() {
+            Some(query) => format!("{}?{query}", url.path()),
+            None => url.path().to_string(),
+        };
+        *self.sample_path.lock() = Some(sample_path);
+
+        let mut hosts = self.hosts.lock();
+        let Some(stats) = hosts.iter_mut().find(|stats| stats.host == host) else {
+            return;
+        };
+        let latency_ms = latency.as_secs_f64() * 1000.0;
+        stats.avg_latency_ms = Some(match stats.avg_latency_ms {
+            Some(avg) => avg * (1.0 - LATENCY_SMOOTHING) + latency_ms * LATENCY_SMOOTHING,
+            None => latency_ms,
+        });
+        stats.consecutive_failures = 0;
+    }
+
+    pub fn report_failure(&self, host: &str) {
+        let mut hosts = self.hosts.lock();
+        if let Some(stats) = hosts.iter_mut().find(|stats| stats.host == host) {
+            stats.consecutive_failures += 1;
+        }
+    }
+}
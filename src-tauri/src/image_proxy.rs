@@ -0,0 +1,445 @@
+use std::{
+    net::{IpAddr, Ipv4Addr, SocketAddr, UdpSocket},
+    path::{Path, PathBuf},
+    sync::atomic::{AtomicBool, AtomicUsize, Ordering},
+    time::Duration,
+};
+
+use anyhow::{anyhow, Context};
+use parking_lot::RwLock;
+use reqwest::Url;
+use sha2::{Digest, Sha256};
+use tauri::{AppHandle, Manager};
+use tauri_specta::Event;
+use tokio::{
+    io::{AsyncReadExt, AsyncWriteExt},
+    net::{TcpListener, TcpStream},
+};
+
+use crate::{
+    config::Config,
+    events::LogEvent,
+    extensions::AnyhowErrorToStringChain,
+    image_format::{self, IMAGE_EXTENSIONS},
+    manhuagui_client::ManhuaguiClient,
+    opds,
+    utils::write_atomic,
+};
+
+/// 只代理这些域名下的图片，避免本地服务被当成任意网址的代理
+const ALLOWED_HOST_SUFFIXES: [&str; 3] = ["hamreus.com", "mhgui.com", "manhuagui.com"];
+
+/// 请求头最多读取的字节数，只处理GET请求，不需要读取请求体
+const MAX_REQUEST_HEAD_SIZE: usize = 8 * 1024;
+
+/// 读取请求头的超时时间，超时的连接直接关闭，避免一直占着
+const REQUEST_HEAD_TIMEOUT: Duration = Duration::from_secs(10);
+
+/// `accept`出错(例如文件描述符耗尽)后等待一会儿再继续，避免空转占满CPU
+const ACCEPT_ERROR_BACKOFF: Duration = Duration::from_millis(500);
+
+/// 缓存目录名，位于`cache_dir`下
+const CACHE_DIR_NAME: &str = "图片代理";
+
+/// 最多缓存的图片数，超出时按修改时间删除最早缓存的
+const CACHED_IMAGE_LIMIT: usize = 2000;
+
+/// 每新缓存这么多张图片检查一次是否超出`CACHED_IMAGE_LIMIT`，避免每次都扫描缓存目录
+const EVICT_INTERVAL: usize = 100;
+
+/// 本地图片代理服务，前端的`<img>`指向`http://127.0.0.1:{port}/img?url=...`就能显示漫画柜的图片
+///
+/// 代理时使用`ManhuaguiClient`下载，会自动带上Referer等请求头绕过防盗链，
+/// 下载过的图片缓存在`cache_dir/图片代理`中，再次请求时直接返回缓存
+///
+/// 同一个服务还在`/opds`下提供已下载漫画的OPDS目录，漫画阅读器添加这个地址就能直接浏览和下载。
+/// 开启`opds_lan_access`后局域网内的其他设备也能访问，但图片代理仍然只对本机开放
+#[derive(Default)]
+pub struct ImageProxy {
+    addr: RwLock<Option<SocketAddr>>,
+    /// 是否监听了所有网卡，启动时按配置决定
+    lan_access: AtomicBool,
+    /// 上次清理缓存后新缓存的图片数
+    cached_since_evict: AtomicUsize,
+}
+
+impl ImageProxy {
+    /// 代理服务的地址(例如`http://127.0.0.1:12345`)，服务还没启动或启动失败时为None
+    pub fn base_url(&self) -> Option<String> {
+        self.addr
+            .read()
+            .map(|addr| format!("http://127.0.0.1:{}", addr.port()))
+    }
+
+    /// OPDS目录的地址，开启局域网访问时使用本机的局域网ip，方便其他设备上的阅读器添加
+    pub fn opds_url(&self) -> Option<String> {
+        let port = self.addr.read().as_ref()?.port();
+        let ip = if self.lan_access.load(Ordering::Relaxed) {
+            local_lan_ip().unwrap_or(IpAddr::V4(Ipv4Addr::LOCALHOST))
+        } else {
+            IpAddr::V4(Ipv4Addr::LOCALHOST)
+        };
+        Some(format!("http://{}/opds", SocketAddr::new(ip, port)))
+    }
+
+    /// 在后台启动代理服务，默认只监听`127.0.0.1`，启动失败只记录日志，不影响软件的其他功能
+    pub fn start(app: &AppHandle) {
+        let app = app.clone();
+        tauri::async_runtime::spawn(async move {
+            if let Err(err) = Self::serve(&app).await {
+                let err = err.context("启动图片代理服务失败");
+                let _ = LogEvent::Warn {
+                    msg: err.to_string_chain(),
+                }
+                .emit(&app);
+            }
+        });
+    }
+
+    async fn serve(app: &AppHandle) -> anyhow::Result<()> {
+        // 端口为0时由系统分配空闲端口
+        let (port, lan_access) = {
+            let config = app.state::<RwLock<Config>>();
+            let config = config.read();
+            (config.image_proxy_port, config.opds_lan_access)
+        };
+        let ip = if lan_access {
+            Ipv4Addr::UNSPECIFIED
+        } else {
+            Ipv4Addr::LOCALHOST
+        };
+        let listener = TcpListener::bind((ip, port))
+            .await
+            .context(format!("监听`{ip}:{port}`失败"))?;
+        let addr = listener.local_addr().context("获取监听地址失败")?;
+        let image_proxy = app.state::<ImageProxy>();
+        *image_proxy.addr.write() = Some(addr);
+        image_proxy.lan_access.store(lan_access, Ordering::Relaxed);
+        // 启动时先清理一次上次运行留下的缓存
+        spawn_evict_cached_images(app);
+
+        loop {
+            // 单个连接出错不影响其他连接
+            let Ok((stream, peer_addr)) = listener.accept().await else {
+                tokio::time::sleep(ACCEPT_ERROR_BACKOFF).await;
+                continue;
+            };
+            let is_local = peer_addr.ip().is_loopback();
+            tauri::async_runtime::spawn(handle_connection(app.clone(), stream, is_local));
+        }
+    }
+}
+
+/// 每个连接只处理一个请求，响应后关闭连接，`is_local`表示请求是否来自本机
+async fn handle_connection(app: AppHandle, mut stream: TcpStream, is_local: bool) {
+    let response =
+        match tokio::time::timeout(REQUEST_HEAD_TIMEOUT, read_request_line(&mut stream)).await {
+            Ok(Ok((method, target))) => route(&app, &method, &target, is_local).await,
+            Ok(Err(err)) => HttpResponse::text(400, &err.to_string_chain()),
+            Err(_) => HttpResponse::text(408, "读取请求头超时"),
+        };
+    // 写入失败通常是前端已经不需要这张图片了，不需要处理
+    let _ = response.write_to(&mut stream).await;
+}
+
+/// 读取完整的请求头，返回请求行中的方法和路径
+async fn read_request_line(stream: &mut TcpStream) -> anyhow::Result<(String, String)> {
+    let mut head = Vec::new();
+    let mut buf = [0u8; 1024];
+    while !head.windows(4).any(|window| window == b"\r\n\r\n") {
+        if head.len() > MAX_REQUEST_HEAD_SIZE {
+            return Err(anyhow!("请求头超过了{MAX_REQUEST_HEAD_SIZE}字节"));
+        }
+        let n = stream.read(&mut buf).await.context("读取请求失败")?;
+        if n == 0 {
+            return Err(anyhow!("请求头还没读完连接就被关闭了"));
+        }
+        head.extend_from_slice(&buf[..n]);
+    }
+
+    let head = String::from_utf8_lossy(&head);
+    let request_line = head.lines().next().unwrap_or_default();
+    let mut parts = request_line.split_whitespace();
+    let (Some(method), Some(target)) = (parts.next(), parts.next()) else {
+        return Err(anyhow!("请求行`{request_line}`格式不正确"));
+    };
+    Ok((method.to_string(), target.to_string()))
+}
+
+async fn route(app: &AppHandle, method: &str, target: &str, is_local: bool) -> HttpResponse {
+    if method != "GET" {
+        return HttpResponse::text(405, "只支持GET请求");
+    }
+    let Ok(request_url) = Url::parse(&format!("http://127.0.0.1{target}")) else {
+        return HttpResponse::text(400, &format!("`{target}`不是合法的路径"));
+    };
+    match request_url.path() {
+        // 图片代理会替局域网内的设备向漫画柜发请求，只对本机开放
+        "/img" if !is_local => HttpResponse::text(403, "图片代理只对本机开放"),
+        "/img" => proxy_image(app, &request_url).await,
+        path if path == "/opds" || path.starts_with("/opds/") => {
+            // 扫描目录、打包cbz都是阻塞操作，放到专门的线程中，不占用处理其他连接的异步线程
+            let app = app.clone();
+            let path = path.to_string();
+            tauri::async_runtime::spawn_blocking(move || serve_opds(&app, &path))
+                .await
+                .unwrap_or_else(|err| HttpResponse::text(500, &format!("处理OPDS请求失败: {err}")))
+        }
+        _ => HttpResponse::text(404, "Not Found"),
+    }
+}
+
+/// 处理`/opds`下的请求，路径中的id都是数字，不会被拼成任意文件路径
+fn serve_opds(app: &AppHandle, path: &str) -> HttpResponse {
+    let segments: Vec<&str> = path.trim_end_matches('/').split('/').skip(2).collect();
+    let result = match segments.as_slice() {
+        [] => opds::root_feed(app).map(|feed| {
+            Some(HttpResponse {
+                status: 200,
+                content_type: opds::NAVIGATION_FEED_TYPE,
+                body: feed.into_bytes(),
+            })
+        }),
+        ["comic", comic_id] => match comic_id.parse() {
+            Ok(comic_id) => opds::comic_feed(app, comic_id).map(|feed| {
+                feed.map(|feed| HttpResponse {
+                    status: 200,
+                    content_type: opds::ACQUISITION_FEED_TYPE,
+                    body: feed.into_bytes(),
+                })
+            }),
+            Err(_) => Ok(None),
+        },
+        ["cover", comic_id] => match comic_id.parse() {
+            Ok(comic_id) => opds::cover_path(app, comic_id).and_then(|cover_path| {
+                let Some(cover_path) = cover_path else {
+                    return Ok(None);
+                };
+                let body =
+                    std::fs::read(&cover_path).context(format!("读取封面`{cover_path:?}`失败"))?;
+                Ok(Some(HttpResponse {
+                    status: 200,
+                    content_type: content_type_of(&cover_path),
+                    body,
+                }))
+            }),
+            Err(_) => Ok(None),
+        },
+        ["chapter", comic_id, filename] => {
+            let chapter_id = filename.strip_suffix(".cbz").unwrap_or(filename);
+            match (comic_id.parse(), chapter_id.parse()) {
+                (Ok(comic_id), Ok(chapter_id)) => {
+                    opds::chapter_cbz(app, comic_id, chapter_id).map(|cbz| {
+                        cbz.map(|body| HttpResponse {
+                            status: 200,
+                            content_type: opds::CBZ_TYPE,
+                            body,
+                        })
+                    })
+                }
+                _ => Ok(None),
+            }
+        }
+        _ => Ok(None),
+    };
+
+    match result {
+        Ok(Some(response)) => response,
+        Ok(None) => HttpResponse::text(404, "Not Found"),
+        Err(err) => {
+            let err = err.context(format!("处理`{path}`失败"));
+            HttpResponse::text(500, &err.to_string_chain())
+        }
+    }
+}
+
+/// 处理`/img?url=...`，`url`需要经过percent编码
+async fn proxy_image(app: &AppHandle, request_url: &Url) -> HttpResponse {
+    let Some(image_url) = request_url
+        .query_pairs()
+        .find(|(key, _)| key == "url")
+        .map(|(_, value)| value.to_string())
+    else {
+        return HttpResponse::text(400, "缺少参数url");
+    };
+    let Ok(parsed_url) = Url::parse(&image_url) else {
+        return HttpResponse::text(400, &format!("`{image_url}`不是合法的url"));
+    };
+    let is_allowed = parsed_url.host_str().is_some_and(|host| {
+        ALLOWED_HOST_SUFFIXES
+            .iter()
+            .any(|suffix| host == *suffix || host.ends_with(&format!(".{suffix}")))
+    });
+    if !is_allowed {
+        return HttpResponse::text(403, &format!("不代理`{image_url}`，只代理漫画柜的图片"));
+    }
+
+    match get_image(app, &image_url).await {
+        Ok((content_type, image_data)) => HttpResponse {
+            status: 200,
+            content_type,
+            body: image_data,
+        },
+        Err(err) => {
+            let err = err.context(format!("代理图片`{image_url}`失败"));
+            HttpResponse::text(502, &err.to_string_chain())
+        }
+    }
+}
+
+/// 优先返回缓存，没有缓存时下载并写入缓存，返回图片的`Content-Type`和数据
+async fn get_image(app: &AppHandle, image_url: &str) -> anyhow::Result<(&'static str, Vec<u8>)> {
+    let cache_path = get_cache_path(app, image_url);
+    if let Some(cached_path) = IMAGE_EXTENSIONS
+        .iter()
+        .map(|extension| cache_path.with_extension(extension))
+        .find(|path| path.exists())
+    {
+        let image_data =
+            std::fs::read(&cached_path).context(format!("读取缓存`{cached_path:?}`失败"))?;
+        return Ok((content_type_of(&cached_path), image_data));
+    }
+
+    let manhuagui_client = app.state::<ManhuaguiClient>().inner().clone();
+    let image_data = manhuagui_client.get_image_bytes(image_url).await?.to_vec();
+    let image_info =
+        image_format::inspect_image(&image_data).context("下载到的数据不是完整的图片")?;
+    let save_path = cache_path.with_extension(image_info.extension());
+    // 缓存写入失败不影响这次返回图片
+    if write_atomic(&save_path, &image_data).is_ok() {
+        let image_proxy = app.state::<ImageProxy>();
+        if image_proxy
+            .cached_since_evict
+            .fetch_add(1, Ordering::Relaxed)
+            + 1
+            >= EVICT_INTERVAL
+        {
+            spawn_evict_cached_images(app);
+        }
+    }
+    Ok((content_type_of(&save_path), image_data))
+}
+
+/// 缓存文件名是图片url的sha256，扩展名按图片的实际格式决定
+fn get_cache_path(app: &AppHandle, image_url: &str) -> PathBuf {
+    let cache_dir = app.state::<RwLock<Config>>().read().cache_dir.clone();
+    let hash = Sha256::digest(image_url.as_bytes())
+        .iter()
+        .map(|byte| format!("{byte:02x}"))
+        .collect::<String>();
+    cache_dir.join(CACHE_DIR_NAME).join(hash)
+}
+
+/// 在后台清理缓存，清理失败只记录日志
+fn spawn_evict_cached_images(app: &AppHandle) {
+    app.state::<ImageProxy>()
+        .cached_since_evict
+        .store(0, Ordering::Relaxed);
+    let cache_dir = app
+        .state::<RwLock<Config>>()
+        .read()
+        .cache_dir
+        .join(CACHE_DIR_NAME);
+    let app = app.clone();
+    tauri::async_runtime::spawn_blocking(move || {
+        if let Err(err) = evict_cached_images(&cache_dir) {
+            let err = err.context("清理图片代理的缓存失败");
+            let _ = LogEvent::Warn {
+                msg: err.to_string_chain(),
+            }
+            .emit(&app);
+        }
+    });
+}
+
+/// 按修改时间删除`cache_dir`中最早缓存的图片，只保留`CACHED_IMAGE_LIMIT`张
+fn evict_cached_images(cache_dir: &Path) -> anyhow::Result<()> {
+    if !cache_dir.exists() {
+        return Ok(());
+    }
+    let mut cached_images = std::fs::read_dir(cache_dir)
+        .context(format!("读取目录`{cache_dir:?}`失败"))?
+        .filter_map(Result::ok)
+        .map(|entry| entry.path())
+        .filter(|path| path.is_file())
+        .map(|path| {
+            let modified = path
+                .metadata()
+                .and_then(|metadata| metadata.modified())
+                .ok();
+            (modified, path)
+        })
+        .collect::<Vec<_>>();
+    let excess = cached_images.len().saturating_sub(CACHED_IMAGE_LIMIT);
+    cached_images.sort();
+    for (_, path) in cached_images.into_iter().take(excess) {
+        std::fs::remove_file(&path).context(format!("删除文件`{path:?}`失败"))?;
+    }
+    Ok(())
+}
+
+/// 本机在局域网中的ip
+///
+/// UDP的`connect`不会真的发出数据，只是让系统按路由表选出访问外网时使用的网卡
+fn local_lan_ip() -> Option<IpAddr> {
+    let socket = UdpSocket::bind((Ipv4Addr::UNSPECIFIED, 0)).ok()?;
+    socket.connect(("8.8.8.8", 80)).ok()?;
+    Some(socket.local_addr().ok()?.ip())
+}
+
+fn content_type_of(path: &Path) -> &'static str {
+    match path.extension().and_then(|extension| extension.to_str()) {
+        Some("png") => "image/png",
+        Some("gif") => "image/gif",
+        Some("webp") => "image/webp",
+        _ => "image/jpeg",
+    }
+}
+
+struct HttpResponse {
+    status: u16,
+    content_type: &'static str,
+    body: Vec<u8>,
+}
+
+impl HttpResponse {
+    fn text(status: u16, text: &str) -> Self {
+        Self {
+            status,
+            content_type: "text/plain; charset=utf-8",
+            body: text.as_bytes().to_vec(),
+        }
+    }
+
+    fn reason_phrase(&self) -> &'static str {
+        match self.status {
+            200 => "OK",
+            400 => "Bad Request",
+            403 => "Forbidden",
+            404 => "Not Found",
+            405 => "Method Not Allowed",
+            408 => "Request Timeout",
+            500 => "Internal Server Error",
+            _ => "Bad Gateway",
+        }
+    }
+
+    async fn write_to(&self, stream: &mut TcpStream) -> std::io::Result<()> {
+        // 只有图片允许浏览器缓存，OPDS目录和错误响应不缓存，下次还会重新请求
+        let cache_control = if self.status == 200 && self.content_type.starts_with("image/") {
+            "max-age=86400"
+        } else {
+            "no-store"
+        };
+        let head = format!(
+            "HTTP/1.1 {} {}\r\nContent-Type: {}\r\nContent-Length: {}\r\nCache-Control: {cache_control}\r\nConnection: close\r\n\r\n",
+            self.status,
+            self.reason_phrase(),
+            self.content_type,
+            self.body.len(),
+        );
+        stream.write_all(head.as_bytes()).await?;
+        stream.write_all(&self.body).await?;
+        stream.shutdown().await
+    }
+}
@@ -0,0 +1,88 @@
+use std::sync::Arc;
+
+use http::{
+    header::{REFERER, USER_AGENT},
+    Extensions, HeaderMap, HeaderName, HeaderValue,
+};
+use parking_lot::RwLock;
+use reqwest::{Request, Response};
+use reqwest_middleware::{Middleware, Next};
+
+/// 桌面版Chrome的User-Agent，漫画柜会把移动端的UA重定向到手机版页面
+const DESKTOP_USER_AGENT: &str = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36";
+
+/// 给请求加上指定的header，请求中已经有这个header时不覆盖
+///
+/// 作为拦截器通过 `ManhuaguiClient::use_interceptor` 挂到http客户端上
+#[derive(Clone)]
+pub struct HeaderInterceptor {
+    name: HeaderName,
+    value: HeaderValue,
+}
+
+impl HeaderInterceptor {
+    pub fn new(name: HeaderName, value: HeaderValue) -> Self {
+        Self { name, value }
+    }
+
+    /// 图片服务器会拒绝没有Referer的请求
+    pub fn referer() -> Self {
+        Self::new(
+            REFERER,
+            HeaderValue::from_static("https://www.manhuagui.com/"),
+        )
+    }
+
+    pub fn user_agent() -> Self {
+        Self::new(USER_AGENT, HeaderValue::from_static(DESKTOP_USER_AGENT))
+    }
+}
+
+#[async_trait::async_trait]
+impl Middleware for HeaderInterceptor {
+    async fn handle(
+        &self,
+        mut req: Request,
+        extensions: &mut Extensions,
+        next: Next<'_>,
+    ) -> reqwest_middleware::Result<Response> {
+        if !req.headers().contains_key(&self.name) {
+            req.headers_mut()
+                .insert(self.name.clone(), self.value.clone());
+        }
+        next.run(req, extensions).await
+    }
+}
+
+/// 给所有请求加上用户自定义的header，例如某些代理要求的鉴权头，可以随时修改
+///
+/// 挂在 `HeaderInterceptor` 之后，请求中已经有的header不会被覆盖，
+/// 所以User-Agent、Referer以及请求自己设置的header总是优先于用户自定义的header。
+/// 克隆 `ExtraHeadersInterceptor` 只是增加引用计数，所有克隆副本共享同一组header
+#[derive(Clone, Default)]
+pub struct ExtraHeadersInterceptor {
+    headers: Arc<RwLock<HeaderMap>>,
+}
+
+impl ExtraHeadersInterceptor {
+    pub fn set_headers(&self, headers: HeaderMap) {
+        *self.headers.write() = headers;
+    }
+}
+
+#[async_trait::async_trait]
+impl Middleware for ExtraHeadersInterceptor {
+    async fn handle(
+        &self,
+        mut req: Request,
+        extensions: &mut Extensions,
+        next: Next<'_>,
+    ) -> reqwest_middleware::Result<Response> {
+        for (name, value) in self.headers.read().iter() {
+            if !req.headers().contains_key(name) {
+                req.headers_mut().insert(name.clone(), value.clone());
+            }
+        }
+        next.run(req, extensions).await
+    }
+}
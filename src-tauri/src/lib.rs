@@ -1,22 +1,61 @@
+mod adaptive_concurrency;
+mod chapter_filter;
+mod chapter_manifest;
+mod comic_cache;
+mod comic_dirs;
 mod commands;
 mod config;
 mod decrypt;
+mod diagnose;
+mod download_batch;
+mod download_history;
 mod download_manager;
+mod download_queue;
+mod downloaded_checker;
 mod errors;
 mod events;
 mod export;
 mod extensions;
+mod image_format;
+mod image_host;
+mod image_proxy;
+mod interceptors;
+mod library;
+mod library_index;
+mod lz_cache;
 mod manhuagui_client;
+mod metrics;
+mod netscape_cookies;
+mod opds;
+mod proxy_detect;
+mod rate_limiter;
+mod reader;
+mod search_history;
+mod site;
+mod task_list;
+#[cfg(test)]
+mod test_utils;
 mod types;
 mod utils;
+mod webdav_sync;
+mod zh_convert;
 
 use anyhow::Context;
 use config::Config;
+use download_history::DownloadHistory;
 use download_manager::DownloadManager;
-use events::{DownloadEvent, ExportCbzEvent, ExportPdfEvent, UpdateDownloadedComicsEvent};
+use events::{
+    DownloadEvent, ExportCbzEvent, ExportEpubEvent, ExportPdfEvent, LogEvent,
+    UpdateDownloadedComicsEvent, WebDavSyncEvent,
+};
+use extensions::AnyhowErrorToStringChain;
+use image_proxy::ImageProxy;
 use manhuagui_client::ManhuaguiClient;
 use parking_lot::RwLock;
+use reader::ReaderSessions;
+use search_history::SearchHistory;
 use tauri::{Manager, Wry};
+use tauri_specta::Event;
 
 use crate::commands::*;
 
@@ -32,22 +71,81 @@ pub fn run() {
             get_config,
             save_config,
             login,
+            import_netscape_cookies,
+            export_netscape_cookies,
+            set_host_rate_limit,
+            set_accept_invalid_certs,
+            add_root_ca,
             get_user_profile,
             search,
+            get_search_history,
+            clear_search_history,
+            get_rank,
+            get_latest_updates,
+            get_comics_by_genre,
             get_comic,
+            get_comic_by_chapter_href,
+            export_comic_json,
+            get_comic_diff,
+            filter_chapters,
+            find_chapters,
             download_chapters,
+            estimate_size,
+            preview_download,
+            cancel_download,
+            pause_download,
+            resume_download,
+            is_download_paused,
+            pause_chapters,
+            resume_chapters,
+            cancel_chapters,
+            pause_comic,
+            resume_comic,
+            get_paused_chapter_ids,
+            get_download_task_states,
+            get_download_queue_state,
+            get_image_proxy_url,
+            get_opds_url,
+            list_download_history,
+            clear_download_history,
+            get_restored_download_tasks,
+            resume_restored_download_tasks,
+            discard_restored_download_tasks,
             get_favorite,
             save_metadata,
             get_downloaded_comics,
+            stream_chapter_images,
+            set_reader_position,
+            close_reader,
+            plan_reorganize_library,
+            reorganize_library,
+            verify_library,
+            repair_chapters,
+            dedupe_images,
+            build_library_index,
             export_cbz,
             export_pdf,
+            export_epub,
+            export_by_rules,
+            export_zip,
+            sync_to_webdav,
             update_downloaded_comics,
+            export_task_list,
+            import_task_list,
+            import_external_tasks,
+            diagnose,
+            get_request_metrics,
+            benchmark_image_servers,
+            auto_detect_proxy,
         ])
         .events(tauri_specta::collect_events![
             DownloadEvent,
+            LogEvent,
             ExportCbzEvent,
             ExportPdfEvent,
+            ExportEpubEvent,
             UpdateDownloadedComicsEvent,
+            WebDavSyncEvent,
         ]);
 
     #[cfg(debug_assertions)]
@@ -76,13 +174,45 @@ pub fn run() {
             std::fs::create_dir_all(&app_data_dir)
                 .context(format!("failed to create app data dir: {app_data_dir:?}"))?;
 
-            let config = RwLock::new(Config::new(app.handle())?);
-            app.manage(config);
+            let config = Config::new(app.handle())?;
+            config
+                .allow_asset_access(app.handle())
+                .context("failed to allow asset access")?;
+            app.manage(RwLock::new(config));
 
             let manhuagui_client = ManhuaguiClient::new(app.handle().clone());
             app.manage(manhuagui_client);
 
+            let download_history = DownloadHistory::new(app.handle());
+            app.manage(download_history);
+
+            let search_history = SearchHistory::new(app.handle());
+            app.manage(search_history);
+
+            app.manage(ReaderSessions::default());
+
+            app.manage(ImageProxy::default());
+            ImageProxy::start(app.handle());
+
             let download_manager = DownloadManager::new(app.handle());
+            let app_handle = app.handle().clone();
+            download_manager.on_chapter_completed(move |chapter_info, chapter_download_dir| {
+                let download_history = app_handle.state::<DownloadHistory>();
+                if let Err(err) = download_history.record(chapter_info, chapter_download_dir) {
+                    let err = err.context(format!(
+                        "记录`{} - {}`的下载历史失败",
+                        chapter_info.comic_title, chapter_info.chapter_title
+                    ));
+                    let _ = LogEvent::Warn {
+                        msg: err.to_string_chain(),
+                    }
+                    .emit(&app_handle);
+                }
+            });
+            let app_handle = app.handle().clone();
+            download_manager.on_chapter_completed(move |chapter_info, chapter_download_dir| {
+                export::on_chapter_completed(&app_handle, chapter_info, chapter_download_dir);
+            });
             app.manage(download_manager);
 
             Ok(())
@@ -0,0 +1,562 @@
+use std::{
+    collections::{HashMap, HashSet},
+    fs::File,
+    hash::{DefaultHasher, Hasher},
+    io::{BufReader, ErrorKind, Read},
+    path::{Component, Path, PathBuf},
+    sync::LazyLock,
+};
+
+use anyhow::{anyhow, Context};
+use regex::Regex;
+use serde::{Deserialize, Serialize};
+use specta::Type;
+
+use crate::{
+    chapter_manifest::ChapterManifest,
+    downloaded_checker::{image_paths, page_num_of},
+    manhuagui_client::ManhuaguiClient,
+    types::{ChapterInfo, Comic},
+    utils::{move_dir, sub_dirs},
+};
+
+/// 章节目录名开头的序号，例如`12 第12话`中的`12 `
+static ORDER_PREFIX_RE: LazyLock<Regex> = LazyLock::new(|| Regex::new(r"^\d+(\.\d+)?\s+").unwrap());
+
+/// 整理已下载漫画目录时的一个操作
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize, Type)]
+#[serde(tag = "kind", rename_all = "camelCase")]
+pub enum ReorganizeAction {
+    /// 把`from`重命名为`to`
+    #[serde(rename_all = "camelCase")]
+    Rename { from: PathBuf, to: PathBuf },
+    /// `to`已存在，把`from`中`to`没有的文件移动过去，与`to`中同名文件内容相同的直接删除，
+    /// 内容不同的保留在`from`中，`from`变空后才删除
+    #[serde(rename_all = "camelCase")]
+    Merge { from: PathBuf, to: PathBuf },
+    /// 删除整理后变空的章节组目录
+    #[serde(rename_all = "camelCase")]
+    RemoveEmptyDir { dir: PathBuf },
+}
+
+/// 整理的结果，`executed`为false时只是计划，没有改动任何文件
+#[derive(Default, Debug, Clone, Serialize, Deserialize, Type)]
+#[serde(rename_all = "camelCase")]
+pub struct ReorganizeReport {
+    pub actions: Vec<ReorganizeAction>,
+    pub executed: bool,
+    /// 读取元数据失败的漫画和执行失败的操作及原因，失败的不影响其他的
+    pub errors: Vec<String>,
+}
+
+/// 按当前版本的命名规则整理`download_dir`中的所有漫画
+///
+/// 目标目录名来自每本漫画的元数据，即元数据中记录的章节目录结构，默认为`漫画标题/组名/序号 章节标题`，
+/// 旧版本下载的没有序号、序号不同或者在改名前的章节组里的目录都会被移动到目标位置
+///
+/// 只生成计划，不改动任何文件，用户确认后把计划中的操作交给`execute_reorganize`执行
+pub fn plan_reorganize(download_dir: &Path) -> anyhow::Result<ReorganizeReport> {
+    let mut actions = Vec::new();
+    let mut errors = Vec::new();
+    let comic_dirs = std::fs::read_dir(download_dir)
+        .context(format!("读取下载目录`{download_dir:?}`失败"))?
+        .filter_map(Result::ok)
+        .map(|entry| entry.path())
+        .filter(|path| path.join("元数据.json").exists());
+    for comic_dir in comic_dirs {
+        // 元数据损坏的漫画不知道目标目录名，跳过它，不影响其他漫画
+        match read_metadata(&comic_dir) {
+            Ok(comic) => actions.extend(plan_comic(&comic_dir, &comic)),
+            Err(err) => errors.push(format!("{err:#}")),
+        }
+    }
+
+    Ok(ReorganizeReport {
+        actions,
+        executed: false,
+        errors,
+    })
+}
+
+/// 执行用户确认过的整理计划，执行的正是确认时看到的操作，不会重新生成计划
+///
+/// 计划来自前端，所有操作涉及的路径都必须位于`download_dir`中，且不能含有`..`
+pub fn execute_reorganize(download_dir: &Path, actions: Vec<ReorganizeAction>) -> ReorganizeReport {
+    let errors = actions
+        .iter()
+        .filter_map(|action| {
+            let paths = match action {
+                ReorganizeAction::Rename { from, to } | ReorganizeAction::Merge { from, to } => {
+                    vec![from, to]
+                }
+                ReorganizeAction::RemoveEmptyDir { dir } => vec![dir],
+            };
+            // `starts_with`按路径组件比较，`下载目录/../x`也能通过，所以还要拒绝含有`..`的路径
+            let is_outside = |path: &&&PathBuf| {
+                !path.starts_with(download_dir)
+                    || path
+                        .components()
+                        .any(|component| component == Component::ParentDir)
+            };
+            if let Some(path) = paths.iter().find(is_outside) {
+                return Some(anyhow!("`{path:?}`不在下载目录`{download_dir:?}`中，跳过"));
+            }
+            execute(action).err()
+        })
+        .map(|err| format!("{err:#}"))
+        .collect();
+    ReorganizeReport {
+        actions,
+        executed: true,
+        errors,
+    }
+}
+
+/// 已下载漫画的完整性校验结果
+#[derive(Default, Debug, Clone, Serialize, Deserialize, Type)]
+#[serde(rename_all = "camelCase")]
+pub struct VerifyReport {
+    pub comic_title: String,
+    /// 本地有章节目录、参与了校验的章节数
+    pub checked_chapters: u32,
+    /// 有缺页或损坏的页的章节，完整的章节不在其中
+    pub incomplete_chapters: Vec<ChapterVerifyResult>,
+    /// 获取页数或读取校验和清单失败的章节及原因，获取页数失败的章节没有被校验
+    pub errors: Vec<String>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, Type)]
+#[serde(rename_all = "camelCase")]
+pub struct ChapterVerifyResult {
+    pub chapter_info: ChapterInfo,
+    /// 网站上的页数
+    pub expected_pages: u32,
+    /// 本地的图片数量
+    pub local_pages: u32,
+    /// 缺失的页码，从1开始
+    pub missing_pages: Vec<u32>,
+    /// 与校验和清单中记录的大小或哈希不符的页码，从1开始，没有清单的章节总是为空
+    pub corrupted_pages: Vec<u32>,
+}
+
+/// 对照网站上的页数检查`comic_dir`中每个已下载章节的图片是否齐全，章节有校验和清单时还会检查每一页是否损坏
+///
+/// 页数优先使用元数据中记录的，没有记录时才请求章节页获取，本地没有目录的章节视为没有下载，不参与校验
+#[allow(clippy::cast_possible_truncation, clippy::cast_sign_loss)]
+pub async fn verify(
+    manhuagui_client: &ManhuaguiClient,
+    comic_dir: &Path,
+) -> anyhow::Result<VerifyReport> {
+    let comic = read_metadata(comic_dir)?;
+    let mut report = VerifyReport {
+        comic_title: comic.title.clone(),
+        ..Default::default()
+    };
+
+    let mut chapter_infos = comic.groups.values().flatten().collect::<Vec<_>>();
+    chapter_infos.sort_by(|a, b| {
+        a.group_name
+            .cmp(&b.group_name)
+            .then(a.order.total_cmp(&b.order))
+    });
+    for chapter_info in chapter_infos {
+        let chapter_dir = get_target(comic_dir, chapter_info);
+        if !chapter_dir.is_dir() {
+            continue;
+        }
+        let expected_pages = if chapter_info.chapter_size > 0 {
+            chapter_info.chapter_size as u32
+        } else {
+            match manhuagui_client.get_image_urls(chapter_info).await {
+                Ok(urls) => urls.len() as u32,
+                Err(err) => {
+                    let err = err.context(format!(
+                        "获取`{} - {}`的页数失败",
+                        chapter_info.group_name, chapter_info.chapter_title
+                    ));
+                    report.errors.push(format!("{err:#}"));
+                    continue;
+                }
+            }
+        };
+        report.checked_chapters += 1;
+
+        let local_page_numbers = image_paths(&chapter_dir)
+            .iter()
+            .map(PathBuf::as_path)
+            .filter_map(page_num_of)
+            .collect::<HashSet<_>>();
+        let missing_pages = (1..=expected_pages)
+            .filter(|page| !local_page_numbers.contains(page))
+            .collect::<Vec<_>>();
+        // 缺失的页已经算在missing_pages里了，不重复报告
+        let corrupted_pages = match ChapterManifest::load(&chapter_dir) {
+            Ok(Some(manifest)) => manifest
+                .verify(&chapter_dir)
+                .into_iter()
+                .filter(|page| local_page_numbers.contains(page))
+                .collect(),
+            Ok(None) => Vec::new(),
+            Err(err) => {
+                let err = err.context(format!(
+                    "读取`{} - {}`的校验和清单失败",
+                    chapter_info.group_name, chapter_info.chapter_title
+                ));
+                report.errors.push(format!("{err:#}"));
+                Vec::new()
+            }
+        };
+        if missing_pages.is_empty() && corrupted_pages.is_empty() {
+            continue;
+        }
+        report.incomplete_chapters.push(ChapterVerifyResult {
+            chapter_info: chapter_info.clone(),
+            expected_pages,
+            local_pages: local_page_numbers.len() as u32,
+            missing_pages,
+            corrupted_pages,
+        });
+    }
+    Ok(report)
+}
+
+/// 内容完全相同的一组图片
+#[derive(Debug, Clone, Serialize, Deserialize, Type)]
+#[serde(rename_all = "camelCase")]
+pub struct DuplicateImages {
+    /// 按路径排序，第一个是保留的，其余的是重复的
+    pub paths: Vec<PathBuf>,
+    /// 单个文件的字节数
+    pub size: u64,
+}
+
+/// 图片去重的结果，`linked`为false时只是报告，没有改动任何文件
+#[derive(Default, Debug, Clone, Serialize, Deserialize, Type)]
+#[serde(rename_all = "camelCase")]
+pub struct DedupeReport {
+    pub duplicates: Vec<DuplicateImages>,
+    /// 重复的文件占用的字节数，用硬链接替换后可以省下这么多空间
+    pub wasted_bytes: u64,
+    pub linked: bool,
+    /// 读取或替换失败的文件及原因，失败的不影响其他的
+    pub errors: Vec<String>,
+}
+
+/// 找出`comic_dir`中内容完全相同的图片，`hard_link`为true时把重复的图片替换为指向保留的那张的硬链接
+///
+/// 先按大小分组，大小相同的才计算哈希，哈希相同的再从磁盘读出两个文件逐字节比较，确认完全相同才算重复。
+/// 哈希和比较都是分块读取的，内存占用与漫画的大小无关。
+/// 硬链接不能跨文件系统，已经是硬链接的文件也会被当成重复的报告出来
+pub fn dedupe_images(comic_dir: &Path, hard_link: bool) -> anyhow::Result<DedupeReport> {
+    if !comic_dir.is_dir() {
+        return Err(anyhow!("`{comic_dir:?}`不是目录"));
+    }
+    let mut report = DedupeReport::default();
+
+    let mut paths_by_size: HashMap<u64, Vec<PathBuf>> = HashMap::new();
+    for path in all_image_paths(comic_dir) {
+        match std::fs::metadata(&path) {
+            Ok(metadata) => paths_by_size.entry(metadata.len()).or_default().push(path),
+            Err(err) => report
+                .errors
+                .push(format!("读取`{path:?}`的大小失败: {err}")),
+        }
+    }
+
+    for (size, paths) in paths_by_size {
+        if paths.len() < 2 || size == 0 {
+            continue;
+        }
+        // 每组只记哈希和路径，组内第一个路径作为逐字节比较的对象
+        let mut groups: Vec<(u64, Vec<PathBuf>)> = Vec::new();
+        for path in paths {
+            let hash = match hash_file(&path) {
+                Ok(hash) => hash,
+                Err(err) => {
+                    report.errors.push(format!("读取`{path:?}`失败: {err}"));
+                    continue;
+                }
+            };
+            let mut matched_group = None;
+            for (index, (group_hash, group)) in groups.iter().enumerate() {
+                if *group_hash != hash {
+                    continue;
+                }
+                match files_equal(&group[0], &path) {
+                    Ok(true) => {
+                        matched_group = Some(index);
+                        break;
+                    }
+                    Ok(false) => {}
+                    Err(err) => report
+                        .errors
+                        .push(format!("比较`{:?}`和`{path:?}`失败: {err}", group[0])),
+                }
+            }
+            match matched_group {
+                Some(index) => groups[index].1.push(path),
+                None => groups.push((hash, vec![path])),
+            }
+        }
+        for (_, mut group) in groups {
+            if group.len() < 2 {
+                continue;
+            }
+            group.sort();
+            report.wasted_bytes += size * (group.len() as u64 - 1);
+            report
+                .duplicates
+                .push(DuplicateImages { paths: group, size });
+        }
+    }
+    report
+        .duplicates
+        .sort_by(|a, b| a.paths[0].cmp(&b.paths[0]));
+
+    if hard_link {
+        for duplicate in &report.duplicates {
+            let original = &duplicate.paths[0];
+            for path in &duplicate.paths[1..] {
+                if let Err(err) = replace_with_hard_link(original, path) {
+                    report.errors.push(format!("{err:#}"));
+                }
+            }
+        }
+        report.linked = true;
+    }
+    Ok(report)
+}
+
+/// 分块读取`path`计算哈希，不会把整个文件读进内存
+fn hash_file(path: &Path) -> std::io::Result<u64> {
+    let mut reader = BufReader::new(File::open(path)?);
+    let mut hasher = DefaultHasher::new();
+    let mut buf = [0u8; 64 * 1024];
+    loop {
+        let n = reader.read(&mut buf)?;
+        if n == 0 {
+            return Ok(hasher.finish());
+        }
+        hasher.write(&buf[..n]);
+    }
+}
+
+/// 分块逐字节比较两个大小相同的文件
+fn files_equal(a: &Path, b: &Path) -> std::io::Result<bool> {
+    let mut reader_a = BufReader::new(File::open(a)?);
+    let mut reader_b = BufReader::new(File::open(b)?);
+    let mut buf_a = [0u8; 64 * 1024];
+    let mut buf_b = [0u8; 64 * 1024];
+    loop {
+        let n = reader_a.read(&mut buf_a)?;
+        if n == 0 {
+            // a读完了，b也必须读完
+            return Ok(reader_b.read(&mut buf_b)? == 0);
+        }
+        match reader_b.read_exact(&mut buf_b[..n]) {
+            Ok(()) => {}
+            Err(err) if err.kind() == ErrorKind::UnexpectedEof => return Ok(false),
+            Err(err) => return Err(err),
+        }
+        if buf_a[..n] != buf_b[..n] {
+            return Ok(false);
+        }
+    }
+}
+
+/// 先在旁边创建硬链接再重命名覆盖`path`，中途失败时`path`保持原样
+fn replace_with_hard_link(original: &Path, path: &Path) -> anyhow::Result<()> {
+    let link_path = path.with_extension("link-part");
+    std::fs::hard_link(original, &link_path)
+        .context(format!("为`{original:?}`创建硬链接`{link_path:?}`失败"))?;
+    if let Err(err) = std::fs::rename(&link_path, path) {
+        let _ = std::fs::remove_file(&link_path);
+        return Err(anyhow!(err).context(format!("将`{link_path:?}`重命名为`{path:?}`失败")));
+    }
+    Ok(())
+}
+
+/// `dir`及其所有子目录中的图片，跳过还在下载中的临时目录
+fn all_image_paths(dir: &Path) -> Vec<PathBuf> {
+    let mut paths = image_paths(dir);
+    for sub_dir in sub_dirs(dir) {
+        if file_name(&sub_dir).starts_with(".下载中-") {
+            continue;
+        }
+        paths.extend(all_image_paths(&sub_dir));
+    }
+    paths
+}
+
+/// 只读取元数据，不计算章节是否已下载
+fn read_metadata(comic_dir: &Path) -> anyhow::Result<Comic> {
+    let metadata_path = comic_dir.join("元数据.json");
+    let comic_json =
+        std::fs::read_to_string(&metadata_path).context(format!("读取`{metadata_path:?}`失败"))?;
+    let comic = serde_json::from_str::<Comic>(&comic_json)
+        .context(format!("将`{metadata_path:?}`反序列化为Comic失败"))?;
+    Ok(comic)
+}
+
+fn plan_comic(comic_dir: &Path, comic: &Comic) -> Vec<ReorganizeAction> {
+    // 已经符合命名规则的目录，同名章节(例如多个`番外`)的目录不能被当成别的章节的旧目录
+    let targets = comic
+        .groups
+        .values()
+        .flatten()
+        .map(|chapter_info| get_target(comic_dir, chapter_info))
+        .collect::<HashSet<_>>();
+
+    let group_names = comic.groups.keys().cloned().collect::<HashSet<_>>();
+    // 元数据里没有的章节组目录，通常是网站改了分页标题后遗留的旧目录，平铺结构下的章节目录不算
+    let stale_group_dirs = sub_dirs(comic_dir)
+        .into_iter()
+        .filter(|dir| !group_names.contains(&file_name(dir)) && !targets.contains(dir))
+        .collect::<Vec<_>>();
+
+    let mut actions = Vec::new();
+    let mut claimed = HashSet::new();
+    for chapter_info in comic.groups.values().flatten() {
+        let group_dir = comic_dir.join(&chapter_info.group_name);
+        let target = get_target(comic_dir, chapter_info);
+        // 优先在同组里找，找不到再去旧的章节组目录里找，避免把其他组的同名章节认错
+        let mut candidates = find_candidates(&group_dir, chapter_info, &targets);
+        if candidates.is_empty() {
+            candidates = stale_group_dirs
+                .iter()
+                .flat_map(|dir| find_candidates(dir, chapter_info, &targets))
+                .collect();
+        }
+        for from in candidates {
+            if !claimed.insert(from.clone()) {
+                continue;
+            }
+            // 同一章节有多个旧目录时，第一个之后的都合并到目标目录
+            let action = if target.exists() || claimed.contains(&target) {
+                ReorganizeAction::Merge {
+                    from,
+                    to: target.clone(),
+                }
+            } else {
+                ReorganizeAction::Rename {
+                    from,
+                    to: target.clone(),
+                }
+            };
+            claimed.insert(target.clone());
+            actions.push(action);
+        }
+    }
+
+    for dir in stale_group_dirs {
+        let Ok(entries) = std::fs::read_dir(&dir) else {
+            continue;
+        };
+        let remaining = entries
+            .filter_map(Result::ok)
+            .filter(|entry| !claimed.contains(&entry.path()))
+            .count();
+        if remaining == 0 {
+            actions.push(ReorganizeAction::RemoveEmptyDir { dir });
+        }
+    }
+    actions
+}
+
+fn get_target(comic_dir: &Path, chapter_info: &ChapterInfo) -> PathBuf {
+    comic_dir.join(chapter_info.dir_in_comic())
+}
+
+/// `dir`中属于`chapter_info`但不符合命名规则的章节目录
+fn find_candidates(
+    dir: &Path,
+    chapter_info: &ChapterInfo,
+    targets: &HashSet<PathBuf>,
+) -> Vec<PathBuf> {
+    sub_dirs(dir)
+        .into_iter()
+        .filter(|path| !targets.contains(path))
+        .filter(|path| {
+            let name = file_name(path);
+            !name.starts_with(".下载中-")
+                && ORDER_PREFIX_RE.replace(&name, "") == chapter_info.chapter_title
+        })
+        .collect()
+}
+
+fn execute(action: &ReorganizeAction) -> anyhow::Result<()> {
+    match action {
+        ReorganizeAction::Rename { from, to } => {
+            move_dir(from, to).context(format!("将`{from:?}`移动到`{to:?}`失败"))?;
+        }
+        ReorganizeAction::Merge { from, to } => {
+            merge_dir(from, to).context(format!("将`{from:?}`合并到`{to:?}`失败"))?;
+        }
+        ReorganizeAction::RemoveEmptyDir { dir } => {
+            // remove_dir只能删除空目录，目录里还有东西时会失败，不会误删
+            std::fs::remove_dir(dir).context(format!("删除目录`{dir:?}`失败"))?;
+        }
+    }
+    Ok(())
+}
+
+/// 把`from`中`to`没有的文件移动到`to`，与`to`中同名文件内容相同的直接删除
+///
+/// 内容不同的同名文件不知道该以哪个为准，保留在`from`中留给用户处理，这时`from`不会被删除
+fn merge_dir(from: &Path, to: &Path) -> anyhow::Result<()> {
+    std::fs::create_dir_all(to).context(format!("创建目录`{to:?}`失败"))?;
+    let mut conflicts = Vec::new();
+    for entry in std::fs::read_dir(from).context(format!("读取目录`{from:?}`失败"))? {
+        let path = entry?.path();
+        let target = to.join(file_name(&path));
+        if path.is_dir() {
+            if target.is_dir() {
+                merge_dir(&path, &target)?;
+            } else if target.exists() {
+                conflicts.push(path);
+            } else {
+                move_dir(&path, &target)?;
+            }
+        } else if !target.exists() {
+            if std::fs::rename(&path, &target).is_err() {
+                std::fs::copy(&path, &target)
+                    .context(format!("将`{path:?}`复制到`{target:?}`失败"))?;
+                std::fs::remove_file(&path).context(format!("删除`{path:?}`失败"))?;
+            }
+        } else if is_same_content(&path, &target)? {
+            std::fs::remove_file(&path).context(format!("删除`{path:?}`失败"))?;
+        } else {
+            conflicts.push(path);
+        }
+    }
+    if !conflicts.is_empty() {
+        return Err(anyhow!(
+            "{}个文件与`{to:?}`中的同名文件内容不同，已保留在`{from:?}`中，请手动处理: {conflicts:?}",
+            conflicts.len()
+        ));
+    }
+    std::fs::remove_dir(from).context(format!("删除目录`{from:?}`失败"))?;
+    Ok(())
+}
+
+fn is_same_content(a: &Path, b: &Path) -> anyhow::Result<bool> {
+    let a_len = std::fs::metadata(a)
+        .context(format!("获取`{a:?}`的元数据失败"))?
+        .len();
+    let b_len = std::fs::metadata(b)
+        .context(format!("获取`{b:?}`的元数据失败"))?
+        .len();
+    if a_len != b_len {
+        return Ok(false);
+    }
+    let a_data = std::fs::read(a).context(format!("读取`{a:?}`失败"))?;
+    let b_data = std::fs::read(b).context(format!("读取`{b:?}`失败"))?;
+    Ok(a_data == b_data)
+}
+
+fn file_name(path: &Path) -> String {
+    path.file_name()
+        .unwrap_or_default()
+        .to_string_lossy()
+        .to_string()
+}
@@ -0,0 +1,228 @@
+use std::{
+    collections::HashMap,
+    path::{Path, PathBuf},
+    time::UNIX_EPOCH,
+};
+
+use anyhow::Context;
+use parking_lot::RwLock;
+use rayon::iter::{IntoParallelIterator, ParallelIterator};
+use serde::{Deserialize, Serialize};
+use specta::Type;
+use tauri::{AppHandle, Manager};
+
+use crate::{
+    config::Config,
+    download_manager::COVER_FILENAME,
+    downloaded_checker::DownloadedCheckStrategy,
+    extensions::AnyhowErrorToStringChain,
+    image_format::IMAGE_EXTENSIONS,
+    types::Comic,
+    utils::{sub_dirs, write_atomic},
+};
+
+/// 索引缓存的文件名，位于`cache_dir`下
+const CACHE_FILENAME: &str = "书架索引.json";
+
+/// 书架上的一本漫画
+#[derive(Debug, Clone, Serialize, Deserialize, Type)]
+#[serde(rename_all = "camelCase")]
+pub struct LibraryEntry {
+    pub id: i64,
+    pub title: String,
+    /// 本地封面的路径，还没下载封面时为None
+    pub cover_path: Option<PathBuf>,
+    /// 元数据中的章节总数
+    pub chapter_count: u32,
+    /// 已下载的章节数
+    pub downloaded_count: u32,
+    /// 网站上的最后更新时间
+    pub update_time: String,
+    /// 本地漫画目录最后一次改动的时间(unix时间戳，秒)
+    pub modified_at: i64,
+}
+
+/// 下载目录中所有漫画的索引
+#[derive(Default, Debug, Clone, Serialize, Deserialize, Type)]
+#[serde(rename_all = "camelCase")]
+pub struct LibraryIndex {
+    /// 按本地改动时间从新到旧排列
+    pub comics: Vec<LibraryEntry>,
+    /// 读取失败的漫画及原因，失败的不影响其他的
+    pub errors: Vec<String>,
+}
+
+/// 缓存中的一本漫画，`fingerprint`和`strategy`都没变时直接复用`entry`
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+struct CachedEntry {
+    fingerprint: u64,
+    /// 统计`entry`的已下载数时使用的策略，换了策略后已下载数可能不同
+    #[serde(default)]
+    strategy: DownloadedCheckStrategy,
+    entry: LibraryEntry,
+}
+
+/// 扫描下载目录，汇总每本漫画的标题、封面、章节数、已下载数和更新时间
+///
+/// 统计已下载数需要检查每个章节目录，比较慢，所以并发扫描，并缓存上次的结果。
+/// 漫画目录及其下两层子目录、元数据文件都没有改动过，且判断是否已下载的策略没变的漫画直接使用缓存
+pub fn build(app: &AppHandle) -> anyhow::Result<LibraryIndex> {
+    let (download_dir, cache_dir, strategy) = {
+        let config = app.state::<RwLock<Config>>();
+        let config = config.read();
+        (
+            config.download_dir.clone(),
+            config.cache_dir.clone(),
+            config.downloaded_check_strategy,
+        )
+    };
+    let cache_path = cache_dir.join(CACHE_FILENAME);
+    // 缓存读取失败只是这次全部重新扫描
+    let cache = load_cache(&cache_path).unwrap_or_default();
+
+    let comic_dirs = std::fs::read_dir(&download_dir)
+        .context(format!("读取下载目录`{download_dir:?}`失败"))?
+        .filter_map(Result::ok)
+        .map(|entry| entry.path())
+        .filter(|path| path.join("元数据.json").is_file())
+        .collect::<Vec<_>>();
+
+    let results = comic_dirs
+        .into_par_iter()
+        .map(|comic_dir| {
+            // 用完整路径作为key，换了下载目录后不会误用其他目录的缓存
+            let dir_name = comic_dir.to_string_lossy().to_string();
+            let fingerprint = get_fingerprint(&comic_dir);
+            if let Some(cached) = cache.get(&dir_name) {
+                if cached.fingerprint == fingerprint && cached.strategy == strategy {
+                    return (dir_name, Ok(cached.clone()));
+                }
+            }
+            let result = create_entry(app, &comic_dir).map(|entry| CachedEntry {
+                fingerprint,
+                strategy,
+                entry,
+            });
+            (dir_name, result)
+        })
+        .collect::<Vec<_>>();
+
+    let mut index = LibraryIndex::default();
+    let mut new_cache = HashMap::new();
+    for (dir_name, result) in results {
+        match result {
+            Ok(cached) => {
+                index.comics.push(cached.entry.clone());
+                new_cache.insert(dir_name, cached);
+            }
+            Err(err) => index
+                .errors
+                .push(format!("读取`{dir_name}`失败: {}", err.to_string_chain())),
+        }
+    }
+    index
+        .comics
+        .sort_by(|a, b| b.modified_at.cmp(&a.modified_at));
+
+    if let Err(err) = save_cache(&cache_path, &new_cache) {
+        index
+            .errors
+            .push(format!("更新书架索引缓存失败: {}", err.to_string_chain()));
+    }
+    Ok(index)
+}
+
+/// 用索引缓存找到漫画`comic_id`在下载目录中的目录，漫画不存在时返回None
+///
+/// 缓存中没有或者目录已经不存在时重新`build`一次，所以刚下载的漫画也能找到
+pub fn find_comic_dir(app: &AppHandle, comic_id: i64) -> anyhow::Result<Option<PathBuf>> {
+    let (download_dir, cache_dir) = {
+        let config = app.state::<RwLock<Config>>();
+        let config = config.read();
+        (config.download_dir.clone(), config.cache_dir.clone())
+    };
+    let cache_path = cache_dir.join(CACHE_FILENAME);
+    let find_in_cache = || {
+        load_cache(&cache_path)
+            .unwrap_or_default()
+            .into_iter()
+            .filter(|(_, cached)| cached.entry.id == comic_id)
+            .map(|(dir_name, _)| PathBuf::from(dir_name))
+            .find(|comic_dir| {
+                comic_dir.starts_with(&download_dir) && comic_dir.join("元数据.json").is_file()
+            })
+    };
+    if let Some(comic_dir) = find_in_cache() {
+        return Ok(Some(comic_dir));
+    }
+    build(app)?;
+    Ok(find_in_cache())
+}
+
+fn create_entry(app: &AppHandle, comic_dir: &Path) -> anyhow::Result<LibraryEntry> {
+    let metadata_path = comic_dir.join("元数据.json");
+    let comic = Comic::from_metadata(app, &metadata_path)?;
+    let chapters = comic.groups.values().flatten();
+    let chapter_count = u32::try_from(chapters.clone().count()).unwrap_or(u32::MAX);
+    let downloaded_count = chapters
+        .filter(|chapter_info| chapter_info.is_downloaded == Some(true))
+        .count();
+    let cover_path = IMAGE_EXTENSIONS
+        .iter()
+        .map(|extension| comic_dir.join(COVER_FILENAME).with_extension(extension))
+        .find(|path| path.is_file());
+    Ok(LibraryEntry {
+        id: comic.id,
+        title: comic.title,
+        cover_path,
+        chapter_count,
+        downloaded_count: u32::try_from(downloaded_count).unwrap_or(u32::MAX),
+        update_time: comic.update_time,
+        modified_at: get_modified_at(comic_dir),
+    })
+}
+
+/// 漫画目录、元数据文件以及两层以内子目录的最新修改时间(纳秒)
+///
+/// 新下载的章节目录位于漫画目录或章节组目录下，下载完成后这两层目录之一的修改时间会变
+fn get_fingerprint(comic_dir: &Path) -> u64 {
+    let mut paths = vec![comic_dir.to_path_buf(), comic_dir.join("元数据.json")];
+    for sub_dir in sub_dirs(comic_dir) {
+        paths.extend(sub_dirs(&sub_dir));
+        paths.push(sub_dir);
+    }
+    paths
+        .iter()
+        .filter_map(|path| path.metadata().ok()?.modified().ok())
+        .filter_map(|modified| modified.duration_since(UNIX_EPOCH).ok())
+        .map(|duration| u64::try_from(duration.as_nanos()).unwrap_or(u64::MAX))
+        .max()
+        .unwrap_or_default()
+}
+
+fn get_modified_at(comic_dir: &Path) -> i64 {
+    comic_dir
+        .metadata()
+        .and_then(|metadata| metadata.modified())
+        .ok()
+        .and_then(|modified| modified.duration_since(UNIX_EPOCH).ok())
+        .map(|duration| i64::try_from(duration.as_secs()).unwrap_or(i64::MAX))
+        .unwrap_or_default()
+}
+
+fn load_cache(cache_path: &Path) -> anyhow::Result<HashMap<String, CachedEntry>> {
+    if !cache_path.exists() {
+        return Ok(HashMap::new());
+    }
+    let cache_json =
+        std::fs::read_to_string(cache_path).context(format!("读取`{cache_path:?}`失败"))?;
+    let cache = serde_json::from_str::<HashMap<String, CachedEntry>>(&cache_json)
+        .context(format!("将`{cache_path:?}`反序列化为书架索引失败"))?;
+    Ok(cache)
+}
+
+fn save_cache(cache_path: &Path, cache: &HashMap<String, CachedEntry>) -> anyhow::Result<()> {
+    let cache_json = serde_json::to_string_pretty(cache).context("将书架索引序列化为json失败")?;
+    write_atomic(cache_path, cache_json)
+}
@@ -0,0 +1,71 @@
+use std::{
+    collections::HashMap,
+    hash::{DefaultHasher, Hash, Hasher},
+    sync::LazyLock,
+    time::{Duration, Instant},
+};
+
+use anyhow::Context;
+use parking_lot::Mutex;
+
+/// 解压缩结果在缓存中保留多久
+const CACHE_TTL: Duration = Duration::from_secs(10 * 60);
+/// 最多缓存多少条，超过时先清掉过期的，还不够就清掉最早的
+const MAX_CACHE_ENTRIES: usize = 64;
+
+struct CachedEntry {
+    /// 压缩数据的长度，与hash一起比较，进一步避免hash碰撞
+    compressed_len: usize,
+    decompressed: String,
+    cached_at: Instant,
+}
+
+/// key为压缩数据的hash
+static CACHE: LazyLock<Mutex<HashMap<u64, CachedEntry>>> =
+    LazyLock::new(|| Mutex::new(HashMap::new()));
+
+/// 解压缩lzstring的base64数据，并把结果解码为字符串
+///
+/// 带警告的漫画每次打开都要解压`__VIEWSTATE`，解析一次详情页还会解压好几次，所以短期缓存解压结果。
+/// 以压缩数据本身为key，网站上的数据变了key也会跟着变，不会用到过时的结果，
+/// 漫画缓存更新时也不需要另外清理
+pub fn decompress_from_base64(compressed_data: &str) -> anyhow::Result<String> {
+    let key = {
+        let mut hasher = DefaultHasher::new();
+        compressed_data.hash(&mut hasher);
+        hasher.finish()
+    };
+    if let Some(entry) = CACHE.lock().get(&key) {
+        if entry.compressed_len == compressed_data.len() && entry.cached_at.elapsed() < CACHE_TTL {
+            return Ok(entry.decompressed.clone());
+        }
+    }
+
+    let decompressed_data =
+        lz_str::decompress_from_base64(compressed_data).context("lzstring解压缩失败")?;
+    let decompressed =
+        String::from_utf16(&decompressed_data).context("lzstring解压缩后的数据不是utf-16字符串")?;
+
+    let mut cache = CACHE.lock();
+    if cache.len() >= MAX_CACHE_ENTRIES {
+        cache.retain(|_, entry| entry.cached_at.elapsed() < CACHE_TTL);
+    }
+    if cache.len() >= MAX_CACHE_ENTRIES {
+        let oldest_key = cache
+            .iter()
+            .min_by_key(|(_, entry)| entry.cached_at)
+            .map(|(key, _)| *key);
+        if let Some(oldest_key) = oldest_key {
+            cache.remove(&oldest_key);
+        }
+    }
+    cache.insert(
+        key,
+        CachedEntry {
+            compressed_len: compressed_data.len(),
+            decompressed: decompressed.clone(),
+            cached_at: Instant::now(),
+        },
+    );
+    Ok(decompressed)
+}
@@ -0,0 +1,147 @@
+use std::{
+    collections::{HashMap, VecDeque},
+    sync::Arc,
+    time::{Duration, Instant},
+};
+
+use http::Extensions;
+use parking_lot::Mutex;
+use reqwest::{Request, Response, StatusCode};
+use reqwest_middleware::{Middleware, Next};
+use serde::{Deserialize, Serialize};
+use specta::Type;
+
+/// 计算最近成功率时参考的请求数
+const RECENT_WINDOW: usize = 100;
+
+/// 按host采集请求指标的中间件
+///
+/// 挂在重试之后，所以每次重试都会被单独统计，克隆 `RequestMetrics` 只是增加引用计数，
+/// 所有克隆副本共享同一份指标
+#[derive(Clone, Default)]
+pub struct RequestMetrics {
+    inner: Arc<Mutex<MetricsInner>>,
+}
+
+#[derive(Default)]
+struct MetricsInner {
+    hosts: HashMap<String, HostCounter>,
+    /// 最近`RECENT_WINDOW`个请求是否成功，不区分host
+    recent: VecDeque<bool>,
+}
+
+#[derive(Default)]
+struct HostCounter {
+    total: u64,
+    success: u64,
+    forbidden: u64,
+    retries: u64,
+    total_latency: Duration,
+}
+
+/// 在同一次请求的多次尝试间共享，有它说明这次是重试
+#[derive(Clone)]
+struct Attempted;
+
+/// 某个host的请求指标
+#[derive(Debug, Clone, Serialize, Deserialize, Type)]
+#[serde(rename_all = "camelCase")]
+pub struct HostMetrics {
+    pub host: String,
+    /// 请求总数，每次重试都算一次
+    pub total: u64,
+    /// 状态码为2xx的请求数
+    pub success: u64,
+    /// 状态码为403的请求数，通常说明被风控了
+    pub forbidden: u64,
+    /// 重试次数
+    pub retries: u64,
+    /// 平均延迟(毫秒)，从发出请求到收到响应头，不包括读取响应体的时间
+    pub avg_latency_ms: u64,
+}
+
+/// 请求指标的快照
+#[derive(Debug, Clone, Serialize, Deserialize, Type)]
+#[serde(rename_all = "camelCase")]
+pub struct MetricsSnapshot {
+    /// 按请求总数从多到少排列
+    pub hosts: Vec<HostMetrics>,
+    /// 最近100个请求的成功率(0~1)，还没有发出过请求时为None
+    pub recent_success_rate: Option<f64>,
+}
+
+impl RequestMetrics {
+    #[allow(clippy::cast_possible_truncation, clippy::cast_precision_loss)]
+    pub fn snapshot(&self) -> MetricsSnapshot {
+        let inner = self.inner.lock();
+        let mut hosts = inner
+            .hosts
+            .iter()
+            .map(|(host, counter)| HostMetrics {
+                host: host.clone(),
+                total: counter.total,
+                success: counter.success,
+                forbidden: counter.forbidden,
+                retries: counter.retries,
+                avg_latency_ms: (counter.total_latency.as_millis()
+                    / u128::from(counter.total.max(1))) as u64,
+            })
+            .collect::<Vec<_>>();
+        hosts.sort_by(|a, b| b.total.cmp(&a.total).then_with(|| a.host.cmp(&b.host)));
+
+        let recent_success_rate = if inner.recent.is_empty() {
+            None
+        } else {
+            let success = inner.recent.iter().filter(|success| **success).count();
+            Some(success as f64 / inner.recent.len() as f64)
+        };
+
+        MetricsSnapshot {
+            hosts,
+            recent_success_rate,
+        }
+    }
+
+    fn record(&self, host: &str, status: Option<StatusCode>, latency: Duration, is_retry: bool) {
+        let success = status.is_some_and(|status| status.is_success());
+        let mut inner = self.inner.lock();
+        let counter = inner.hosts.entry(host.to_string()).or_default();
+        counter.total += 1;
+        counter.total_latency += latency;
+        if success {
+            counter.success += 1;
+        }
+        if status == Some(StatusCode::FORBIDDEN) {
+            counter.forbidden += 1;
+        }
+        if is_retry {
+            counter.retries += 1;
+        }
+
+        if inner.recent.len() == RECENT_WINDOW {
+            inner.recent.pop_front();
+        }
+        inner.recent.push_back(success);
+    }
+}
+
+#[async_trait::async_trait]
+impl Middleware for RequestMetrics {
+    async fn handle(
+        &self,
+        req: Request,
+        extensions: &mut Extensions,
+        next: Next<'_>,
+    ) -> reqwest_middleware::Result<Response> {
+        let Some(host) = req.url().host_str().map(ToString::to_string) else {
+            return next.run(req, extensions).await;
+        };
+        // 重试中间件在多次尝试间复用同一个extensions
+        let is_retry = extensions.insert(Attempted).is_some();
+        let start = Instant::now();
+        let result = next.run(req, extensions).await;
+        let status = result.as_ref().ok().map(Response::status);
+        self.record(&host, status, start.elapsed(), is_retry);
+        result
+    }
+}
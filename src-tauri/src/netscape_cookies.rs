@@ -0,0 +1,107 @@
+use anyhow::{anyhow, Context};
+
+/// 浏览器扩展导出的cookies.txt中以这个前缀开头的行不是注释，而是带HttpOnly标记的cookie
+const HTTP_ONLY_PREFIX: &str = "#HttpOnly_";
+
+/// 导出时使用的域名，漫画柜的cookie对所有子域名都有效
+const EXPORT_DOMAIN: &str = ".manhuagui.com";
+
+/// 请求头`cookie`中不属于cookie本身的属性名，登录时保存的是`set-cookie`字段，可能带有这些属性
+const COOKIE_ATTRIBUTES: [&str; 7] = [
+    "expires", "max-age", "domain", "path", "secure", "httponly", "samesite",
+];
+
+/// Netscape格式的cookies.txt中的一条cookie
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct NetscapeCookie {
+    pub domain: String,
+    /// 过期时间的unix时间戳(秒)，0表示会话cookie
+    pub expires: i64,
+    pub name: String,
+    pub value: String,
+}
+
+/// 解析Netscape格式的cookies.txt，只保留漫画柜域名下没有过期的cookie
+///
+/// 每行是用tab分隔的7个字段：域名、是否包含子域名、路径、是否仅https、过期时间、名称、值，
+/// 以`#`开头的行是注释，以`#HttpOnly_`开头的行是带HttpOnly标记的cookie
+pub fn parse(content: &str, now_secs: i64) -> anyhow::Result<Vec<NetscapeCookie>> {
+    let mut cookies = Vec::new();
+    for (i, line) in content.lines().enumerate() {
+        let line = line.trim_end_matches('\r');
+        // 请求时不区分是否HttpOnly，去掉前缀后按普通cookie处理
+        let line = line.strip_prefix(HTTP_ONLY_PREFIX).unwrap_or(line);
+        if line.trim().is_empty() || line.starts_with('#') {
+            continue;
+        }
+
+        let fields = line.split('\t').collect::<Vec<_>>();
+        let [domain, _include_subdomains, _path, _secure, expires, name, value] = fields[..] else {
+            return Err(anyhow!(
+                "第{}行有{}个字段，不是Netscape格式的cookie: {line}",
+                i + 1,
+                fields.len()
+            ));
+        };
+        let expires = expires
+            .parse::<i64>()
+            .context(format!("第{}行的过期时间`{expires}`不是整数", i + 1))?;
+
+        let cookie = NetscapeCookie {
+            domain: domain.to_string(),
+            expires,
+            name: name.to_string(),
+            value: value.to_string(),
+        };
+        let is_expired = cookie.expires != 0 && cookie.expires <= now_secs;
+        if !is_expired && is_manhuagui_domain(&cookie.domain) {
+            cookies.push(cookie);
+        }
+    }
+    Ok(cookies)
+}
+
+/// `domain`是否为`manhuagui.com`或它的子域名，`evilmanhuagui.com`这种只是后缀相同的不算
+fn is_manhuagui_domain(domain: &str) -> bool {
+    let domain = domain.trim_start_matches('.').to_ascii_lowercase();
+    domain == "manhuagui.com" || domain.ends_with(".manhuagui.com")
+}
+
+/// 把cookie拼成请求头`cookie`的值，同名cookie以后出现的为准
+pub fn to_cookie_header(cookies: &[NetscapeCookie]) -> String {
+    let mut pairs: Vec<(&str, &str)> = Vec::new();
+    for cookie in cookies {
+        pairs.retain(|(name, _)| *name != cookie.name);
+        pairs.push((&cookie.name, &cookie.value));
+    }
+    pairs
+        .iter()
+        .map(|(name, value)| format!("{name}={value}"))
+        .collect::<Vec<_>>()
+        .join("; ")
+}
+
+/// 把配置中的cookie转换为Netscape格式，导出的cookie都是会话cookie
+///
+/// 配置中的cookie可能是登录时保存的`set-cookie`字段，其中的`path`、`expires`等属性会被忽略
+pub fn from_cookie_header(cookie_header: &str) -> String {
+    let mut lines = vec![
+        "# Netscape HTTP Cookie File".to_string(),
+        "# 由漫画柜下载器导出".to_string(),
+        String::new(),
+    ];
+    for pair in cookie_header.split(';') {
+        let Some((name, value)) = pair.split_once('=') else {
+            continue;
+        };
+        let name = name.trim();
+        if name.is_empty() || COOKIE_ATTRIBUTES.contains(&name.to_lowercase().as_str()) {
+            continue;
+        }
+        let value = value.trim();
+        lines.push(format!(
+            "{EXPORT_DOMAIN}\tTRUE\t/\tFALSE\t0\t{name}\t{value}"
+        ));
+    }
+    lines.join("\n") + "\n"
+}
@@ -0,0 +1,193 @@
+use std::{io::Cursor, path::PathBuf};
+
+use anyhow::Context;
+use tauri::AppHandle;
+use zip::ZipWriter;
+
+use crate::{
+    download_manager::COVER_FILENAME,
+    export::{escape_xml, write_chapter_pages},
+    image_format::IMAGE_EXTENSIONS,
+    library_index,
+    types::{ChapterInfo, Comic},
+};
+
+/// 导航feed的`Content-Type`，条目链接到下一级目录
+pub const NAVIGATION_FEED_TYPE: &str = "application/atom+xml;profile=opds-catalog;kind=navigation";
+/// 获取feed的`Content-Type`，条目可以直接下载
+pub const ACQUISITION_FEED_TYPE: &str =
+    "application/atom+xml;profile=opds-catalog;kind=acquisition";
+/// 章节以cbz提供，大多数漫画阅读器都支持
+pub const CBZ_TYPE: &str = "application/vnd.comicbook+zip";
+
+/// 书库的根目录，每本已下载的漫画是一个条目，链接到这本漫画的章节列表
+///
+/// 链接都是绝对路径，阅读器会按feed的地址补全主机和端口
+pub fn root_feed(app: &AppHandle) -> anyhow::Result<String> {
+    let index = library_index::build(app)?;
+    let entries = index
+        .comics
+        .iter()
+        .map(|comic| {
+            let id = comic.id;
+            let cover_links = if comic.cover_path.is_some() {
+                format!(
+                    r#"
+    <link rel="http://opds-spec.org/image" href="/opds/cover/{id}"/>
+    <link rel="http://opds-spec.org/image/thumbnail" href="/opds/cover/{id}"/>"#
+                )
+            } else {
+                String::new()
+            };
+            format!(
+                r#"  <entry>
+    <title>{title}</title>
+    <id>urn:manhuagui:comic:{id}</id>
+    <updated>{updated}</updated>
+    <content type="text">已下载{downloaded}/{total}话，最后更新于{update_time}</content>
+    <link rel="subsection" href="/opds/comic/{id}" type="{ACQUISITION_FEED_TYPE}"/>{cover_links}
+  </entry>
+"#,
+                title = escape_xml(&comic.title),
+                updated = rfc3339_from_timestamp(comic.modified_at),
+                downloaded = comic.downloaded_count,
+                total = comic.chapter_count,
+                update_time = escape_xml(&comic.update_time),
+            )
+        })
+        .collect::<String>();
+    Ok(create_feed(
+        "urn:manhuagui-downloader:root",
+        "漫画柜下载器",
+        "/opds",
+        NAVIGATION_FEED_TYPE,
+        &entries,
+    ))
+}
+
+/// 漫画`comic_id`已下载的章节，按章节组和章节顺序排列，每一话可以作为cbz下载
+pub fn comic_feed(app: &AppHandle, comic_id: i64) -> anyhow::Result<Option<String>> {
+    let Some((_, comic)) = find_comic(app, comic_id)? else {
+        return Ok(None);
+    };
+    let authors = comic
+        .authors
+        .iter()
+        .map(|author| format!("\n    <author><name>{}</name></author>", escape_xml(author)))
+        .collect::<String>();
+    let updated = chrono::Local::now().to_rfc3339();
+    let entries = downloaded_chapters(&comic)
+        .iter()
+        .map(|chapter_info| {
+            let chapter_id = chapter_info.chapter_id;
+            format!(
+                r#"  <entry>
+    <title>{group_name} - {chapter_title}</title>
+    <id>urn:manhuagui:chapter:{chapter_id}</id>
+    <updated>{updated}</updated>{authors}
+    <content type="text">{chapter_size}页</content>
+    <link rel="http://opds-spec.org/acquisition" href="/opds/chapter/{comic_id}/{chapter_id}.cbz" type="{CBZ_TYPE}"/>
+  </entry>
+"#,
+                group_name = escape_xml(&chapter_info.group_name),
+                chapter_title = escape_xml(&chapter_info.prefixed_chapter_title),
+                chapter_size = chapter_info.chapter_size,
+            )
+        })
+        .collect::<String>();
+    Ok(Some(create_feed(
+        &format!("urn:manhuagui:comic:{comic_id}"),
+        &comic.title,
+        &format!("/opds/comic/{comic_id}"),
+        ACQUISITION_FEED_TYPE,
+        &entries,
+    )))
+}
+
+/// 漫画`comic_id`本地封面的路径，漫画不存在或者还没下载封面时返回None
+pub fn cover_path(app: &AppHandle, comic_id: i64) -> anyhow::Result<Option<PathBuf>> {
+    let Some(comic_dir) = library_index::find_comic_dir(app, comic_id)? else {
+        return Ok(None);
+    };
+    let cover_path = IMAGE_EXTENSIONS
+        .iter()
+        .map(|extension| comic_dir.join(COVER_FILENAME).with_extension(extension))
+        .find(|path| path.is_file());
+    Ok(cover_path)
+}
+
+/// 把章节在内存中打包成cbz，章节不存在或者还没下载时返回None
+pub fn chapter_cbz(
+    app: &AppHandle,
+    comic_id: i64,
+    chapter_id: i64,
+) -> anyhow::Result<Option<Vec<u8>>> {
+    let Some((comic_dir, comic)) = find_comic(app, comic_id)? else {
+        return Ok(None);
+    };
+    let Some(chapter_info) = downloaded_chapters(&comic)
+        .into_iter()
+        .find(|chapter_info| chapter_info.chapter_id == chapter_id)
+    else {
+        return Ok(None);
+    };
+    let chapter_download_dir = comic_dir.join(chapter_info.dir_in_comic());
+    let mut zip_writer = ZipWriter::new(Cursor::new(Vec::new()));
+    write_chapter_pages(
+        app,
+        &mut zip_writer,
+        &chapter_info,
+        &chapter_download_dir,
+        "",
+    )
+    .context(format!("打包`{chapter_download_dir:?}`失败"))?;
+    let cursor = zip_writer.finish().context("完成cbz打包失败")?;
+    Ok(Some(cursor.into_inner()))
+}
+
+fn create_feed(id: &str, title: &str, self_href: &str, feed_type: &str, entries: &str) -> String {
+    let updated = chrono::Local::now().to_rfc3339();
+    let title = escape_xml(title);
+    format!(
+        r#"<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom" xmlns:opds="http://opds-spec.org/2010/catalog">
+  <id>{id}</id>
+  <title>{title}</title>
+  <updated>{updated}</updated>
+  <link rel="self" href="{self_href}" type="{feed_type}"/>
+  <link rel="start" href="/opds" type="{NAVIGATION_FEED_TYPE}"/>
+{entries}</feed>
+"#
+    )
+}
+
+/// 通过书架索引找到漫画`comic_id`的目录，再读取它的元数据
+fn find_comic(app: &AppHandle, comic_id: i64) -> anyhow::Result<Option<(PathBuf, Comic)>> {
+    let Some(comic_dir) = library_index::find_comic_dir(app, comic_id)? else {
+        return Ok(None);
+    };
+    let comic = Comic::from_metadata(app, &comic_dir.join("元数据.json"))?;
+    Ok(Some((comic_dir, comic)))
+}
+
+fn downloaded_chapters(comic: &Comic) -> Vec<ChapterInfo> {
+    let mut chapters = comic
+        .groups
+        .values()
+        .flatten()
+        .filter(|chapter_info| chapter_info.is_downloaded == Some(true))
+        .cloned()
+        .collect::<Vec<_>>();
+    chapters.sort_by(|a, b| {
+        a.group_name
+            .cmp(&b.group_name)
+            .then(a.order.total_cmp(&b.order))
+    });
+    chapters
+}
+
+fn rfc3339_from_timestamp(timestamp: i64) -> String {
+    chrono::DateTime::from_timestamp(timestamp, 0)
+        .unwrap_or_default()
+        .to_rfc3339()
+}
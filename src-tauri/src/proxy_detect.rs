@@ -0,0 +1,95 @@
+use std::time::{Duration, Instant};
+
+use anyhow::{anyhow, Context};
+use serde::{Deserialize, Serialize};
+use specta::Type;
+use tokio::{net::TcpStream, task::JoinSet};
+
+/// 常见代理软件默认监听的本地端口
+const COMMON_PROXY_PORTS: [(u16, &str, &str); 7] = [
+    (7890, "Clash", "http"),
+    (7897, "Clash Verge", "http"),
+    (10809, "v2rayN", "http"),
+    (10808, "v2rayN", "socks5"),
+    (1080, "Shadowsocks", "socks5"),
+    (1081, "Shadowsocks", "http"),
+    (8889, "Qv2ray", "http"),
+];
+/// 端口是否在监听，本地连接应该很快，超过这个时间就认为没有监听
+const PORT_CONNECT_TIMEOUT: Duration = Duration::from_millis(300);
+/// 通过代理访问漫画柜的超时时间
+const PROXY_TEST_TIMEOUT: Duration = Duration::from_secs(5);
+
+/// 能连通漫画柜的本地代理
+#[derive(Debug, Clone, Serialize, Deserialize, Type)]
+#[serde(rename_all = "camelCase")]
+pub struct ProxyCandidate {
+    /// 代理地址，可以直接填到配置的`proxy`里
+    pub url: String,
+    /// 这个端口通常属于哪个代理软件，只是根据默认端口猜的
+    pub software: String,
+    /// 通过这个代理访问漫画柜首页的耗时(毫秒)
+    pub latency_ms: u32,
+}
+
+/// 扫描常见的本地代理端口，返回能连通漫画柜的代理，按耗时从低到高排序
+pub async fn auto_detect_proxy() -> anyhow::Result<Vec<ProxyCandidate>> {
+    let mut join_set = JoinSet::new();
+    for (port, software, scheme) in COMMON_PROXY_PORTS {
+        join_set.spawn(async move {
+            // 先检查端口是否在监听，没有监听就不用再发请求了
+            let connect = TcpStream::connect(("127.0.0.1", port));
+            if !matches!(
+                tokio::time::timeout(PORT_CONNECT_TIMEOUT, connect).await,
+                Ok(Ok(_))
+            ) {
+                return Ok(None);
+            }
+            let url = format!("{scheme}://127.0.0.1:{port}");
+            let Ok(latency_ms) = test_proxy(&url).await else {
+                return Ok(None);
+            };
+            Ok::<_, anyhow::Error>(Some(ProxyCandidate {
+                url,
+                software: software.to_string(),
+                latency_ms,
+            }))
+        });
+    }
+
+    let mut candidates = Vec::new();
+    for result in join_set.join_all().await {
+        if let Some(candidate) = result? {
+            candidates.push(candidate);
+        }
+    }
+    candidates.sort_by_key(|candidate| candidate.latency_ms);
+    Ok(candidates)
+}
+
+/// 通过代理`url`访问漫画柜首页，返回耗时(毫秒)
+#[allow(clippy::cast_possible_truncation)]
+async fn test_proxy(url: &str) -> anyhow::Result<u32> {
+    let proxy = reqwest::Proxy::all(url).context(format!("`{url}`不是合法的代理地址"))?;
+    let client = reqwest::ClientBuilder::new()
+        .proxy(proxy)
+        .timeout(PROXY_TEST_TIMEOUT)
+        .build()
+        .context("创建http客户端失败")?;
+
+    let start = Instant::now();
+    let http_resp = client
+        .get("https://www.manhuagui.com/")
+        .send()
+        .await
+        .context(format!("通过代理`{url}`访问漫画柜失败"))?;
+    let latency_ms = start.elapsed().as_millis() as u32;
+
+    let status = http_resp.status();
+    if !status.is_success() {
+        return Err(anyhow!(
+            "通过代理`{url}`访问漫画柜时收到预料之外的状态码({status})"
+        ));
+    }
+    Ok(latency_ms)
+}
@@ -0,0 +1,154 @@
+use std::{
+    collections::HashMap,
+    sync::Arc,
+    time::{Duration, Instant},
+};
+
+use http::Extensions;
+use parking_lot::Mutex;
+use reqwest::{Request, Response};
+use reqwest_middleware::{Middleware, Next};
+use tokio::sync::{Semaphore, SemaphorePermit};
+
+/// 按host限制请求频率的令牌桶限速器
+///
+/// 作为中间件挂在 `ClientWithMiddleware` 上，对所有经过该client的请求透明生效。
+/// 克隆 `HostRateLimiter` 只是增加引用计数，所有克隆副本共享同一组令牌桶。
+#[derive(Clone, Default)]
+pub struct HostRateLimiter {
+    buckets: Arc<Mutex<HashMap<String, TokenBucket>>>,
+}
+
+impl HostRateLimiter {
+    /// 设置`host`每秒最多发送`qps`个请求
+    ///
+    /// `qps`小于等于0表示取消对`host`的限速
+    pub fn set_host_rate_limit(&self, host: &str, qps: f64) {
+        let mut buckets = self.buckets.lock();
+        if qps <= 0.0 {
+            buckets.remove(host);
+            return;
+        }
+        buckets.insert(host.to_string(), TokenBucket::new(qps));
+    }
+
+    /// 等待直到`host`有可用的令牌，没有设置限速的host直接返回
+    async fn acquire(&self, host: &str) {
+        let wait = match self.buckets.lock().get_mut(host) {
+            Some(bucket) => bucket.reserve(),
+            None => return,
+        };
+        if !wait.is_zero() {
+            tokio::time::sleep(wait).await;
+        }
+    }
+}
+
+#[async_trait::async_trait]
+impl Middleware for HostRateLimiter {
+    async fn handle(
+        &self,
+        req: Request,
+        extensions: &mut Extensions,
+        next: Next<'_>,
+    ) -> reqwest_middleware::Result<Response> {
+        if let Some(host) = req.url().host_str() {
+            self.acquire(host).await;
+        }
+        next.run(req, extensions).await
+    }
+}
+
+/// 限制同时进行的任务数，上限可以随时调整
+///
+/// 与`HostRateLimiter`配合使用，`HostRateLimiter`限制的是每秒发出的请求数，
+/// 这里限制的是同时在途(请求+读取响应体+解析)的任务数。
+/// 克隆 `ConcurrencyLimiter` 只是增加引用计数，所有克隆副本共享同一组许可
+#[derive(Clone)]
+pub struct ConcurrencyLimiter {
+    sem: Arc<Semaphore>,
+    limit: Arc<Mutex<usize>>,
+}
+
+impl ConcurrencyLimiter {
+    /// `limit`为0时按1处理
+    pub fn new(limit: usize) -> Self {
+        let limit = limit.max(1);
+        Self {
+            sem: Arc::new(Semaphore::new(limit)),
+            limit: Arc::new(Mutex::new(limit)),
+        }
+    }
+
+    /// 调整上限，已经拿到许可的任务不受影响，对之后的任务生效
+    pub fn set_limit(&self, limit: usize) {
+        let limit = limit.max(1);
+        let mut current = self.limit.lock();
+        if limit > *current {
+            self.sem.add_permits(limit - *current);
+        } else if limit < *current {
+            let excess = *current - limit;
+            let forgotten = self.sem.forget_permits(excess);
+            // 剩下的许可正被占用，等它们归还后再回收
+            if let Ok(rest) = u32::try_from(excess - forgotten) {
+                if rest > 0 {
+                    let sem = self.sem.clone();
+                    tauri::async_runtime::spawn(async move {
+                        if let Ok(permit) = sem.acquire_many_owned(rest).await {
+                            permit.forget();
+                        }
+                    });
+                }
+            }
+        }
+        *current = limit;
+    }
+
+    pub fn limit(&self) -> usize {
+        *self.limit.lock()
+    }
+
+    /// 等待直到有空闲的许可，许可在返回值drop时归还
+    pub async fn acquire(&self) -> SemaphorePermit<'_> {
+        // 信号量不会被close，所以不会出错
+        self.sem.acquire().await.unwrap()
+    }
+}
+
+struct TokenBucket {
+    /// 每秒生成的令牌数
+    qps: f64,
+    /// 桶的容量，也就是允许的最大突发请求数
+    capacity: f64,
+    /// 当前令牌数，为负数时表示有请求已经预约了尚未生成的令牌
+    tokens: f64,
+    /// 上次补充令牌的时间
+    last_refill: Instant,
+}
+
+impl TokenBucket {
+    fn new(qps: f64) -> Self {
+        let capacity = qps.max(1.0);
+        Self {
+            qps,
+            capacity,
+            tokens: capacity,
+            last_refill: Instant::now(),
+        }
+    }
+
+    /// 预约一个令牌，返回拿到这个令牌之前需要等待的时间
+    fn reserve(&mut self) -> Duration {
+        let now = Instant::now();
+        let elapsed = now.duration_since(self.last_refill).as_secs_f64();
+        self.tokens = (self.tokens + elapsed * self.qps).min(self.capacity);
+        self.last_refill = now;
+        // 先扣除令牌再等待，这样并发的请求会依次排在后面，而不是同时醒来争抢
+        self.tokens -= 1.0;
+        if self.tokens >= 0.0 {
+            Duration::ZERO
+        } else {
+            Duration::from_secs_f64(-self.tokens / self.qps)
+        }
+    }
+}
@@ -0,0 +1,243 @@
+use std::{
+    collections::{HashMap, VecDeque},
+    path::{Path, PathBuf},
+};
+
+use anyhow::{anyhow, Context};
+use parking_lot::{Mutex, RwLock};
+use serde::{Deserialize, Serialize};
+use specta::Type;
+use tauri::{ipc::Channel, AppHandle, Manager};
+use tokio::{sync::watch, task::JoinHandle};
+
+use crate::{
+    config::Config,
+    downloaded_checker::image_paths,
+    image_format::{self, IMAGE_EXTENSIONS},
+    manhuagui_client::ManhuaguiClient,
+    types::ChapterInfo,
+    utils::write_atomic,
+};
+
+/// `cache_dir/阅读`中最多保留多少个章节的缓存，超出时删除最早缓存的
+const CACHED_CHAPTER_LIMIT: usize = 20;
+
+/// 打开着的阅读器，key为前端生成的会话id，value为当前阅读到的页码
+///
+/// 边下载边阅读时，只下载到当前页之后的`prefetch`页，会话被关闭后停止下载
+#[derive(Default)]
+pub struct ReaderSessions {
+    positions: Mutex<HashMap<String, watch::Sender<u32>>>,
+}
+
+impl ReaderSessions {
+    fn open(&self, session_id: &str) -> watch::Receiver<u32> {
+        let (sender, receiver) = watch::channel(0);
+        self.positions.lock().insert(session_id.to_string(), sender);
+        receiver
+    }
+
+    /// 更新阅读到的页码，会话不存在时忽略
+    pub fn set_position(&self, session_id: &str, index: u32) {
+        if let Some(sender) = self.positions.lock().get(session_id) {
+            sender.send_replace(index);
+        }
+    }
+
+    /// 关闭会话，正在下载的页会被取消
+    pub fn close(&self, session_id: &str) {
+        self.positions.lock().remove(session_id);
+    }
+}
+
+/// 阅读器中的一页
+#[derive(Debug, Clone, Serialize, Deserialize, Type)]
+#[serde(rename_all = "camelCase")]
+pub struct ImagePage {
+    /// 页码，从0开始
+    pub index: u32,
+    /// 总页数
+    pub total: u32,
+    /// 图片在本地的路径，前端通过`convertFileSrc`显示
+    pub path: PathBuf,
+}
+
+/// 按页码顺序把章节的图片逐页推送给`on_page`
+///
+/// 已下载的章节直接推送下载目录中的图片，否则边下载边推送，下载的图片缓存在`cache_dir/阅读`中。
+/// 只下载到`session_id`当前阅读页之后的`prefetch`页，会话被关闭或推送失败时取消正在下载的页
+#[allow(clippy::cast_possible_truncation)]
+pub async fn stream_chapter_images(
+    app: &AppHandle,
+    session_id: &str,
+    chapter_info: &ChapterInfo,
+    prefetch: u32,
+    on_page: &Channel<ImagePage>,
+) -> anyhow::Result<()> {
+    let (download_dir, cache_dir) = {
+        let config = app.state::<RwLock<Config>>();
+        let config = config.read();
+        (config.download_dir.clone(), config.cache_dir.clone())
+    };
+
+    let chapter_download_dir = download_dir.join(chapter_info.relative_dir());
+    let local_paths = image_paths(&chapter_download_dir);
+    if !local_paths.is_empty() {
+        let total = local_paths.len() as u32;
+        for (index, path) in local_paths.into_iter().enumerate() {
+            let page = ImagePage {
+                index: index as u32,
+                total,
+                path,
+            };
+            on_page
+                .send(page)
+                .context("推送图片失败，阅读器可能已关闭")?;
+        }
+        return Ok(());
+    }
+
+    let reader_sessions = app.state::<ReaderSessions>();
+    let mut position = reader_sessions.open(session_id);
+    let result = stream_remote_images(
+        app,
+        chapter_info,
+        &cache_dir,
+        prefetch,
+        &mut position,
+        on_page,
+    )
+    .await;
+    reader_sessions.close(session_id);
+    result
+}
+
+#[allow(clippy::cast_possible_truncation)]
+async fn stream_remote_images(
+    app: &AppHandle,
+    chapter_info: &ChapterInfo,
+    cache_dir: &Path,
+    prefetch: u32,
+    position: &mut watch::Receiver<u32>,
+    on_page: &Channel<ImagePage>,
+) -> anyhow::Result<()> {
+    let manhuagui_client = app.state::<ManhuaguiClient>().inner().clone();
+    let urls = manhuagui_client
+        .get_image_urls(chapter_info)
+        .await
+        .context("获取图片链接失败")?;
+    let total = urls.len() as u32;
+    let reader_cache_dir = cache_dir.join("阅读");
+    let chapter_cache_dir = reader_cache_dir.join(chapter_info.chapter_id.to_string());
+    std::fs::create_dir_all(&chapter_cache_dir)
+        .context(format!("创建目录`{chapter_cache_dir:?}`失败"))?;
+    // 清理失败只是多占一些空间，不影响阅读
+    let _ = evict_cached_chapters(&reader_cache_dir, &chapter_cache_dir);
+
+    let mut next_index = 0;
+    let mut pending: VecDeque<(u32, JoinHandle<anyhow::Result<PathBuf>>)> = VecDeque::new();
+    loop {
+        let last_index = *position.borrow_and_update() as usize + prefetch as usize;
+        while next_index < urls.len() && next_index <= last_index {
+            let manhuagui_client = manhuagui_client.clone();
+            let url = urls[next_index].clone();
+            let save_path = chapter_cache_dir.join(format!("{:03}", next_index + 1));
+            let page_num = next_index + 1;
+            let handle = tokio::spawn(async move {
+                fetch_page(&manhuagui_client, &url, &save_path)
+                    .await
+                    .context(format!("下载第{page_num}页失败"))
+            });
+            pending.push_back((next_index as u32, handle));
+            next_index += 1;
+        }
+
+        let mut session_closed = false;
+        let join_result = match pending.front_mut() {
+            Some((_, handle)) => tokio::select! {
+                join_result = handle => Some(join_result),
+                changed = position.changed() => {
+                    session_closed = changed.is_err();
+                    None
+                }
+            },
+            // 预取的页都推送完了，等用户往后翻
+            None if next_index < urls.len() => {
+                session_closed = position.changed().await.is_err();
+                None
+            }
+            None => break,
+        };
+        if session_closed {
+            pending.iter().for_each(|(_, handle)| handle.abort());
+            return Ok(());
+        }
+        // 阅读位置变了，先按新的位置补充要下载的页
+        let Some(join_result) = join_result else {
+            continue;
+        };
+        let Some((index, _)) = pending.pop_front() else {
+            break;
+        };
+        let result = match join_result {
+            Ok(result) => result,
+            Err(err) => Err(anyhow!(err).context("下载任务异常退出")),
+        };
+        let sent = result.and_then(|path| {
+            let page = ImagePage { index, total, path };
+            on_page.send(page).context("推送图片失败，阅读器可能已关闭")
+        });
+        if let Err(err) = sent {
+            pending.iter().for_each(|(_, handle)| handle.abort());
+            return Err(err);
+        }
+    }
+
+    Ok(())
+}
+
+/// 按修改时间删除`reader_cache_dir`中最早缓存的章节，只保留`CACHED_CHAPTER_LIMIT`个，`current`不会被删除
+fn evict_cached_chapters(reader_cache_dir: &Path, current: &Path) -> anyhow::Result<()> {
+    let mut chapter_dirs = std::fs::read_dir(reader_cache_dir)
+        .context(format!("读取目录`{reader_cache_dir:?}`失败"))?
+        .filter_map(Result::ok)
+        .map(|entry| entry.path())
+        .filter(|path| path.is_dir() && path != current)
+        .map(|path| {
+            let modified = path
+                .metadata()
+                .and_then(|metadata| metadata.modified())
+                .ok();
+            (modified, path)
+        })
+        .collect::<Vec<_>>();
+    // 算上当前章节，超出上限的部分从最旧的开始删除
+    let excess = (chapter_dirs.len() + 1).saturating_sub(CACHED_CHAPTER_LIMIT);
+    chapter_dirs.sort();
+    for (_, path) in chapter_dirs.into_iter().take(excess) {
+        std::fs::remove_dir_all(&path).context(format!("删除目录`{path:?}`失败"))?;
+    }
+    Ok(())
+}
+
+/// 下载图片并保存到`save_path`，扩展名按图片的实际格式决定，已缓存时直接返回缓存的路径
+async fn fetch_page(
+    manhuagui_client: &ManhuaguiClient,
+    url: &str,
+    save_path: &Path,
+) -> anyhow::Result<PathBuf> {
+    if let Some(cached_path) = IMAGE_EXTENSIONS
+        .iter()
+        .map(|extension| save_path.with_extension(extension))
+        .find(|path| path.exists())
+    {
+        return Ok(cached_path);
+    }
+    let image_data = manhuagui_client.get_image_bytes(url).await?;
+    let image_info =
+        image_format::inspect_image(&image_data).context("下载到的数据不是完整的图片")?;
+    let save_path = save_path.with_extension(image_info.extension());
+    // 保证缓存的图片一定是完整的
+    write_atomic(&save_path, &image_data)?;
+    Ok(save_path)
+}
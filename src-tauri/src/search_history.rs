@@ -0,0 +1,78 @@
+use std::path::PathBuf;
+
+use anyhow::Context;
+use parking_lot::RwLock;
+use tauri::{AppHandle, Manager};
+
+use crate::{config::Config, utils::write_atomic};
+
+/// 搜索历史文件的文件名，位于`cache_dir`下
+const HISTORY_FILENAME: &str = "search_history.json";
+
+/// 全局的搜索历史，最近使用的关键词排在前面，持久化到`cache_dir/search_history.json`
+pub struct SearchHistory {
+    app: AppHandle,
+    keywords: RwLock<Vec<String>>,
+}
+
+impl SearchHistory {
+    pub fn new(app: &AppHandle) -> Self {
+        // 历史文件损坏时不应该影响软件启动，直接当作没有历史
+        let keywords = load_keywords(app).unwrap_or_default();
+        Self {
+            app: app.clone(),
+            keywords: RwLock::new(keywords),
+        }
+    }
+
+    /// 记录`keyword`，已存在时移到最前面，超出上限的最旧记录会被丢弃
+    pub fn record(&self, keyword: &str) -> anyhow::Result<()> {
+        let keyword = keyword.trim();
+        if keyword.is_empty() {
+            return Ok(());
+        }
+        let limit = self
+            .app
+            .state::<RwLock<Config>>()
+            .read()
+            .search_history_limit as usize;
+        let mut keywords = self.keywords.write();
+        keywords.retain(|k| k != keyword);
+        keywords.insert(0, keyword.to_string());
+        keywords.truncate(limit);
+        save_keywords(&self.app, &keywords)
+    }
+
+    pub fn list(&self) -> Vec<String> {
+        self.keywords.read().clone()
+    }
+
+    pub fn clear(&self) -> anyhow::Result<()> {
+        let mut keywords = self.keywords.write();
+        keywords.clear();
+        save_keywords(&self.app, &keywords)
+    }
+}
+
+fn get_history_path(app: &AppHandle) -> PathBuf {
+    let cache_dir = app.state::<RwLock<Config>>().read().cache_dir.clone();
+    cache_dir.join(HISTORY_FILENAME)
+}
+
+fn load_keywords(app: &AppHandle) -> anyhow::Result<Vec<String>> {
+    let history_path = get_history_path(app);
+    if !history_path.exists() {
+        return Ok(Vec::new());
+    }
+    let history_string =
+        std::fs::read_to_string(&history_path).context(format!("读取`{history_path:?}`失败"))?;
+    let keywords = serde_json::from_str::<Vec<String>>(&history_string)
+        .context(format!("将`{history_path:?}`反序列化为搜索历史失败"))?;
+    Ok(keywords)
+}
+
+fn save_keywords(app: &AppHandle, keywords: &[String]) -> anyhow::Result<()> {
+    let history_json =
+        serde_json::to_string_pretty(keywords).context("将搜索历史序列化为json失败")?;
+    write_atomic(&get_history_path(app), history_json)
+}
@@ -0,0 +1,87 @@
+use serde::{Deserialize, Serialize};
+use specta::Type;
+
+/// 解析规则的版本，每次为适配网站改版修改选择器时加一，反馈网站结构变更时附带这个版本号
+pub const PARSE_RULES_VERSION: u32 = 1;
+
+/// 漫画柜的站点
+#[derive(Default, Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize, Type)]
+pub enum Site {
+    /// 简体站`www.manhuagui.com`
+    #[default]
+    Www,
+    /// 繁体站`tw.manhuagui.com`
+    Tw,
+}
+
+impl Site {
+    pub fn host(self) -> &'static str {
+        match self {
+            Site::Www => "www.manhuagui.com",
+            Site::Tw => "tw.manhuagui.com",
+        }
+    }
+
+    /// 站点的根地址，不以`/`结尾，例如`https://www.manhuagui.com`
+    pub fn base_url(self) -> String {
+        format!("https://{}", self.host())
+    }
+
+    /// 解析漫画详情页时使用的选择器
+    ///
+    /// 繁体站详情页的结构和class与简体站相同，目前共用一套选择器，哪天繁体站单独改版时再在这里区分
+    pub fn selectors(self) -> &'static PageSelectors {
+        match self {
+            Site::Www | Site::Tw => &WWW_SELECTORS,
+        }
+    }
+}
+
+/// 漫画详情页中各部分的选择器
+pub struct PageSelectors {
+    /// 漫画详情
+    pub book_detail: &'static str,
+    /// 面包屑导航中指向漫画自己的链接，用来获取漫画id
+    pub comic_link: &'static str,
+    /// 标题，在`book_detail`中查找
+    pub title: &'static str,
+    /// 副标题，在`book_detail`中查找
+    pub subtitle: &'static str,
+    /// 出版年份、类型、别名、状态等信息所在的行，在`book_detail`中查找
+    pub detail_item: &'static str,
+    /// 简介
+    pub intro: &'static str,
+    /// 包含所有章节组的元素
+    pub chapter: &'static str,
+    /// 一个章节组的章节列表
+    pub chapter_list: &'static str,
+    /// 章节列表上方的分页链接
+    pub chapter_page_link: &'static str,
+}
+
+impl PageSelectors {
+    /// 解析前自检用的关键区块：标题、章节列表、章节分页以及隐藏的章节数据
+    ///
+    /// 正常的详情页至少能命中其中一个，全都命中为空时说明网站结构可能已变更
+    pub fn key_blocks(&self) -> [&'static str; 5] {
+        [
+            self.title,
+            self.chapter,
+            self.chapter_list,
+            self.chapter_page_link,
+            "#__VIEWSTATE",
+        ]
+    }
+}
+
+const WWW_SELECTORS: PageSelectors = PageSelectors {
+    book_detail: ".book-detail",
+    comic_link: ".crumb > a:nth-last-child(1)",
+    title: ".book-title h1",
+    subtitle: ".book-title h2",
+    detail_item: ".detail-list > li",
+    intro: "#intro-cut",
+    chapter: ".chapter",
+    chapter_list: ".chapter-list",
+    chapter_page_link: ".chapter-page a",
+};
@@ -0,0 +1,183 @@
+use std::{
+    collections::BTreeMap,
+    path::{Path, PathBuf},
+    sync::LazyLock,
+};
+
+use anyhow::{anyhow, Context};
+use regex::Regex;
+use serde::{Deserialize, Serialize};
+use specta::Type;
+
+use crate::types::ChapterInfo;
+
+/// 任务清单当前的格式版本，格式有不兼容的改动时递增
+const TASK_LIST_VERSION: u32 = 1;
+
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+struct TaskList {
+    /// 任务清单的格式版本
+    version: u32,
+    tasks: Vec<DownloadTask>,
+}
+
+#[derive(Default, Debug, Clone, PartialEq, Serialize, Deserialize, Type)]
+#[serde(rename_all = "camelCase")]
+pub struct DownloadTask {
+    /// 漫画id
+    pub comic_id: i64,
+    /// 漫画标题
+    pub comic_title: String,
+    /// 导出任务清单时的下载目录
+    pub download_dir: PathBuf,
+    /// 要下载的章节
+    pub chapters: Vec<ChapterInfo>,
+}
+
+/// 把`chapters`按漫画分组，生成下载任务
+pub fn create_tasks(chapters: Vec<ChapterInfo>, download_dir: &Path) -> Vec<DownloadTask> {
+    let mut tasks = BTreeMap::new();
+    for mut chapter_info in chapters {
+        // 是否已下载与本地的下载目录有关，换了电脑就不准了，所以不导出
+        chapter_info.is_downloaded = None;
+        let task = tasks
+            .entry(chapter_info.comic_id)
+            .or_insert_with(|| DownloadTask {
+                comic_id: chapter_info.comic_id,
+                comic_title: chapter_info.comic_title.clone(),
+                download_dir: download_dir.to_path_buf(),
+                chapters: Vec::new(),
+            });
+        task.chapters.push(chapter_info);
+    }
+    tasks.into_values().collect()
+}
+
+/// 将下载任务序列化为带版本号的json
+pub fn export(tasks: Vec<DownloadTask>) -> anyhow::Result<Vec<u8>> {
+    let task_list = TaskList {
+        version: TASK_LIST_VERSION,
+        tasks,
+    };
+    let bytes = serde_json::to_vec_pretty(&task_list).context("将任务清单序列化为json失败")?;
+    Ok(bytes)
+}
+
+/// 从json中解析出下载任务
+pub fn import(bytes: &[u8]) -> anyhow::Result<Vec<DownloadTask>> {
+    let value =
+        serde_json::from_slice::<serde_json::Value>(bytes).context("任务清单不是合法的json")?;
+    // 先检查版本号，避免用旧的格式去解析新版本导出的任务清单
+    let version = value
+        .get("version")
+        .and_then(serde_json::Value::as_u64)
+        .context("任务清单中没有version字段")?;
+    if version > u64::from(TASK_LIST_VERSION) {
+        return Err(anyhow!(
+            "任务清单的版本`{version}`高于当前支持的版本`{TASK_LIST_VERSION}`，请升级软件后再导入"
+        ));
+    }
+    let task_list =
+        serde_json::from_value::<TaskList>(value).context("将json反序列化为任务清单失败")?;
+    Ok(task_list.tasks)
+}
+
+/// 漫画柜的漫画或章节链接，例如`https://www.manhuagui.com/comic/1128/`、`https://m.manhuagui.com/comic/1128/18753.html`，
+/// 以及页面中`/comic/1128/18753.html`这样的相对链接
+static MANHUAGUI_URL_RE: LazyLock<Regex> = LazyLock::new(|| {
+    Regex::new(r"(?:^|(?:manhuagui|mhgui)\.com)/comic/(\d+)(?:/(\d+)\.html)?").unwrap()
+});
+
+/// 第三方工具的任务格式
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize, Type)]
+pub enum ExternalTaskFormat {
+    /// 每行一个链接，EhViewer等工具导出的链接列表就是这种格式，行中可以带有标题等其他文本
+    UrlList,
+}
+
+/// 从第三方任务中识别出的漫画柜链接
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct ExternalEntry {
+    pub comic_id: i64,
+    /// 链接指向章节时为章节id，指向漫画时为None，表示下载整本漫画
+    pub chapter_id: Option<i64>,
+}
+
+/// 导入第三方任务的结果
+#[derive(Default, Debug, Clone, Serialize, Deserialize, Type)]
+#[serde(rename_all = "camelCase")]
+pub struct ExternalImportReport {
+    /// 已加入下载队列的任务
+    pub tasks: Vec<DownloadTask>,
+    /// 无法识别或获取失败而跳过的条目及原因
+    pub skipped: Vec<String>,
+}
+
+/// 识别漫画柜的漫画或章节链接，不是漫画柜的链接时返回None
+///
+/// 完整链接、手机版链接、相对链接以及带查询参数或`#p=2`之类页码的链接都能识别
+pub fn parse_manhuagui_url(text: &str) -> Option<ExternalEntry> {
+    let captures = MANHUAGUI_URL_RE.captures(text)?;
+    let comic_id = captures.get(1)?.as_str().parse().ok()?;
+    let chapter_id = captures
+        .get(2)
+        .and_then(|chapter_id| chapter_id.as_str().parse().ok());
+    Some(ExternalEntry {
+        comic_id,
+        chapter_id,
+    })
+}
+
+/// 按`format`解析第三方任务，返回识别出的链接和无法识别的条目
+pub fn parse_external(format: ExternalTaskFormat, text: &str) -> (Vec<ExternalEntry>, Vec<String>) {
+    let mut entries = Vec::new();
+    let mut skipped = Vec::new();
+    match format {
+        ExternalTaskFormat::UrlList => {
+            for (i, line) in text.lines().enumerate() {
+                let line = line.trim();
+                // 空行和注释不算无法识别
+                if line.is_empty() || line.starts_with('#') {
+                    continue;
+                }
+                match parse_manhuagui_url(line) {
+                    Some(entry) if !entries.contains(&entry) => entries.push(entry),
+                    Some(_) => {}
+                    None => skipped.push(format!("第{}行`{line}`不是漫画柜的链接", i + 1)),
+                }
+            }
+        }
+    }
+    (entries, skipped)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn parse(text: &str) -> Option<(i64, Option<i64>)> {
+        parse_manhuagui_url(text).map(|entry| (entry.comic_id, entry.chapter_id))
+    }
+
+    #[test]
+    fn parse_manhuagui_urls() {
+        assert_eq!(
+            parse("https://www.manhuagui.com/comic/1128/"),
+            Some((1128, None))
+        );
+        assert_eq!(
+            parse("https://m.manhuagui.com/comic/1128/18753.html"),
+            Some((1128, Some(18753)))
+        );
+        assert_eq!(
+            parse("第1话 https://tw.manhuagui.com/comic/1128/18753.html#p=2"),
+            Some((1128, Some(18753)))
+        );
+        // 页面中的相对链接
+        assert_eq!(parse("/comic/1128/18753.html"), Some((1128, Some(18753))));
+        // 其他网站的同名路径不算
+        assert_eq!(parse("https://example.com/comic/1128/18753.html"), None);
+        assert_eq!(parse("https://www.manhuagui.com/list/"), None);
+    }
+}
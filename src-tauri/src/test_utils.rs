@@ -0,0 +1,9 @@
+use std::path::PathBuf;
+
+/// 读取`src-tauri/testdata`中保存的页面，用来离线测试解析函数
+pub fn load_fixture(name: &str) -> String {
+    let path = PathBuf::from(env!("CARGO_MANIFEST_DIR"))
+        .join("testdata")
+        .join(name);
+    std::fs::read_to_string(&path).unwrap_or_else(|err| panic!("读取样本`{path:?}`失败: {err}"))
+}
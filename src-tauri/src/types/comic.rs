@@ -1,13 +1,27 @@
-use std::{collections::HashMap, path::Path};
+use std::{
+    collections::{HashMap, HashSet},
+    path::{Path, PathBuf},
+    sync::LazyLock,
+};
 
 use anyhow::{anyhow, Context};
 use parking_lot::RwLock;
+use regex::Regex;
 use scraper::{ElementRef, Html, Selector};
 use serde::{Deserialize, Serialize};
 use specta::Type;
 use tauri::{AppHandle, Manager};
 
-use crate::{config::Config, extensions::ToAnyhow, utils::filename_filter};
+use crate::{
+    comic_dirs,
+    config::{ChapterDedupeScope, ChapterDirLayout, Config},
+    downloaded_checker::DownloadedChecker,
+    extensions::ToAnyhow,
+    lz_cache,
+    site::{PageSelectors, Site},
+    task_list::parse_manhuagui_url,
+    utils::{collapse_whitespace, filename_filter},
+};
 
 #[derive(Default, Debug, Clone, PartialEq, Serialize, Deserialize, Type)]
 #[serde(rename_all = "camelCase")]
@@ -17,6 +31,12 @@ pub struct Comic {
     pub id: i64,
     /// 漫画标题
     pub title: String,
+    /// 下载目录中漫画目录的名字，通常与`title`相同，网站改名后沿用原来的目录
+    #[serde(default)]
+    pub dir_name: String,
+    /// 网站上用过的标题，按改名的先后顺序排列
+    #[serde(default)]
+    pub former_titles: Vec<String>,
     /// 漫画副标题
     pub subtitle: Option<String>,
     /// 封面链接
@@ -31,6 +51,9 @@ pub struct Comic {
     pub region: String,
     /// 类型
     pub genres: Vec<String>,
+    /// 类型标签，可以用来获取同类型的漫画列表
+    #[serde(default)]
+    pub genre_tags: Vec<GenreTag>,
     /// 作者
     pub authors: Vec<String>,
     /// 漫画别名
@@ -39,41 +62,86 @@ pub struct Comic {
     pub intro: String,
     /// 组名(单话、单行本...)->章节信息
     pub groups: HashMap<String, Vec<ChapterInfo>>,
+    /// 详情页中「喜欢这部漫画的人也喜欢」的推荐漫画，没有推荐时为空
+    #[serde(default)]
+    pub related: Vec<RelatedComic>,
+    /// 评分(满分10分)，由评分接口的投票统计算出，还没有人评分或获取失败时为None
+    #[serde(default)]
+    pub rating: Option<f64>,
+    /// 评分人数，获取失败时为None
+    #[serde(default)]
+    pub vote_count: Option<u64>,
+    /// 页面提示登录后可以看到更多章节，此时章节列表可能不完整，检测不到提示时为false
+    #[serde(default)]
+    pub requires_login: bool,
 }
 
 impl Comic {
-    pub fn from_html(app: &AppHandle, html: &str) -> anyhow::Result<Comic> {
-        let document = Html::parse_document(html);
-
-        let hidden_fragment = match document
-            .select(&Selector::parse("#__VIEWSTATE").to_anyhow()?)
-            .next()
-        {
-            Some(hidden_input) => {
-                // 有隐藏数据
-                let compressed_data = hidden_input
-                    .value()
-                    .attr("value")
-                    .context("没有在包含隐藏数据的<input>中找到value属性")?;
+    /// `lazy_pages`是懒加载分页的`<ul>`的html，key为(章节组的索引, 分页的索引)，
+    /// `site`是`html`所在的站点，决定使用哪套选择器
+    pub fn from_html(
+        app: &AppHandle,
+        html: &str,
+        lazy_pages: &HashMap<(usize, usize), String>,
+        site: Site,
+    ) -> anyhow::Result<Comic> {
+        let dedupe_scope = app.state::<RwLock<Config>>().read().chapter_dedupe_scope;
+        let mut comic = Comic::parse_html(html, lazy_pages, site, dedupe_scope)?;
+        comic.apply_local_state(app);
+        Ok(comic)
+    }
 
-                let decompressed_data = lz_str::decompress_from_base64(compressed_data)
-                    .context("lzstring解压缩失败")?;
+    /// 按本地的配置和已下载的文件填充目录名、章节目录结构和是否已下载，这些不属于页面内容
+    pub fn apply_local_state(&mut self, app: &AppHandle) {
+        let (download_dir, downloaded_checker) = get_download_dir_and_checker(app);
+        // 漫画改名后沿用原来的目录，映射读取失败时按标题拼目录
+        if let Ok(comic_dir) = comic_dirs::lookup(&download_dir, self.id, &self.title) {
+            self.dir_name = comic_dir.dir_name;
+            self.former_titles = comic_dir.former_titles;
+        }
+        let dir_layout = get_dir_layout(app, &self.dir_name);
+        for chapter_info in self.groups.values_mut().flatten() {
+            chapter_info.comic_title.clone_from(&self.dir_name);
+            chapter_info.dir_layout = dir_layout;
+            let is_downloaded =
+                chapter_info.get_is_downloaded(&download_dir, downloaded_checker.as_ref());
+            chapter_info.is_downloaded = Some(is_downloaded);
+        }
+    }
 
-                let hidden_html = String::from_utf16(&decompressed_data)
-                    .context("lzstring解压缩后的数据不是utf-16字符串")?;
+    /// 只解析详情页中的更新时间，用来判断能否直接使用缓存，解析不到时返回None
+    pub fn parse_update_time(html: &str, site: Site) -> Option<String> {
+        let selectors = site.selectors();
+        let document = Html::parse_document(html);
+        let book_detail_div = document
+            .select(&Selector::parse(selectors.book_detail).ok()?)
+            .next()?;
+        let li = book_detail_div
+            .select(&Selector::parse(selectors.detail_item).ok()?)
+            .nth(3)?;
+        let (_, update_time) = get_status_and_update_time(&li).ok()?;
+        Some(update_time).filter(|update_time| !update_time.is_empty())
+    }
 
-                Some(Html::parse_fragment(&hidden_html))
-            }
-            None => None,
-        };
+    /// 只解析页面本身，不读取配置和下载目录
+    ///
+    /// `dir_name`与标题相同，章节的`dir_layout`为默认值，`is_downloaded`为None
+    pub fn parse_html(
+        html: &str,
+        lazy_pages: &HashMap<(usize, usize), String>,
+        site: Site,
+        dedupe_scope: ChapterDedupeScope,
+    ) -> anyhow::Result<Comic> {
+        let selectors = site.selectors();
+        let document = Html::parse_document(html);
 
         let book_detail_div = document
-            .select(&Selector::parse(".book-detail").to_anyhow()?)
+            .select(&Selector::parse(selectors.book_detail).to_anyhow()?)
             .next()
             .context("没有找到漫画详情的<div>")?;
 
         let id = document
-            .select(&Selector::parse(".crumb > a:nth-last-child(1)").to_anyhow()?)
+            .select(&Selector::parse(selectors.comic_link).to_anyhow()?)
             .next()
             .context("没有找到漫画链接的<a>")?
             .value()
@@ -84,27 +152,20 @@ impl Comic {
             .parse::<i64>()
             .context("漫画id不是整数")?;
 
-        let (title, subtitle) = get_title_and_subtitle(&book_detail_div)?;
+        let (title, subtitle) = get_title_and_subtitle(&book_detail_div, selectors)?;
 
-        let cover_src = document
-            .select(&Selector::parse(".hcover img").to_anyhow()?)
-            .next()
-            .context("没有找到封面的<img>")?
-            .value()
-            .attr("src")
-            .context("没有在封面的<img>中找到src属性")?
-            .to_string();
-        let cover = format!("https:{cover_src}");
+        let cover = get_cover(&document)?;
 
         let detail_lis = book_detail_div
-            .select(&Selector::parse(".detail-list > li").to_anyhow()?)
+            .select(&Selector::parse(selectors.detail_item).to_anyhow()?)
             .collect::<Vec<_>>();
 
         let li = detail_lis.first().context("没有找到出版年份和地区的<li>")?;
         let (year, region) = get_year_and_region(li)?;
 
         let li = detail_lis.get(1).context("没有找到漫画类型和作者的<li>")?;
-        let (genres, authors) = get_genres_and_authors(li)?;
+        let (genre_tags, authors) = get_genre_tags_and_authors(li)?;
+        let genres = genre_tags.iter().map(|tag| tag.name.clone()).collect();
 
         let li = detail_lis.get(2).context("没有找到别名的<li>")?;
         let aliases = li
@@ -114,9 +175,15 @@ impl Comic {
 
         let li = detail_lis.get(3).context("没有找到状态和更新时间的<li>")?;
         let (status, update_time) = get_status_and_update_time(li)?;
+        // `更新至 第xx话`中的链接，没有时只是最新一话没有更新日期
+        let latest_chapter_id = li
+            .select(&Selector::parse("a").to_anyhow()?)
+            .filter_map(|a| a.value().attr("href"))
+            .filter_map(parse_manhuagui_url)
+            .find_map(|entry| entry.chapter_id);
 
         let intro = book_detail_div
-            .select(&Selector::parse("#intro-cut").to_anyhow()?)
+            .select(&Selector::parse(selectors.intro).to_anyhow()?)
             .next()
             .context("没有找到简介的<div>")?
             .text()
@@ -125,20 +192,38 @@ impl Comic {
             .trim()
             .to_string();
 
-        let groups = if let Some(fragment) = hidden_fragment {
-            get_groups(app, &fragment.root_element(), id, &title, &status)?
-        } else {
-            let chapter_div = document
-                .select(&Selector::parse(".chapter").to_anyhow()?)
-                .next()
-                .context("没有找到章节列表的<div>")?;
+        // 推荐只是附加信息，解析失败不应该影响获取漫画
+        let related = get_related(&document).unwrap_or_default();
+        let requires_login = get_requires_login(&document);
 
-            get_groups(app, &chapter_div, id, &title, &status)?
-        };
+        let mut groups = with_chapter_div(&document, selectors, |chapter_div| {
+            get_groups(
+                chapter_div,
+                selectors,
+                &ComicBrief {
+                    id,
+                    title: &title,
+                    status: &status,
+                },
+                lazy_pages,
+                dedupe_scope,
+            )
+        })?;
+        if let Some(updated_at) = parse_date(&update_time) {
+            for chapter_info in groups.values_mut().flatten() {
+                if chapter_info.updated_at.is_none()
+                    && Some(chapter_info.chapter_id) == latest_chapter_id
+                {
+                    chapter_info.updated_at = Some(updated_at.clone());
+                }
+            }
+        }
 
         Ok(Comic {
             id,
+            dir_name: title.clone(),
             title,
+            former_titles: Vec::new(),
             subtitle,
             cover,
             status,
@@ -146,10 +231,90 @@ impl Comic {
             year,
             region,
             genres,
+            genre_tags,
             authors,
             aliases,
             intro,
             groups,
+            related,
+            // 评分不在详情页中，由`ManhuaguiClient::get_comic`另外请求评分接口填充
+            rating: None,
+            vote_count: None,
+            requires_login,
+        })
+    }
+
+    /// 找出章节列表中懒加载的分页(`<ul>`为空，需要另外请求才能拿到章节)
+    pub fn find_lazy_chapter_pages(html: &str, site: Site) -> anyhow::Result<Vec<LazyChapterPage>> {
+        let selectors = site.selectors();
+        let document = Html::parse_document(html);
+        with_chapter_div(&document, selectors, |chapter_div| {
+            let li_selector = Selector::parse("li").to_anyhow()?;
+            let a_selector = Selector::parse(selectors.chapter_page_link).to_anyhow()?;
+
+            let mut lazy_pages = Vec::new();
+            let chapter_list_divs =
+                chapter_div.select(&Selector::parse(selectors.chapter_list).to_anyhow()?);
+            for (group_index, chapter_list_div) in chapter_list_divs.enumerate() {
+                // 分页控件在章节列表之前，与章节组名的<h4>之间
+                let page_hrefs = chapter_list_div
+                    .prev_siblings()
+                    .filter_map(ElementRef::wrap)
+                    .take_while(|element| element.value().name() != "h4")
+                    .flat_map(|element| {
+                        element
+                            .select(&a_selector)
+                            .map(|a| a.value().attr("href").unwrap_or_default().to_string())
+                            .collect::<Vec<_>>()
+                    })
+                    .collect::<Vec<_>>();
+                let uls = chapter_list_div.select(&Selector::parse("ul").to_anyhow()?);
+                for (page_index, ul) in uls.enumerate() {
+                    if ul.select(&li_selector).next().is_some() {
+                        continue;
+                    }
+                    // 只有真正的链接才能补抓，`javascript:;`之类的跳过
+                    let Some(href) = page_hrefs.get(page_index) else {
+                        continue;
+                    };
+                    let url = if href.starts_with("http") {
+                        href.clone()
+                    } else if href.starts_with('/') {
+                        format!("{}{href}", site.base_url())
+                    } else {
+                        continue;
+                    };
+                    lazy_pages.push(LazyChapterPage {
+                        group_index,
+                        page_index,
+                        url,
+                    });
+                }
+            }
+            Ok(lazy_pages)
+        })
+    }
+
+    /// 从`html`中取出第`group_index`个章节组的第`page_index`个分页的`<ul>`
+    pub fn get_chapter_page_html(
+        html: &str,
+        group_index: usize,
+        page_index: usize,
+        site: Site,
+    ) -> anyhow::Result<String> {
+        let selectors = site.selectors();
+        let document = Html::parse_document(html);
+        with_chapter_div(&document, selectors, |chapter_div| {
+            let ul = chapter_div
+                .select(&Selector::parse(selectors.chapter_list).to_anyhow()?)
+                .nth(group_index)
+                .context(format!("没有找到第{group_index}个章节列表"))?
+                .select(&Selector::parse("ul").to_anyhow()?)
+                .nth(page_index)
+                .context(format!(
+                    "没有在第{group_index}个章节列表中找到第{page_index}个分页"
+                ))?;
+            Ok(ul.html())
         })
     }
 
@@ -160,23 +325,91 @@ impl Comic {
         let mut comic = serde_json::from_str::<Comic>(&comic_json).context(format!(
             "从元数据转为Comic失败，将 {metadata_path:?} 反序列化为Comic失败"
         ))?;
+        // 元数据所在的目录就是漫画目录，旧版本的元数据中没有记录目录名
+        if let Some(dir_name) = metadata_path
+            .parent()
+            .and_then(Path::file_name)
+            .and_then(|name| name.to_str())
+        {
+            comic.dir_name = dir_name.to_string();
+        }
         // 这个comic中的is_downloaded字段是None，需要重新计算
+        let (download_dir, downloaded_checker) = get_download_dir_and_checker(app);
         for chapter_infos in comic.groups.values_mut() {
             for chapter_info in chapter_infos.iter_mut() {
-                let comic_title = &comic.title;
-                let group_name = &chapter_info.group_name;
-                let prefixed_chapter_title = &chapter_info.prefixed_chapter_title;
-                let is_downloaded = ChapterInfo::get_is_downloaded(
-                    app,
-                    comic_title,
-                    group_name,
-                    prefixed_chapter_title,
-                );
+                let is_downloaded =
+                    chapter_info.get_is_downloaded(&download_dir, downloaded_checker.as_ref());
                 chapter_info.is_downloaded = Some(is_downloaded);
             }
         }
         Ok(comic)
     }
+
+    /// 序列化为格式化的json，作为元数据保存或者导出给其他工具使用
+    ///
+    /// `is_downloaded`只在当前下载目录下有意义，所以会被忽略
+    pub fn to_json(&self) -> anyhow::Result<String> {
+        let mut comic = self.clone();
+        for chapter_info in comic.groups.values_mut().flatten() {
+            chapter_info.is_downloaded = None;
+        }
+        let comic_json = serde_json::to_string_pretty(&comic).context("将Comic序列化为json失败")?;
+        Ok(comic_json)
+    }
+
+    /// 把所有章节组的章节按阅读顺序排成一列，用于批量操作，前端的列表视图按同样的顺序在本地排列
+    ///
+    /// 章节组的顺序与前端的分组标签页相同，即章节多的组在前，章节数相同时按组名排序，组内按章节顺序排列
+    pub fn flatten_chapters(&self) -> Vec<ChapterInfo> {
+        let mut groups = self.groups.iter().collect::<Vec<_>>();
+        groups.sort_by(|(a_name, a_chapters), (b_name, b_chapters)| {
+            b_chapters
+                .len()
+                .cmp(&a_chapters.len())
+                .then_with(|| a_name.cmp(b_name))
+        });
+        groups
+            .into_iter()
+            .flat_map(|(_, chapters)| {
+                let mut chapters = chapters.clone();
+                chapters.sort_by(|a, b| a.order.total_cmp(&b.order));
+                chapters
+            })
+            .collect()
+    }
+}
+
+/// 漫画的类型标签
+#[derive(Default, Debug, Clone, PartialEq, Eq, Serialize, Deserialize, Type)]
+#[serde(rename_all = "camelCase")]
+pub struct GenreTag {
+    /// 标签名，例如`热血`
+    pub name: String,
+    /// 标签链接`/list/{slug}/`中的slug，例如`rexue`
+    pub slug: String,
+}
+
+/// 详情页推荐的漫画
+#[derive(Default, Debug, Clone, PartialEq, Eq, Serialize, Deserialize, Type)]
+#[serde(rename_all = "camelCase")]
+pub struct RelatedComic {
+    /// 漫画id
+    pub id: i64,
+    /// 漫画标题
+    pub title: String,
+    /// 封面链接
+    pub cover: String,
+}
+
+/// 章节列表中懒加载的分页
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct LazyChapterPage {
+    /// 章节组的索引
+    pub group_index: usize,
+    /// 分页在章节组中的索引
+    pub page_index: usize,
+    /// 获取这个分页的章节需要请求的url
+    pub url: String,
 }
 
 #[derive(Default, Debug, Clone, PartialEq, Serialize, Deserialize, Type)]
@@ -192,7 +425,7 @@ pub struct ChapterInfo {
     pub prefixed_chapter_title: String,
     /// 漫画id
     pub comic_id: i64,
-    /// 漫画标题
+    /// 漫画目录的名字，通常就是漫画标题，网站改名后是原来的标题
     pub comic_title: String,
     /// 组名(单话、单行本、番外篇)
     pub group_name: String,
@@ -202,47 +435,104 @@ pub struct ChapterInfo {
     pub order: f64,
     /// 漫画状态(连载中/已完结)
     pub comic_status: String,
+    /// 是否需要付费或登录才能查看
+    #[serde(default)]
+    pub is_locked: bool,
+    /// 章节目录的结构，随元数据一起保存，这样切换配置后依然能找到已下载的章节
+    #[serde(default)]
+    pub dir_layout: ChapterDirLayout,
+    /// 章节的语言，从标题或class中识别，识别不出时为`Unknown`
+    #[serde(default)]
+    pub language: ChapterLanguage,
+    /// 章节的更新日期(YYYY-MM-DD)，章节列表中通常没有，只有最新一话能从漫画的更新时间得知
+    #[serde(default)]
+    pub updated_at: Option<String>,
     /// 是否已下载
     #[serde(skip_serializing_if = "Option::is_none")]
     pub is_downloaded: Option<bool>,
 }
 
+/// 章节的语言，同一本漫画可能混有简体、繁体和日文原版的章节
+#[derive(Default, Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize, Type)]
+pub enum ChapterLanguage {
+    /// 标题和class中都没有标注语言，通常是网站的默认版本
+    #[default]
+    Unknown,
+    /// 简体
+    Simplified,
+    /// 繁体
+    Traditional,
+    /// 日文原版
+    Japanese,
+}
+
+/// 标题中标注语言的文本，按顺序匹配，先匹配到的为准
+const LANGUAGE_TITLE_MARKERS: [(&str, ChapterLanguage); 10] = [
+    ("日文", ChapterLanguage::Japanese),
+    ("日语", ChapterLanguage::Japanese),
+    ("日語", ChapterLanguage::Japanese),
+    ("日版", ChapterLanguage::Japanese),
+    ("繁体", ChapterLanguage::Traditional),
+    ("繁體", ChapterLanguage::Traditional),
+    ("繁中", ChapterLanguage::Traditional),
+    ("简体", ChapterLanguage::Simplified),
+    ("簡體", ChapterLanguage::Simplified),
+    ("简中", ChapterLanguage::Simplified),
+];
+
 impl ChapterInfo {
+    /// 用`downloaded_checker`判断此章节是否已下载在`download_dir`中
     pub fn get_is_downloaded(
-        app: &AppHandle,
-        comic_title: &str,
-        group_name: &str,
-        prefixed_chapter_title: &str,
+        &self,
+        download_dir: &Path,
+        downloaded_checker: &dyn DownloadedChecker,
     ) -> bool {
-        app.state::<RwLock<Config>>()
-            .read()
-            .download_dir
-            .join(comic_title)
-            .join(group_name)
-            .join(prefixed_chapter_title)
-            .exists()
+        let chapter_download_dir = download_dir.join(self.relative_dir());
+        downloaded_checker.is_downloaded(&chapter_download_dir, self.chapter_size)
+    }
+
+    /// 章节目录相对于下载目录的路径
+    pub fn relative_dir(&self) -> PathBuf {
+        Path::new(&self.comic_title).join(self.dir_in_comic())
+    }
+
+    /// 章节目录相对于漫画目录的路径，结构由`dir_layout`决定
+    pub fn dir_in_comic(&self) -> PathBuf {
+        match self.dir_layout {
+            ChapterDirLayout::ByGroup => {
+                Path::new(&self.group_name).join(&self.prefixed_chapter_title)
+            }
+            ChapterDirLayout::Flat => PathBuf::from(format!(
+                "{} {}",
+                self.group_name, self.prefixed_chapter_title
+            )),
+        }
     }
 }
 
 fn get_title_and_subtitle(
     book_detail_div: &ElementRef,
+    selectors: &PageSelectors,
 ) -> anyhow::Result<(String, Option<String>)> {
-    let title = book_detail_div
-        .select(&Selector::parse(".book-title h1").to_anyhow()?)
+    let h1 = book_detail_div
+        .select(&Selector::parse(selectors.title).to_anyhow()?)
         .next()
-        .context("没有找到漫画标题的<h1>")?
-        .text()
-        .next()
-        .context("没有在漫画标题的<h1>中找到文本")?
-        .trim()
-        .to_string();
-    let title = filename_filter(&title);
+        .context("没有找到漫画标题的<h1>")?;
+    // <h1>里可能还嵌着状态之类的标签，只取<h1>自己的文本节点
+    let title = h1
+        .children()
+        .filter_map(|child| child.value().as_text().map(|text| String::from(&**text)))
+        .collect::<String>();
+    let title = filename_filter(&collapse_whitespace(&title));
+    if title.is_empty() {
+        return Err(anyhow!("漫画标题为空"));
+    }
 
     let subtitle = book_detail_div
-        .select(&Selector::parse(".book-title h2").to_anyhow()?)
+        .select(&Selector::parse(selectors.subtitle).to_anyhow()?)
         .next()
-        .and_then(|h2| h2.text().next())
-        .map(|text| text.trim().to_string());
+        .map(|h2| collapse_whitespace(&h2.text().collect::<String>()))
+        .filter(|subtitle| !subtitle.is_empty());
 
     Ok((title, subtitle))
 }
@@ -281,17 +571,28 @@ fn get_year_and_region(li: &ElementRef) -> anyhow::Result<(i64, String)> {
     Ok((year, region))
 }
 
-fn get_genres_and_authors(li: &ElementRef) -> anyhow::Result<(Vec<String>, Vec<String>)> {
+fn get_genre_tags_and_authors(li: &ElementRef) -> anyhow::Result<(Vec<GenreTag>, Vec<String>)> {
     let spans = li
         .select(&Selector::parse("span").to_anyhow()?)
         .collect::<Vec<_>>();
     let a_selector = Selector::parse("a").to_anyhow()?;
 
-    let genres = spans
+    let genre_tags = spans
         .first()
         .context("没有找到漫画类型的<span>")?
         .select(&a_selector)
-        .filter_map(|a| a.text().next().map(|text| text.trim().to_string()))
+        .filter_map(|a| {
+            let name = a.text().next()?.trim().to_string();
+            // 链接形如`/list/rexue/`
+            let slug = a
+                .value()
+                .attr("href")
+                .unwrap_or_default()
+                .trim_start_matches("/list/")
+                .trim_end_matches('/')
+                .to_string();
+            Some(GenreTag { name, slug })
+        })
         .collect::<Vec<_>>();
 
     let authors = spans
@@ -301,7 +602,7 @@ fn get_genres_and_authors(li: &ElementRef) -> anyhow::Result<(Vec<String>, Vec<S
         .filter_map(|a| a.value().attr("title").map(str::to_string))
         .collect::<Vec<_>>();
 
-    Ok((genres, authors))
+    Ok((genre_tags, authors))
 }
 
 fn get_status_and_update_time(li: &ElementRef) -> anyhow::Result<(String, String)> {
@@ -330,28 +631,59 @@ fn get_status_and_update_time(li: &ElementRef) -> anyhow::Result<(String, String
     Ok((status, update_time))
 }
 
+/// 生成章节信息时需要的漫画信息
+struct ComicBrief<'a> {
+    id: i64,
+    title: &'a str,
+    status: &'a str,
+}
+
+/// 已有元数据时沿用元数据中记录的章节目录结构，否则使用配置中的结构
+///
+/// 旧版本的元数据中没有记录结构，反序列化后是默认的`ByGroup`，正好是旧版本使用的结构
+fn get_dir_layout(app: &AppHandle, comic_title: &str) -> ChapterDirLayout {
+    let (download_dir, configured_layout) = {
+        let config = app.state::<RwLock<Config>>();
+        let config = config.read();
+        (config.download_dir.clone(), config.chapter_dir_layout)
+    };
+    let metadata_path = download_dir.join(comic_title).join("元数据.json");
+    std::fs::read_to_string(metadata_path)
+        .ok()
+        .and_then(|comic_json| serde_json::from_str::<Comic>(&comic_json).ok())
+        .and_then(|comic| {
+            let chapter_info = comic.groups.values().flatten().next()?;
+            Some(chapter_info.dir_layout)
+        })
+        .unwrap_or(configured_layout)
+}
+
 #[allow(clippy::cast_possible_wrap)]
 fn get_groups(
-    app: &AppHandle,
     chapter_div: &ElementRef,
-    comic_id: i64,
-    comic_title: &str,
-    comic_status: &str,
+    selectors: &PageSelectors,
+    comic: &ComicBrief,
+    lazy_pages: &HashMap<(usize, usize), String>,
+    dedupe_scope: ChapterDedupeScope,
 ) -> anyhow::Result<HashMap<String, Vec<ChapterInfo>>> {
     let h4s = chapter_div
         .select(&Selector::parse("h4").to_anyhow()?)
         .collect::<Vec<_>>();
 
     let chapter_divs = chapter_div
-        .select(&Selector::parse(".chapter-list").to_anyhow()?)
+        .select(&Selector::parse(selectors.chapter_list).to_anyhow()?)
         .collect::<Vec<_>>();
 
     if h4s.len() != chapter_divs.len() {
         return Err(anyhow!("章节组名和章节列表数量不一致"));
     }
 
+    let li_selector = Selector::parse("li").to_anyhow()?;
+    let a_selector = Selector::parse("a").to_anyhow()?;
+    // 已经出现过的章节href，`ChapterDedupeScope::Comic`时跨章节组共用
+    let mut seen_hrefs = HashSet::new();
     let mut groups = HashMap::new();
-    for (h4, chapter_list_div) in h4s.iter().zip(chapter_divs.iter()) {
+    for (group_index, (h4, chapter_list_div)) in h4s.iter().zip(chapter_divs.iter()).enumerate() {
         let group_name = h4
             .text()
             .next()
@@ -363,74 +695,129 @@ fn get_groups(
         let uls = chapter_list_div
             .select(&Selector::parse("ul").to_anyhow()?)
             .collect::<Vec<_>>();
+        // 懒加载的分页用补抓到的<ul>代替，按分页顺序重建
+        let lazy_fragments = (0..uls.len())
+            .map(|page_index| {
+                lazy_pages
+                    .get(&(group_index, page_index))
+                    .map(|ul_html| Html::parse_fragment(ul_html))
+            })
+            .collect::<Vec<_>>();
+        let pages = uls
+            .iter()
+            .zip(lazy_fragments.iter())
+            .map(|(ul, lazy_fragment)| match lazy_fragment {
+                Some(fragment) => fragment.select(&li_selector).collect::<Vec<_>>(),
+                None => ul.select(&li_selector).collect::<Vec<_>>(),
+            })
+            .collect::<Vec<_>>();
+        // 按文档顺序去重，这样保留的是第一次出现的章节
+        if dedupe_scope == ChapterDedupeScope::Group {
+            seen_hrefs.clear();
+        }
+        let pages = pages
+            .into_iter()
+            .map(|lis| {
+                if dedupe_scope == ChapterDedupeScope::Page {
+                    seen_hrefs.clear();
+                }
+                lis.into_iter()
+                    .filter(|li| {
+                        if dedupe_scope == ChapterDedupeScope::Disabled {
+                            return true;
+                        }
+                        // 没有href的交给后面报错
+                        let Some(href) = li
+                            .select(&a_selector)
+                            .next()
+                            .and_then(|a| a.value().attr("href"))
+                        else {
+                            return true;
+                        };
+                        seen_hrefs.insert(href.to_string())
+                    })
+                    .collect::<Vec<_>>()
+            })
+            .collect::<Vec<_>>();
 
         let mut order = 0.0;
         // 统计一共有多少个li
-        let group_size = chapter_list_div
-            .select(&Selector::parse("li").to_anyhow()?)
-            .count() as i64;
+        let group_size = pages.iter().map(Vec::len).sum::<usize>() as i64;
+
+        let mut lis = Vec::new();
+        for mut page in pages {
+            // 不同分页、不同章节组的排列方向不一定相同，按章节序号判断后统一为正序
+            if is_descending(&page, &a_selector) {
+                page.reverse();
+            }
+            lis.extend(page);
+        }
+        // 再按章节序号排序，这样分页之间的先后、个别错位的章节都不依赖网页中的位置
+        let lis = sort_by_chapter_number(lis, |li| chapter_number_of(li, &a_selector));
 
         let mut chapter_infos = Vec::new();
-        for ul in uls {
-            let mut lis = ul
-                .select(&Selector::parse("li").to_anyhow()?)
-                .collect::<Vec<_>>();
-            lis.reverse();
-
-            for li in lis {
-                order += 1.0;
-                let a = li
-                    .select(&Selector::parse("a").to_anyhow()?)
-                    .next()
-                    .context("没有找到章节的<a>")?;
-
-                let chapter_id = a
-                    .value()
-                    .attr("href")
-                    .context("没有在章节的<a>中找到href属性")?
-                    .trim_start_matches(&format!("/comic/{comic_id}/"))
-                    .trim_end_matches(".html")
-                    .parse::<i64>()
-                    .context("章节id不是整数")?;
-
-                let chapter_title = a
-                    .value()
-                    .attr("title")
-                    .context("没有在章节的<a>中找到title属性")?
-                    .to_string();
-                let chapter_title = filename_filter(&chapter_title);
-
-                let prefixed_chapter_title = format!("{order} {chapter_title}");
-
-                let chapter_size = a
-                    .select(&Selector::parse("span > i").to_anyhow()?)
-                    .next()
-                    .context("没有找到章节的<i>")?
-                    .text()
-                    .next()
-                    .context("没有在章节的<i>中找到文本")?
-                    .trim()
-                    .trim_end_matches('p')
-                    .parse::<i64>()
-                    .context("章节页数不是整数")?;
+        for li in lis {
+            order += 1.0;
+            let a = li.select(&a_selector).next().context("没有找到章节的<a>")?;
 
-                let is_downloaded =
-                    get_is_downloaded(app, comic_title, &group_name, &prefixed_chapter_title);
-
-                chapter_infos.push(ChapterInfo {
-                    chapter_id,
-                    chapter_title,
-                    chapter_size,
-                    prefixed_chapter_title,
-                    comic_id,
-                    comic_title: comic_title.to_string(),
-                    group_name: group_name.clone(),
-                    group_size,
-                    order,
-                    comic_status: comic_status.to_string(),
-                    is_downloaded: Some(is_downloaded),
-                });
-            }
+            let chapter_id = a
+                .value()
+                .attr("href")
+                .context("没有在章节的<a>中找到href属性")?
+                .trim_start_matches(&format!("/comic/{}/", comic.id))
+                .trim_end_matches(".html")
+                .parse::<i64>()
+                .context("章节id不是整数")?;
+
+            let chapter_title = a
+                .value()
+                .attr("title")
+                .context("没有在章节的<a>中找到title属性")?
+                .to_string();
+            let chapter_title = filename_filter(&chapter_title);
+
+            let prefixed_chapter_title = format!("{order} {chapter_title}");
+
+            let chapter_size = a
+                .select(&Selector::parse("span > i").to_anyhow()?)
+                .next()
+                .context("没有找到章节的<i>")?
+                .text()
+                .next()
+                .context("没有在章节的<i>中找到文本")?
+                .trim()
+                .trim_end_matches('p')
+                .parse::<i64>()
+                .context("章节页数不是整数")?;
+
+            let is_locked = get_is_locked(&li);
+            let language = get_language(&li, &chapter_title);
+            // 个别章节的title或旁边的文本中带有日期
+            let updated_at = a
+                .value()
+                .attr("title")
+                .and_then(parse_date)
+                .or_else(|| parse_date(&li.text().collect::<String>()));
+
+            let chapter_info = ChapterInfo {
+                chapter_id,
+                chapter_title,
+                chapter_size,
+                prefixed_chapter_title,
+                comic_id: comic.id,
+                comic_title: comic.title.to_string(),
+                group_name: group_name.clone(),
+                group_size,
+                order,
+                comic_status: comic.status.to_string(),
+                is_locked,
+                dir_layout: ChapterDirLayout::default(),
+                language,
+                updated_at,
+                is_downloaded: None,
+            };
+
+            chapter_infos.push(chapter_info);
         }
 
         groups.insert(group_name, chapter_infos);
@@ -439,17 +826,543 @@ fn get_groups(
     Ok(groups)
 }
 
-fn get_is_downloaded(
-    app: &AppHandle,
-    comic_title: &str,
-    group_name: &str,
-    prefixed_chapter_title: &str,
-) -> bool {
-    app.state::<RwLock<Config>>()
-        .read()
-        .download_dir
-        .join(comic_title)
-        .join(group_name)
-        .join(prefixed_chapter_title)
-        .exists()
+/// 优先从`.hcover img`获取封面链接，没有时退回到`og:image`
+fn get_cover(document: &Html) -> anyhow::Result<String> {
+    let cover_src = document
+        .select(&Selector::parse(".hcover img").to_anyhow()?)
+        .next()
+        .and_then(|img| img.value().attr("src"))
+        .or_else(|| {
+            let selector = Selector::parse(r#"meta[property="og:image"]"#).ok()?;
+            document.select(&selector).next()?.value().attr("content")
+        })
+        .context("没有找到封面的<img>，也没有找到og:image")?;
+    // 封面链接通常是省略了协议的`//cf.hamreus.com/...`
+    let cover = if cover_src.starts_with("//") {
+        format!("https:{cover_src}")
+    } else {
+        cover_src.to_string()
+    };
+    Ok(cover)
+}
+
+/// 解析详情页的推荐漫画，推荐区块不存在时返回空列表，单个推荐解析失败时跳过它
+fn get_related(document: &Html) -> anyhow::Result<Vec<RelatedComic>> {
+    let li_selector = Selector::parse(".similar-list li, #similar li").to_anyhow()?;
+    let a_selector = Selector::parse(r#"a[href^="/comic/"]"#).to_anyhow()?;
+    let img_selector = Selector::parse("img").to_anyhow()?;
+
+    let mut seen_ids = HashSet::new();
+    let related = document
+        .select(&li_selector)
+        .filter_map(|li| {
+            let a = li.select(&a_selector).next()?;
+            // 链接形如`/comic/12345/`
+            let id = a
+                .value()
+                .attr("href")?
+                .trim_start_matches("/comic/")
+                .trim_end_matches('/')
+                .parse::<i64>()
+                .ok()?;
+            let title = a
+                .value()
+                .attr("title")
+                .map(str::to_string)
+                .unwrap_or_else(|| collapse_whitespace(&a.text().collect::<String>()));
+            if title.is_empty() {
+                return None;
+            }
+            // 靠后的封面是懒加载的，真正的链接在data-src里
+            let cover = li
+                .select(&img_selector)
+                .next()
+                .and_then(|img| img.value().attr("data-src").or(img.value().attr("src")))
+                .map(|src| {
+                    if src.starts_with("//") {
+                        format!("https:{src}")
+                    } else {
+                        src.to_string()
+                    }
+                })
+                .unwrap_or_default();
+            Some(RelatedComic { id, title, cover })
+        })
+        .filter(|comic| seen_ids.insert(comic.id))
+        .collect();
+    Ok(related)
+}
+
+/// 章节列表附近是否有「登录后可见更多章节」之类的提示
+///
+/// 只检查章节列表和警告栏中的文本，页头的登录链接不算
+fn get_requires_login(document: &Html) -> bool {
+    static LOGIN_HINT_REGEX: LazyLock<Regex> = LazyLock::new(|| {
+        Regex::new(r"(?:登录|登入|登錄)(?:后|後)?(?:才能|才可|可以|可)?(?:查看|观看|觀看|阅读|閱讀|可见|可見|显示|顯示)")
+            .unwrap()
+    });
+    let Ok(selector) = Selector::parse(".chapter, .chapter-box, .warning-bar, .chapter-tips")
+    else {
+        return false;
+    };
+    document.select(&selector).any(|element| {
+        let text = collapse_whitespace(&element.text().collect::<String>());
+        LOGIN_HINT_REGEX.is_match(&text)
+    })
+}
+
+/// 评分接口`/tools/vote.ashx?act=get&bid={id}`返回的投票统计
+///
+/// 接口返回形如`{"success":true,"data":{"s1":1,"s2":0,"s3":2,"s4":5,"s5":30}}`的JSON，
+/// `s1`~`s5`分别是打1~5星的人数，数值有时是字符串
+#[derive(Default, Debug, Clone, Copy, PartialEq, Eq)]
+pub struct VoteStats {
+    /// 下标0~4分别是打1~5星的人数
+    pub star_counts: [u64; 5],
+}
+
+impl VoteStats {
+    pub fn from_json(json: &str) -> anyhow::Result<VoteStats> {
+        let value = serde_json::from_str::<serde_json::Value>(json)
+            .context(format!("将评分接口的响应`{json}`解析为JSON失败"))?;
+        let data = value
+            .get("data")
+            .context(format!("评分接口的响应`{json}`中没有`data`字段"))?;
+        let mut star_counts = [0; 5];
+        for (i, count) in star_counts.iter_mut().enumerate() {
+            let key = format!("s{}", i + 1);
+            let field = data
+                .get(&key)
+                .context(format!("评分接口的响应`{json}`中没有`{key}`字段"))?;
+            *count = match field {
+                serde_json::Value::Number(number) => number.as_u64(),
+                serde_json::Value::String(text) => text.trim().parse::<u64>().ok(),
+                _ => None,
+            }
+            .context(format!("评分接口的响应`{json}`中的`{key}`不是非负整数"))?;
+        }
+        Ok(VoteStats { star_counts })
+    }
+
+    /// 评分人数
+    pub fn vote_count(&self) -> u64 {
+        self.star_counts.iter().sum()
+    }
+
+    /// 把平均星数换算成满分10分，保留一位小数，还没有人评分时返回None
+    #[allow(clippy::cast_precision_loss)]
+    pub fn rating(&self) -> Option<f64> {
+        let vote_count = self.vote_count();
+        if vote_count == 0 {
+            return None;
+        }
+        let total_stars = (1..=5)
+            .zip(self.star_counts)
+            .map(|(stars, count)| stars * count)
+            .sum::<u64>();
+        let rating = total_stars as f64 / vote_count as f64 * 2.0;
+        Some((rating * 10.0).round() / 10.0)
+    }
+}
+
+/// 根据章节标题中的序号判断`lis`是否为倒序
+///
+/// 统计相邻两个有序号的章节是递增还是递减，递减多于递增就是倒序，
+/// 无法判断时(比如标题都没有序号)沿用网站通常的倒序
+fn is_descending(lis: &[ElementRef], a_selector: &Selector) -> bool {
+    let numbers = lis
+        .iter()
+        .filter_map(|li| chapter_number_of(li, a_selector))
+        .collect::<Vec<_>>();
+    let (mut increasing, mut decreasing) = (0, 0);
+    for pair in numbers.windows(2) {
+        match pair[0].total_cmp(&pair[1]) {
+            std::cmp::Ordering::Less => increasing += 1,
+            std::cmp::Ordering::Greater => decreasing += 1,
+            std::cmp::Ordering::Equal => {}
+        }
+    }
+    increasing <= decreasing
+}
+
+fn chapter_number_of(li: &ElementRef, a_selector: &Selector) -> Option<f64> {
+    let title = li.select(a_selector).next()?.value().attr("title")?;
+    get_chapter_number(title)
+}
+
+/// 提取章节标题中的序号，例如`第12话`->12，`第3.5话`->3.5，`第01卷`->1，`Vol.2`->2
+///
+/// 只认`第N`和标题开头的数字，`2021年特别篇`这种标题中间的数字不是序号
+fn get_chapter_number(title: &str) -> Option<f64> {
+    static NUMBER_REGEX: LazyLock<Regex> = LazyLock::new(|| {
+        Regex::new(r"(?i)第\s*(\d+(?:\.\d+)?)|^\s*(?:vol\.?|#)?\s*(\d+(?:\.\d+)?)(?:\D|$)").unwrap()
+    });
+    let captures = NUMBER_REGEX.captures(title)?;
+    let number = captures.get(1).or_else(|| captures.get(2))?;
+    // `2021年`这种开头的年份不是序号
+    if title[number.end()..].trim_start().starts_with('年') {
+        return None;
+    }
+    number.as_str().parse().ok()
+}
+
+/// 把有序号的章节按序号稳定排序，没有序号的章节(番外、特别篇等)留在原来的位置
+fn sort_by_chapter_number<T: Copy>(items: Vec<T>, number_of: impl Fn(&T) -> Option<f64>) -> Vec<T> {
+    let slots = items
+        .iter()
+        .enumerate()
+        .filter_map(|(index, item)| Some((index, number_of(item)?)))
+        .collect::<Vec<_>>();
+    let mut sorted_slots = slots.clone();
+    sorted_slots.sort_by(|a, b| a.1.total_cmp(&b.1));
+
+    let mut sorted = items.clone();
+    for ((slot, _), (from, _)) in slots.iter().zip(&sorted_slots) {
+        sorted[*slot] = items[*from];
+    }
+    sorted
+}
+
+/// 从文本中找出`2024-01-05`、`2024/1/5`、`2024.01.05`或`2024年1月5日`格式的日期，统一为`2024-01-05`
+///
+/// 找不到或日期不合法时返回None
+pub fn parse_date(text: &str) -> Option<String> {
+    static DATE_REGEX: LazyLock<Regex> = LazyLock::new(|| {
+        Regex::new(r"(\d{4})\s*[-/.年]\s*(\d{1,2})\s*[-/.月]\s*(\d{1,2})").unwrap()
+    });
+    let captures = DATE_REGEX.captures(text)?;
+    let year = captures[1].parse::<u32>().ok()?;
+    let month = captures[2].parse::<u32>().ok()?;
+    let day = captures[3].parse::<u32>().ok()?;
+    if !(1..=12).contains(&month) || !(1..=31).contains(&day) {
+        return None;
+    }
+    Some(format!("{year:04}-{month:02}-{day:02}"))
+}
+
+/// 需要付费或登录的章节，`<li>`或其中的元素会带有锁图标或vip相关的class
+fn get_is_locked(li: &ElementRef) -> bool {
+    // 按`-`和`_`拆分后逐段比较，避免把`block`之类的class误判为锁
+    let is_locked_class = |class: &str| {
+        class
+            .split(['-', '_'])
+            .any(|part| matches!(part.to_lowercase().as_str(), "lock" | "locked" | "vip"))
+    };
+    li.value().classes().any(is_locked_class)
+        || li
+            .descendants()
+            .filter_map(ElementRef::wrap)
+            .any(|element| element.value().classes().any(is_locked_class))
+}
+
+/// 优先按标题中的文本识别章节的语言，标题中没有标注时再看`<li>`及其中元素的class，
+/// 例如`lang-jp`、`tw`，按`-`和`_`拆分后逐段比较
+fn get_language(li: &ElementRef, chapter_title: &str) -> ChapterLanguage {
+    if let Some((_, language)) = LANGUAGE_TITLE_MARKERS
+        .iter()
+        .find(|(marker, _)| chapter_title.contains(marker))
+    {
+        return *language;
+    }
+
+    let language_of_class = |class: &str| {
+        class
+            .split(['-', '_'])
+            .find_map(|part| match part.to_lowercase().as_str() {
+                "jp" | "ja" | "jpn" => Some(ChapterLanguage::Japanese),
+                "tw" | "hk" | "cht" => Some(ChapterLanguage::Traditional),
+                "cn" | "chs" => Some(ChapterLanguage::Simplified),
+                _ => None,
+            })
+    };
+    std::iter::once(*li)
+        .chain(li.descendants().filter_map(ElementRef::wrap))
+        .flat_map(|element| element.value().classes().collect::<Vec<_>>())
+        .find_map(language_of_class)
+        .unwrap_or_default()
+}
+
+/// 从配置中获取下载目录，以及按配置的策略创建的`DownloadedChecker`
+fn get_download_dir_and_checker(app: &AppHandle) -> (PathBuf, Box<dyn DownloadedChecker>) {
+    let config = app.state::<RwLock<Config>>();
+    let config = config.read();
+    (
+        config.download_dir.clone(),
+        config.downloaded_check_strategy.checker(),
+    )
+}
+
+/// 用章节列表所在的元素调用`f`，有隐藏数据时章节列表在解码后的隐藏html中
+fn with_chapter_div<T>(
+    document: &Html,
+    selectors: &PageSelectors,
+    f: impl FnOnce(&ElementRef) -> anyhow::Result<T>,
+) -> anyhow::Result<T> {
+    if let Some(hidden_html) = decode_hidden_html(document)? {
+        let fragment = Html::parse_fragment(&hidden_html);
+        return f(&fragment.root_element());
+    }
+
+    let chapter_div = document
+        .select(&Selector::parse(selectors.chapter).to_anyhow()?)
+        .next()
+        .context("没有找到章节列表的<div>")?;
+    f(&chapter_div)
+}
+
+/// 解码warning-bar下隐藏的章节列表html，没有隐藏数据时返回None
+pub fn decode_hidden_html(document: &Html) -> anyhow::Result<Option<String>> {
+    let Some(hidden_input) = document
+        .select(&Selector::parse("#__VIEWSTATE").to_anyhow()?)
+        .next()
+    else {
+        return Ok(None);
+    };
+
+    let compressed_data = hidden_input
+        .value()
+        .attr("value")
+        .context("没有在包含隐藏数据的<input>中找到value属性")?;
+
+    let hidden_html = lz_cache::decompress_from_base64(compressed_data)?;
+
+    Ok(Some(hidden_html))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_utils::load_fixture;
+
+    fn parse(name: &str, lazy_pages: &HashMap<(usize, usize), String>) -> Comic {
+        let html = load_fixture(name);
+        Comic::parse_html(&html, lazy_pages, Site::Www, ChapterDedupeScope::Group).unwrap()
+    }
+
+    /// `group_name`组的所有章节，按章节顺序排列
+    fn group_chapters(comic: &Comic, group_name: &str) -> Vec<ChapterInfo> {
+        let mut chapters = comic.groups[group_name].clone();
+        chapters.sort_by(|a, b| a.order.total_cmp(&b.order));
+        chapters
+    }
+
+    /// 按章节顺序排列的带序号的章节标题
+    fn chapter_titles(comic: &Comic, group_name: &str) -> Vec<String> {
+        group_chapters(comic, group_name)
+            .into_iter()
+            .map(|chapter_info| chapter_info.prefixed_chapter_title)
+            .collect()
+    }
+
+    fn chapter_ids(comic: &Comic, group_name: &str) -> Vec<i64> {
+        group_chapters(comic, group_name)
+            .into_iter()
+            .map(|chapter_info| chapter_info.chapter_id)
+            .collect()
+    }
+
+    #[test]
+    fn parse_comic() {
+        let comic = parse("comic.html", &HashMap::new());
+
+        assert_eq!(comic.id, 1234);
+        assert_eq!(comic.title, "测试漫画");
+        // 不读取本地的目录映射，目录名就是标题
+        assert_eq!(comic.dir_name, "测试漫画");
+        assert!(comic.former_titles.is_empty());
+        assert_eq!(comic.subtitle.as_deref(), Some("Test Comic"));
+        assert_eq!(comic.cover, "https://cf.mhgui.com/cpic/b/1234.jpg");
+        assert_eq!(comic.status, "连载中");
+        assert_eq!(comic.update_time, "2024-09-30");
+        assert_eq!(comic.year, 2019);
+        assert_eq!(comic.region, "日本");
+        assert_eq!(comic.genres, ["热血", "冒险"]);
+        assert_eq!(
+            comic.genre_tags[0],
+            GenreTag {
+                name: "热血".to_string(),
+                slug: "rexue".to_string(),
+            }
+        );
+        assert_eq!(comic.authors, ["作者甲", "作者乙"]);
+        assert_eq!(comic.aliases, ["测试别名", "Test Alias"]);
+        assert_eq!(comic.intro, "这是一本用来测试解析的漫画。");
+        assert_eq!(
+            comic.related,
+            [RelatedComic {
+                id: 2222,
+                title: "推荐漫画".to_string(),
+                cover: "https://cf.mhgui.com/cpic/m/2222.jpg".to_string(),
+            }]
+        );
+    }
+
+    #[test]
+    fn parse_tw_comic() {
+        let html = load_fixture("comic_tw.html");
+        let comic =
+            Comic::parse_html(&html, &HashMap::new(), Site::Tw, ChapterDedupeScope::Group).unwrap();
+
+        assert_eq!(comic.id, 1234);
+        assert_eq!(comic.title, "測試漫畫");
+        assert_eq!(comic.subtitle.as_deref(), Some("Test Comic"));
+        assert_eq!(comic.status, "連載中");
+        assert_eq!(comic.update_time, "2024-09-30");
+        assert_eq!(comic.year, 2019);
+        assert_eq!(comic.region, "日本");
+        assert_eq!(comic.genres, ["熱血", "冒險"]);
+        assert_eq!(comic.authors, ["作者甲"]);
+        assert_eq!(comic.aliases, ["測試別名"]);
+        assert_eq!(comic.intro, "這是一本用來測試解析的漫畫。");
+        assert_eq!(comic.groups.len(), 2);
+        assert_eq!(chapter_titles(&comic, "單話"), ["1 第1話", "2 第2話"]);
+        assert_eq!(chapter_ids(&comic, "單話"), [1001, 1002]);
+        assert_eq!(chapter_titles(&comic, "單行本"), ["1 第01卷"]);
+    }
+
+    #[test]
+    fn parse_comic_chapters() {
+        let comic = parse("comic.html", &HashMap::new());
+
+        assert_eq!(comic.groups.len(), 2);
+        // 每个分页内是倒序，分页之间是正序
+        assert_eq!(
+            chapter_titles(&comic, "单话"),
+            ["1 第1话", "2 第2话", "3 第3话", "4 第4话"]
+        );
+        assert_eq!(chapter_ids(&comic, "单话"), [1001, 1002, 1003, 1004]);
+        assert_eq!(chapter_titles(&comic, "单行本"), ["1 第01卷", "2 第02卷"]);
+
+        let chapter_info = &group_chapters(&comic, "单话")[3];
+        assert_eq!(chapter_info.chapter_title, "第4话");
+        assert_eq!(chapter_info.chapter_size, 17);
+        assert_eq!(chapter_info.comic_id, 1234);
+        assert_eq!(chapter_info.comic_title, "测试漫画");
+        assert_eq!(chapter_info.group_name, "单话");
+        assert_eq!(chapter_info.group_size, 4);
+        assert_eq!(chapter_info.comic_status, "连载中");
+        assert_eq!(chapter_info.is_downloaded, None);
+    }
+
+    #[test]
+    fn parse_comic_with_warning_bar() {
+        let comic = parse("comic_warning_bar.html", &HashMap::new());
+
+        assert_eq!(comic.id, 4321);
+        assert_eq!(comic.title, "隐藏章节漫画");
+        assert_eq!(comic.subtitle, None);
+        assert_eq!(comic.status, "已完结");
+        assert!(comic.aliases.is_empty());
+        // 章节列表只在解码后的隐藏html中
+        assert_eq!(comic.groups.len(), 1);
+        assert_eq!(chapter_titles(&comic, "单话"), ["1 第1话", "2 第2话"]);
+        assert_eq!(chapter_ids(&comic, "单话"), [5001, 5002]);
+    }
+
+    #[test]
+    fn parse_redesigned_comic() {
+        let comic = parse("comic_redesigned.html", &HashMap::new());
+
+        assert_eq!(comic.id, 5678);
+        // <h1>中嵌套的标签不算标题
+        assert_eq!(comic.title, "改版漫画");
+        assert_eq!(comic.subtitle, None);
+        // 没有`.hcover`时用`og:image`
+        assert_eq!(comic.cover, "https://cf.mhgui.com/cpic/b/5678.jpg");
+        assert_eq!(comic.region, "内地");
+        // 正序的分页不需要反转，没有补抓的懒加载分页没有章节
+        assert_eq!(chapter_titles(&comic, "单话"), ["1 第1话", "2 第2话"]);
+    }
+
+    #[test]
+    fn find_lazy_chapter_pages() {
+        let html = load_fixture("comic.html");
+        let lazy_pages = Comic::find_lazy_chapter_pages(&html, Site::Www).unwrap();
+        assert!(lazy_pages.is_empty());
+
+        let html = load_fixture("comic_redesigned.html");
+        let lazy_pages = Comic::find_lazy_chapter_pages(&html, Site::Www).unwrap();
+        assert_eq!(
+            lazy_pages,
+            [LazyChapterPage {
+                group_index: 0,
+                page_index: 1,
+                url: "https://www.manhuagui.com/comic/5678/p2.html".to_string(),
+            }]
+        );
+    }
+
+    #[test]
+    fn parse_comic_with_lazy_pages() {
+        let page_html = load_fixture("comic_redesigned_page2.html");
+        let ul_html = Comic::get_chapter_page_html(&page_html, 0, 1, Site::Www).unwrap();
+        let lazy_pages = HashMap::from([((0, 1), ul_html)]);
+
+        let comic = parse("comic_redesigned.html", &lazy_pages);
+
+        assert_eq!(
+            chapter_titles(&comic, "单话"),
+            ["1 第1话", "2 第2话", "3 第3话", "4 第4话"]
+        );
+        assert_eq!(chapter_ids(&comic, "单话"), [6001, 6002, 6003, 6004]);
+    }
+
+    #[test]
+    fn get_missing_chapter_page_html() {
+        let page_html = load_fixture("comic_redesigned_page2.html");
+        assert!(Comic::get_chapter_page_html(&page_html, 0, 2, Site::Www).is_err());
+        assert!(Comic::get_chapter_page_html(&page_html, 1, 0, Site::Www).is_err());
+    }
+
+    #[test]
+    fn get_chapter_numbers() {
+        assert_eq!(get_chapter_number("第12话"), Some(12.0));
+        assert_eq!(get_chapter_number("第3.5话"), Some(3.5));
+        assert_eq!(get_chapter_number("第01卷"), Some(1.0));
+        assert_eq!(get_chapter_number("Vol.2"), Some(2.0));
+        assert_eq!(get_chapter_number("07 上"), Some(7.0));
+        // 年份和标题中间的数字不是序号
+        assert_eq!(get_chapter_number("2021年特别篇"), None);
+        assert_eq!(get_chapter_number("番外 写给3年后的你"), None);
+    }
+
+    #[test]
+    fn sort_chapters_by_number() {
+        let sort = |titles: &[&'static str]| {
+            sort_by_chapter_number(titles.to_vec(), |title| get_chapter_number(title))
+        };
+        // 正序和倒序的结果相同
+        assert_eq!(
+            sort(&["第1话", "第2话", "第3话"]),
+            ["第1话", "第2话", "第3话"]
+        );
+        assert_eq!(
+            sort(&["第3话", "第2话", "第1话"]),
+            ["第1话", "第2话", "第3话"]
+        );
+        // 错位的章节回到序号对应的位置，没有序号的番外留在原位，序号相同的保持原来的先后
+        assert_eq!(
+            sort(&["第1话", "第3话", "番外", "第2话", "第4话 上", "第4话 下"]),
+            ["第1话", "第2话", "番外", "第3话", "第4话 上", "第4话 下"]
+        );
+    }
+
+    #[test]
+    fn parse_vote_stats() {
+        let json = r#"{"success":true,"data":{"s1":1,"s2":0,"s3":"2","s4":5,"s5":12}}"#;
+        let vote_stats = VoteStats::from_json(json).unwrap();
+        assert_eq!(vote_stats.star_counts, [1, 0, 2, 5, 12]);
+        assert_eq!(vote_stats.vote_count(), 20);
+        // (1 + 6 + 20 + 60) / 20 * 2 = 8.7
+        assert_eq!(vote_stats.rating(), Some(8.7));
+
+        // 还没有人评分
+        let json = r#"{"success":true,"data":{"s1":0,"s2":0,"s3":0,"s4":0,"s5":0}}"#;
+        let vote_stats = VoteStats::from_json(json).unwrap();
+        assert_eq!(vote_stats.vote_count(), 0);
+        assert_eq!(vote_stats.rating(), None);
+
+        assert!(VoteStats::from_json(r#"{"success":false}"#).is_err());
+        assert!(VoteStats::from_json(r#"{"data":{"s1":-1}}"#).is_err());
+    }
 }
@@ -0,0 +1,76 @@
+use std::collections::HashMap;
+
+use serde::{Deserialize, Serialize};
+use specta::Type;
+
+use crate::types::{ChapterInfo, Comic};
+
+/// 同一本漫画新旧两次获取结果之间的差异，前端可以据此只更新变化的章节，而不是替换整个漫画
+///
+/// 章节以(组名, 章节id)区分，同一章节出现在不同组中时视为不同的章节
+#[derive(Default, Debug, Clone, PartialEq, Serialize, Deserialize, Type)]
+#[serde(rename_all = "camelCase")]
+pub struct ComicDiff {
+    /// 漫画id
+    pub id: i64,
+    /// 最新的漫画状态(连载中/已完结)
+    pub status: String,
+    /// 最新的更新时间
+    pub update_time: String,
+    /// 新增的章节，同一组内按章节顺序排列
+    pub added: Vec<ChapterInfo>,
+    /// 内容有变化的章节(标题、页数、顺序、是否已下载等)，为变化后的版本
+    pub changed: Vec<ChapterInfo>,
+    /// 被移除的章节，为移除前的版本
+    pub removed: Vec<ChapterInfo>,
+}
+
+impl ComicDiff {
+    pub fn diff(old: &Comic, new: &Comic) -> ComicDiff {
+        let old_chapters = chapters_by_key(old);
+        let new_chapters = chapters_by_key(new);
+
+        let mut added = Vec::new();
+        let mut changed = Vec::new();
+        for (key, new_chapter) in &new_chapters {
+            match old_chapters.get(key) {
+                None => added.push((*new_chapter).clone()),
+                Some(old_chapter) if old_chapter != new_chapter => {
+                    changed.push((*new_chapter).clone());
+                }
+                Some(_) => {}
+            }
+        }
+        let mut removed = old_chapters
+            .iter()
+            .filter(|(key, _)| !new_chapters.contains_key(*key))
+            .map(|(_, old_chapter)| (*old_chapter).clone())
+            .collect::<Vec<_>>();
+
+        for chapters in [&mut added, &mut changed, &mut removed] {
+            chapters.sort_by(|a, b| {
+                a.group_name
+                    .cmp(&b.group_name)
+                    .then(a.order.total_cmp(&b.order))
+            });
+        }
+
+        ComicDiff {
+            id: new.id,
+            status: new.status.clone(),
+            update_time: new.update_time.clone(),
+            added,
+            changed,
+            removed,
+        }
+    }
+}
+
+fn chapters_by_key(comic: &Comic) -> HashMap<(&str, i64), &ChapterInfo> {
+    comic
+        .groups
+        .values()
+        .flatten()
+        .map(|chapter| ((chapter.group_name.as_str(), chapter.chapter_id), chapter))
+        .collect()
+}
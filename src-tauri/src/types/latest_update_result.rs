@@ -0,0 +1,165 @@
+use anyhow::Context;
+use scraper::{ElementRef, Html, Selector};
+use serde::{Deserialize, Serialize};
+use specta::Type;
+
+use crate::extensions::ToAnyhow;
+
+#[derive(Default, Debug, Clone, PartialEq, Serialize, Deserialize, Type)]
+#[serde(rename_all = "camelCase")]
+pub struct LatestUpdateResult {
+    comics: Vec<ComicInLatestUpdate>,
+    /// 当前页码
+    current: i64,
+    /// 总页数
+    total: i64,
+}
+
+impl LatestUpdateResult {
+    pub fn from_html(html: &str) -> anyhow::Result<LatestUpdateResult> {
+        let document = Html::parse_document(html);
+
+        let mut comics = Vec::new();
+        for li in document.select(&Selector::parse("#contList > li").to_anyhow()?) {
+            let comic = ComicInLatestUpdate::from_li(&li)?;
+            comics.push(comic);
+        }
+
+        let current = match document
+            .select(&Selector::parse(".pager .current").to_anyhow()?)
+            .next()
+        {
+            Some(span) => span
+                .text()
+                .next()
+                .context("没有在当前页码的<span>中找到文本")?
+                .trim()
+                .parse::<i64>()
+                .context("当前页码不是整数")?,
+            None => 1,
+        };
+        // 分页控件里最大的数字就是总页数，如果没有分页控件，说明只有一页
+        let total = document
+            .select(&Selector::parse(".pager a").to_anyhow()?)
+            .filter_map(|a| a.text().next()?.trim().parse::<i64>().ok())
+            .max()
+            .unwrap_or(1)
+            .max(current);
+
+        Ok(LatestUpdateResult {
+            comics,
+            current,
+            total,
+        })
+    }
+}
+
+#[derive(Default, Debug, Clone, PartialEq, Serialize, Deserialize, Type)]
+#[serde(rename_all = "camelCase")]
+pub struct ComicInLatestUpdate {
+    /// 漫画id
+    id: i64,
+    /// 漫画标题
+    title: String,
+    /// 封面链接
+    cover: String,
+    /// 最新章节标题
+    last_chapter: String,
+    /// 上次更新时间
+    update_time: String,
+}
+
+impl ComicInLatestUpdate {
+    pub fn from_li(li: &ElementRef) -> anyhow::Result<ComicInLatestUpdate> {
+        let a = li
+            .select(&Selector::parse(".bcover").to_anyhow()?)
+            .next()
+            .context("没有找到封面和链接的<a>")?;
+
+        let id = a
+            .value()
+            .attr("href")
+            .context("没有在封面和链接的<a>中找到href属性")?
+            .trim_start_matches("/comic/")
+            .trim_end_matches('/')
+            .parse::<i64>()
+            .context("漫画id不是整数")?;
+
+        let title = a
+            .value()
+            .attr("title")
+            .context("没有在封面和链接的<a>中找到title属性")?
+            .trim()
+            .to_string();
+
+        let img = a
+            .select(&Selector::parse("img").to_anyhow()?)
+            .next()
+            .context("没有找到封面的<img>")?;
+        // 靠后的封面是懒加载的，真正的链接在data-src里
+        let cover_src = img
+            .value()
+            .attr("data-src")
+            .or(img.value().attr("src"))
+            .context("没有在封面的<img>中找到src属性")?;
+        let cover = format!("https:{cover_src}");
+
+        let last_chapter = a
+            .select(&Selector::parse(".tt").to_anyhow()?)
+            .next()
+            .context("没有找到最新章节的<span>")?
+            .text()
+            .next()
+            .context("没有在最新章节的<span>中找到文本")?
+            .trim()
+            .trim_start_matches("更新至")
+            .to_string();
+
+        let update_time = li
+            .select(&Selector::parse(".updateon").to_anyhow()?)
+            .next()
+            .context("没有找到更新时间的<span>")?
+            .text()
+            .next()
+            .context("没有在更新时间的<span>中找到文本")?
+            .trim()
+            .trim_start_matches("更新于：")
+            .to_string();
+
+        Ok(ComicInLatestUpdate {
+            id,
+            title,
+            cover,
+            last_chapter,
+            update_time,
+        })
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_utils::load_fixture;
+
+    #[test]
+    fn parse_latest_update_result() {
+        let html = load_fixture("update.html");
+        let result = LatestUpdateResult::from_html(&html).unwrap();
+
+        assert_eq!(result.current, 1);
+        assert_eq!(result.total, 3);
+        assert_eq!(result.comics.len(), 2);
+
+        let comic = &result.comics[0];
+        assert_eq!(comic.id, 1234);
+        assert_eq!(comic.title, "测试漫画");
+        assert_eq!(comic.cover, "https://cf.mhgui.com/cpic/b/1234.jpg");
+        assert_eq!(comic.last_chapter, "第4话");
+        assert_eq!(comic.update_time, "2024-09-30");
+
+        // 懒加载的封面取data-src
+        let comic = &result.comics[1];
+        assert_eq!(comic.id, 5678);
+        assert_eq!(comic.cover, "https://cf.mhgui.com/cpic/b/5678.jpg");
+    }
+}
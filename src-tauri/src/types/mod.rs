@@ -1,11 +1,20 @@
+/// 解析规则的版本，网站改版导致解析规则变化时递增，方便排查问题时确认用户用的是哪套规则
+pub const PARSER_VERSION: u32 = 1;
+
 mod comic;
+mod comic_diff;
 mod comic_info;
 mod get_favorite_result;
+mod latest_update_result;
+mod rank_result;
 mod search_result;
 mod user_profile;
 
 pub use comic::*;
+pub use comic_diff::*;
 pub use comic_info::*;
 pub use get_favorite_result::*;
+pub use latest_update_result::*;
+pub use rank_result::*;
 pub use search_result::*;
 pub use user_profile::*;
@@ -0,0 +1,284 @@
+use anyhow::Context;
+use scraper::{ElementRef, Html, Selector};
+use serde::{Deserialize, Serialize};
+use specta::Type;
+
+use crate::{extensions::ToAnyhow, site::Site};
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize, Type)]
+pub enum RankType {
+    /// 日排行
+    Day,
+    /// 周排行
+    Week,
+    /// 月排行
+    Month,
+    /// 总排行
+    Total,
+}
+
+impl RankType {
+    /// 日排行是排行榜首页，其他榜单是首页下的`xxx.html`
+    pub fn url(self, site: Site) -> String {
+        let base_url = site.base_url();
+        match self {
+            RankType::Day => format!("{base_url}/rank/"),
+            RankType::Week => format!("{base_url}/rank/week.html"),
+            RankType::Month => format!("{base_url}/rank/month.html"),
+            RankType::Total => format!("{base_url}/rank/total.html"),
+        }
+    }
+}
+
+/// 排行榜表格中的一行，转换为`ComicInSearch`后返回给前端
+#[derive(Debug, Clone, PartialEq)]
+pub struct RankedComic {
+    /// 排名
+    pub rank: i64,
+    pub id: i64,
+    pub title: String,
+    /// 封面链接，榜单没有封面时为空字符串
+    pub cover: String,
+    pub authors: Vec<String>,
+    /// 最新章节标题，榜单没有这一列时为None
+    pub last_chapter: Option<String>,
+    /// 上次更新时间，榜单没有这一列时为空字符串
+    pub update_time: String,
+    /// 人气值，榜单没有这一列时为None
+    pub popularity: Option<i64>,
+}
+
+/// 排行榜表格中各列的位置
+///
+/// 日/周/月榜和总榜的列不完全一样(总榜没有更新时间，人气列的表头也不同)，
+/// 单元格上也不一定有`rank-xxx`的class，所以按表头文字确定每一列的位置
+#[derive(Default, Debug, Clone, Copy, PartialEq, Eq)]
+struct RankColumns {
+    rank: Option<usize>,
+    title: Option<usize>,
+    author: Option<usize>,
+    last_chapter: Option<usize>,
+    update_time: Option<usize>,
+    popularity: Option<usize>,
+}
+
+impl RankColumns {
+    fn from_header(tr: &ElementRef) -> anyhow::Result<RankColumns> {
+        let mut columns = RankColumns::default();
+        for (index, th) in tr.select(&Selector::parse("th").to_anyhow()?).enumerate() {
+            let text = th.text().collect::<String>();
+            let column = if text.contains("排名") {
+                &mut columns.rank
+            } else if text.contains("名称") || text.contains("漫画名") {
+                &mut columns.title
+            } else if text.contains("作者") {
+                &mut columns.author
+            } else if text.contains("章节") {
+                &mut columns.last_chapter
+            } else if text.contains("时间") {
+                &mut columns.update_time
+            } else if ["评分", "人气", "热度", "点击"]
+                .iter()
+                .any(|keyword| text.contains(keyword))
+            {
+                &mut columns.popularity
+            } else {
+                continue;
+            };
+            column.get_or_insert(index);
+        }
+        Ok(columns)
+    }
+
+    /// 没有表头时使用日排行的列顺序
+    fn day_board() -> RankColumns {
+        RankColumns {
+            rank: Some(0),
+            title: Some(1),
+            author: Some(2),
+            last_chapter: Some(3),
+            update_time: Some(4),
+            popularity: Some(5),
+        }
+    }
+}
+
+/// 解析排行榜页面中的所有漫画，排行榜只有一页，分页由`SearchResult::from_ranked`在本地完成
+pub fn parse_rank_html(html: &str, rank_type: RankType) -> anyhow::Result<Vec<RankedComic>> {
+    let document = Html::parse_document(html);
+    let tr_selector = Selector::parse(".rank-detail tr").to_anyhow()?;
+    let th_selector = Selector::parse("th").to_anyhow()?;
+    let title_selector = Selector::parse("a[href^='/comic/']").to_anyhow()?;
+
+    let mut columns = None;
+    let mut ranked_comics = Vec::new();
+    for tr in document.select(&tr_selector) {
+        if columns.is_none() && tr.select(&th_selector).next().is_some() {
+            columns = Some(RankColumns::from_header(&tr)?);
+            continue;
+        }
+        // 分隔行里没有漫画链接，跳过
+        if tr.select(&title_selector).next().is_none() {
+            continue;
+        }
+        let columns = columns.unwrap_or_else(RankColumns::day_board);
+        let ranked_comic = RankedComic::from_tr(&tr, &columns).context(format!(
+            "解析{rank_type:?}榜第{}行失败",
+            ranked_comics.len() + 1
+        ))?;
+        ranked_comics.push(ranked_comic);
+    }
+
+    Ok(ranked_comics)
+}
+
+impl RankedComic {
+    fn from_tr(tr: &ElementRef, columns: &RankColumns) -> anyhow::Result<RankedComic> {
+        let tds = tr
+            .select(&Selector::parse("td").to_anyhow()?)
+            .collect::<Vec<_>>();
+        // 优先用单元格上的class，没有时再按表头确定的列取
+        let cell = |class: &str, index: Option<usize>| -> anyhow::Result<Option<ElementRef>> {
+            let selector = Selector::parse(&format!("td.{class}")).to_anyhow()?;
+            let td = tr
+                .select(&selector)
+                .next()
+                .or_else(|| index.and_then(|index| tds.get(index).copied()));
+            Ok(td)
+        };
+        let cell_text = |td: ElementRef| td.text().collect::<String>().trim().to_string();
+
+        let rank = cell("rank-no", columns.rank)?
+            .context("没有找到排名的<td>")
+            .map(cell_text)?
+            .parse::<i64>()
+            .context("排名不是整数")?;
+
+        let title_td = cell("rank-title", columns.title)?.context("没有找到标题的<td>")?;
+        let a = title_td
+            .select(&Selector::parse("a[href^='/comic/']").to_anyhow()?)
+            .next()
+            .context("没有找到标题和链接的<a>")?;
+
+        let id = a
+            .value()
+            .attr("href")
+            .context("没有在标题和链接的<a>中找到href属性")?
+            .trim_start_matches("/comic/")
+            .trim_end_matches('/')
+            .parse::<i64>()
+            .context("漫画id不是整数")?;
+
+        let title = match a.value().attr("title") {
+            Some(title) => title.trim().to_string(),
+            None => a.text().collect::<String>().trim().to_string(),
+        };
+
+        let cover = get_cover(tr)?;
+
+        let authors = match cell("rank-author", columns.author)? {
+            Some(td) => td
+                .select(&Selector::parse("a").to_anyhow()?)
+                .map(|a| a.text().collect::<String>().trim().to_string())
+                .filter(|author| !author.is_empty())
+                .collect(),
+            None => Vec::new(),
+        };
+
+        let last_chapter = cell("rank-update", columns.last_chapter)?
+            .map(cell_text)
+            .filter(|text| !text.is_empty());
+
+        let update_time = cell("rank-time", columns.update_time)?
+            .map(cell_text)
+            .unwrap_or_default();
+
+        let popularity = cell("rank-score", columns.popularity)?
+            .map(|td| cell_text(td).replace(',', ""))
+            .and_then(|score| score.parse::<i64>().ok());
+
+        Ok(RankedComic {
+            rank,
+            id,
+            title,
+            cover,
+            authors,
+            last_chapter,
+            update_time,
+            popularity,
+        })
+    }
+}
+
+/// 有封面的榜单把封面图放在行内，懒加载的图片地址在`data-src`中，没有封面时返回空字符串
+fn get_cover(tr: &ElementRef) -> anyhow::Result<String> {
+    let Some(img) = tr.select(&Selector::parse("img").to_anyhow()?).next() else {
+        return Ok(String::new());
+    };
+    let src = img
+        .value()
+        .attr("data-src")
+        .or_else(|| img.value().attr("src"))
+        .unwrap_or_default()
+        .trim();
+    let cover = match src.strip_prefix("//") {
+        Some(src) => format!("https://{src}"),
+        None => src.to_string(),
+    };
+    Ok(cover)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_utils::load_fixture;
+
+    #[test]
+    fn parse_day_rank() {
+        let html = load_fixture("rank.html");
+        let ranked_comics = parse_rank_html(&html, RankType::Day).unwrap();
+
+        // 表头和分隔行被跳过
+        assert_eq!(ranked_comics.len(), 2);
+
+        let comic = &ranked_comics[0];
+        assert_eq!(comic.rank, 1);
+        assert_eq!(comic.id, 1234);
+        assert_eq!(comic.title, "测试漫画");
+        assert_eq!(comic.cover, "https://cf.mhgui.com/cpic/h/1234.jpg");
+        assert_eq!(comic.authors, ["作者甲", "作者乙"]);
+        assert_eq!(comic.last_chapter.as_deref(), Some("第4话"));
+        assert_eq!(comic.update_time, "2024-09-30");
+        assert_eq!(comic.popularity, Some(12345));
+
+        // 没有title属性时用链接的文本，没有封面时留空
+        let comic = &ranked_comics[1];
+        assert_eq!(comic.rank, 2);
+        assert_eq!(comic.title, "隐藏章节漫画");
+        assert_eq!(comic.cover, "");
+        assert_eq!(comic.popularity, Some(678));
+    }
+
+    #[test]
+    fn parse_total_rank() {
+        let html = load_fixture("rank_total.html");
+        let ranked_comics = parse_rank_html(&html, RankType::Total).unwrap();
+
+        // 总榜的单元格没有class，列也不同，按表头定位
+        assert_eq!(ranked_comics.len(), 2);
+
+        let comic = &ranked_comics[0];
+        assert_eq!(comic.rank, 1);
+        assert_eq!(comic.id, 4321);
+        assert_eq!(comic.title, "隐藏章节漫画");
+        assert_eq!(comic.authors, ["作者丙"]);
+        assert_eq!(comic.last_chapter.as_deref(), Some("第2话"));
+        assert_eq!(comic.update_time, "");
+        assert_eq!(comic.popularity, Some(9_876_543));
+
+        let comic = &ranked_comics[1];
+        assert_eq!(comic.rank, 2);
+        assert_eq!(comic.id, 1234);
+        assert_eq!(comic.popularity, Some(1_234_567));
+    }
+}
@@ -1,20 +1,91 @@
+use std::{collections::HashMap, path::Path, sync::LazyLock};
+
 use anyhow::Context;
+use regex::Regex;
 use scraper::{ElementRef, Html, Selector};
 use serde::{Deserialize, Serialize};
 use specta::Type;
 
-use crate::extensions::ToAnyhow;
+use crate::{
+    downloaded_checker::image_paths,
+    extensions::ToAnyhow,
+    types::RankedComic,
+    utils::{collapse_whitespace, filename_filter, sub_dirs},
+    zh_convert,
+};
+
+/// 漫画目录中存放元数据的文件名
+const METADATA_FILENAME: &str = "元数据.json";
+
+/// 搜错字时，网站会在结果上方显示`您是不是要找 xxx`
+const SUGGESTION_MARKER: &str = "是不是要找";
+
+/// 网站搜索结果每页的漫画数，用于分页控件解析不到时估算总页数，排行榜也按这个数量在本地分页
+const PAGE_SIZE: i64 = 10;
+
+/// 分页链接中的页码，例如`/s/海贼王_o2_p3.html`中的`3`
+static PAGE_NUM_IN_HREF_RE: LazyLock<Regex> =
+    LazyLock::new(|| Regex::new(r"_p(\d+)\.html").unwrap());
+
+/// 搜索结果的排序方式，与搜索页顶部的排序选项一一对应
+#[derive(Default, Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize, Type)]
+pub enum SearchSort {
+    /// 最新更新，也是网站的默认排序
+    #[default]
+    Update,
+    /// 最近发布
+    Publish,
+    /// 人气最旺
+    Popularity,
+    /// 评分最高
+    Rating,
+}
+
+impl SearchSort {
+    /// 拼在搜索页url中关键词后面的排序参数，例如`/s/海贼王_o2_p1.html`
+    pub fn url_suffix(self) -> &'static str {
+        match self {
+            SearchSort::Update => "",
+            SearchSort::Publish => "_o1",
+            SearchSort::Popularity => "_o2",
+            SearchSort::Rating => "_o3",
+        }
+    }
+}
+
+/// 在当前页的搜索结果上做的本地过滤，网站的搜索经常带出无关的结果，不会产生额外的请求
+#[derive(Default, Debug, Clone, PartialEq, Eq, Serialize, Deserialize, Type)]
+#[serde(rename_all = "camelCase")]
+pub struct SearchFilter {
+    /// 只保留已完结的漫画
+    pub finished_only: bool,
+    /// 只保留作者中有人包含这个名字的漫画，为空时不过滤
+    pub author: String,
+    /// 只保留标题、副标题或别名中真正包含搜索关键词的漫画
+    pub title_contains_keyword: bool,
+}
 
 #[derive(Default, Debug, Clone, PartialEq, Serialize, Deserialize, Type)]
 #[serde(rename_all = "camelCase")]
 pub struct SearchResult {
     comics: Vec<ComicInSearch>,
     current: i64,
+    /// 总结果数
     total: i64,
+    /// 总页数，以分页控件中最大的页码为准，解析不到时按总结果数估算
+    total_page: i64,
+    /// 实际用于搜索的关键词，简繁转换后可能与用户输入的不同
+    keyword: String,
+    /// 当前结果的排序方式
+    sort: SearchSort,
+    /// 网站给出的纠错建议，即`您是不是要找 xxx`中的`xxx`，没有时为None
+    suggestion: Option<String>,
+    /// 当前页按`SearchFilter`过滤掉的漫画数，没有过滤时为0
+    filtered_out: u32,
 }
 
 impl SearchResult {
-    pub fn from_html(html: &str) -> anyhow::Result<SearchResult> {
+    pub fn from_html(html: &str, keyword: &str, sort: SearchSort) -> anyhow::Result<SearchResult> {
         let document = Html::parse_document(html);
         let book_result_selector = Selector::parse(".book-result .cf").to_anyhow()?;
 
@@ -47,12 +118,253 @@ impl SearchResult {
             .parse::<i64>()
             .context("总结果数不是整数")?;
 
+        let total_page = match parse_last_page_num(&document)? {
+            Some(last_page_num) => last_page_num.max(current),
+            None => ((total + PAGE_SIZE - 1) / PAGE_SIZE).max(current),
+        };
+
         Ok(SearchResult {
             comics,
             current,
             total,
+            total_page,
+            keyword: keyword.to_string(),
+            sort,
+            suggestion: parse_suggestion(&document)?,
+            filtered_out: 0,
+        })
+    }
+
+    /// 排行榜只有一页，所以在本地按`PAGE_SIZE`分页，总结果数和总页数的含义与搜索结果相同
+    #[allow(clippy::cast_possible_wrap)]
+    #[allow(clippy::cast_sign_loss)]
+    #[allow(clippy::cast_possible_truncation)]
+    pub fn from_ranked(ranked_comics: Vec<RankedComic>, page_num: i64) -> SearchResult {
+        let total = ranked_comics.len() as i64;
+        let total_page = ((total + PAGE_SIZE - 1) / PAGE_SIZE).max(1);
+        let current = page_num.clamp(1, total_page);
+        let comics = ranked_comics
+            .into_iter()
+            .skip(((current - 1) * PAGE_SIZE) as usize)
+            .take(PAGE_SIZE as usize)
+            .map(ComicInSearch::from)
+            .collect();
+
+        SearchResult {
+            comics,
+            current,
+            total,
+            total_page,
+            keyword: String::new(),
+            sort: SearchSort::Popularity,
+            suggestion: None,
+            filtered_out: 0,
+        }
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.comics.is_empty()
+    }
+
+    /// 只保留当前页中符合`filter`的漫画，总数和页数仍然是网站给出的，翻页照常进行
+    ///
+    /// 比较时忽略空白、大小写和简繁差异，过滤掉的数量记在`filtered_out`中
+    pub fn filter(mut self, filter: &SearchFilter) -> SearchResult {
+        let comic_count = self.comics.len();
+        let author = normalize_for_filter(&filter.author);
+        let keyword = normalize_for_filter(&self.keyword);
+        self.comics.retain(|comic| {
+            if filter.finished_only && !comic.is_finished {
+                return false;
+            }
+            if !author.is_empty()
+                && !comic
+                    .authors
+                    .iter()
+                    .any(|name| normalize_for_filter(name).contains(&author))
+            {
+                return false;
+            }
+            if filter.title_contains_keyword && !keyword.is_empty() {
+                let mut titles = std::iter::once(&comic.title)
+                    .chain(comic.subtitle.as_ref())
+                    .chain(&comic.aliases);
+                if !titles.any(|title| normalize_for_filter(title).contains(&keyword)) {
+                    return false;
+                }
+            }
+            true
+        });
+        self.filtered_out = u32::try_from(comic_count - self.comics.len()).unwrap_or(u32::MAX);
+        self
+    }
+
+    /// 检查`download_dir`中是否已有搜索结果中的漫画，填充`is_local_downloaded`和`local_chapter_count`
+    ///
+    /// 优先用元数据中的漫画id匹配，没有元数据的目录再按清洗后的标题匹配
+    pub fn mark_local_downloaded(&mut self, download_dir: &Path) {
+        let local_comics = LocalComicIndex::new(download_dir);
+        for comic in &mut self.comics {
+            if let Some(chapter_count) = local_comics.chapter_count(comic.id, &comic.title) {
+                comic.is_local_downloaded = true;
+                comic.local_chapter_count = chapter_count;
+            }
+        }
+    }
+}
+
+fn normalize_for_filter(s: &str) -> String {
+    zh_convert::to_simplified(s)
+        .to_lowercase()
+        .split_whitespace()
+        .collect()
+}
+
+/// 解析分页控件中最大的页码，没有分页控件(只有一页)时返回None
+///
+/// 页码多时分页控件只显示当前页附近的几页，所以除了链接文本，还要看`尾页`等链接的href中的页码
+fn parse_last_page_num(document: &Html) -> anyhow::Result<Option<i64>> {
+    let page_selector = Selector::parse(".flickr a, .flickr .current").to_anyhow()?;
+    let last_page_num = document
+        .select(&page_selector)
+        .flat_map(|element| {
+            let num_in_text = element
+                .text()
+                .collect::<String>()
+                .trim()
+                .parse::<i64>()
+                .ok();
+            let num_in_href = element
+                .value()
+                .attr("href")
+                .and_then(|href| PAGE_NUM_IN_HREF_RE.captures(href))
+                .and_then(|captures| captures[1].parse::<i64>().ok());
+            [num_in_text, num_in_href]
         })
+        .flatten()
+        .max();
+    Ok(last_page_num)
+}
+
+/// 解析纠错建议，优先取建议区块中的链接文本，没有链接时取提示文本后面的部分
+///
+/// 大部分搜索结果页都没有建议区块，找不到时返回None
+fn parse_suggestion(document: &Html) -> anyhow::Result<Option<String>> {
+    let block_selector = Selector::parse("div, p, span").to_anyhow()?;
+    let link_selector = Selector::parse("a").to_anyhow()?;
+    // 外层元素的文本也包含提示文本，取文本最短的，即最内层的建议区块
+    let Some(block) = document
+        .select(&block_selector)
+        .filter(|element| element.text().any(|text| text.contains(SUGGESTION_MARKER)))
+        .min_by_key(|element| element.text().map(str::len).sum::<usize>())
+    else {
+        return Ok(None);
+    };
+
+    let suggestion = match block.select(&link_selector).next() {
+        Some(a) => a.text().collect::<String>(),
+        None => {
+            let text = block.text().collect::<String>();
+            text.split_once(SUGGESTION_MARKER)
+                .map(|(_, rest)| rest.to_string())
+                .unwrap_or_default()
+        }
+    };
+    let suggestion = collapse_whitespace(&suggestion)
+        .trim_matches(|c: char| c.is_whitespace() || "?？:：「」\"“”".contains(c))
+        .to_string();
+    if suggestion.is_empty() {
+        return Ok(None);
     }
+    Ok(Some(suggestion))
+}
+
+/// 下载目录中的漫画，用于判断搜索结果是否已下载
+struct LocalComicIndex {
+    /// key为漫画id，value为已下载的章节数
+    by_id: HashMap<i64, u32>,
+    /// key为规范化后的目录名，value为已下载的章节数
+    by_title: HashMap<String, u32>,
+}
+
+impl LocalComicIndex {
+    fn new(download_dir: &Path) -> Self {
+        let mut index = LocalComicIndex {
+            by_id: HashMap::new(),
+            by_title: HashMap::new(),
+        };
+        let Ok(entries) = std::fs::read_dir(download_dir) else {
+            return index;
+        };
+        for comic_dir in entries.filter_map(Result::ok).map(|entry| entry.path()) {
+            if !comic_dir.is_dir() {
+                continue;
+            }
+            let chapter_count = count_chapter_dirs(&comic_dir);
+            match read_comic_id(&comic_dir) {
+                Some(id) => {
+                    index.by_id.insert(id, chapter_count);
+                }
+                None => {
+                    let dir_name = comic_dir.file_name().unwrap_or_default().to_string_lossy();
+                    index
+                        .by_title
+                        .insert(normalize_title(&dir_name), chapter_count);
+                }
+            }
+        }
+        index
+    }
+
+    fn chapter_count(&self, id: i64, title: &str) -> Option<u32> {
+        self.by_id
+            .get(&id)
+            .or_else(|| self.by_title.get(&normalize_title(title)))
+            .copied()
+    }
+}
+
+/// 只读元数据中的漫画id，不需要完整解析为`Comic`
+fn read_comic_id(comic_dir: &Path) -> Option<i64> {
+    let metadata_string = std::fs::read_to_string(comic_dir.join(METADATA_FILENAME)).ok()?;
+    let metadata = serde_json::from_str::<serde_json::Value>(&metadata_string).ok()?;
+    metadata.get("id")?.as_i64()
+}
+
+/// 漫画目录下是章节组目录，章节组目录下是章节目录，旧版本遗留的`.下载中-`临时目录不算
+///
+/// 平铺结构下漫画目录下直接就是章节目录，直接包含图片的目录算作一个章节
+#[allow(clippy::cast_possible_truncation)]
+fn count_chapter_dirs(comic_dir: &Path) -> u32 {
+    let child_dirs = |dir: &Path| {
+        sub_dirs(dir)
+            .into_iter()
+            .filter(|path| {
+                !path
+                    .file_name()
+                    .is_some_and(|name| name.to_string_lossy().starts_with(".下载中-"))
+            })
+            .collect::<Vec<_>>()
+    };
+    child_dirs(comic_dir)
+        .iter()
+        .map(|dir| {
+            if image_paths(dir).is_empty() {
+                child_dirs(dir).len() as u32
+            } else {
+                1
+            }
+        })
+        .sum()
+}
+
+/// 目录名是经过`filename_filter`清洗的标题，标题也按同样的方式清洗后再比较，同时忽略空白、大小写和简繁差异
+fn normalize_title(title: &str) -> String {
+    let title = filename_filter(&collapse_whitespace(title));
+    zh_convert::to_simplified(&title)
+        .to_lowercase()
+        .split_whitespace()
+        .collect()
 }
 
 #[derive(Default, Debug, Clone, PartialEq, Serialize, Deserialize, Type)]
@@ -70,6 +382,10 @@ pub struct ComicInSearch {
     status: String,
     /// 上次更新时间
     update_time: String,
+    /// 最新章节标题(第120话、全一卷...)
+    last_chapter: Option<String>,
+    /// 是否已完结
+    is_finished: bool,
     /// 出版年份
     year: i64,
     /// 地区
@@ -82,6 +398,18 @@ pub struct ComicInSearch {
     aliases: Vec<String>,
     /// 简介
     intro: String,
+    /// 下载目录中是否已有这本漫画
+    #[serde(default)]
+    is_local_downloaded: bool,
+    /// 下载目录中这本漫画已下载的章节数
+    #[serde(default)]
+    local_chapter_count: u32,
+    /// 在排行榜中的名次，不是来自排行榜时为None
+    #[serde(default)]
+    rank: Option<i64>,
+    /// 排行榜上的人气值，不是来自排行榜或榜单没有这一列时为None
+    #[serde(default)]
+    popularity: Option<i64>,
 }
 
 impl ComicInSearch {
@@ -112,6 +440,8 @@ impl ComicInSearch {
 
         let status_dd = dds.first().context("没有找到漫画状态和更新时间的dd")?;
         let (status, update_time) = get_status_and_update_time(status_dd)?;
+        let last_chapter = get_last_chapter(status_dd)?;
+        let is_finished = get_is_finished(&status, last_chapter.as_deref());
 
         let info_dd = dds.get(1).context("没有找到年份、地区、类型的dd")?;
         let (year, region, genres) = get_year_and_region_and_genres(info_dd)?;
@@ -148,16 +478,41 @@ impl ComicInSearch {
             cover,
             status,
             update_time,
+            last_chapter,
+            is_finished,
             year,
             region,
             genres,
             authors,
             aliases,
             intro,
+            is_local_downloaded: false,
+            local_chapter_count: 0,
+            rank: None,
+            popularity: None,
         })
     }
 }
 
+impl From<RankedComic> for ComicInSearch {
+    /// 排行榜上没有的信息(状态、年份、简介等)留空
+    fn from(ranked: RankedComic) -> Self {
+        let is_finished = get_is_finished("", ranked.last_chapter.as_deref());
+        ComicInSearch {
+            id: ranked.id,
+            title: ranked.title,
+            cover: ranked.cover,
+            update_time: ranked.update_time,
+            last_chapter: ranked.last_chapter,
+            is_finished,
+            authors: ranked.authors,
+            rank: Some(ranked.rank),
+            popularity: ranked.popularity,
+            ..ComicInSearch::default()
+        }
+    }
+}
+
 fn get_id_and_title_and_subtitle(dt: ElementRef) -> anyhow::Result<(i64, String, Option<String>)> {
     let a = dt
         .select(&Selector::parse("dt > a").to_anyhow()?)
@@ -214,6 +569,25 @@ fn get_status_and_update_time(status_dd: &ElementRef) -> anyhow::Result<(String,
     Ok((status, update_time))
 }
 
+fn get_last_chapter(status_dd: &ElementRef) -> anyhow::Result<Option<String>> {
+    let last_chapter = status_dd
+        .select(&Selector::parse("a").to_anyhow()?)
+        .next()
+        .map(|a| a.text().collect::<String>().trim().to_string())
+        .filter(|text| !text.is_empty());
+
+    Ok(last_chapter)
+}
+
+/// 有的漫画状态写的是`连载`，但最新章节却是`全一卷`、`完结`之类的，这种也算已完结
+fn get_is_finished(status: &str, last_chapter: Option<&str>) -> bool {
+    if status.contains("完结") {
+        return true;
+    }
+
+    last_chapter.is_some_and(|chapter| chapter.starts_with('全') || chapter.contains("完结"))
+}
+
 fn get_year_and_region_and_genres(
     info_dd: &ElementRef,
 ) -> anyhow::Result<(i64, String, Vec<String>)> {
@@ -257,3 +631,83 @@ fn get_year_and_region_and_genres(
 
     Ok((year, region, genres))
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_utils::load_fixture;
+
+    #[test]
+    fn paginate_ranked_comics() {
+        let html = load_fixture("rank.html");
+        let ranked_comics =
+            crate::types::parse_rank_html(&html, crate::types::RankType::Day).unwrap();
+        let ranked_comics = ranked_comics
+            .into_iter()
+            .cycle()
+            .take(12)
+            .collect::<Vec<_>>();
+
+        let result = SearchResult::from_ranked(ranked_comics.clone(), 2);
+        assert_eq!(result.current, 2);
+        assert_eq!(result.total, 12);
+        assert_eq!(result.total_page, 2);
+        assert_eq!(result.comics.len(), 2);
+        assert_eq!(result.comics[0].rank, Some(1));
+        assert_eq!(result.comics[0].popularity, Some(12345));
+        assert_eq!(
+            result.comics[0].cover,
+            "https://cf.mhgui.com/cpic/h/1234.jpg"
+        );
+
+        // 超出范围的页码按最后一页处理
+        let result = SearchResult::from_ranked(ranked_comics, 5);
+        assert_eq!(result.current, 2);
+    }
+
+    #[test]
+    fn parse_search_result() {
+        let html = load_fixture("search.html");
+        let result = SearchResult::from_html(&html, "测试", SearchSort::Update).unwrap();
+
+        assert_eq!(result.current, 2);
+        assert_eq!(result.total, 12);
+        assert_eq!(result.keyword, "测试");
+        assert_eq!(result.suggestion, None);
+        assert_eq!(result.comics.len(), 2);
+
+        let comic = &result.comics[0];
+        assert_eq!(comic.id, 1234);
+        assert_eq!(comic.title, "测试漫画");
+        assert_eq!(comic.subtitle.as_deref(), Some("Test Comic"));
+        assert_eq!(comic.cover, "https://cf.mhgui.com/cpic/h/1234.jpg");
+        assert_eq!(comic.status, "连载中");
+        assert_eq!(comic.update_time, "2024-09-30");
+        assert_eq!(comic.last_chapter.as_deref(), Some("第4话"));
+        assert!(!comic.is_finished);
+        assert_eq!(comic.year, 2019);
+        assert_eq!(comic.region, "日本");
+        assert_eq!(comic.genres, ["热血", "冒险"]);
+        assert_eq!(comic.authors, ["作者甲", "作者乙"]);
+        assert_eq!(comic.aliases, ["测试别名"]);
+        assert_eq!(comic.intro, "这是一本用来测试解析的漫画。");
+
+        let comic = &result.comics[1];
+        assert_eq!(comic.id, 4321);
+        assert_eq!(comic.subtitle, None);
+        assert!(comic.aliases.is_empty());
+        // 状态是连载中，但最新章节是`全一卷`
+        assert!(comic.is_finished);
+    }
+
+    #[test]
+    fn parse_empty_search_result() {
+        let html = load_fixture("search_empty.html");
+        let result = SearchResult::from_html(&html, "海贼网", SearchSort::Update).unwrap();
+
+        assert!(result.is_empty());
+        assert_eq!(result.current, 1);
+        assert_eq!(result.total, 0);
+        assert_eq!(result.suggestion.as_deref(), Some("海贼王"));
+    }
+}
@@ -1,3 +1,12 @@
+use std::{
+    fs::File,
+    io::{BufWriter, Write},
+    path::{Path, PathBuf},
+};
+
+use anyhow::Context;
+use sysinfo::Disks;
+
 pub fn filename_filter(s: &str) -> String {
     s.chars()
         .map(|c| match c {
@@ -15,3 +24,137 @@ pub fn filename_filter(s: &str) -> String {
         .trim()
         .to_string()
 }
+
+/// `dir`下的所有子目录，按路径排序，读取失败时返回空
+pub fn sub_dirs(dir: &Path) -> Vec<PathBuf> {
+    let Ok(entries) = std::fs::read_dir(dir) else {
+        return Vec::new();
+    };
+    let mut dirs = entries
+        .filter_map(Result::ok)
+        .map(|entry| entry.path())
+        .filter(|path| path.is_dir())
+        .collect::<Vec<_>>();
+    dirs.sort();
+    dirs
+}
+
+/// 去掉首尾空白，并把中间连续的空白(包括换行)折叠成一个空格
+pub fn collapse_whitespace(s: &str) -> String {
+    s.split_whitespace().collect::<Vec<_>>().join(" ")
+}
+
+/// 解码percent编码(例如`%40`)的字符串，不合法的转义原样保留
+pub fn percent_decode(s: &str) -> String {
+    let bytes = s.as_bytes();
+    let mut decoded = Vec::with_capacity(bytes.len());
+    let mut i = 0;
+    while i < bytes.len() {
+        let hex = bytes
+            .get(i + 1..i + 3)
+            .and_then(|hex| std::str::from_utf8(hex).ok())
+            .and_then(|hex| u8::from_str_radix(hex, 16).ok());
+        match hex {
+            Some(byte) if bytes[i] == b'%' => {
+                decoded.push(byte);
+                i += 3;
+            }
+            _ => {
+                decoded.push(bytes[i]);
+                i += 1;
+            }
+        }
+    }
+    String::from_utf8_lossy(&decoded).to_string()
+}
+
+/// 把`data`写入`path`，父目录不存在时会自动创建
+///
+/// 先写入临时文件再重命名，避免写到一半时崩溃导致`path`损坏或不完整
+pub fn write_atomic(path: &Path, data: impl AsRef<[u8]>) -> anyhow::Result<()> {
+    write_atomic_with(path, |writer| {
+        writer.write_all(data.as_ref())?;
+        Ok(())
+    })
+}
+
+/// 与`write_atomic`相同，只是由`write`边生成边写入，适合zip之类不方便先整个放进内存的内容
+///
+/// `write`失败时删除临时文件，`path`保持原样
+pub fn write_atomic_with(
+    path: &Path,
+    write: impl FnOnce(&mut BufWriter<File>) -> anyhow::Result<()>,
+) -> anyhow::Result<()> {
+    if let Some(parent) = path.parent() {
+        std::fs::create_dir_all(parent).context(format!("创建目录`{parent:?}`失败"))?;
+    }
+    let part_path = path.with_extension("part");
+    let file = File::create(&part_path).context(format!("创建文件`{part_path:?}`失败"))?;
+    let mut writer = BufWriter::new(file);
+    let result = write(&mut writer).and_then(|()| {
+        writer.flush()?;
+        Ok(())
+    });
+    if let Err(err) = result {
+        drop(writer);
+        let _ = std::fs::remove_file(&part_path);
+        return Err(err.context(format!("写入`{part_path:?}`失败")));
+    }
+    drop(writer);
+    std::fs::rename(&part_path, path)
+        .context(format!("将`{part_path:?}`重命名为`{path:?}`失败"))?;
+    Ok(())
+}
+
+/// 把目录`from`移动到`to`，`to`的父目录不存在时会自动创建
+///
+/// `from`和`to`不在同一个磁盘上时无法直接重命名，此时先复制再删除`from`
+pub fn move_dir(from: &Path, to: &Path) -> anyhow::Result<()> {
+    if let Some(parent) = to.parent() {
+        std::fs::create_dir_all(parent).context(format!("创建目录`{parent:?}`失败"))?;
+    }
+    if std::fs::rename(from, to).is_ok() {
+        return Ok(());
+    }
+    copy_dir(from, to).context(format!("将`{from:?}`复制到`{to:?}`失败"))?;
+    std::fs::remove_dir_all(from).context(format!("删除目录`{from:?}`失败"))?;
+    Ok(())
+}
+
+fn copy_dir(from: &Path, to: &Path) -> anyhow::Result<()> {
+    std::fs::create_dir_all(to).context(format!("创建目录`{to:?}`失败"))?;
+    for entry in std::fs::read_dir(from).context(format!("读取目录`{from:?}`失败"))? {
+        let path = entry?.path();
+        let Some(file_name) = path.file_name() else {
+            continue;
+        };
+        let target = to.join(file_name);
+        if path.is_dir() {
+            copy_dir(&path, &target)?;
+        } else {
+            std::fs::copy(&path, &target).context(format!("将`{path:?}`复制到`{target:?}`失败"))?;
+        }
+    }
+    Ok(())
+}
+
+/// 获取`path`所在磁盘的剩余空间(字节)
+///
+/// 如果`path`还不存在，则以它最近的已存在的祖先目录为准
+pub fn get_available_space(path: &Path) -> anyhow::Result<u64> {
+    let existing_path = path
+        .ancestors()
+        .find(|p| p.exists())
+        .context(format!("`{path:?}`及其所有祖先目录都不存在"))?;
+    let absolute_path = std::path::absolute(existing_path)
+        .context(format!("获取`{existing_path:?}`的绝对路径失败"))?;
+    // 挂载点最长的那个磁盘才是`path`真正所在的磁盘
+    let disks = Disks::new_with_refreshed_list();
+    let disk = disks
+        .list()
+        .iter()
+        .filter(|disk| absolute_path.starts_with(disk.mount_point()))
+        .max_by_key(|disk| disk.mount_point().as_os_str().len())
+        .context(format!("没有找到`{absolute_path:?}`所在的磁盘"))?;
+    Ok(disk.available_space())
+}
@@ -0,0 +1,289 @@
+use std::{
+    collections::{HashMap, HashSet},
+    path::{Path, PathBuf},
+    time::{Duration, UNIX_EPOCH},
+};
+
+use anyhow::{anyhow, Context};
+use reqwest::{Method, StatusCode, Url};
+use serde::{Deserialize, Serialize};
+use specta::Type;
+use tauri::{AppHandle, Manager};
+use tauri_specta::Event;
+
+use crate::{events::WebDavSyncEvent, manhuagui_client::ManhuaguiClient, utils::write_atomic};
+
+/// 同步状态文件的文件名，位于`app_data_dir`下
+const SYNC_STATE_FILENAME: &str = "webdav_sync_state.json";
+/// 每上传成功这么多个文件保存一次同步状态，避免每个文件都重写一遍整个状态文件
+const SAVE_STATE_INTERVAL: u32 = 50;
+
+/// 已上传的文件在上传时的大小和修改时间，两者都没变就认为不需要重新上传
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+struct SyncedFile {
+    size: u64,
+    modified_secs: u64,
+}
+
+/// 同步结果
+#[derive(Default, Debug, Clone, Serialize, Deserialize, Type)]
+#[serde(rename_all = "camelCase")]
+pub struct WebDavSyncReport {
+    /// 本次上传的文件数
+    pub uploaded: u32,
+    /// 没有变化而跳过的文件数
+    pub skipped: u32,
+    /// 上传失败的文件及原因，再次同步时会重新上传
+    pub failed: Vec<String>,
+}
+
+/// 把`local_dir`镜像到WebDAV服务器上的`remote_url`目录
+///
+/// 只上传新增或变更的文件，上传成功的文件会分批记录到同步状态文件中，
+/// 所以中途失败后再次同步最多重新上传最后一批文件
+#[allow(clippy::cast_possible_truncation)]
+pub async fn sync_to_webdav(
+    app: &AppHandle,
+    local_dir: &Path,
+    remote_url: &str,
+    username: &str,
+    password: &str,
+) -> anyhow::Result<WebDavSyncReport> {
+    let base_url = Url::parse(remote_url).context(format!("`{remote_url}`不是合法的url"))?;
+    if !local_dir.is_dir() {
+        return Err(anyhow!("`{local_dir:?}`不是目录"));
+    }
+
+    let client_builder = app.state::<ManhuaguiClient>().client_builder();
+    let client = WebDavClient::new(client_builder, username, password)?;
+    let mut synced_files = load_sync_state(app).unwrap_or_default();
+
+    let mut local_files = Vec::new();
+    collect_files(local_dir, &mut local_files).context(format!("遍历`{local_dir:?}`失败"))?;
+
+    let event_uuid = uuid::Uuid::new_v4().to_string();
+    let dir_name = local_dir
+        .file_name()
+        .map(|name| name.to_string_lossy().to_string())
+        .unwrap_or_default();
+    let _ = WebDavSyncEvent::Start {
+        uuid: event_uuid.clone(),
+        dir_name,
+        total: local_files.len() as u32,
+    }
+    .emit(app);
+
+    client
+        .make_collection(&base_url)
+        .await
+        .context(format!("创建远程目录`{base_url}`失败"))?;
+    let mut created_dirs = HashSet::new();
+    let mut report = WebDavSyncReport::default();
+    let mut unsaved_count = 0;
+    for (i, local_path) in local_files.iter().enumerate() {
+        let relative_path = local_path.strip_prefix(local_dir)?;
+        let file_url = join_url(&base_url, relative_path)?;
+
+        let synced_file = get_synced_file(local_path)?;
+        let state_key = file_url.to_string();
+        if synced_files.get(&state_key) == Some(&synced_file) {
+            report.skipped += 1;
+        } else {
+            let result = upload_file(
+                &client,
+                &base_url,
+                relative_path,
+                local_path,
+                &mut created_dirs,
+            )
+            .await;
+            match result {
+                Ok(()) => {
+                    report.uploaded += 1;
+                    synced_files.insert(state_key, synced_file);
+                    unsaved_count += 1;
+                    if unsaved_count >= SAVE_STATE_INTERVAL {
+                        save_sync_state(app, &synced_files)?;
+                        unsaved_count = 0;
+                    }
+                }
+                Err(err) => {
+                    let err = err.context(format!("上传`{local_path:?}`失败"));
+                    report.failed.push(format!("{err:#}"));
+                }
+            }
+        }
+
+        let _ = WebDavSyncEvent::Progress {
+            uuid: event_uuid.clone(),
+            current: i as u32 + 1,
+        }
+        .emit(app);
+    }
+    if unsaved_count > 0 {
+        save_sync_state(app, &synced_files)?;
+    }
+    let _ = WebDavSyncEvent::End { uuid: event_uuid }.emit(app);
+
+    Ok(report)
+}
+
+/// `created_dirs`记录本次同步中已创建的远程目录，避免每个文件都重复创建
+async fn upload_file(
+    client: &WebDavClient,
+    base_url: &Url,
+    relative_path: &Path,
+    local_path: &Path,
+    created_dirs: &mut HashSet<Url>,
+) -> anyhow::Result<()> {
+    // 逐级创建父目录，已存在的目录MKCOL会返回405，直接忽略
+    if let Some(parent) = relative_path.parent() {
+        let mut dir = PathBuf::new();
+        for component in parent.components() {
+            dir.push(component);
+            let dir_url = join_url(base_url, &dir)?;
+            if created_dirs.contains(&dir_url) {
+                continue;
+            }
+            client
+                .make_collection(&dir_url)
+                .await
+                .context(format!("创建远程目录`{dir_url}`失败"))?;
+            created_dirs.insert(dir_url);
+        }
+    }
+    let data = tokio::fs::read(local_path)
+        .await
+        .context(format!("读取`{local_path:?}`失败"))?;
+    let file_url = join_url(base_url, relative_path)?;
+    client.put(&file_url, data).await
+}
+
+struct WebDavClient {
+    client: reqwest::Client,
+    username: String,
+    password: String,
+}
+
+impl WebDavClient {
+    /// `client_builder`带有与漫画柜请求相同的代理、证书和HTTP版本设置
+    fn new(
+        client_builder: reqwest::ClientBuilder,
+        username: &str,
+        password: &str,
+    ) -> anyhow::Result<Self> {
+        let client = client_builder
+            .timeout(Duration::from_secs(300))
+            .build()
+            .context("创建WebDAV客户端失败")?;
+        Ok(Self {
+            client,
+            username: username.to_string(),
+            password: password.to_string(),
+        })
+    }
+
+    fn request(&self, method: Method, url: &Url) -> reqwest::RequestBuilder {
+        let builder = self.client.request(method, url.clone());
+        if self.username.is_empty() {
+            return builder;
+        }
+        builder.basic_auth(&self.username, Some(&self.password))
+    }
+
+    async fn make_collection(&self, url: &Url) -> anyhow::Result<()> {
+        let method = Method::from_bytes(b"MKCOL")?;
+        let http_resp = self.request(method, url).send().await?;
+        let status = http_resp.status();
+        if status.is_success() || status == StatusCode::METHOD_NOT_ALLOWED {
+            return Ok(());
+        }
+        let body = http_resp.text().await.unwrap_or_default();
+        Err(anyhow!("预料之外的状态码({status}): {body}"))
+    }
+
+    async fn put(&self, url: &Url, data: Vec<u8>) -> anyhow::Result<()> {
+        let http_resp = self.request(Method::PUT, url).body(data).send().await?;
+        let status = http_resp.status();
+        if status.is_success() {
+            return Ok(());
+        }
+        let body = http_resp.text().await.unwrap_or_default();
+        Err(anyhow!("预料之外的状态码({status}): {body}"))
+    }
+}
+
+/// 把`relative_path`的每一级拼到`base_url`后面，每一级都会被正确编码
+fn join_url(base_url: &Url, relative_path: &Path) -> anyhow::Result<Url> {
+    let mut url = base_url.clone();
+    {
+        let mut segments = url
+            .path_segments_mut()
+            .map_err(|()| anyhow!("`{base_url}`不能作为目录"))?;
+        segments.pop_if_empty();
+        for component in relative_path.components() {
+            segments.push(&component.as_os_str().to_string_lossy());
+        }
+    }
+    Ok(url)
+}
+
+fn collect_files(dir: &Path, files: &mut Vec<PathBuf>) -> anyhow::Result<()> {
+    let mut entries = std::fs::read_dir(dir)
+        .context(format!("读取目录`{dir:?}`失败"))?
+        .filter_map(Result::ok)
+        .map(|entry| entry.path())
+        .collect::<Vec<_>>();
+    entries.sort();
+    for path in entries {
+        if path.is_dir() {
+            collect_files(&path, files)?;
+        } else {
+            files.push(path);
+        }
+    }
+    Ok(())
+}
+
+fn get_synced_file(path: &Path) -> anyhow::Result<SyncedFile> {
+    let metadata = std::fs::metadata(path).context(format!("获取`{path:?}`的元数据失败"))?;
+    let modified_secs = metadata
+        .modified()
+        .ok()
+        .and_then(|modified| modified.duration_since(UNIX_EPOCH).ok())
+        .map_or(0, |duration| duration.as_secs());
+    Ok(SyncedFile {
+        size: metadata.len(),
+        modified_secs,
+    })
+}
+
+fn get_sync_state_path(app: &AppHandle) -> anyhow::Result<PathBuf> {
+    let app_data_dir = app
+        .path()
+        .app_data_dir()
+        .context("获取app_data_dir目录失败")?;
+    Ok(app_data_dir.join(SYNC_STATE_FILENAME))
+}
+
+/// 同步状态的key为远程文件的url
+fn load_sync_state(app: &AppHandle) -> anyhow::Result<HashMap<String, SyncedFile>> {
+    let state_path = get_sync_state_path(app)?;
+    if !state_path.exists() {
+        return Ok(HashMap::new());
+    }
+    let state_string =
+        std::fs::read_to_string(&state_path).context(format!("读取`{state_path:?}`失败"))?;
+    let synced_files = serde_json::from_str(&state_string)
+        .context(format!("将`{state_path:?}`反序列化为同步状态失败"))?;
+    Ok(synced_files)
+}
+
+fn save_sync_state(
+    app: &AppHandle,
+    synced_files: &HashMap<String, SyncedFile>,
+) -> anyhow::Result<()> {
+    let state_json = serde_json::to_string(synced_files).context("将同步状态序列化为json失败")?;
+    write_atomic(&get_sync_state_path(app)?, state_json)
+}
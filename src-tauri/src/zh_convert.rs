@@ -0,0 +1,75 @@
+use std::{collections::HashMap, sync::LazyLock};
+
+// 常用字的简繁对照表，两个字符串中相同位置的字一一对应
+// 只收录了漫画标题中常见的一对一转换的字，一简对多繁的字(如`发`对应`發`和`髮`)只取最常用的那个
+const SIMPLIFIED: &str = concat!(
+    "万与专业丛东丝丢两严丧个丰临为丽举么义乌乐乔习乡书买乱争于亏云亚产亩亲亿仅从仑仓",
+    "仪们价众优会伞伟传伤伦伪体佣侠侣侦侧侨俭债倾偿儿党兰关兴养兽内冈册写军农冯冲决况",
+    "冻净凉减凑凤凭凯击凿刘则刚创删别刹剂剑剧劝办务动励劲劳势勋区医华协单卖卢卫却厂厅",
+    "历厉压厌县参双发变叙叶号叹吓吕吗听启吴员呜咏哑响唤啸园围国图圆圣场坏块坚坛坟垒垦",
+    "堕墙壮声壳处备复够头夹夺奋奖妆妇妈娄娱婴孙学宁宝实宠审宪宫宽宾对寻导寿将尔尘尝层",
+    "属岁岛岭峡币师帐带帮广庆库应庙废开异弃张弥弯弹强归当录彻径忆忧怀态怜总恋恶恼悦悬",
+    "惊惧惨惯愤愿戏战户扑执扩扫扬扰抚抛护报担拟拥拦择挂挡挤挥损捡换据掷揽搁摄摆摇撑敌",
+    "数斋斗断无旧时旷显晋晓晕暂术机杀杂权条来杨极构枪柜标栈树样桥档梦检欢欧残毁毕气汇",
+    "汉汤沟没沦泪泽洁浅测济浑涛润涩渊温湾湿满灭灯灵灾炉点炼烂烟热焕爱爷牵犹狮独狭狱猎",
+    "猫献环现玛电画畅疗疯痴监盖盘着睁矫码砖础确礼祸离种积称稳穷窃竞笔笼签简类粮紧纠红",
+    "纤约级纪纯纳纸纹线练组细织终绍经结绕绘给络绝统继绩续绳维绵绿缓编缘缩罗罚职联肃肠",
+    "肤胁胜胶脉脑脚脸腾舰艺节芦苏荐药莱获萨虑虫虽蚀蛮补装见观规视览觉触计订认讨让训议",
+    "记讲许论设访证评识诈诉词译试诗诚话诞询该详语误说请诸读课谁调谈谋谎谜谢谣谱贝负贡",
+    "财责败货质贩贪购贯贵费贺贼资赌赏赐赖赛赞赠赵赶跃践踪车轨转轮软轰轻载较辈辉输辑边",
+    "辽达迁过运还这进远违连迟选递逻遗邓邮邻郑酱释鉴针钓钟钢钥钱铁铃银铺链销锁错锦键锻",
+    "镇镜长门闪闭问闯间闹闻阅阔队阳阴阵阶际陆陈险随隐难雾静韦页顶项顺须顽顾顿预领频题",
+    "颜额风飘飞饥饭饮饰饱饿馆马驰驱驶驻驾验骂骑骗骚髅鱼鲁鲜鸟鸡鸣鸭鸿鹅鹰麦黄齐齿龙龟",
+    "恒诀猪",
+);
+
+const TRADITIONAL: &str = concat!(
+    "萬與專業叢東絲丟兩嚴喪個豐臨為麗舉麼義烏樂喬習鄉書買亂爭於虧雲亞產畝親億僅從侖倉",
+    "儀們價眾優會傘偉傳傷倫偽體傭俠侶偵側僑儉債傾償兒黨蘭關興養獸內岡冊寫軍農馮衝決況",
+    "凍淨涼減湊鳳憑凱擊鑿劉則剛創刪別剎劑劍劇勸辦務動勵勁勞勢勳區醫華協單賣盧衛卻廠廳",
+    "歷厲壓厭縣參雙發變敘葉號嘆嚇呂嗎聽啟吳員嗚詠啞響喚嘯園圍國圖圓聖場壞塊堅壇墳壘墾",
+    "墮牆壯聲殼處備復夠頭夾奪奮獎妝婦媽婁娛嬰孫學寧寶實寵審憲宮寬賓對尋導壽將爾塵嘗層",
+    "屬歲島嶺峽幣師帳帶幫廣慶庫應廟廢開異棄張彌彎彈強歸當錄徹徑憶憂懷態憐總戀惡惱悅懸",
+    "驚懼慘慣憤願戲戰戶撲執擴掃揚擾撫拋護報擔擬擁攔擇掛擋擠揮損撿換據擲攬擱攝擺搖撐敵",
+    "數齋鬥斷無舊時曠顯晉曉暈暫術機殺雜權條來楊極構槍櫃標棧樹樣橋檔夢檢歡歐殘毀畢氣匯",
+    "漢湯溝沒淪淚澤潔淺測濟渾濤潤澀淵溫灣濕滿滅燈靈災爐點煉爛煙熱煥愛爺牽猶獅獨狹獄獵",
+    "貓獻環現瑪電畫暢療瘋癡監蓋盤著睜矯碼磚礎確禮禍離種積稱穩窮竊競筆籠簽簡類糧緊糾紅",
+    "纖約級紀純納紙紋線練組細織終紹經結繞繪給絡絕統繼績續繩維綿綠緩編緣縮羅罰職聯肅腸",
+    "膚脅勝膠脈腦腳臉騰艦藝節蘆蘇薦藥萊獲薩慮蟲雖蝕蠻補裝見觀規視覽覺觸計訂認討讓訓議",
+    "記講許論設訪證評識詐訴詞譯試詩誠話誕詢該詳語誤說請諸讀課誰調談謀謊謎謝謠譜貝負貢",
+    "財責敗貨質販貪購貫貴費賀賊資賭賞賜賴賽贊贈趙趕躍踐蹤車軌轉輪軟轟輕載較輩輝輸輯邊",
+    "遼達遷過運還這進遠違連遲選遞邏遺鄧郵鄰鄭醬釋鑒針釣鐘鋼鑰錢鐵鈴銀鋪鏈銷鎖錯錦鍵鍛",
+    "鎮鏡長門閃閉問闖間鬧聞閱闊隊陽陰陣階際陸陳險隨隱難霧靜韋頁頂項順須頑顧頓預領頻題",
+    "顏額風飄飛飢飯飲飾飽餓館馬馳驅駛駐駕驗罵騎騙騷髏魚魯鮮鳥雞鳴鴨鴻鵝鷹麥黃齊齒龍龜",
+    "恆訣豬",
+);
+
+static TO_TRADITIONAL: LazyLock<HashMap<char, char>> =
+    LazyLock::new(|| SIMPLIFIED.chars().zip(TRADITIONAL.chars()).collect());
+
+static TO_SIMPLIFIED: LazyLock<HashMap<char, char>> =
+    LazyLock::new(|| TRADITIONAL.chars().zip(SIMPLIFIED.chars()).collect());
+
+pub fn to_traditional(s: &str) -> String {
+    s.chars()
+        .map(|c| TO_TRADITIONAL.get(&c).copied().unwrap_or(c))
+        .collect()
+}
+
+pub fn to_simplified(s: &str) -> String {
+    s.chars()
+        .map(|c| TO_SIMPLIFIED.get(&c).copied().unwrap_or(c))
+        .collect()
+}
+
+/// 返回搜索`keyword`时可以尝试的候选关键词，依次为原关键词、简体、繁体，已去重
+pub fn normalize_keyword(keyword: &str) -> Vec<String> {
+    let keyword = keyword.trim();
+    let mut candidates = vec![keyword.to_string()];
+    for candidate in [to_simplified(keyword), to_traditional(keyword)] {
+        if !candidates.contains(&candidate) {
+            candidates.push(candidate);
+        }
+    }
+    candidates
+}
@@ -27,6 +27,47 @@ async login(username: string, password: string) : Promise<Result<string, Command
     else return { status: "error", error: e  as any };
 }
 },
+async importNetscapeCookies(path: string) : Promise<Result<string, CommandError>> {
+    try {
+    return { status: "ok", data: await TAURI_INVOKE("import_netscape_cookies", { path }) };
+} catch (e) {
+    if(e instanceof Error) throw e;
+    else return { status: "error", error: e  as any };
+}
+},
+async exportNetscapeCookies(path: string) : Promise<Result<null, CommandError>> {
+    try {
+    return { status: "ok", data: await TAURI_INVOKE("export_netscape_cookies", { path }) };
+} catch (e) {
+    if(e instanceof Error) throw e;
+    else return { status: "error", error: e  as any };
+}
+},
+async setHostRateLimit(host: string, qps: number) : Promise<void> {
+    await TAURI_INVOKE("set_host_rate_limit", { host, qps });
+},
+/**
+ * 设置是否跳过TLS证书校验，同时保存到配置中，重启后仍然生效
+ */
+async setAcceptInvalidCerts(acceptInvalidCerts: boolean) : Promise<Result<null, CommandError>> {
+    try {
+    return { status: "ok", data: await TAURI_INVOKE("set_accept_invalid_certs", { acceptInvalidCerts }) };
+} catch (e) {
+    if(e instanceof Error) throw e;
+    else return { status: "error", error: e  as any };
+}
+},
+/**
+ * 额外信任`path`中PEM格式的根证书，证书内容保存到配置中，重启后仍然生效
+ */
+async addRootCa(path: string) : Promise<Result<null, CommandError>> {
+    try {
+    return { status: "ok", data: await TAURI_INVOKE("add_root_ca", { path }) };
+} catch (e) {
+    if(e instanceof Error) throw e;
+    else return { status: "error", error: e  as any };
+}
+},
 async getUserProfile() : Promise<Result<UserProfile, CommandError>> {
     try {
     return { status: "ok", data: await TAURI_INVOKE("get_user_profile") };
@@ -35,9 +76,47 @@ async getUserProfile() : Promise<Result<UserProfile, CommandError>> {
     else return { status: "error", error: e  as any };
 }
 },
-async search(keyword: string, pageNum: number) : Promise<Result<SearchResult, CommandError>> {
+/**
+ * 搜索`keyword`的第`page_num`页，并在本地按`filter`过滤这一页的结果
+ */
+async search(keyword: string, pageNum: number, sort: SearchSort, filter: SearchFilter) : Promise<Result<SearchResult, CommandError>> {
+    try {
+    return { status: "ok", data: await TAURI_INVOKE("search", { keyword, pageNum, sort, filter }) };
+} catch (e) {
+    if(e instanceof Error) throw e;
+    else return { status: "error", error: e  as any };
+}
+},
+async getSearchHistory() : Promise<string[]> {
+    return await TAURI_INVOKE("get_search_history");
+},
+async clearSearchHistory() : Promise<Result<null, CommandError>> {
+    try {
+    return { status: "ok", data: await TAURI_INVOKE("clear_search_history") };
+} catch (e) {
+    if(e instanceof Error) throw e;
+    else return { status: "error", error: e  as any };
+}
+},
+async getRank(rankType: RankType, pageNum: number) : Promise<Result<SearchResult, CommandError>> {
+    try {
+    return { status: "ok", data: await TAURI_INVOKE("get_rank", { rankType, pageNum }) };
+} catch (e) {
+    if(e instanceof Error) throw e;
+    else return { status: "error", error: e  as any };
+}
+},
+async getLatestUpdates(pageNum: number) : Promise<Result<LatestUpdateResult, CommandError>> {
+    try {
+    return { status: "ok", data: await TAURI_INVOKE("get_latest_updates", { pageNum }) };
+} catch (e) {
+    if(e instanceof Error) throw e;
+    else return { status: "error", error: e  as any };
+}
+},
+async getComicsByGenre(slug: string, pageNum: number) : Promise<Result<LatestUpdateResult, CommandError>> {
     try {
-    return { status: "ok", data: await TAURI_INVOKE("search", { keyword, pageNum }) };
+    return { status: "ok", data: await TAURI_INVOKE("get_comics_by_genre", { slug, pageNum }) };
 } catch (e) {
     if(e instanceof Error) throw e;
     else return { status: "error", error: e  as any };
@@ -51,14 +130,158 @@ async getComic(id: number) : Promise<Result<Comic, CommandError>> {
     else return { status: "error", error: e  as any };
 }
 },
-async downloadChapters(chapters: ChapterInfo[]) : Promise<Result<null, CommandError>> {
+/**
+ * 通过章节链接获取章节所属的漫画，例如历史记录中只有章节链接时
+ */
+async getComicByChapterHref(href: string) : Promise<Result<Comic, CommandError>> {
+    try {
+    return { status: "ok", data: await TAURI_INVOKE("get_comic_by_chapter_href", { href }) };
+} catch (e) {
+    if(e instanceof Error) throw e;
+    else return { status: "error", error: e  as any };
+}
+},
+/**
+ * 重新获取漫画`comic_id`的信息，以json格式保存到`path`，方便备份或者给其他工具使用
+ */
+async exportComicJson(comicId: number, path: string) : Promise<Result<null, CommandError>> {
+    try {
+    return { status: "ok", data: await TAURI_INVOKE("export_comic_json", { comicId, path }) };
+} catch (e) {
+    if(e instanceof Error) throw e;
+    else return { status: "error", error: e  as any };
+}
+},
+/**
+ * 重新获取漫画，只返回与`old_comic`相比有变化的部分
+ */
+async getComicDiff(oldComic: Comic) : Promise<Result<ComicDiff, CommandError>> {
+    try {
+    return { status: "ok", data: await TAURI_INVOKE("get_comic_diff", { oldComic }) };
+} catch (e) {
+    if(e instanceof Error) throw e;
+    else return { status: "error", error: e  as any };
+}
+},
+async filterChapters(chapters: ChapterInfo[], include: string[], exclude: string[], languages: ChapterLanguage[], updatedAfter: string | null, updatedBefore: string | null) : Promise<Result<ChapterInfo[], CommandError>> {
+    try {
+    return { status: "ok", data: await TAURI_INVOKE("filter_chapters", { chapters, include, exclude, languages, updatedAfter, updatedBefore }) };
+} catch (e) {
+    if(e instanceof Error) throw e;
+    else return { status: "error", error: e  as any };
+}
+},
+/**
+ * 在漫画`comic_id`中按标题关键词或章节号查找章节，返回每个匹配章节所在的组和章节id
+ *
+ * 章节列表取自获取漫画时保存的缓存，没有缓存时取自已下载漫画的元数据，不需要前端把整个漫画传过来
+ */
+async findChapters(comicId: number, keyword: string) : Promise<Result<ChapterLocation[], CommandError>> {
+    try {
+    return { status: "ok", data: await TAURI_INVOKE("find_chapters", { comicId, keyword }) };
+} catch (e) {
+    if(e instanceof Error) throw e;
+    else return { status: "error", error: e  as any };
+}
+},
+async downloadChapters(chapters: ChapterInfo[], priority: DownloadPriority, order: DownloadOrder) : Promise<Result<null, CommandError>> {
+    try {
+    return { status: "ok", data: await TAURI_INVOKE("download_chapters", { chapters, priority, order }) };
+} catch (e) {
+    if(e instanceof Error) throw e;
+    else return { status: "error", error: e  as any };
+}
+},
+async estimateSize(chapters: ChapterInfo[]) : Promise<number> {
+    return await TAURI_INVOKE("estimate_size", { chapters });
+},
+async previewDownload(chapters: ChapterInfo[]) : Promise<Result<DownloadPlan, CommandError>> {
+    try {
+    return { status: "ok", data: await TAURI_INVOKE("preview_download", { chapters }) };
+} catch (e) {
+    if(e instanceof Error) throw e;
+    else return { status: "error", error: e  as any };
+}
+},
+async cancelDownload(comicId: number, deleteTempDirs: boolean) : Promise<void> {
+    await TAURI_INVOKE("cancel_download", { comicId, deleteTempDirs });
+},
+async pauseDownload() : Promise<void> {
+    await TAURI_INVOKE("pause_download");
+},
+async resumeDownload() : Promise<void> {
+    await TAURI_INVOKE("resume_download");
+},
+async isDownloadPaused() : Promise<boolean> {
+    return await TAURI_INVOKE("is_download_paused");
+},
+/**
+ * 单独暂停、恢复或取消一些章节，`chapter_ids`为空时什么都不做
+ */
+async pauseChapters(chapterIds: number[]) : Promise<void> {
+    await TAURI_INVOKE("pause_chapters", { chapterIds });
+},
+async resumeChapters(chapterIds: number[]) : Promise<void> {
+    await TAURI_INVOKE("resume_chapters", { chapterIds });
+},
+async cancelChapters(chapterIds: number[], deleteTempDirs: boolean) : Promise<void> {
+    await TAURI_INVOKE("cancel_chapters", { chapterIds, deleteTempDirs });
+},
+/**
+ * 暂停漫画`comic_id`所有未完成的章节，之后再提交的章节不受影响
+ */
+async pauseComic(comicId: number) : Promise<void> {
+    await TAURI_INVOKE("pause_comic", { comicId });
+},
+async resumeComic(comicId: number) : Promise<void> {
+    await TAURI_INVOKE("resume_comic", { comicId });
+},
+async getPausedChapterIds() : Promise<number[]> {
+    return await TAURI_INVOKE("get_paused_chapter_ids");
+},
+async getDownloadTaskStates() : Promise<DownloadTaskState[]> {
+    return await TAURI_INVOKE("get_download_task_states");
+},
+async getDownloadQueueState() : Promise<DownloadQueueState> {
+    return await TAURI_INVOKE("get_download_queue_state");
+},
+/**
+ * 本地图片代理服务的地址，前端把图片url编码后拼成`{地址}/img?url=...`即可绕过防盗链显示图片
+ */
+async getImageProxyUrl() : Promise<string | null> {
+    return await TAURI_INVOKE("get_image_proxy_url");
+},
+/**
+ * OPDS目录的地址，开启局域网访问时是本机的局域网地址，本地服务没有启动时为None
+ */
+async getOpdsUrl() : Promise<string | null> {
+    return await TAURI_INVOKE("get_opds_url");
+},
+async listDownloadHistory(filter: DownloadHistoryFilter) : Promise<DownloadHistoryEntry[]> {
+    return await TAURI_INVOKE("list_download_history", { filter });
+},
+async clearDownloadHistory() : Promise<Result<null, CommandError>> {
+    try {
+    return { status: "ok", data: await TAURI_INVOKE("clear_download_history") };
+} catch (e) {
+    if(e instanceof Error) throw e;
+    else return { status: "error", error: e  as any };
+}
+},
+async getRestoredDownloadTasks() : Promise<DownloadTaskState[]> {
+    return await TAURI_INVOKE("get_restored_download_tasks");
+},
+async resumeRestoredDownloadTasks() : Promise<Result<null, CommandError>> {
     try {
-    return { status: "ok", data: await TAURI_INVOKE("download_chapters", { chapters }) };
+    return { status: "ok", data: await TAURI_INVOKE("resume_restored_download_tasks") };
 } catch (e) {
     if(e instanceof Error) throw e;
     else return { status: "error", error: e  as any };
 }
 },
+async discardRestoredDownloadTasks() : Promise<void> {
+    await TAURI_INVOKE("discard_restored_download_tasks");
+},
 async getFavorite(pageNum: number) : Promise<Result<GetFavoriteResult, CommandError>> {
     try {
     return { status: "ok", data: await TAURI_INVOKE("get_favorite", { pageNum }) };
@@ -83,6 +306,78 @@ async getDownloadedComics() : Promise<Result<Comic[], CommandError>> {
     else return { status: "error", error: e  as any };
 }
 },
+async streamChapterImages(sessionId: string, chapterInfo: ChapterInfo, prefetch: number, onPage: TAURI_CHANNEL<ImagePage>) : Promise<Result<null, CommandError>> {
+    try {
+    return { status: "ok", data: await TAURI_INVOKE("stream_chapter_images", { sessionId, chapterInfo, prefetch, onPage }) };
+} catch (e) {
+    if(e instanceof Error) throw e;
+    else return { status: "error", error: e  as any };
+}
+},
+/**
+ * 告诉后端阅读器当前阅读到第几页(从0开始)，边下载边阅读时只预取这一页之后的几页
+ */
+async setReaderPosition(sessionId: string, index: number) : Promise<void> {
+    await TAURI_INVOKE("set_reader_position", { sessionId, index });
+},
+/**
+ * 关闭阅读器，取消还在下载的页
+ */
+async closeReader(sessionId: string) : Promise<void> {
+    await TAURI_INVOKE("close_reader", { sessionId });
+},
+async planReorganizeLibrary() : Promise<Result<ReorganizeReport, CommandError>> {
+    try {
+    return { status: "ok", data: await TAURI_INVOKE("plan_reorganize_library") };
+} catch (e) {
+    if(e instanceof Error) throw e;
+    else return { status: "error", error: e  as any };
+}
+},
+/**
+ * 执行`plan_reorganize_library`生成、用户确认过的操作
+ */
+async reorganizeLibrary(actions: ReorganizeAction[]) : Promise<ReorganizeReport> {
+    return await TAURI_INVOKE("reorganize_library", { actions });
+},
+/**
+ * 校验`comic_dir`中已下载章节是否缺页
+ */
+async verifyLibrary(comicDir: string) : Promise<Result<VerifyReport, CommandError>> {
+    try {
+    return { status: "ok", data: await TAURI_INVOKE("verify_library", { comicDir }) };
+} catch (e) {
+    if(e instanceof Error) throw e;
+    else return { status: "error", error: e  as any };
+}
+},
+/**
+ * 把`verify_library`找出的缺页章节提交到下载队列补下，不会再校验一遍
+ */
+async repairChapters(chapters: ChapterInfo[]) : Promise<Result<null, CommandError>> {
+    try {
+    return { status: "ok", data: await TAURI_INVOKE("repair_chapters", { chapters }) };
+} catch (e) {
+    if(e instanceof Error) throw e;
+    else return { status: "error", error: e  as any };
+}
+},
+async dedupeImages(comicDir: string, hardLink: boolean) : Promise<Result<DedupeReport, CommandError>> {
+    try {
+    return { status: "ok", data: await TAURI_INVOKE("dedupe_images", { comicDir, hardLink }) };
+} catch (e) {
+    if(e instanceof Error) throw e;
+    else return { status: "error", error: e  as any };
+}
+},
+async buildLibraryIndex() : Promise<Result<LibraryIndex, CommandError>> {
+    try {
+    return { status: "ok", data: await TAURI_INVOKE("build_library_index") };
+} catch (e) {
+    if(e instanceof Error) throw e;
+    else return { status: "error", error: e  as any };
+}
+},
 async exportCbz(comic: Comic) : Promise<Result<null, CommandError>> {
     try {
     return { status: "ok", data: await TAURI_INVOKE("export_cbz", { comic }) };
@@ -99,6 +394,42 @@ async exportPdf(comic: Comic) : Promise<Result<null, CommandError>> {
     else return { status: "error", error: e  as any };
 }
 },
+async exportEpub(comic: Comic, longStrip: boolean) : Promise<Result<null, CommandError>> {
+    try {
+    return { status: "ok", data: await TAURI_INVOKE("export_epub", { comic, longStrip }) };
+} catch (e) {
+    if(e instanceof Error) throw e;
+    else return { status: "error", error: e  as any };
+}
+},
+async exportByRules(comic: Comic, longStrip: boolean) : Promise<Result<null, CommandError>> {
+    try {
+    return { status: "ok", data: await TAURI_INVOKE("export_by_rules", { comic, longStrip }) };
+} catch (e) {
+    if(e instanceof Error) throw e;
+    else return { status: "error", error: e  as any };
+}
+},
+/**
+ * 把`comic_dir`中已下载的章节打包成普通zip，`per_chapter`为true时每话一个zip，`out_path`为保存zip的目录，
+ * 否则整本一个zip，`out_path`为zip的路径
+ */
+async exportZip(comicDir: string, outPath: string, perChapter: boolean) : Promise<Result<null, CommandError>> {
+    try {
+    return { status: "ok", data: await TAURI_INVOKE("export_zip", { comicDir, outPath, perChapter }) };
+} catch (e) {
+    if(e instanceof Error) throw e;
+    else return { status: "error", error: e  as any };
+}
+},
+async syncToWebdav(localDir: string, remoteUrl: string, username: string, password: string) : Promise<Result<WebDavSyncReport, CommandError>> {
+    try {
+    return { status: "ok", data: await TAURI_INVOKE("sync_to_webdav", { localDir, remoteUrl, username, password }) };
+} catch (e) {
+    if(e instanceof Error) throw e;
+    else return { status: "error", error: e  as any };
+}
+},
 async updateDownloadedComics() : Promise<Result<null, CommandError>> {
     try {
     return { status: "ok", data: await TAURI_INVOKE("update_downloaded_comics") };
@@ -106,6 +437,52 @@ async updateDownloadedComics() : Promise<Result<null, CommandError>> {
     if(e instanceof Error) throw e;
     else return { status: "error", error: e  as any };
 }
+},
+async exportTaskList(chapters: ChapterInfo[], path: string) : Promise<Result<null, CommandError>> {
+    try {
+    return { status: "ok", data: await TAURI_INVOKE("export_task_list", { chapters, path }) };
+} catch (e) {
+    if(e instanceof Error) throw e;
+    else return { status: "error", error: e  as any };
+}
+},
+async importTaskList(path: string) : Promise<Result<DownloadTask[], CommandError>> {
+    try {
+    return { status: "ok", data: await TAURI_INVOKE("import_task_list", { path }) };
+} catch (e) {
+    if(e instanceof Error) throw e;
+    else return { status: "error", error: e  as any };
+}
+},
+async importExternalTasks(format: ExternalTaskFormat, path: string) : Promise<Result<ExternalImportReport, CommandError>> {
+    try {
+    return { status: "ok", data: await TAURI_INVOKE("import_external_tasks", { format, path }) };
+} catch (e) {
+    if(e instanceof Error) throw e;
+    else return { status: "error", error: e  as any };
+}
+},
+async diagnose() : Promise<DiagnoseReport> {
+    return await TAURI_INVOKE("diagnose");
+},
+async getRequestMetrics() : Promise<MetricsSnapshot> {
+    return await TAURI_INVOKE("get_request_metrics");
+},
+async benchmarkImageServers() : Promise<Result<ServerSpeed[], CommandError>> {
+    try {
+    return { status: "ok", data: await TAURI_INVOKE("benchmark_image_servers") };
+} catch (e) {
+    if(e instanceof Error) throw e;
+    else return { status: "error", error: e  as any };
+}
+},
+async autoDetectProxy() : Promise<Result<ProxyCandidate[], CommandError>> {
+    try {
+    return { status: "ok", data: await TAURI_INVOKE("auto_detect_proxy") };
+} catch (e) {
+    if(e instanceof Error) throw e;
+    else return { status: "error", error: e  as any };
+}
 }
 }
 
@@ -114,14 +491,20 @@ async updateDownloadedComics() : Promise<Result<null, CommandError>> {
 
 export const events = __makeEvents__<{
 downloadEvent: DownloadEvent,
+logEvent: LogEvent,
 exportCbzEvent: ExportCbzEvent,
 exportPdfEvent: ExportPdfEvent,
-updateDownloadedComicsEvent: UpdateDownloadedComicsEvent
+exportEpubEvent: ExportEpubEvent,
+updateDownloadedComicsEvent: UpdateDownloadedComicsEvent,
+webDavSyncEvent: WebDavSyncEvent
 }>({
 downloadEvent: "download-event",
+logEvent: "log-event",
 exportCbzEvent: "export-cbz-event",
 exportPdfEvent: "export-pdf-event",
-updateDownloadedComicsEvent: "update-downloaded-comics-event"
+exportEpubEvent: "export-epub-event",
+updateDownloadedComicsEvent: "update-downloaded-comics-event",
+webDavSyncEvent: "web-dav-sync-event"
 })
 
 /** user-defined constants **/
@@ -130,29 +513,85 @@ updateDownloadedComicsEvent: "update-downloaded-comics-event"
 
 /** user-defined types **/
 
-export type ChapterInfo = { 
+export type AcceptLanguage = 
 /**
- * 章节id
+ * 不携带，由漫画柜自己决定
  */
-chapterId: number; 
+"Auto" | 
 /**
- * 章节标题
+ * 简体
  */
-chapterTitle: string; 
+"Simplified" | 
 /**
- * 此章节有多少页
+ * 繁体
  */
-chapterSize: number; 
+"Traditional"
+export type ChapterDedupeScope = 
 /**
- * 以order为前缀的章节标题
+ * 不去重
  */
-prefixedChapterTitle: string; 
+"Disabled" | 
 /**
- * 漫画id
+ * 只在同一个分页内去重
  */
-comicId: number; 
+"Page" | 
 /**
- * 漫画标题
+ * 在同一个章节组(单话、单行本...)的所有分页间去重
+ */
+"Group" | 
+/**
+ * 在所有章节组间去重，同一话同时出现在单话和单行本里时只会保留一个
+ */
+"Comic"
+export type ChapterDirLayout = 
+/**
+ * `漫画标题/组名/序号 章节标题`，每个章节组(单话、单行本...)一个子目录
+ */
+"ByGroup" | 
+/**
+ * `漫画标题/组名 序号 章节标题`，所有章节平铺在漫画目录下
+ */
+"Flat"
+export type ChapterDownloadPlan = { chapterInfo: ChapterInfo; 
+/**
+ * 下载完成后图片所在的目录
+ */
+downloadDir: string; 
+/**
+ * 页数
+ */
+pageCount: number; 
+/**
+ * 预估大小(字节)，按默认的平均图片大小估算
+ */
+estimatedSize: number; 
+/**
+ * 按当前的已下载判断策略，此章节是否已经下载过
+ */
+exists: boolean }
+export type ChapterInfo = { 
+/**
+ * 章节id
+ */
+chapterId: number; 
+/**
+ * 章节标题
+ */
+chapterTitle: string; 
+/**
+ * 此章节有多少页
+ */
+chapterSize: number; 
+/**
+ * 以order为前缀的章节标题
+ */
+prefixedChapterTitle: string; 
+/**
+ * 漫画id
+ */
+comicId: number; 
+/**
+ * 漫画目录的名字，通常就是漫画标题，网站改名后是原来的标题
  */
 comicTitle: string; 
 /**
@@ -171,10 +610,64 @@ order: number;
  * 漫画状态(连载中/已完结)
  */
 comicStatus: string; 
+/**
+ * 是否需要付费或登录才能查看
+ */
+isLocked: boolean; 
+/**
+ * 章节目录的结构，随元数据一起保存，这样切换配置后依然能找到已下载的章节
+ */
+dirLayout: ChapterDirLayout; 
+/**
+ * 章节的语言，从标题或class中识别，识别不出时为`Unknown`
+ */
+language: ChapterLanguage; 
+/**
+ * 章节的更新日期(YYYY-MM-DD)，章节列表中通常没有，只有最新一话能从漫画的更新时间得知
+ */
+updatedAt: string | null; 
 /**
  * 是否已下载
  */
 isDownloaded?: boolean | null }
+/**
+ * 章节的语言，同一本漫画可能混有简体、繁体和日文原版的章节
+ */
+export type ChapterLanguage = 
+/**
+ * 标题和class中都没有标注语言，通常是网站的默认版本
+ */
+"Unknown" | 
+/**
+ * 简体
+ */
+"Simplified" | 
+/**
+ * 繁体
+ */
+"Traditional" | 
+/**
+ * 日文原版
+ */
+"Japanese"
+export type ChapterLocation = { groupName: string; chapterId: number }
+export type ChapterVerifyResult = { chapterInfo: ChapterInfo; 
+/**
+ * 网站上的页数
+ */
+expectedPages: number; 
+/**
+ * 本地的图片数量
+ */
+localPages: number; 
+/**
+ * 缺失的页码，从1开始
+ */
+missingPages: number[]; 
+/**
+ * 与校验和清单中记录的大小或哈希不符的页码，从1开始，没有清单的章节总是为空
+ */
+corruptedPages: number[] }
 export type Comic = { 
 /**
  * 漫画id
@@ -184,6 +677,14 @@ id: number;
  * 漫画标题
  */
 title: string; 
+/**
+ * 下载目录中漫画目录的名字，通常与`title`相同，网站改名后沿用原来的目录
+ */
+dirName: string; 
+/**
+ * 网站上用过的标题，按改名的先后顺序排列
+ */
+formerTitles: string[]; 
 /**
  * 漫画副标题
  */
@@ -212,6 +713,10 @@ region: string;
  * 类型
  */
 genres: string[]; 
+/**
+ * 类型标签，可以用来获取同类型的漫画列表
+ */
+genreTags: GenreTag[]; 
 /**
  * 作者
  */
@@ -227,7 +732,48 @@ intro: string;
 /**
  * 组名(单话、单行本...)->章节信息
  */
-groups: { [key in string]: ChapterInfo[] } }
+groups: { [key in string]: ChapterInfo[] }; 
+/**
+ * 详情页中「喜欢这部漫画的人也喜欢」的推荐漫画，没有推荐时为空
+ */
+related: RelatedComic[]; 
+/**
+ * 评分(满分10分)，由评分接口的投票统计算出，还没有人评分或获取失败时为None
+ */
+rating: number | null; 
+/**
+ * 评分人数，获取失败时为None
+ */
+voteCount: number | null; 
+/**
+ * 页面提示登录后可以看到更多章节，此时章节列表可能不完整，检测不到提示时为false
+ */
+requiresLogin: boolean }
+export type ComicDiff = { 
+/**
+ * 漫画id
+ */
+id: number; 
+/**
+ * 最新的漫画状态(连载中/已完结)
+ */
+status: string; 
+/**
+ * 最新的更新时间
+ */
+updateTime: string; 
+/**
+ * 新增的章节，同一组内按章节顺序排列
+ */
+added: ChapterInfo[]; 
+/**
+ * 内容有变化的章节(标题、页数、顺序、是否已下载等)，为变化后的版本
+ */
+changed: ChapterInfo[]; 
+/**
+ * 被移除的章节，为移除前的版本
+ */
+removed: ChapterInfo[] }
 export type ComicInFavorite = { 
 /**
  * 漫画id
@@ -253,6 +799,27 @@ lastUpdate: string;
  * - x分钟前
  */
 lastRead: string }
+export type ComicInLatestUpdate = { 
+/**
+ * 漫画id
+ */
+id: number; 
+/**
+ * 漫画标题
+ */
+title: string; 
+/**
+ * 封面链接
+ */
+cover: string; 
+/**
+ * 最新章节标题
+ */
+lastChapter: string; 
+/**
+ * 上次更新时间
+ */
+updateTime: string }
 export type ComicInSearch = { 
 /**
  * 漫画id
@@ -278,6 +845,14 @@ status: string;
  * 上次更新时间
  */
 updateTime: string; 
+/**
+ * 最新章节标题(第120话、全一卷...)
+ */
+lastChapter: string | null; 
+/**
+ * 是否已完结
+ */
+isFinished: boolean; 
 /**
  * 出版年份
  */
@@ -301,16 +876,760 @@ aliases: string[];
 /**
  * 简介
  */
-intro: string }
-export type CommandError = string
-export type Config = { cookie: string; downloadDir: string; exportDir: string }
-export type DownloadEvent = { event: "ChapterPending"; data: { chapterId: number; comicTitle: string; chapterTitle: string } } | { event: "ChapterControlRisk"; data: { chapterId: number; retryAfter: number } } | { event: "ChapterStart"; data: { chapterId: number; total: number } } | { event: "ChapterEnd"; data: { chapterId: number; errMsg: string | null } } | { event: "ImageSuccess"; data: { chapterId: number; url: string; current: number } } | { event: "ImageError"; data: { chapterId: number; url: string; errMsg: string } } | { event: "Speed"; data: { speed: string } }
+intro: string; 
+/**
+ * 下载目录中是否已有这本漫画
+ */
+isLocalDownloaded: boolean; 
+/**
+ * 下载目录中这本漫画已下载的章节数
+ */
+localChapterCount: number; 
+/**
+ * 在排行榜中的名次，不是来自排行榜时为None
+ */
+rank: number | null; 
+/**
+ * 排行榜上的人气值，不是来自排行榜或榜单没有这一列时为None
+ */
+popularity: number | null }
+export type CommandError = { kind: ErrorKind; message: string }
+export type Config = { cookie: string; downloadDir: string; exportDir: string; 
+/**
+ * 缓存目录，存放下载中的章节等临时数据，可以和下载目录放在不同的磁盘上
+ */
+cacheDir: string; 
+/**
+ * 下载到webp动图时是否转换为gif动图，gif的兼容性更好，但体积更大
+ */
+convertAnimatedWebpToGif: boolean; 
+/**
+ * 调试模式，解析漫画详情页失败时把原始html保存到调试目录
+ */
+debugMode: boolean; 
+/**
+ * 每下载完一个章节就立即导出为cbz
+ */
+exportCbzOnChapterCompleted: boolean; 
+/**
+ * 自动导出cbz后删除原图，章节目录会保留，这样章节仍被视为已下载，但之后手动导出时会跳过这些章节
+ */
+deleteImagesAfterAutoExport: boolean; 
+/**
+ * 请求时携带的`Accept-Language`，固定下来可以避免同一本漫画在不同时间下载时标题简繁不一
+ */
+acceptLanguage: AcceptLanguage; 
+/**
+ * 章节列表中href相同的章节的去重范围
+ */
+chapterDedupeScope: ChapterDedupeScope; 
+/**
+ * 新下载的漫画的章节目录结构，已有元数据的漫画沿用元数据中记录的结构
+ */
+chapterDirLayout: ChapterDirLayout; 
+/**
+ * 代理地址，例如`http://127.0.0.1:7890`、`socks5://127.0.0.1:1080`，为空时使用系统代理
+ */
+proxy: string; 
+/**
+ * 下载图片的质量
+ */
+imageQuality: ImageQuality; 
+/**
+ * 网站没有提供压缩图时，在本地把图片缩小到原来的百分之多少
+ */
+compressedImageScale: number; 
+/**
+ * 判断章节是否已下载的策略
+ */
+downloadedCheckStrategy: DownloadedCheckStrategy; 
+/**
+ * WebDAV服务器上用于存放漫画的目录，例如`https://nas.local/dav/漫画`
+ */
+webdavUrl: string; 
+/**
+ * WebDAV用户名，为空时不认证
+ */
+webdavUsername: string; 
+/**
+ * WebDAV密码，只保存在内存中，不会写入配置文件，重启后需要重新输入
+ */
+webdavPassword: string; 
+/**
+ * 按规则导出时，章节组名(单话、单行本...)->导出格式
+ */
+exportFormatRules: { [key in string]: ExportFormat }; 
+/**
+ * 按规则导出时，`export_format_rules`中没有的章节组使用的导出格式
+ */
+defaultExportFormat: ExportFormat; 
+/**
+ * 导出pdf时是否把条漫的长图切成多页，方便在电子书阅读器上翻页阅读
+ */
+splitLongImagesInPdf: boolean; 
+/**
+ * 导出cbz、pdf时是否用标注了页码的占位图代替缺失的页，保证页码连续、阅读不错位
+ */
+placeholderForMissingPages: boolean; 
+/**
+ * 下载时是否裁掉图片顶部和底部的水印条，动图不会被裁剪
+ */
+cropWatermark: boolean; 
+/**
+ * 图片宽度为`crop_reference_width`时，顶部要裁掉的像素数
+ */
+cropTop: number; 
+/**
+ * 图片宽度为`crop_reference_width`时，底部要裁掉的像素数
+ */
+cropBottom: number; 
+/**
+ * `crop_top`和`crop_bottom`对应的图片宽度，其他宽度的图片按比例换算
+ */
+cropReferenceWidth: number; 
+/**
+ * 最多保留多少条搜索历史
+ */
+searchHistoryLimit: number; 
+/**
+ * 最多同时下载多少本漫画，0表示不限制，同一本漫画的章节之间不受影响
+ */
+maxActiveComics: number; 
+/**
+ * 本地图片代理服务监听的端口，0表示由系统分配，修改后重启软件生效
+ */
+imageProxyPort: number; 
+/**
+ * 是否允许局域网内的其他设备访问OPDS目录，开启后本地服务监听所有网卡，修改后重启软件生效
+ */
+opdsLanAccess: boolean; 
+/**
+ * 获取漫画、搜索和章节时使用的站点，切换后按对应站点的页面结构解析
+ */
+site: Site; 
+/**
+ * 最多同时请求并解析多少个网页，例如获取漫画时补抓的章节分页，修改后对之后的解析生效
+ */
+parseConcurrency: number; 
+/**
+ * 附加在所有请求上的自定义header，不会覆盖User-Agent、Referer等请求本身已有的header
+ */
+extraHeaders: { [key in string]: string }; 
+/**
+ * 要下载的章节已经在其他目录下载过时的处理方式，默认关闭以免意外跳过
+ */
+crossDirDedupe: CrossDirDedupe; 
+/**
+ * 按最近的成功率和传输速度自动调整同时下载的图片数，关闭时一张一张下载
+ */
+adaptiveImageConcurrency: boolean; 
+/**
+ * 是否允许使用HTTP/2，关闭时强制使用HTTP/1.1，有些代理对HTTP/2支持不好，连接异常时可以关掉试试
+ */
+http2Enabled: boolean; 
+/**
+ * 是否跳过TLS证书校验，只能通过`set_accept_invalid_certs`修改，保存后重启仍然生效
+ */
+acceptInvalidCerts: boolean; 
+/**
+ * 额外信任的PEM格式根证书，只能通过`add_root_ca`添加，保存的是证书内容，原文件移走后仍然生效
+ */
+rootCaPems: string[] }
+export type Connectivity = { url: string; 
+/**
+ * 响应的状态码，连接失败时为None
+ */
+status: number | null; 
+/**
+ * 从发出请求到收到响应的耗时(毫秒)
+ */
+latencyMs: number; 
+/**
+ * 连接失败的原因
+ */
+errMsg: string | null }
+export type CrossDirDedupe = 
+/**
+ * 照常重新下载
+ */
+"Disabled" | 
+/**
+ * 跳过这一话
+ */
+"Skip" | 
+/**
+ * 把已下载的图片硬链接到新目录，不在同一个磁盘上时改为复制
+ */
+"HardLink"
+export type DedupeReport = { duplicates: DuplicateImages[]; 
+/**
+ * 重复的文件占用的字节数，用硬链接替换后可以省下这么多空间
+ */
+wastedBytes: number; linked: boolean; 
+/**
+ * 读取或替换失败的文件及原因，失败的不影响其他的
+ */
+errors: string[] }
+export type DiagnoseReport = { 
+/**
+ * 软件版本
+ */
+appVersion: string; 
+/**
+ * 解析规则版本
+ */
+parserVersion: number; 
+/**
+ * 配置中的代理，代理的用户名和密码已隐去，为空时使用系统代理
+ */
+proxy: string; 
+/**
+ * 环境变量中的代理设置，代理的用户名和密码已隐去
+ */
+proxyEnvs: string[]; 
+/**
+ * 是否跳过TLS证书校验
+ */
+acceptInvalidCerts: boolean; 
+/**
+ * 额外信任的根证书数量
+ */
+rootCaCount: number; 
+/**
+ * 是否设置了cookie
+ */
+hasCookie: boolean; 
+/**
+ * 对漫画柜首页和各个图片服务器的连通性
+ */
+connectivities: Connectivity[]; 
+/**
+ * 下载目录
+ */
+downloadDir: string; 
+/**
+ * 下载目录不可写的原因，可写时为None
+ */
+downloadDirErrMsg: string | null; 
+/**
+ * 下载目录所在磁盘的剩余空间(字节)，获取失败时为None
+ */
+availableSpace: number | null }
+export type DownloadEvent = { event: "ChapterPending"; data: { chapterId: number; comicId: number; comicTitle: string; chapterTitle: string } } | { event: "ChapterControlRisk"; data: { chapterId: number; retryAfter: number } } | 
+/**
+ * `total_bytes`是按首张图片的大小估算的整话字节数，估算失败时为None，此时按页数计算进度
+ */
+{ event: "ChapterStart"; data: { chapterId: number; total: number; totalBytes: number | null } } | { event: "ChapterEnd"; data: { chapterId: number; errMsg: string | null } } | { event: "ChapterCanceled"; data: { chapterId: number } } | 
+/**
+ * 章节被单独暂停
+ */
+{ event: "ChapterPaused"; data: { chapterId: number } } | 
+/**
+ * 单独暂停的章节恢复了，全局暂停时要等全局恢复后才会继续下载
+ */
+{ event: "ChapterResumed"; data: { chapterId: number } } | { event: "ChapterUnavailable"; data: { chapterId: number; reason: string } } | { event: "ImageSuccess"; data: { chapterId: number; url: string; current: number; 
+/**
+ * 此章节已下载图片的总字节数
+ */
+downloadedBytes: number } } | { event: "ImageError"; data: { chapterId: number; url: string; errMsg: string } } | 
+/**
+ * 一本漫画提交的章节全部结束了，包括失败和取消的，`elapsed_secs`从第一个章节提交时算起
+ */
+{ event: "ComicCompleted"; data: { comicId: number; comicTitle: string; succeeded: number; failed: number; canceled: number; elapsedSecs: number } } | 
+/**
+ * 队列中所有的章节都结束了，期间陆续提交的章节都算在这一批里
+ */
+{ event: "BatchCompleted"; data: { comicCount: number; succeeded: number; failed: number; canceled: number; elapsedSecs: number } } | 
+/**
+ * 网络断开，下载任务暂停，等网络恢复后自动继续
+ */
+{ event: "NetworkWaiting" } | 
+/**
+ * 网络已恢复，下载任务继续
+ */
+{ event: "NetworkRestored" } | { event: "Speed"; data: { speed: string } }
+export type DownloadHistoryEntry = { 
+/**
+ * 漫画id
+ */
+comicId: number; 
+/**
+ * 漫画标题
+ */
+comicTitle: string; 
+/**
+ * 组名(单话、单行本、番外篇)
+ */
+groupName: string; 
+/**
+ * 章节id
+ */
+chapterId: number; 
+/**
+ * 章节标题
+ */
+chapterTitle: string; 
+/**
+ * 下载完成时的保存目录
+ */
+downloadDir: string; 
+/**
+ * 下载完成的时间，RFC 3339格式
+ */
+downloadedAt: string }
+export type DownloadHistoryFilter = { 
+/**
+ * 只返回这本漫画的历史
+ */
+comicId: number | null; 
+/**
+ * 漫画标题或章节标题包含这个关键词
+ */
+keyword: string | null; 
+/**
+ * 最多返回多少条
+ */
+limit: number | null }
+export type DownloadOrder = 
+/**
+ * 按章节序号从旧到新
+ */
+"OldestFirst" | 
+/**
+ * 按章节序号从新到旧，追连载时可以先看到最新的
+ */
+"NewestFirst"
+export type DownloadPlan = { chapters: ChapterDownloadPlan[]; 
+/**
+ * 总页数
+ */
+totalPages: number; 
+/**
+ * 预估总大小(字节)
+ */
+estimatedSize: number; 
+/**
+ * 下载目录所在磁盘的剩余空间(字节)，获取失败时为None
+ */
+availableSpace: number | null }
+export type DownloadPriority = "Normal" | 
+/**
+ * 手动触发的下载，会插到自动更新、导入清单等任务前面
+ */
+"High"
+export type DownloadQueueState = { 
+/**
+ * 同时下载的漫画数量上限，0表示不限制
+ */
+maxActiveComics: number; 
+/**
+ * 正在下载的漫画
+ */
+activeComics: QueuedComic[]; 
+/**
+ * 排队中的漫画，按开始下载的先后顺序排列
+ */
+waitingComics: QueuedComic[] }
+export type DownloadTask = { 
+/**
+ * 漫画id
+ */
+comicId: number; 
+/**
+ * 漫画标题
+ */
+comicTitle: string; 
+/**
+ * 导出任务清单时的下载目录
+ */
+downloadDir: string; 
+/**
+ * 要下载的章节
+ */
+chapters: ChapterInfo[] }
+export type DownloadTaskState = { chapterInfo: ChapterInfo; 
+/**
+ * 已下载的图片数量
+ */
+downloadedCount: number; 
+/**
+ * 总共需要下载的图片数量，还没开始下载时为0
+ */
+total: number; 
+/**
+ * 恢复任务时沿用提交时的优先级
+ */
+priority: DownloadPriority }
+export type DownloadedCheckStrategy = 
+/**
+ * 下载目录存在就算已下载
+ */
+"PathExists" | 
+/**
+ * 下载目录中的图片数量不少于章节页数才算已下载，导出后删除了原图的章节会被视为未下载
+ */
+"ImageCount" | 
+/**
+ * 图片数量足够且每张都能完整解码才算已下载，导出后删除了原图的章节会被视为未下载
+ */
+"ImageIntegrity" | 
+/**
+ * 校验和清单存在且每一页都校验通过才算已下载，旧版本下载的没有清单的章节会被视为未下载
+ */
+"Manifest"
+export type DuplicateImages = { 
+/**
+ * 按路径排序，第一个是保留的，其余的是重复的
+ */
+paths: string[]; 
+/**
+ * 单个文件的字节数
+ */
+size: number }
+export type ErrorKind = 
+/**
+ * 漫画或章节不存在、已被删除或下架，重试也不会成功
+ */
+"ComicNotFound" | 
+/**
+ * 被网站风控拦截，需要换代理、稍后再试或登录
+ */
+"Blocked" | 
+/**
+ * 页面的关键区块全都找不到，网站结构可能已变更，需要更新解析规则
+ */
+"SiteChanged" | 
+/**
+ * 网页无法解析，通常是网站改版了
+ */
+"ParseFailed" | 
+/**
+ * 代理要求认证，但用户名或密码不对，需要修改代理地址
+ */
+"ProxyAuthFailed" | 
+/**
+ * 连接失败或超时，网络恢复后重试即可
+ */
+"Network" | 
+/**
+ * 其他错误
+ */
+"Other"
 export type ExportCbzEvent = { event: "Start"; data: { uuid: string; comicTitle: string; total: number } } | { event: "Progress"; data: { uuid: string; current: number } } | { event: "End"; data: { uuid: string } }
+export type ExportEpubEvent = { event: "Start"; data: { uuid: string; comicTitle: string; total: number } } | { event: "Progress"; data: { uuid: string; current: number } } | { event: "End"; data: { uuid: string } }
+export type ExportFormat = "Cbz" | "Pdf" | "Epub"
 export type ExportPdfEvent = { event: "CreateStart"; data: { uuid: string; comicTitle: string; total: number } } | { event: "CreateProgress"; data: { uuid: string; current: number } } | { event: "CreateEnd"; data: { uuid: string } } | { event: "MergeStart"; data: { uuid: string; comicTitle: string; total: number } } | { event: "MergeProgress"; data: { uuid: string; current: number } } | { event: "MergeEnd"; data: { uuid: string } }
+export type ExternalImportReport = { 
+/**
+ * 已加入下载队列的任务
+ */
+tasks: DownloadTask[]; 
+/**
+ * 无法识别或获取失败而跳过的条目及原因
+ */
+skipped: string[] }
+export type ExternalTaskFormat = 
+/**
+ * 每行一个链接，EhViewer等工具导出的链接列表就是这种格式，行中可以带有标题等其他文本
+ */
+"UrlList"
+export type GenreTag = { 
+/**
+ * 标签名，例如`热血`
+ */
+name: string; 
+/**
+ * 标签链接`/list/{slug}/`中的slug，例如`rexue`
+ */
+slug: string }
 export type GetFavoriteResult = { comics: ComicInFavorite[]; current: number; total: number }
-export type SearchResult = { comics: ComicInSearch[]; current: number; total: number }
+/**
+ * 阅读器中的一页
+ */
+export type HostMetrics = { host: string; 
+/**
+ * 请求总数，每次重试都算一次
+ */
+total: number; 
+/**
+ * 状态码为2xx的请求数
+ */
+success: number; 
+/**
+ * 状态码为403的请求数，通常说明被风控了
+ */
+forbidden: number; 
+/**
+ * 重试次数
+ */
+retries: number; 
+/**
+ * 平均延迟(毫秒)，从发出请求到收到响应头，不包括读取响应体的时间
+ */
+avgLatencyMs: number }
+export type ImagePage = { 
+/**
+ * 页码，从0开始
+ */
+index: number; 
+/**
+ * 总页数
+ */
+total: number; 
+/**
+ * 图片在本地的路径，前端通过`convertFileSrc`显示
+ */
+path: string }
+export type ImageQuality = 
+/**
+ * 原图
+ */
+"Original" | 
+/**
+ * 压缩图，优先使用漫画柜提供的webp压缩图，没有时下载原图后在本地按比例缩小
+ */
+"Compressed"
+export type LatestUpdateResult = { comics: ComicInLatestUpdate[]; 
+/**
+ * 当前页码
+ */
+current: number; 
+/**
+ * 总页数
+ */
+total: number }
+export type LibraryEntry = { id: number; title: string; 
+/**
+ * 本地封面的路径，还没下载封面时为None
+ */
+coverPath: string | null; 
+/**
+ * 元数据中的章节总数
+ */
+chapterCount: number; 
+/**
+ * 已下载的章节数
+ */
+downloadedCount: number; 
+/**
+ * 网站上的最后更新时间
+ */
+updateTime: string; 
+/**
+ * 本地漫画目录最后一次改动的时间(unix时间戳，秒)
+ */
+modifiedAt: number }
+export type LibraryIndex = { 
+/**
+ * 按本地改动时间从新到旧排列
+ */
+comics: LibraryEntry[]; 
+/**
+ * 读取失败的漫画及原因，失败的不影响其他的
+ */
+errors: string[] }
+export type LogEvent = { event: "Info"; data: { msg: string } } | { event: "Warn"; data: { msg: string } }
+export type MetricsSnapshot = { 
+/**
+ * 按请求总数从多到少排列
+ */
+hosts: HostMetrics[]; 
+/**
+ * 最近100个请求的成功率(0~1)，还没有发出过请求时为None
+ */
+recentSuccessRate: number | null }
+export type ProxyCandidate = { 
+/**
+ * 代理地址，可以直接填到配置的`proxy`里
+ */
+url: string; 
+/**
+ * 这个端口通常属于哪个代理软件，只是根据默认端口猜的
+ */
+software: string; 
+/**
+ * 通过这个代理访问漫画柜首页的耗时(毫秒)
+ */
+latencyMs: number }
+export type QueuedComic = { comicId: number; comicTitle: string; priority: DownloadPriority; 
+/**
+ * 这本漫画正在下载或排队中的章节数
+ */
+chapterCount: number }
+export type RankType = 
+/**
+ * 日排行
+ */
+"Day" | 
+/**
+ * 周排行
+ */
+"Week" | 
+/**
+ * 月排行
+ */
+"Month" | 
+/**
+ * 总排行
+ */
+"Total"
+export type RelatedComic = { 
+/**
+ * 漫画id
+ */
+id: number; 
+/**
+ * 漫画标题
+ */
+title: string; 
+/**
+ * 封面链接
+ */
+cover: string }
+export type ReorganizeAction = 
+/**
+ * 把`from`重命名为`to`
+ */
+{ kind: "rename"; from: string; to: string } | 
+/**
+ * `to`已存在，把`from`中`to`没有的文件移动过去，与`to`中同名文件内容相同的直接删除，
+ * 内容不同的保留在`from`中，`from`变空后才删除
+ */
+{ kind: "merge"; from: string; to: string } | 
+/**
+ * 删除整理后变空的章节组目录
+ */
+{ kind: "removeEmptyDir"; dir: string }
+/**
+ * 整理的结果，`executed`为false时只是计划，没有改动任何文件
+ */
+export type ReorganizeReport = { actions: ReorganizeAction[]; executed: boolean; 
+/**
+ * 读取元数据失败的漫画和执行失败的操作及原因，失败的不影响其他的
+ */
+errors: string[] }
+/**
+ * 在当前页的搜索结果上做的本地过滤，网站的搜索经常带出无关的结果，不会产生额外的请求
+ */
+export type SearchFilter = { 
+/**
+ * 只保留已完结的漫画
+ */
+finishedOnly: boolean; 
+/**
+ * 只保留作者中有人包含这个名字的漫画，为空时不过滤
+ */
+author: string; 
+/**
+ * 只保留标题、副标题或别名中真正包含搜索关键词的漫画
+ */
+titleContainsKeyword: boolean }
+export type SearchResult = { comics: ComicInSearch[]; current: number; 
+/**
+ * 总结果数
+ */
+total: number; 
+/**
+ * 总页数，以分页控件中最大的页码为准，解析不到时按总结果数估算
+ */
+totalPage: number; 
+/**
+ * 实际用于搜索的关键词，简繁转换后可能与用户输入的不同
+ */
+keyword: string; 
+/**
+ * 当前结果的排序方式
+ */
+sort: SearchSort; 
+/**
+ * 网站给出的纠错建议，即`您是不是要找 xxx`中的`xxx`，没有时为None
+ */
+suggestion: string | null; 
+/**
+ * 当前页按`SearchFilter`过滤掉的漫画数，没有过滤时为0
+ */
+filteredOut: number }
+/**
+ * 搜索结果的排序方式，与搜索页顶部的排序选项一一对应
+ */
+export type SearchSort = 
+/**
+ * 最新更新，也是网站的默认排序
+ */
+"Update" | 
+/**
+ * 最近发布
+ */
+"Publish" | 
+/**
+ * 人气最旺
+ */
+"Popularity" | 
+/**
+ * 评分最高
+ */
+"Rating"
+/**
+ * 漫画柜的站点，繁体站的部分class与简体站不同，需要用各自的选择器解析
+ */
+export type ServerSpeed = { host: string; 
+/**
+ * 从发出请求到收到响应头的时间(毫秒)，失败时为None
+ */
+latencyMs: number | null; 
+/**
+ * 下载完整张图片的时间(毫秒)，失败时为None
+ */
+totalMs: number | null; 
+/**
+ * 平均下载速度(KB/s)，失败时为None
+ */
+speedKbps: number | null; 
+/**
+ * 失败的原因
+ */
+error: string | null }
+export type Site = 
+/**
+ * 简体站`www.manhuagui.com`
+ */
+"Www" | 
+/**
+ * 繁体站`tw.manhuagui.com`
+ */
+"Tw"
 export type UpdateDownloadedComicsEvent = { event: "GettingComics"; data: { total: number } } | { event: "ComicGot"; data: { current: number; total: number } } | { event: "DownloadTaskCreated" }
 export type UserProfile = { username: string; avatar: string }
+export type VerifyReport = { comicTitle: string; 
+/**
+ * 本地有章节目录、参与了校验的章节数
+ */
+checkedChapters: number; 
+/**
+ * 有缺页或损坏的页的章节，完整的章节不在其中
+ */
+incompleteChapters: ChapterVerifyResult[]; 
+/**
+ * 获取页数或读取校验和清单失败的章节及原因，获取页数失败的章节没有被校验
+ */
+errors: string[] }
+export type WebDavSyncEvent = { event: "Start"; data: { uuid: string; dirName: string; total: number } } | { event: "Progress"; data: { uuid: string; current: number } } | { event: "End"; data: { uuid: string } }
+/**
+ * 同步结果
+ */
+export type WebDavSyncReport = { 
+/**
+ * 本次上传的文件数
+ */
+uploaded: number; 
+/**
+ * 没有变化而跳过的文件数
+ */
+skipped: number; 
+/**
+ * 上传失败的文件及原因，再次同步时会重新上传
+ */
+failed: string[] }
 
 /** tauri-specta globals **/
 
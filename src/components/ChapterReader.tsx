@@ -0,0 +1,98 @@
+import { ChapterInfo, commands, ImagePage } from '../bindings.ts'
+import { App as AntdApp, Modal, Spin } from 'antd'
+import { useEffect, useRef, useState } from 'react'
+import { Channel, convertFileSrc } from '@tauri-apps/api/core'
+
+// 边下载边显示时，预取当前阅读页之后的页数
+const PREFETCH_PAGES = 3
+
+interface Props {
+  chapterInfo: ChapterInfo | undefined
+  onClose: () => void
+}
+
+function ChapterReader({ chapterInfo, onClose }: Props) {
+  const { notification } = AntdApp.useApp()
+  const [pages, setPages] = useState<ImagePage[]>([])
+  const [total, setTotal] = useState<number>()
+  const containerRef = useRef<HTMLDivElement>(null)
+  const sessionIdRef = useRef<string | undefined>(undefined)
+  const positionRef = useRef<number>(0)
+
+  useEffect(() => {
+    if (chapterInfo === undefined) {
+      return
+    }
+    setPages([])
+    setTotal(undefined)
+    const sessionId = crypto.randomUUID()
+    sessionIdRef.current = sessionId
+    positionRef.current = 0
+    // 关闭阅读器后丢弃后续推送的页，并通知后端取消还在下载的页
+    let closed = false
+    const onPage = new Channel<ImagePage>()
+    onPage.onmessage = (page) => {
+      if (closed) {
+        return
+      }
+      setTotal(page.total)
+      setPages((prev) => [...prev, page])
+    }
+    commands.streamChapterImages(sessionId, chapterInfo, PREFETCH_PAGES, onPage).then((result) => {
+      if (!closed && result.status === 'error') {
+        notification.error({ message: '读取章节失败', description: result.error.message, duration: 0 })
+      }
+    })
+    return () => {
+      closed = true
+      onPage.onmessage = () => {}
+      sessionIdRef.current = undefined
+      commands.closeReader(sessionId)
+    }
+  }, [chapterInfo, notification])
+
+  // 把已经滚动到视野中的最后一页告诉后端，后端只预取这一页之后的几页
+  function reportPosition() {
+    const container = containerRef.current
+    const sessionId = sessionIdRef.current
+    if (container === null || sessionId === undefined) {
+      return
+    }
+    const bottom = container.scrollTop + container.clientHeight
+    const visibleCount = Array.from(container.querySelectorAll('img')).filter((img) => img.offsetTop < bottom).length
+    const position = Math.max(visibleCount - 1, 0)
+    if (position !== positionRef.current) {
+      positionRef.current = position
+      commands.setReaderPosition(sessionId, position)
+    }
+  }
+
+  return (
+    <Modal
+      title={chapterInfo && `${chapterInfo.comicTitle} - ${chapterInfo.chapterTitle}`}
+      open={chapterInfo !== undefined}
+      onCancel={onClose}
+      footer={null}
+      width="90%"
+      destroyOnClose>
+      <div ref={containerRef} className="relative h-75vh overflow-auto flex flex-col items-center" onScroll={reportPosition}>
+        {pages.map((page) => (
+          <img
+            key={page.index}
+            className="max-w-full"
+            src={convertFileSrc(page.path)}
+            alt={`${page.index + 1}`}
+            onLoad={reportPosition}
+          />
+        ))}
+        {(total === undefined || pages.length < total) && (
+          <Spin className="my-4" tip={total === undefined ? '加载中' : `${pages.length}/${total}`}>
+            <div className="w-20 h-20" />
+          </Spin>
+        )}
+      </div>
+    </Modal>
+  )
+}
+
+export default ChapterReader
@@ -1,6 +1,8 @@
 import { Comic, commands } from '../bindings.ts'
 import { CurrentTabName } from '../types.ts'
-import { App as AntdApp, Card } from 'antd'
+import { App as AntdApp, Button, Card, Tag } from 'antd'
+import ErrorDescription from './ErrorDescription.tsx'
+import { isRetryable, useProxiedImageUrl } from '../utils.ts'
 
 interface Props {
   comicId: number
@@ -11,6 +13,7 @@ interface Props {
   comicGenres?: string[]
   comicLastUpdateTime?: string
   comicLastReadTime?: string
+  comicLocalChapterCount?: number
   setPickedComic: (comic: Comic | undefined) => void
   setCurrentTabName: (currentTabName: CurrentTabName) => void
 }
@@ -24,17 +27,31 @@ function ComicCard({
   comicGenres,
   comicLastUpdateTime,
   comicLastReadTime,
+  comicLocalChapterCount,
   setPickedComic,
   setCurrentTabName,
 }: Props) {
   const { notification } = AntdApp.useApp()
+  const coverUrl = useProxiedImageUrl(comicCover)
 
   async function pickComic(id: number) {
     const result = await commands.getComic(id)
     if (result.status === 'error') {
+      const error = result.error
       notification.error({
+        key: 'get-comic-error',
         message: '获取漫画信息失败',
-        description: result.error,
+        description: <ErrorDescription error={error} />,
+        btn: isRetryable(error) && (
+          <Button
+            size="small"
+            onClick={async () => {
+              notification.destroy('get-comic-error')
+              await pickComic(id)
+            }}>
+            重试
+          </Button>
+        ),
         duration: 0,
       })
       return
@@ -49,7 +66,7 @@ function ComicCard({
       <div className="flex">
         <img
           className="w-24 object-cover mr-4 cursor-pointer transition-transform duration-200 hover:scale-106"
-          src={comicCover}
+          src={coverUrl}
           alt=""
           onClick={() => pickComic(comicId)}
         />
@@ -59,6 +76,11 @@ function ComicCard({
             onClick={() => pickComic(comicId)}>
             {comicTitle}
             {comicSubtitle && `(${comicSubtitle})`}
+            {comicLocalChapterCount !== undefined && (
+              <Tag className="ml-2 align-middle" color="green">
+                本地已下载{comicLocalChapterCount}话
+              </Tag>
+            )}
           </span>
           {comicAuthors !== undefined && <span className="text-red">作者：{comicAuthors.join(', ')}</span>}
           {comicGenres !== undefined && <span className="text-black">类型：{comicGenres.join(' ')}</span>}
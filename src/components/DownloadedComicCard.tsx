@@ -1,7 +1,10 @@
-import { Comic, commands } from '../bindings.ts'
+import { Comic, commands, Config } from '../bindings.ts'
 import { CurrentTabName } from '../types.ts'
-import { App as AntdApp, Button, Card } from 'antd'
+import { App as AntdApp, Button, Card, Dropdown } from 'antd'
 import { useMemo } from 'react'
+import { join } from '@tauri-apps/api/path'
+import { open, save } from '@tauri-apps/plugin-dialog'
+import { useProxiedImageUrl } from '../utils.ts'
 
 interface GroupInfo {
   name: string
@@ -11,12 +14,14 @@ interface GroupInfo {
 
 interface Props {
   comic: Comic
+  config: Config
   setPickedComic: (comic: Comic | undefined) => void
   setCurrentTabName: (currentTabName: CurrentTabName) => void
 }
 
-function DownloadedComicCard({ comic, setPickedComic, setCurrentTabName }: Props) {
-  const { notification } = AntdApp.useApp()
+function DownloadedComicCard({ comic, config, setPickedComic, setCurrentTabName }: Props) {
+  const { notification, modal } = AntdApp.useApp()
+  const coverUrl = useProxiedImageUrl(comic.cover)
   const groupInfos = useMemo(() => {
     const groups = comic.groups
 
@@ -41,7 +46,7 @@ function DownloadedComicCard({ comic, setPickedComic, setCurrentTabName }: Props
   async function exportCbz() {
     const result = await commands.exportCbz(comic)
     if (result.status === 'error') {
-      notification.error({ message: '导出cbz失败', description: result.error, duration: 0 })
+      notification.error({ message: '导出cbz失败', description: result.error.message, duration: 0 })
       return
     }
   }
@@ -49,17 +54,166 @@ function DownloadedComicCard({ comic, setPickedComic, setCurrentTabName }: Props
   async function exportPdf() {
     const result = await commands.exportPdf(comic)
     if (result.status === 'error') {
-      notification.error({ message: '导出pdf失败', description: result.error, duration: 0 })
+      notification.error({ message: '导出pdf失败', description: result.error.message, duration: 0 })
       return
     }
   }
 
+  // 按导出规则把不同的章节组导出为不同的格式
+  async function exportByRules() {
+    const result = await commands.exportByRules(comic, false)
+    if (result.status === 'error') {
+      notification.error({ message: '按规则导出失败', description: result.error.message, duration: 0 })
+      return
+    }
+  }
+
+  // 打包成普通zip，整本一个zip时选择保存路径，每话一个zip时选择保存目录
+  async function exportZip(perChapter: boolean) {
+    const comicDir = await join(config.downloadDir, comic.dirName)
+    const outPath = perChapter
+      ? await open({ directory: true, defaultPath: config.exportDir })
+      : await save({
+          defaultPath: await join(config.exportDir, `${comic.title}.zip`),
+          filters: [{ name: 'zip', extensions: ['zip'] }],
+        })
+    if (outPath === null) {
+      return
+    }
+    const result = await commands.exportZip(comicDir, outPath, perChapter)
+    if (result.status === 'error') {
+      notification.error({ message: '导出zip失败', description: result.error.message, duration: 0 })
+      return
+    }
+    notification.success({ message: `${comic.title} 导出zip成功` })
+  }
+
+  // 对照网站上的页数和校验和清单检查已下载的章节有没有缺页或损坏的页，有问题时可以一键补下
+  async function verify() {
+    const comicDir = await join(config.downloadDir, comic.dirName)
+    const result = await commands.verifyLibrary(comicDir)
+    if (result.status === 'error') {
+      notification.error({ message: '校验缺页失败', description: result.error.message, duration: 0 })
+      return
+    }
+    const report = result.data
+    if (report.errors.length > 0) {
+      notification.warning({
+        message: `${comic.title} 有${report.errors.length}话没能完成校验`,
+        description: report.errors.join('\n'),
+        duration: 0,
+      })
+    }
+    if (report.incompleteChapters.length === 0) {
+      notification.success({ message: `${comic.title} 校验了${report.checkedChapters}话，没有缺页或损坏的页` })
+      return
+    }
+    const confirmed = await modal.confirm({
+      title: `${comic.title} 有${report.incompleteChapters.length}话缺页或有损坏的页`,
+      width: 600,
+      okText: '补下缺页和损坏的页',
+      cancelText: '关闭',
+      content: (
+        <div className="max-h-64 overflow-auto">
+          {report.incompleteChapters.map((c) => (
+            <div key={c.chapterInfo.chapterId} className="text-xs">
+              {c.chapterInfo.groupName} - {c.chapterInfo.chapterTitle}：{c.localPages}/{c.expectedPages}页
+              {c.missingPages.length > 0 && `，缺第${c.missingPages.join(', ')}页`}
+              {c.corruptedPages.length > 0 && `，第${c.corruptedPages.join(', ')}页已损坏`}
+            </div>
+          ))}
+        </div>
+      ),
+    })
+    if (!confirmed) {
+      return
+    }
+    const redownloadResult = await commands.repairChapters(report.incompleteChapters.map((c) => c.chapterInfo))
+    if (redownloadResult.status === 'error') {
+      notification.error({ message: '补下缺页失败', description: redownloadResult.error.message, duration: 0 })
+    }
+  }
+
+  // 找出内容完全相同的图片，确认后用硬链接替换重复的图片以节省空间
+  async function dedupeImages() {
+    const comicDir = await join(config.downloadDir, comic.dirName)
+    const result = await commands.dedupeImages(comicDir, false)
+    if (result.status === 'error') {
+      notification.error({ message: '图片去重失败', description: result.error.message, duration: 0 })
+      return
+    }
+    const report = result.data
+    if (report.duplicates.length === 0) {
+      notification.success({ message: `${comic.title} 没有重复的图片` })
+      return
+    }
+    const toMB = (size: number) => (size / 1024 / 1024).toFixed(2)
+    const confirmed = await modal.confirm({
+      title: `${comic.title} 有${report.duplicates.length}组重复的图片，共占用${toMB(report.wastedBytes)} MB`,
+      width: 600,
+      okText: '用硬链接替换',
+      cancelText: '关闭',
+      content: (
+        <div className="max-h-64 overflow-auto">
+          {report.duplicates.map((duplicate) => (
+            <div key={duplicate.paths[0]} className="text-xs mb-1">
+              {duplicate.paths.map((path) => (
+                <div key={path}>{path}</div>
+              ))}
+            </div>
+          ))}
+        </div>
+      ),
+    })
+    if (!confirmed) {
+      return
+    }
+    const linkResult = await commands.dedupeImages(comicDir, true)
+    if (linkResult.status === 'error') {
+      notification.error({ message: '图片去重失败', description: linkResult.error.message, duration: 0 })
+      return
+    }
+    if (linkResult.data.errors.length > 0) {
+      notification.warning({
+        message: `${comic.title} 有${linkResult.data.errors.length}个文件替换失败`,
+        description: linkResult.data.errors.join('\n'),
+        duration: 0,
+      })
+      return
+    }
+    notification.success({ message: `${comic.title} 图片去重完成` })
+  }
+
+  // 把漫画的下载目录镜像到WebDAV上同名的目录
+  async function syncToWebdav() {
+    const webdavUrl = config.webdavUrl.trim().replace(/\/+$/, '')
+    if (webdavUrl === '') {
+      notification.error({ message: '同步到WebDAV失败', description: '请先填写WebDAV地址', duration: 0 })
+      return
+    }
+    const localDir = await join(config.downloadDir, comic.dirName)
+    const remoteUrl = `${webdavUrl}/${encodeURIComponent(comic.dirName)}`
+    const result = await commands.syncToWebdav(localDir, remoteUrl, config.webdavUsername, config.webdavPassword)
+    if (result.status === 'error') {
+      notification.error({ message: '同步到WebDAV失败', description: result.error.message, duration: 0 })
+      return
+    }
+    const { failed } = result.data
+    if (failed.length > 0) {
+      notification.warning({
+        message: `${comic.title} 有${failed.length}个文件同步失败，再次同步会重新上传`,
+        description: failed.join('\n'),
+        duration: 0,
+      })
+    }
+  }
+
   return (
     <Card hoverable={true} className="cursor-auto m-0! rounded-none" styles={{ body: { padding: '0.25rem' } }}>
       <div className="flex">
         <img
           className="w-24 object-cover mr-4 cursor-pointer transition-transform duration-200 hover:scale-106"
-          src={comic.cover}
+          src={coverUrl}
           alt=""
           onClick={() => pickComic()}
         />
@@ -84,6 +238,29 @@ function DownloadedComicCard({ comic, setPickedComic, setCurrentTabName }: Props
             <Button className="ml-auto mt-auto" size="small" onClick={exportPdf}>
               导出pdf
             </Button>
+            <Dropdown
+              menu={{
+                items: [
+                  { key: 'whole', label: '整本一个zip', onClick: () => exportZip(false) },
+                  { key: 'perChapter', label: '每话一个zip', onClick: () => exportZip(true) },
+                ],
+              }}>
+              <Button className="ml-auto mt-auto" size="small">
+                导出zip
+              </Button>
+            </Dropdown>
+            <Button className="ml-auto mt-auto" size="small" onClick={exportByRules}>
+              按规则导出
+            </Button>
+            <Button className="ml-auto mt-auto" size="small" onClick={verify}>
+              校验缺页
+            </Button>
+            <Button className="ml-auto mt-auto" size="small" onClick={dedupeImages}>
+              图片去重
+            </Button>
+            <Button className="ml-auto mt-auto" size="small" onClick={syncToWebdav}>
+              同步WebDAV
+            </Button>
           </div>
         </div>
       </div>
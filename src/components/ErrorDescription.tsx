@@ -0,0 +1,19 @@
+import { CommandError } from '../bindings.ts'
+import { getErrorHint } from '../utils.ts'
+
+interface Props {
+  error: CommandError
+}
+
+function ErrorDescription({ error }: Props) {
+  const hint = getErrorHint(error)
+
+  return (
+    <div className="flex flex-col">
+      <span>{error.message}</span>
+      {hint !== undefined && <span className="mt-1 text-gray-5">{hint}</span>}
+    </div>
+  )
+}
+
+export default ErrorDescription
@@ -33,7 +33,7 @@ function LoginDialog({ loginDialogShowing, setLoginDialogShowing, config, setCon
     if (result.status === 'error') {
       notification.error({
         message: '登录失败',
-        description: result.error,
+        description: result.error.message,
         duration: 0,
       })
       return
@@ -0,0 +1,43 @@
+import { useEffect, useState } from 'react'
+import { Tooltip } from 'antd'
+import { commands, MetricsSnapshot } from '../bindings.ts'
+
+// 每隔几秒刷新一次，指标只用来判断要不要换代理，不需要太实时
+const REFRESH_INTERVAL_MS = 5000
+
+function RequestMetricsIndicator() {
+  const [metrics, setMetrics] = useState<MetricsSnapshot>()
+
+  useEffect(() => {
+    const refresh = () => commands.getRequestMetrics().then(setMetrics)
+    refresh()
+    const timer = setInterval(refresh, REFRESH_INTERVAL_MS)
+    return () => clearInterval(timer)
+  }, [])
+
+  if (metrics === undefined || metrics.recentSuccessRate === null) {
+    return null
+  }
+
+  const rate = Math.round(metrics.recentSuccessRate * 100)
+  // 成功率低时大概率是代理有问题或被风控了
+  const colorClass = rate >= 90 ? 'text-green-6' : rate >= 60 ? 'text-orange-5' : 'text-red-5'
+
+  return (
+    <Tooltip
+      title={
+        <div className="flex flex-col">
+          {metrics.hosts.map((host) => (
+            <span key={host.host}>
+              {host.host}：{host.success}/{host.total}成功，403 {host.forbidden}次，重试{host.retries}次，平均
+              {host.avgLatencyMs}ms
+            </span>
+          ))}
+        </div>
+      }>
+      <span className={`flex items-center whitespace-nowrap px-2 ${colorClass}`}>最近成功率 {rate}%</span>
+    </Tooltip>
+  )
+}
+
+export default RequestMetricsIndicator
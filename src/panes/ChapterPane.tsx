@@ -1,4 +1,5 @@
 import {
+  Alert,
   App as AntdApp,
   Button,
   Card,
@@ -7,13 +8,33 @@ import {
   Divider,
   Dropdown,
   Empty,
+  Input,
   MenuProps,
+  Segmented,
+  Select,
   Tabs,
   TabsProps,
+  Tag,
 } from 'antd'
-import { ChapterInfo, Comic, commands } from '../bindings.ts'
+import { ChapterInfo, ChapterLanguage, ChapterLocation, Comic, commands, DownloadOrder } from '../bindings.ts'
 import { useEffect, useMemo, useState } from 'react'
 import SelectionArea, { SelectionEvent } from '@viselect/react'
+import ChapterReader from '../components/ChapterReader.tsx'
+import { save } from '@tauri-apps/plugin-dialog'
+import { useProxiedImageUrl } from '../utils.ts'
+
+// 章节列表中大多没有更新日期，过滤用的日期是从标题或漫画的更新时间推测出来的
+const UPDATE_DATE_HINT =
+  '按章节的更新日期过滤。章节的更新日期大多是从标题或漫画的更新时间推测的，不一定准确，不知道更新日期的章节视为很久以前更新的'
+
+// `days`天前的本地日期，格式为YYYY-MM-DD
+function localDateDaysAgo(days: number): string {
+  const date = new Date()
+  date.setDate(date.getDate() - days)
+  const month = String(date.getMonth() + 1).padStart(2, '0')
+  const day = String(date.getDate()).padStart(2, '0')
+  return `${date.getFullYear()}-${month}-${day}`
+}
 
 interface Props {
   pickedComic: Comic | undefined
@@ -21,15 +42,17 @@ interface Props {
 }
 
 function ChapterPane({ pickedComic, setPickedComic }: Props) {
-  const { message, notification } = AntdApp.useApp()
+  const { message, notification, modal } = AntdApp.useApp()
+  const coverUrl = useProxiedImageUrl(pickedComic?.cover ?? '')
   // 按章节数排序的分组
   const sortedGroups = useMemo<[string, ChapterInfo[]][] | undefined>(() => {
     const groups = pickedComic?.groups
     if (groups === undefined) {
       return
     }
+    // 章节数相同时按组名排序，与后端Comic::flatten_chapters的顺序保持一致
     return Object.entries(groups).sort((a, b) => {
-      return b[1].length - a[1].length
+      return b[1].length - a[1].length || (a[0] < b[0] ? -1 : a[0] > b[0] ? 1 : 0)
     })
   }, [pickedComic?.groups])
   // 第一个group的名字
@@ -51,13 +74,92 @@ function ChapterPane({ pickedComic, setPickedComic }: Props) {
   const [checkedIds, setCheckedIds] = useState<Set<number>>(new Set())
   // 已选中(被框选选到)的章节id
   const [selectedIds, setSelectedIds] = useState<Set<number>>(new Set())
-  // 如果漫画变了，清空勾选和选中状态
+  // 查找章节的关键词和匹配的章节，再次查找同一个关键词时跳到下一个匹配的章节
+  const [locatedKeyword, setLocatedKeyword] = useState<string>('')
+  const [locations, setLocations] = useState<ChapterLocation[]>([])
+  const [locationIndex, setLocationIndex] = useState<number>(0)
+  const matchedIds = useMemo<Set<number>>(() => new Set(locations.map((l) => l.chapterId)), [locations])
+  // 如果漫画变了，清空勾选、选中状态和查找结果
   useEffect(() => {
     setCheckedIds(new Set())
     setSelectedIds(new Set())
     setCurrentGroupName(firstGroupName)
+    setLocatedKeyword('')
+    setLocations([])
   }, [firstGroupName, pickedComic?.id])
 
+  // 下载历史中有记录的章节id，文件被删除后也能知道曾经下载过
+  const [historyChapterIds, setHistoryChapterIds] = useState<Set<number>>(new Set())
+  useEffect(() => {
+    if (pickedComic?.id === undefined) {
+      setHistoryChapterIds(new Set())
+      return
+    }
+    commands.listDownloadHistory({ comicId: pickedComic.id, keyword: null, limit: null }).then((entries) => {
+      setHistoryChapterIds(new Set(entries.map((entry) => entry.chapterId)))
+    })
+  }, [pickedComic?.id, pickedComic?.groups])
+
+  // 章节过滤规则，用空格分隔，以`/`开头和结尾的是正则
+  const [includeFilter, setIncludeFilter] = useState<string>('')
+  const [excludeFilter, setExcludeFilter] = useState<string>('')
+  // 只下载这些语言的章节，为空时不限制
+  const [languageFilter, setLanguageFilter] = useState<ChapterLanguage[]>([])
+  // 只下载最近多少天内更新的章节，为null时不限制
+  // 更新日期范围(YYYY-MM-DD，本地时间)，为null时不限制这一端
+  const [updatedAfter, setUpdatedAfter] = useState<string | null>(null)
+  const [updatedBefore, setUpdatedBefore] = useState<string | null>(null)
+  // 这一批章节排队下载的顺序
+  const [downloadOrder, setDownloadOrder] = useState<DownloadOrder>('OldestFirst')
+  // 正在阅读的章节
+  const [readingChapter, setReadingChapter] = useState<ChapterInfo>()
+  // 分组视图按章节组分标签页显示，列表视图把所有章节按阅读顺序排成一列
+  const [viewMode, setViewMode] = useState<'group' | 'list'>('group')
+  // 按分组标签页的顺序把所有章节排成一列，组内按章节顺序排列
+  const flattenedChapters = useMemo<ChapterInfo[]>(
+    () => sortedGroups?.flatMap(([, chapters]) => [...chapters].sort((a, b) => a.order - b.order)) ?? [],
+    [sortedGroups],
+  )
+
+  // 切换到章节所在的组，并滚动到章节的位置
+  function showLocation(location: ChapterLocation) {
+    setViewMode('group')
+    setCurrentGroupName(location.groupName)
+    // 等切换标签页后的渲染完成再滚动
+    setTimeout(() => {
+      document.querySelector(`[data-key="${location.chapterId}"]`)?.scrollIntoView({ block: 'center' })
+    })
+  }
+
+  // 按标题关键词或章节号查找章节
+  async function locateChapters(keyword: string) {
+    if (pickedComic === undefined) {
+      return
+    }
+    if (keyword === locatedKeyword && locations.length > 0) {
+      const nextIndex = (locationIndex + 1) % locations.length
+      setLocationIndex(nextIndex)
+      showLocation(locations[nextIndex])
+      return
+    }
+
+    const result = await commands.findChapters(pickedComic.id, keyword)
+    if (result.status === 'error') {
+      notification.error({ message: '查找章节失败', description: result.error.message, duration: 0 })
+      return
+    }
+    setLocatedKeyword(keyword)
+    setLocations(result.data)
+    setLocationIndex(0)
+    if (result.data.length === 0) {
+      if (keyword.trim() !== '') {
+        message.warning('没有找到匹配的章节')
+      }
+      return
+    }
+    showLocation(result.data[0])
+  }
+
   // 下载勾选的章节
   async function downloadChapters() {
     if (pickedComic === undefined) {
@@ -69,21 +171,81 @@ function ChapterPane({ pickedComic, setPickedComic }: Props) {
     if (saveMetadataResult.status === 'error') {
       notification.error({
         message: '保存元数据失败',
-        description: saveMetadataResult.error,
+        description: saveMetadataResult.error.message,
         duration: 0,
       })
       return
     }
     // 下载没有下载过的且已勾选的章节
-    const chapterToDownload = chapterInfos?.filter((c) => c.isDownloaded === false && checkedIds.has(c.chapterId))
-    if (chapterToDownload === undefined) {
+    const checkedChapters = chapterInfos?.filter((c) => c.isDownloaded === false && checkedIds.has(c.chapterId))
+    if (checkedChapters === undefined) {
       return
     }
-    const result = await commands.downloadChapters(chapterToDownload)
+    // 再按过滤规则筛一遍
+    const splitFilter = (filter: string) => filter.split(/\s+/).filter((s) => s !== '')
+    const filterResult = await commands.filterChapters(
+      checkedChapters,
+      splitFilter(includeFilter),
+      splitFilter(excludeFilter),
+      languageFilter,
+      updatedAfter,
+      updatedBefore,
+    )
+    if (filterResult.status === 'error') {
+      notification.error({
+        message: '过滤章节失败',
+        description: filterResult.error.message,
+        duration: 0,
+      })
+      return
+    }
+    const chapterToDownload = filterResult.data
+    if (chapterToDownload.length === 0) {
+      message.warning('过滤后没有要下载的章节')
+      return
+    }
+    // 先预览下载计划，让用户确认后再开始下载
+    const previewResult = await commands.previewDownload(chapterToDownload)
+    if (previewResult.status === 'error') {
+      notification.error({
+        message: '预览下载失败',
+        description: previewResult.error.message,
+        duration: 0,
+      })
+      return
+    }
+    const plan = previewResult.data
+    const toMB = (size: number) => (size / 1024 / 1024).toFixed(2)
+    const confirmed = await modal.confirm({
+      title: '确认下载',
+      width: 600,
+      okText: '开始下载',
+      cancelText: '取消',
+      content: (
+        <div className="flex flex-col gap-1">
+          <span>
+            共{plan.chapters.length}话，{plan.totalPages}张图片，预计{toMB(plan.estimatedSize)} MB
+            {plan.availableSpace !== null && `，磁盘剩余${toMB(plan.availableSpace)} MB`}
+          </span>
+          <div className="max-h-64 overflow-auto">
+            {plan.chapters.map((c) => (
+              <div key={c.chapterInfo.chapterId} className="text-xs" title={c.downloadDir}>
+                {c.exists && '(已存在) '}
+                {c.chapterInfo.groupName} - {c.chapterInfo.chapterTitle}：{c.pageCount}页，约{toMB(c.estimatedSize)} MB
+              </div>
+            ))}
+          </div>
+        </div>
+      ),
+    })
+    if (!confirmed) {
+      return
+    }
+    const result = await commands.downloadChapters(chapterToDownload, 'High', downloadOrder)
     if (result.status === 'error') {
       notification.error({
         message: '下载失败',
-        description: result.error,
+        description: result.error.message,
         duration: 0,
       })
       return
@@ -108,19 +270,55 @@ function ChapterPane({ pickedComic, setPickedComic }: Props) {
     })
   }
 
-  // 重新加载选中的漫画
+  // 勾选当前分组中所有未下载的章节，与右键菜单的全选相同
+  function checkCurrentGroup() {
+    setCheckedIds((prev) => withUndownloadedIds(prev, pickedComic?.groups[currentGroupName]))
+  }
+
+  // 重新加载选中的漫画，只更新有变化的分组
   async function reloadPickedComic() {
     if (pickedComic === undefined) {
       return
     }
 
-    const result = await commands.getComic(pickedComic.id)
+    const result = await commands.getComicDiff(pickedComic)
     if (result.status === 'error') {
-      console.error(result.error)
+      console.error(result.error.message)
       return
     }
 
-    setPickedComic(() => result.data)
+    const diff = result.data
+    const changedCount = diff.added.length + diff.changed.length + diff.removed.length
+    if (changedCount === 0) {
+      message.info('章节没有变化')
+    } else {
+      message.success(`新增${diff.added.length}话，变化${diff.changed.length}话，移除${diff.removed.length}话`)
+    }
+
+    setPickedComic((prev) => {
+      if (prev === undefined || prev.id !== diff.id) {
+        return prev
+      }
+      const chapterKey = (c: ChapterInfo) => `${c.groupName}-${c.chapterId}`
+      const removedKeys = new Set(diff.removed.map(chapterKey))
+      const changedChapters = new Map(diff.changed.map((c) => [chapterKey(c), c]))
+      const touchedGroupNames = new Set([...diff.added, ...diff.changed, ...diff.removed].map((c) => c.groupName))
+      // 没有变化的分组保持原来的引用，避免重新渲染
+      const groups = { ...prev.groups }
+      for (const groupName of touchedGroupNames) {
+        const chapters = (groups[groupName] ?? [])
+          .filter((c) => !removedKeys.has(chapterKey(c)))
+          .map((c) => changedChapters.get(chapterKey(c)) ?? c)
+        chapters.push(...diff.added.filter((c) => c.groupName === groupName))
+        chapters.sort((a, b) => a.order - b.order)
+        if (chapters.length === 0) {
+          delete groups[groupName]
+        } else {
+          groups[groupName] = chapters
+        }
+      }
+      return { ...prev, status: diff.status, updateTime: diff.updateTime, groups }
+    })
   }
 
   return (
@@ -132,11 +330,17 @@ function ChapterPane({ pickedComic, setPickedComic }: Props) {
         <Divider type="vertical" />
         <span>已勾选：{checkedIds.size}</span>
       </div>
+      {pickedComic?.requiresLogin && (
+        <Alert type="warning" showIcon banner message="网站提示登录后可以看到更多章节，当前章节列表可能不完整，登录后刷新即可" />
+      )}
       <div className="flex justify-between select-none">
-        左键拖动进行框选，右键打开菜单
+        左键拖动进行框选，右键打开菜单，双击章节阅读
         <Button className="w-1/6" disabled={pickedComic === undefined} size="small" onClick={reloadPickedComic}>
           刷新
         </Button>
+        <Button className="w-1/6" disabled={pickedComic === undefined} size="small" onClick={checkCurrentGroup}>
+          全选{currentGroupName}
+        </Button>
         <Button
           className="w-1/4"
           disabled={pickedComic === undefined}
@@ -146,20 +350,135 @@ function ChapterPane({ pickedComic, setPickedComic }: Props) {
           下载勾选章节
         </Button>
       </div>
-      <ChapterTabs
-        pickedComic={pickedComic}
-        sortedGroups={sortedGroups}
-        setCheckedIds={setCheckedIds}
-        selectedIds={selectedIds}
-        setSelectedIds={setSelectedIds}
-        checkedIds={checkedIds}
-        currentGroupName={currentGroupName}
-        setCurrentGroupName={setCurrentGroupName}
-      />
+      <div className="flex gap-col-1">
+        <Input
+          size="small"
+          prefix="包含"
+          placeholder="关键词或/正则/，空格分隔"
+          value={includeFilter}
+          onChange={(e) => setIncludeFilter(e.target.value)}
+          allowClear={true}
+        />
+        <Input
+          size="small"
+          prefix="排除"
+          placeholder="例如：番外 特别篇"
+          value={excludeFilter}
+          onChange={(e) => setExcludeFilter(e.target.value)}
+          allowClear={true}
+        />
+        <Select<ChapterLanguage[]>
+          size="small"
+          mode="multiple"
+          className="min-w-40"
+          placeholder="语言：不限"
+          value={languageFilter}
+          onChange={setLanguageFilter}
+          allowClear={true}
+          options={[
+            { value: 'Simplified', label: '简体' },
+            { value: 'Traditional', label: '繁体' },
+            { value: 'Japanese', label: '日文' },
+            { value: 'Unknown', label: '未标注' },
+          ]}
+        />
+        <Select<number | null>
+          size="small"
+          className="min-w-32"
+          title={UPDATE_DATE_HINT}
+          value={null}
+          onChange={(days) => {
+            setUpdatedAfter(days === null ? null : localDateDaysAgo(days))
+            setUpdatedBefore(null)
+          }}
+          options={[
+            { value: null, label: '快速选择更新时间' },
+            { value: 7, label: '最近一周更新' },
+            { value: 30, label: '最近一个月更新' },
+            { value: 90, label: '最近三个月更新' },
+            { value: 365, label: '最近一年更新' },
+          ]}
+        />
+        <Input
+          className="w-40"
+          size="small"
+          type="date"
+          prefix="从"
+          title={UPDATE_DATE_HINT}
+          value={updatedAfter ?? ''}
+          onChange={(e) => setUpdatedAfter(e.target.value === '' ? null : e.target.value)}
+        />
+        <Input
+          className="w-40"
+          size="small"
+          type="date"
+          prefix="到"
+          title={UPDATE_DATE_HINT}
+          value={updatedBefore ?? ''}
+          onChange={(e) => setUpdatedBefore(e.target.value === '' ? null : e.target.value)}
+        />
+        <Select<DownloadOrder>
+          size="small"
+          className="min-w-32"
+          value={downloadOrder}
+          onChange={setDownloadOrder}
+          options={[
+            { value: 'OldestFirst', label: '从旧到新下载' },
+            { value: 'NewestFirst', label: '从新到旧下载' },
+          ]}
+        />
+      </div>
+      <div className="flex items-center gap-col-1">
+        <Segmented
+          size="small"
+          value={viewMode}
+          onChange={setViewMode}
+          options={[
+            { value: 'group', label: '分组视图' },
+            { value: 'list', label: '列表视图' },
+          ]}
+        />
+        <Input.Search
+          size="small"
+          className="w-64"
+          placeholder="按标题或章节号查找，回车跳到下一个"
+          disabled={pickedComic === undefined}
+          onSearch={locateChapters}
+          allowClear={true}
+        />
+        {locations.length > 0 && (
+          <span className="text-gray">
+            {locationIndex + 1}/{locations.length}
+          </span>
+        )}
+      </div>
+      {viewMode === 'group' ? (
+        <ChapterTabs
+          pickedComic={pickedComic}
+          sortedGroups={sortedGroups}
+          setCheckedIds={setCheckedIds}
+          selectedIds={selectedIds}
+          setSelectedIds={setSelectedIds}
+          checkedIds={checkedIds}
+          historyChapterIds={historyChapterIds}
+          matchedIds={matchedIds}
+          currentGroupName={currentGroupName}
+          setCurrentGroupName={setCurrentGroupName}
+          setReadingChapter={setReadingChapter}
+        />
+      ) : (
+        <ChapterList
+          chapters={flattenedChapters}
+          checkedIds={checkedIds}
+          setCheckedIds={setCheckedIds}
+          setReadingChapter={setReadingChapter}
+        />
+      )}
+      <ChapterReader chapterInfo={readingChapter} onClose={() => setReadingChapter(undefined)} />
       {pickedComic !== undefined && (
         <Card className="cursor-auto m-0! rounded-none" styles={{ body: { padding: '0.25rem' } }}>
           <div className="flex">
-            <img className="w-24" src={pickedComic.cover} alt="" />
+            <img className="w-24" src={coverUrl} alt="" />
             <div className="flex flex-col h-full">
               <span className="font-bold text-xl line-clamp-3">
                 {pickedComic.title}
@@ -167,6 +486,17 @@ function ChapterPane({ pickedComic, setPickedComic }: Props) {
               </span>
               <span className="text-red">作者：{pickedComic.authors.join(', ')}</span>
               <span className="text-gray">类型：{pickedComic.genres.join(' ')}</span>
+              {pickedComic.formerTitles.length > 0 && (
+                <span className="text-gray">曾用名：{pickedComic.formerTitles.join(', ')}</span>
+              )}
+              {pickedComic.dirName !== pickedComic.title && (
+                <span className="text-gray">下载目录：{pickedComic.dirName}</span>
+              )}
+              {pickedComic.voteCount !== null && (
+                <span className="text-gray">
+                  评分：{pickedComic.rating !== null ? `${pickedComic.rating}（${pickedComic.voteCount}人评分）` : '暂无评分'}
+                </span>
+              )}
             </div>
           </div>
         </Card>
@@ -175,6 +505,56 @@ function ChapterPane({ pickedComic, setPickedComic }: Props) {
   )
 }
 
+// 返回在`checkedIds`的基础上加入`chapters`中所有未下载章节id的新集合
+function withUndownloadedIds(checkedIds: Set<number>, chapters: ChapterInfo[] | undefined): Set<number> {
+  const next = new Set(checkedIds)
+  chapters?.filter((c) => c.isDownloaded === false).forEach((c) => next.add(c.chapterId))
+  return next
+}
+
+interface ChapterListProps {
+  chapters: ChapterInfo[]
+  checkedIds: Set<number>
+  setCheckedIds: (value: ((prevState: Set<number>) => Set<number>) | Set<number>) => void
+  setReadingChapter: (chapter: ChapterInfo) => void
+}
+
+function ChapterList({ chapters, checkedIds, setCheckedIds, setReadingChapter }: ChapterListProps) {
+  const onCheckboxChange: CheckboxProps['onChange'] = (e) => {
+    setCheckedIds((prev) => {
+      const next = new Set(prev)
+      const id = e.target.value
+      if (e.target.checked) {
+        next.add(id)
+      } else {
+        next.delete(id)
+      }
+      return next
+    })
+  }
+
+  return (
+    <div className="h-full flex flex-col gap-row-1 overflow-auto">
+      {chapters.map((chapter) => (
+        <div
+          className={chapter.isDownloaded ? 'downloaded' : ''}
+          key={chapter.chapterId}
+          onDoubleClick={() => setReadingChapter(chapter)}>
+          <Checkbox
+            value={chapter.chapterId}
+            checked={checkedIds.has(chapter.chapterId)}
+            disabled={chapter.isDownloaded === true}
+            onChange={onCheckboxChange}>
+            <Tag>{chapter.groupName}</Tag>
+            {chapter.isLocked && '🔒'}
+            {chapter.chapterTitle}
+          </Checkbox>
+        </div>
+      ))}
+    </div>
+  )
+}
+
 interface ChapterTabsProps {
   pickedComic: Comic | undefined
   sortedGroups?: [string, ChapterInfo[]][]
@@ -182,8 +562,11 @@ interface ChapterTabsProps {
   selectedIds: Set<number>
   setSelectedIds: (value: ((prevState: Set<number>) => Set<number>) | Set<number>) => void
   checkedIds: Set<number>
+  historyChapterIds: Set<number>
+  matchedIds: Set<number>
   currentGroupName: string
   setCurrentGroupName: (value: string) => void
+  setReadingChapter: (chapter: ChapterInfo) => void
 }
 
 function ChapterTabs({
@@ -193,8 +576,11 @@ function ChapterTabs({
   selectedIds,
   setSelectedIds,
   checkedIds,
+  historyChapterIds,
+  matchedIds,
   currentGroupName,
   setCurrentGroupName,
+  setReadingChapter,
 }: ChapterTabsProps) {
   // 当前分组
   const currentGroup = pickedComic?.groups[currentGroupName]
@@ -276,11 +662,7 @@ function ChapterTabs({
         key: 'check all',
         onClick: () =>
           // 将当前分组中未下载的章节id加入已勾选的章节id中
-          setCheckedIds((prev) => {
-            const next = new Set(prev)
-            currentGroup?.filter((c) => c.isDownloaded === false).forEach((c) => next.add(c.chapterId))
-            return next
-          }),
+          setCheckedIds((prev) => withUndownloadedIds(prev, currentGroup)),
       },
       {
         label: '取消全选',
@@ -308,15 +690,24 @@ function ChapterTabs({
               <div className="grid grid-cols-3 gap-1.5 w-full mb-3">
                 {chapters.map((chapter) => (
                   <div
-                    className={`${selectedIds.has(chapter.chapterId) ? 'selected' : ''} ${chapter.isDownloaded ? 'downloaded' : ''} selectable`}
+                    className={`${selectedIds.has(chapter.chapterId) ? 'selected' : ''} ${chapter.isDownloaded ? 'downloaded' : ''} ${matchedIds.has(chapter.chapterId) ? 'matched' : ''} selectable`}
                     key={chapter.chapterId}
-                    data-key={chapter.chapterId}>
+                    data-key={chapter.chapterId}
+                    onDoubleClick={() => setReadingChapter(chapter)}>
                     <Checkbox
                       value={chapter.chapterId}
                       checked={checkedIds.has(chapter.chapterId)}
                       disabled={chapter.isDownloaded === true}
                       onChange={onCheckboxChange}>
-                      <span title={chapter.chapterTitle}>{chapter.chapterTitle}</span>
+                      <span title={chapter.isLocked ? `${chapter.chapterTitle}(需要付费或登录)` : chapter.chapterTitle}>
+                        {chapter.isLocked && '🔒'}
+                        {chapter.chapterTitle}
+                        {chapter.isDownloaded === false && historyChapterIds.has(chapter.chapterId) && (
+                          <span className="text-gray" title="下载历史中有记录，但文件已不在下载目录中">
+                            (曾下载)
+                          </span>
+                        )}
+                      </span>
                     </Checkbox>
                   </div>
                 ))}
@@ -326,21 +717,72 @@ function ChapterTabs({
         </Dropdown>
       ),
     }))
-  }, [sortedGroups, setSelectedIds, setCheckedIds, selectedIds, currentGroup, checkedIds])
+  }, [
+    sortedGroups,
+    setSelectedIds,
+    setCheckedIds,
+    selectedIds,
+    currentGroup,
+    checkedIds,
+    historyChapterIds,
+    matchedIds,
+    setReadingChapter,
+  ])
+
+  // 点击推荐的漫画时切换到它
+  async function pickRelatedComic(id: number) {
+    const result = await commands.getComic(id)
+    if (result.status === 'error') {
+      notification.error({
+        message: '获取漫画信息失败',
+        description: result.error.message,
+        duration: 0,
+      })
+      return
+    }
+    setPickedComic(() => result.data)
+  }
+
+  // 把漫画信息导出为json，方便备份或者给其他工具使用
+  async function exportComicJson(comic: Comic) {
+    const jsonPath = await save({ defaultPath: `${comic.title}.json` })
+    if (jsonPath === null) {
+      return
+    }
+    const result = await commands.exportComicJson(comic.id, jsonPath)
+    if (result.status === 'error') {
+      notification.error({ message: '导出漫画信息失败', description: result.error.message, duration: 0 })
+      return
+    }
+    message.success('导出漫画信息成功')
+  }
 
   if (pickedComic === undefined) {
     return <Empty description="请先进行漫画搜索" />
   }
 
   return (
-    <Tabs
-      key={pickedComic.id}
-      activeKey={currentGroupName}
-      className="flex-1 overflow-auto select-none"
-      size="small"
-      items={items}
-      onChange={setCurrentGroupName}
-    />
+    <div className="flex-1 flex flex-col overflow-hidden">
+      <Tabs
+        key={pickedComic.id}
+        activeKey={currentGroupName}
+        className="flex-1 overflow-auto select-none"
+        size="small"
+        items={items}
+        onChange={setCurrentGroupName}
+      />
+      <div className="flex flex-wrap items-center gap-1 pt-1">
+        <Button size="small" onClick={() => exportComicJson(pickedComic)}>
+          导出JSON
+        </Button>
+        {pickedComic.related.length > 0 && <span>喜欢这部漫画的人也喜欢:</span>}
+        {pickedComic.related.map((comic) => (
+          <Tag className="cursor-pointer" key={comic.id} onClick={() => pickRelatedComic(comic.id)}>
+            {comic.title}
+          </Tag>
+        ))}
+      </div>
+    </div>
   )
 }
 
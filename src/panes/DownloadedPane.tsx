@@ -1,11 +1,12 @@
-import { Comic, commands, Config, events } from '../bindings.ts'
+import { Comic, commands, Config, events, ExportFormat, ReorganizeAction } from '../bindings.ts'
 import { CurrentTabName } from '../types.ts'
 import { useEffect, useMemo, useRef, useState } from 'react'
-import { App as AntdApp, Button, Input, Pagination } from 'antd'
+import { App as AntdApp, Button, Checkbox, Input, Pagination, Select } from 'antd'
 import DownloadedComicCard from '../components/DownloadedComicCard.tsx'
 import { MessageInstance } from 'antd/es/message/interface'
 import { open } from '@tauri-apps/plugin-dialog'
 import { revealItemInDir } from '@tauri-apps/plugin-opener'
+import { convertFileSrc } from '@tauri-apps/api/core'
 
 interface ProgressData {
   comicTitle: string
@@ -22,11 +23,17 @@ interface Props {
 }
 
 function DownloadedPane({ config, setConfig, setPickedComic, currentTabName, setCurrentTabName }: Props) {
-  const { message, notification } = AntdApp.useApp()
+  const { message, notification, modal } = AntdApp.useApp()
 
   const [downloadedComics, setDownloadedComics] = useState<Comic[]>([])
   const [downloadedPageNum, setDownloadedPageNum] = useState<number>(1)
   const progresses = useRef<Map<string, ProgressData>>(new Map())
+  // 导出规则的文本，形如`单行本:Pdf 单话:Cbz`，失去焦点时才解析并写入配置
+  const [exportRulesText, setExportRulesText] = useState<string>(() =>
+    Object.entries(config.exportFormatRules)
+      .map(([groupName, format]) => `${groupName}:${format}`)
+      .join(' '),
+  )
 
   const showingDownloadedComics = useMemo<Comic[]>(() => {
     const PAGE_SIZE = 20
@@ -42,7 +49,7 @@ function DownloadedPane({ config, setConfig, setPickedComic, currentTabName, set
 
     commands.getDownloadedComics().then(async (result) => {
       if (result.status === 'error') {
-        notification.error({ message: '获取本地库存失败', description: result.error, duration: 0 })
+        notification.error({ message: '获取本地库存失败', description: result.error.message, duration: 0 })
         return
       }
 
@@ -60,6 +67,7 @@ function DownloadedPane({ config, setConfig, setPickedComic, currentTabName, set
     let unListenExportCbzEvent: () => void | undefined
     let unListenExportPdfEvent: () => void | undefined
     let unListenUpdateEvents: () => void | undefined
+    let unListenWebDavSyncEvent: () => void | undefined
 
     events.exportCbzEvent
       .listen(async ({ payload: exportCbzEvent }) => {
@@ -166,6 +174,45 @@ function DownloadedPane({ config, setConfig, setPickedComic, currentTabName, set
         }
       })
 
+    events.webDavSyncEvent
+      .listen(async ({ payload: webDavSyncEvent }) => {
+        if (webDavSyncEvent.event === 'Start') {
+          const { uuid, dirName, total } = webDavSyncEvent.data
+          progresses.current.set(uuid, { comicTitle: dirName, current: 0, total })
+          messageRef.current.loading({ key: uuid, content: `${dirName} 正在同步到WebDAV(0/${total})`, duration: 0 })
+        } else if (webDavSyncEvent.event === 'Progress') {
+          const { uuid, current } = webDavSyncEvent.data
+          const progressData = progresses.current.get(uuid)
+          if (progressData === undefined) {
+            return
+          }
+          progresses.current.set(uuid, { ...progressData, current })
+          messageRef.current.loading({
+            key: uuid,
+            content: `${progressData.comicTitle} 正在同步到WebDAV(${current}/${progressData.total})`,
+            duration: 0,
+          })
+        } else if (webDavSyncEvent.event === 'End') {
+          const { uuid } = webDavSyncEvent.data
+          const progressData = progresses.current.get(uuid)
+          if (progressData === undefined) {
+            return
+          }
+          messageRef.current.success({
+            key: uuid,
+            content: `${progressData.comicTitle} 同步到WebDAV完成(${progressData.total}/${progressData.total})`,
+          })
+          progresses.current.delete(uuid)
+        }
+      })
+      .then((unListenFn) => {
+        if (mounted) {
+          unListenWebDavSyncEvent = unListenFn
+        } else {
+          unListenFn()
+        }
+      })
+
     events.updateDownloadedComicsEvent
       .listen(async ({ payload: updateEvent }) => {
         if (updateEvent.event === 'GettingComics') {
@@ -202,6 +249,7 @@ function DownloadedPane({ config, setConfig, setPickedComic, currentTabName, set
       unListenExportCbzEvent?.()
       unListenExportPdfEvent?.()
       unListenUpdateEvents?.()
+      unListenWebDavSyncEvent?.()
     }
   }, [])
 
@@ -218,14 +266,115 @@ function DownloadedPane({ config, setConfig, setPickedComic, currentTabName, set
     })
   }
 
+  // 把导出规则的文本解析为章节组名->导出格式，格式不合法的规则会被忽略
+  function applyExportRules() {
+    const formats: ExportFormat[] = ['Cbz', 'Pdf', 'Epub']
+    const rules: { [key in string]: ExportFormat } = {}
+    for (const rule of exportRulesText.split(/\s+/)) {
+      const [groupName, format] = rule.split(':')
+      const matchedFormat = formats.find((f) => f.toLowerCase() === format?.trim().toLowerCase())
+      if (groupName === '' || matchedFormat === undefined) {
+        continue
+      }
+      rules[groupName] = matchedFormat
+    }
+    setConfig((prev) => (prev === undefined ? prev : { ...prev, exportFormatRules: rules }))
+  }
+
   // 更新已下载漫画
   async function updateDownloadedComics() {
     const result = await commands.updateDownloadedComics()
     if (result.status === 'error') {
-      notification.error({ message: '更新已下载漫画失败', description: result.error, duration: 0 })
+      notification.error({ message: '更新已下载漫画失败', description: result.error.message, duration: 0 })
     }
   }
 
+  // 先生成整理计划给用户确认，确认后才真正改动文件
+  async function reorganizeLibrary() {
+    const planResult = await commands.planReorganizeLibrary()
+    if (planResult.status === 'error') {
+      notification.error({ message: '生成整理计划失败', description: planResult.error.message, duration: 0 })
+      return
+    }
+    const { actions } = planResult.data
+    if (actions.length === 0) {
+      message.success('目录结构已经是最新的，无需整理')
+      return
+    }
+    const describe = (action: ReorganizeAction) => {
+      if (action.kind === 'rename') {
+        return `重命名 ${action.from} → ${action.to}`
+      } else if (action.kind === 'merge') {
+        return `合并 ${action.from} → ${action.to}`
+      }
+      return `删除空目录 ${action.dir}`
+    }
+    modal.confirm({
+      title: `将执行以下${actions.length}个操作`,
+      width: 800,
+      content: (
+        <div className="max-h-96 overflow-auto flex flex-col text-xs">
+          {actions.map((action, i) => (
+            <span key={i}>{describe(action)}</span>
+          ))}
+        </div>
+      ),
+      okText: '执行',
+      cancelText: '取消',
+      onOk: async () => {
+        // 执行的是用户刚才确认过的操作，而不是重新生成的计划
+        const { errors } = await commands.reorganizeLibrary(actions)
+        if (errors.length > 0) {
+          notification.warning({ message: '部分操作失败', description: errors.join('\n'), duration: 0 })
+        } else {
+          message.success('整理完成')
+        }
+        const comicsResult = await commands.getDownloadedComics()
+        if (comicsResult.status === 'ok') {
+          setDownloadedComics(comicsResult.data)
+        }
+      },
+    })
+  }
+
+  // 扫描下载目录生成书架索引，总览每本漫画的下载进度，没有改动过的漫画直接用上次的结果
+  async function showLibraryIndex() {
+    const key = 'library-index'
+    message.loading({ key, content: '正在扫描下载目录...', duration: 0 })
+    const result = await commands.buildLibraryIndex()
+    message.destroy(key)
+    if (result.status === 'error') {
+      notification.error({ message: '生成书架索引失败', description: result.error.message, duration: 0 })
+      return
+    }
+    const index = result.data
+    if (index.errors.length > 0) {
+      notification.warning({
+        message: `有${index.errors.length}本漫画读取失败`,
+        description: index.errors.join('\n'),
+        duration: 0,
+      })
+    }
+    modal.info({
+      title: `书架共${index.comics.length}本漫画`,
+      width: 720,
+      content: (
+        <div className="max-h-96 overflow-auto flex flex-col gap-row-1">
+          {index.comics.map((entry) => (
+            <div key={entry.id} className="flex items-center gap-col-2">
+              {entry.coverPath !== null && <img className="w-8" src={convertFileSrc(entry.coverPath)} alt="" />}
+              <span className="flex-1 line-clamp-1">{entry.title}</span>
+              <span className="text-gray whitespace-nowrap">
+                已下载{entry.downloadedCount}/{entry.chapterCount}话
+              </span>
+              <span className="text-gray whitespace-nowrap">更新于{entry.updateTime}</span>
+            </div>
+          ))}
+        </div>
+      ),
+    })
+  }
+
   return (
     <div className="h-full flex flex-col overflow-auto">
       <div className="flex gap-col-1">
@@ -236,6 +385,81 @@ function DownloadedPane({ config, setConfig, setPickedComic, currentTabName, set
         <Button size="small" onClick={updateDownloadedComics}>
           更新库存
         </Button>
+        <Button size="small" onClick={reorganizeLibrary}>
+          整理库存
+        </Button>
+        <Button size="small" onClick={showLibraryIndex}>
+          书架总览
+        </Button>
+      </div>
+      <div className="flex gap-col-1">
+        <Input
+          value={config.webdavUrl}
+          prefix="WebDAV"
+          placeholder="https://nas.local/dav/漫画"
+          size="small"
+          onChange={(e) => setConfig((prev) => (prev === undefined ? prev : { ...prev, webdavUrl: e.target.value }))}
+        />
+        <Input
+          className="w-40"
+          value={config.webdavUsername}
+          prefix="用户名"
+          size="small"
+          onChange={(e) =>
+            setConfig((prev) => (prev === undefined ? prev : { ...prev, webdavUsername: e.target.value }))
+          }
+        />
+        <Input.Password
+          className="w-40"
+          value={config.webdavPassword}
+          prefix="密码"
+          placeholder="不会保存到磁盘"
+          size="small"
+          onChange={(e) =>
+            setConfig((prev) => (prev === undefined ? prev : { ...prev, webdavPassword: e.target.value }))
+          }
+        />
+      </div>
+      <div className="flex gap-col-1">
+        <Input
+          value={exportRulesText}
+          prefix="导出规则"
+          placeholder="单行本:Pdf 单话:Cbz"
+          size="small"
+          onChange={(e) => setExportRulesText(e.target.value)}
+          onBlur={applyExportRules}
+        />
+        <Select
+          className="w-40 shrink-0"
+          size="small"
+          value={config.defaultExportFormat}
+          onChange={(defaultExportFormat) =>
+            setConfig((prev) => (prev === undefined ? prev : { ...prev, defaultExportFormat }))
+          }
+          options={[
+            { value: 'Cbz', label: '其他分组: cbz' },
+            { value: 'Pdf', label: '其他分组: pdf' },
+            { value: 'Epub', label: '其他分组: epub' },
+          ]}
+        />
+        <Checkbox
+          className="whitespace-nowrap items-center"
+          checked={config.splitLongImagesInPdf}
+          onChange={(e) =>
+            setConfig((prev) => (prev === undefined ? prev : { ...prev, splitLongImagesInPdf: e.target.checked }))
+          }>
+          pdf切割长图
+        </Checkbox>
+        <Checkbox
+          className="whitespace-nowrap items-center"
+          checked={config.placeholderForMissingPages}
+          onChange={(e) =>
+            setConfig((prev) =>
+              prev === undefined ? prev : { ...prev, placeholderForMissingPages: e.target.checked },
+            )
+          }>
+          缺页用占位图
+        </Checkbox>
       </div>
       <div className="h-full flex flex-col gap-row-1 overflow-auto">
         <div className="h-full flex flex-col gap-row-2 overflow-auto p-2">
@@ -243,6 +467,7 @@ function DownloadedPane({ config, setConfig, setPickedComic, currentTabName, set
             <DownloadedComicCard
               key={comic.id}
               comic={comic}
+              config={config}
               setPickedComic={setPickedComic}
               setCurrentTabName={setCurrentTabName}
             />
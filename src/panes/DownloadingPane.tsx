@@ -1,14 +1,17 @@
-import { App as AntdApp, Button, Input, Progress } from 'antd'
-import { Config, events } from '../bindings.ts'
+import { App as AntdApp, Button, Checkbox, Dropdown, Input, InputNumber, Progress, Select } from 'antd'
+import { commands, Config, DownloadQueueState, events } from '../bindings.ts'
 import { useEffect, useMemo, useRef, useState } from 'react'
 import { revealItemInDir } from '@tauri-apps/plugin-opener'
 import { open } from '@tauri-apps/plugin-dialog'
 
 type ProgressData = {
+    comicId: number
     comicTitle: string
     chapterTitle: string
     current: number
     total: number
+    // 按首张图片估算的整话字节数，为null时按页数计算进度
+    totalBytes: number | null
     percentage: number
     indicator: string
     retryAfter: number
@@ -21,9 +24,35 @@ interface Props {
 }
 
 function DownloadingPane({ className, config, setConfig }: Props) {
-    const { notification } = AntdApp.useApp()
+    const { notification, modal } = AntdApp.useApp()
     const [progresses, setProgresses] = useState<Map<number, ProgressData>>(new Map())
     const [downloadSpeed, setDownloadSpeed] = useState<string>()
+    const [paused, setPaused] = useState<boolean>(false)
+    // 网络断开时下载任务会等待网络恢复后自动继续
+    const [networkWaiting, setNetworkWaiting] = useState<boolean>(false)
+    // 单独暂停的章节id
+    const [pausedChapterIds, setPausedChapterIds] = useState<Set<number>>(new Set())
+    useEffect(() => {
+        commands.isDownloadPaused().then(setPaused)
+        commands.getPausedChapterIds().then((chapterIds) => setPausedChapterIds(new Set(chapterIds)))
+    }, [])
+
+    // 正在下载和排队中的漫画数量，定时刷新
+    const [queueState, setQueueState] = useState<DownloadQueueState>()
+    useEffect(() => {
+        commands.getDownloadQueueState().then(setQueueState)
+        const interval = setInterval(() => commands.getDownloadQueueState().then(setQueueState), 2000)
+        return () => clearInterval(interval)
+    }, [])
+
+    async function togglePaused() {
+        if (paused) {
+            await commands.resumeDownload()
+        } else {
+            await commands.pauseDownload()
+        }
+        setPaused(!paused)
+    }
     const sortedProgresses = useMemo(
       () =>
         Array.from(progresses.entries()).sort((a, b) => {
@@ -51,12 +80,14 @@ function DownloadingPane({ className, config, setConfig }: Props) {
           .listen(({ payload: downloadEvent }) => {
               if (downloadEvent.event == 'ChapterPending') {
                   console.log(downloadEvent)
-                  const { chapterId, comicTitle, chapterTitle } = downloadEvent.data
+                  const { chapterId, comicId, comicTitle, chapterTitle } = downloadEvent.data
                   const progressData: ProgressData = {
+                      comicId,
                       comicTitle,
                       chapterTitle,
                       current: 0,
                       total: 0,
+                      totalBytes: null,
                       percentage: 0,
                       indicator: '',
                       retryAfter: 0,
@@ -74,14 +105,14 @@ function DownloadingPane({ className, config, setConfig }: Props) {
                       return new Map(next)
                   })
               } else if (downloadEvent.event == 'ChapterStart') {
-                  const { chapterId, total } = downloadEvent.data
+                  const { chapterId, total, totalBytes } = downloadEvent.data
                   setProgresses((prev) => {
                       const progressData = prev.get(chapterId)
                       if (progressData === undefined) {
                           return prev
                       }
                       const next = new Map(prev)
-                      next.set(chapterId, { ...progressData, total })
+                      next.set(chapterId, { ...progressData, total, totalBytes })
                       return new Map(next)
                   })
               } else if (downloadEvent.event == 'ChapterEnd') {
@@ -102,15 +133,52 @@ function DownloadingPane({ className, config, setConfig }: Props) {
                       next.delete(chapterId)
                       return new Map(next)
                   })
+              } else if (downloadEvent.event == 'ChapterCanceled') {
+                  const { chapterId } = downloadEvent.data
+                  setProgresses((prev) => {
+                      const next = new Map(prev)
+                      next.delete(chapterId)
+                      return next
+                  })
+              } else if (downloadEvent.event == 'ChapterPaused') {
+                  const { chapterId } = downloadEvent.data
+                  setPausedChapterIds((prev) => new Set(prev).add(chapterId))
+              } else if (downloadEvent.event == 'ChapterResumed') {
+                  const { chapterId } = downloadEvent.data
+                  setPausedChapterIds((prev) => {
+                      const next = new Set(prev)
+                      next.delete(chapterId)
+                      return next
+                  })
+              } else if (downloadEvent.event == 'ChapterUnavailable') {
+                  const { chapterId, reason } = downloadEvent.data
+                  setProgresses((prev) => {
+                      const progressData = prev.get(chapterId)
+                      if (progressData === undefined) {
+                          return prev
+                      }
+                      notificationRef.current.warning({
+                          message: `${progressData.comicTitle} - ${progressData.chapterTitle}已被删除或下架，已跳过`,
+                          description: reason,
+                          duration: 0,
+                      })
+                      const next = new Map(prev)
+                      next.delete(chapterId)
+                      return next
+                  })
               } else if (downloadEvent.event == 'ImageSuccess') {
-                  const { chapterId, current } = downloadEvent.data
+                  const { chapterId, current, downloadedBytes } = downloadEvent.data
                   setProgresses((prev) => {
                       const progressData = prev.get(chapterId)
                       if (progressData === undefined) {
                           return prev
                       }
                       const next = new Map(prev)
-                      const percentage = Math.round((current / progressData.total) * 100)
+                      // 字节数是估算的，全部下载完之前最多显示99%
+                      const percentage =
+                        progressData.totalBytes === null || current === progressData.total
+                          ? Math.round((current / progressData.total) * 100)
+                          : Math.min(99, Math.round((downloadedBytes / progressData.totalBytes) * 100))
                       next.set(chapterId, { ...progressData, current, percentage })
                       return new Map(next)
                   })
@@ -124,6 +192,23 @@ function DownloadingPane({ className, config, setConfig }: Props) {
                       message: `${progressData.comicTitle} - ${progressData.chapterTitle}下载图片失败`,
                       description: errMsg,
                   })
+              } else if (downloadEvent.event == 'ComicCompleted') {
+                  const { comicTitle, succeeded, failed, canceled, elapsedSecs } = downloadEvent.data
+                  const summary = `成功${succeeded}话，失败${failed}话${canceled > 0 ? `，取消${canceled}话` : ''}，耗时${formatElapsed(elapsedSecs)}`
+                  if (failed > 0) {
+                      notificationRef.current.warning({ message: `${comicTitle}下载结束`, description: summary })
+                  } else {
+                      notificationRef.current.success({ message: `${comicTitle}下载完成`, description: summary })
+                  }
+              } else if (downloadEvent.event == 'BatchCompleted') {
+                  const { comicCount, succeeded, failed, canceled, elapsedSecs } = downloadEvent.data
+                  const summary = `${comicCount}本漫画，成功${succeeded}话，失败${failed}话${canceled > 0 ? `，取消${canceled}话` : ''}，耗时${formatElapsed(elapsedSecs)}`
+                  notificationRef.current.info({ message: '所有下载任务已结束', description: summary, duration: 0 })
+                  notifySystem('所有下载任务已结束', summary)
+              } else if (downloadEvent.event == 'NetworkWaiting') {
+                  setNetworkWaiting(true)
+              } else if (downloadEvent.event == 'NetworkRestored') {
+                  setNetworkWaiting(false)
               } else if (downloadEvent.event == 'Speed') {
                   const { speed } = downloadEvent.data
                   setDownloadSpeed(speed)
@@ -143,6 +228,44 @@ function DownloadingPane({ className, config, setConfig }: Props) {
         }
     }, [])
 
+    // 取消这本漫画所有未完成的下载任务
+    function cancelComicDownload(comicId: number, comicTitle: string) {
+        let deleteTempDirs = true
+        modal.confirm({
+            title: '取消下载',
+            content: (
+              <div className="flex flex-col gap-2">
+                  <span>{`取消《${comicTitle}》所有未完成的章节吗？`}</span>
+                  <Checkbox defaultChecked onChange={(e) => (deleteTempDirs = e.target.checked)}>
+                      删除未下完的章节
+                  </Checkbox>
+              </div>
+            ),
+            okText: '取消下载',
+            cancelText: '继续下载',
+            onOk: () => commands.cancelDownload(comicId, deleteTempDirs),
+        })
+    }
+
+    // 只取消这一话
+    function cancelChapterDownload(chapterId: number, comicTitle: string, chapterTitle: string) {
+        let deleteTempDirs = true
+        modal.confirm({
+            title: '取消下载',
+            content: (
+              <div className="flex flex-col gap-2">
+                  <span>{`取消《${comicTitle}》的${chapterTitle}吗？`}</span>
+                  <Checkbox defaultChecked onChange={(e) => (deleteTempDirs = e.target.checked)}>
+                      删除未下完的图片
+                  </Checkbox>
+              </div>
+            ),
+            okText: '取消下载',
+            cancelText: '继续下载',
+            onOk: () => commands.cancelChapters([chapterId], deleteTempDirs),
+        })
+    }
+
     // 通过对话框选择下载目录
     async function selectDownloadDir() {
         const selectedDirPath = await open({ directory: true })
@@ -157,6 +280,46 @@ function DownloadingPane({ className, config, setConfig }: Props) {
         })
     }
 
+    // 通过对话框选择缓存目录
+    async function selectCacheDir() {
+        const selectedDirPath = await open({ directory: true })
+        if (selectedDirPath === null) {
+            return
+        }
+        setConfig((prev) => {
+            if (prev === undefined) {
+                return prev
+            }
+            return { ...prev, cacheDir: selectedDirPath }
+        })
+    }
+
+    // 导入其他工具导出的链接列表，其中漫画柜的链接会被转换为下载任务
+    async function importExternalTasks() {
+        const path = await open({ filters: [{ name: '链接列表', extensions: ['txt'] }] })
+        if (path === null) {
+            return
+        }
+        const key = 'import-external-tasks'
+        notification.info({ key, message: '正在导入任务，需要逐个获取漫画信息，请稍候...', duration: 0 })
+        const result = await commands.importExternalTasks('UrlList', path)
+        notification.destroy(key)
+        if (result.status === 'error') {
+            notification.error({ message: '导入任务失败', description: result.error.message, duration: 0 })
+            return
+        }
+        const report = result.data
+        if (report.skipped.length > 0) {
+            notification.warning({
+                message: `导入了${report.tasks.length}个任务，跳过了${report.skipped.length}条`,
+                description: <div className="max-h-64 overflow-auto whitespace-pre-wrap">{report.skipped.join('\n')}</div>,
+                duration: 0,
+            })
+            return
+        }
+        notification.success({ message: `导入了${report.tasks.length}个任务` })
+    }
+
     return (
       <div className={`h-full flex flex-col ${className}`}>
           <span className="h-38px text-lg font-bold">下载列表</span>
@@ -166,17 +329,297 @@ function DownloadingPane({ className, config, setConfig }: Props) {
                   打开目录
               </Button>
           </div>
-          <span>下载速度: {downloadSpeed}</span>
+          <div className="flex gap-col-1">
+              <Input value={config.cacheDir} prefix="缓存目录" size="small" readOnly onClick={selectCacheDir} />
+              <Button size="small" onClick={() => revealItemInDir(config.cacheDir)}>
+                  打开目录
+              </Button>
+          </div>
+          <div className="flex justify-between">
+              <span>
+                  下载速度: {networkWaiting ? '网络已断开，恢复后自动继续' : downloadSpeed}
+                  <Button className="ml-1" size="small" onClick={togglePaused}>
+                      {paused ? '继续下载' : '暂停下载'}
+                  </Button>
+                  <Button className="ml-1" size="small" title="导入EhViewer等工具导出的链接列表" onClick={importExternalTasks}>
+                      导入任务
+                  </Button>
+                  {queueState !== undefined &&
+                    ` 下载中${queueState.activeComics.length}本，排队${queueState.waitingComics.length}本`}
+              </span>
+              <InputNumber
+                className="w-44"
+                size="small"
+                min={0}
+                precision={0}
+                prefix="同时下载漫画数"
+                title="0表示不限制"
+                value={config.maxActiveComics}
+                onChange={(value) => {
+                    if (value === null) {
+                        return
+                    }
+                    setConfig((prev) => {
+                        if (prev === undefined) {
+                            return prev
+                        }
+                        return { ...prev, maxActiveComics: value }
+                    })
+                }}
+              />
+              <Checkbox
+                checked={config.convertAnimatedWebpToGif}
+                onChange={(e) =>
+                  setConfig((prev) => {
+                      if (prev === undefined) {
+                          return prev
+                      }
+                      return { ...prev, convertAnimatedWebpToGif: e.target.checked }
+                  })
+                }>
+                  webp动图转为gif
+              </Checkbox>
+              <Checkbox
+                checked={config.adaptiveImageConcurrency}
+                onChange={(e) =>
+                  setConfig((prev) => {
+                      if (prev === undefined) {
+                          return prev
+                      }
+                      return { ...prev, adaptiveImageConcurrency: e.target.checked }
+                  })
+                }>
+                  自适应并发
+              </Checkbox>
+              <Checkbox
+                checked={config.exportCbzOnChapterCompleted}
+                onChange={(e) =>
+                  setConfig((prev) => {
+                      if (prev === undefined) {
+                          return prev
+                      }
+                      return { ...prev, exportCbzOnChapterCompleted: e.target.checked }
+                  })
+                }>
+                  下完一话自动导出cbz
+              </Checkbox>
+              <Checkbox
+                checked={config.deleteImagesAfterAutoExport}
+                disabled={!config.exportCbzOnChapterCompleted}
+                onChange={(e) =>
+                  setConfig((prev) => {
+                      if (prev === undefined) {
+                          return prev
+                      }
+                      return { ...prev, deleteImagesAfterAutoExport: e.target.checked }
+                  })
+                }>
+                  导出后删除原图
+              </Checkbox>
+          </div>
+          <div className="flex gap-col-1 items-center">
+              <Checkbox
+                checked={config.cropWatermark}
+                onChange={(e) =>
+                  setConfig((prev) => {
+                      if (prev === undefined) {
+                          return prev
+                      }
+                      return { ...prev, cropWatermark: e.target.checked }
+                  })
+                }>
+                  裁掉水印
+              </Checkbox>
+              <InputNumber
+                className="w-36"
+                size="small"
+                min={0}
+                precision={0}
+                disabled={!config.cropWatermark}
+                prefix="顶部"
+                suffix="px"
+                value={config.cropTop}
+                onChange={(value) => {
+                    if (value === null) {
+                        return
+                    }
+                    setConfig((prev) => {
+                        if (prev === undefined) {
+                            return prev
+                        }
+                        return { ...prev, cropTop: value }
+                    })
+                }}
+              />
+              <InputNumber
+                className="w-36"
+                size="small"
+                min={0}
+                precision={0}
+                disabled={!config.cropWatermark}
+                prefix="底部"
+                suffix="px"
+                value={config.cropBottom}
+                onChange={(value) => {
+                    if (value === null) {
+                        return
+                    }
+                    setConfig((prev) => {
+                        if (prev === undefined) {
+                            return prev
+                        }
+                        return { ...prev, cropBottom: value }
+                    })
+                }}
+              />
+              <InputNumber
+                className="w-36"
+                size="small"
+                min={1}
+                precision={0}
+                disabled={!config.cropWatermark}
+                prefix="基准宽度"
+                suffix="px"
+                value={config.cropReferenceWidth}
+                onChange={(value) => {
+                    if (value === null) {
+                        return
+                    }
+                    setConfig((prev) => {
+                        if (prev === undefined) {
+                            return prev
+                        }
+                        return { ...prev, cropReferenceWidth: value }
+                    })
+                }}
+              />
+          </div>
+          <div className="flex gap-col-1">
+              <Select
+                className="w-32"
+                size="small"
+                value={config.imageQuality}
+                onChange={(imageQuality) =>
+                  setConfig((prev) => {
+                      if (prev === undefined) {
+                          return prev
+                      }
+                      return { ...prev, imageQuality }
+                  })
+                }
+                options={[
+                    { value: 'Original', label: '图片质量: 原图' },
+                    { value: 'Compressed', label: '图片质量: 压缩' },
+                ]}
+              />
+              <InputNumber
+                size="small"
+                min={1}
+                max={100}
+                precision={0}
+                disabled={config.imageQuality !== 'Compressed'}
+                prefix="本地压缩比例"
+                suffix="%"
+                value={config.compressedImageScale}
+                onChange={(value) => {
+                    if (value === null) {
+                        return
+                    }
+                    setConfig((prev) => {
+                        if (prev === undefined) {
+                            return prev
+                        }
+                        return { ...prev, compressedImageScale: value }
+                    })
+                }}
+              />
+              <Select
+                className="w-40"
+                size="small"
+                value={config.downloadedCheckStrategy}
+                onChange={(downloadedCheckStrategy) =>
+                  setConfig((prev) => {
+                      if (prev === undefined) {
+                          return prev
+                      }
+                      return { ...prev, downloadedCheckStrategy }
+                  })
+                }
+                options={[
+                    { value: 'PathExists', label: '已下载判断: 目录存在' },
+                    { value: 'ImageCount', label: '已下载判断: 图片数量' },
+                    { value: 'ImageIntegrity', label: '已下载判断: 图片完整' },
+                    { value: 'Manifest', label: '已下载判断: 校验清单' },
+                ]}
+              />
+              <Select
+                className="w-48"
+                size="small"
+                value={config.crossDirDedupe}
+                onChange={(crossDirDedupe) =>
+                  setConfig((prev) => {
+                      if (prev === undefined) {
+                          return prev
+                      }
+                      return { ...prev, crossDirDedupe }
+                  })
+                }
+                options={[
+                    { value: 'Disabled', label: '别处已下载: 重新下载' },
+                    { value: 'Skip', label: '别处已下载: 跳过' },
+                    { value: 'HardLink', label: '别处已下载: 硬链接复用' },
+                ]}
+              />
+          </div>
           <div className="overflow-auto">
-              {sortedProgresses.map(([chapterId, { comicTitle, chapterTitle, percentage, current, total, retryAfter }]) => (
-                <div className="grid grid-cols-[1fr_1fr_2fr]" key={chapterId}>
+              {sortedProgresses.map(([chapterId, { comicId, comicTitle, chapterTitle, percentage, current, total, retryAfter }]) => (
+                <div className="grid grid-cols-[1fr_1fr_2fr_auto_auto_auto]" key={chapterId}>
             <span className="mb-1! text-ellipsis whitespace-nowrap overflow-hidden" title={comicTitle}>
               {comicTitle}
             </span>
                     <span className="mb-1! text-ellipsis whitespace-nowrap overflow-hidden" title={chapterTitle}>
               {chapterTitle}
             </span>
-                    <DownloadingProgress retryAfter={retryAfter} total={total} percentage={percentage} current={current} />
+                    {pausedChapterIds.has(chapterId) ? (
+                      <span className="mb-1! text-ellipsis whitespace-nowrap overflow-hidden">
+                          已暂停({current}/{total})
+                      </span>
+                    ) : (
+                      <DownloadingProgress retryAfter={retryAfter} total={total} percentage={percentage} current={current} />
+                    )}
+                    {pausedChapterIds.has(chapterId) ? (
+                      <Button size="small" type="link" onClick={() => commands.resumeChapters([chapterId])}>
+                          继续
+                      </Button>
+                    ) : (
+                      <Button size="small" type="link" onClick={() => commands.pauseChapters([chapterId])}>
+                          暂停
+                      </Button>
+                    )}
+                    <Button
+                      size="small"
+                      type="link"
+                      danger
+                      onClick={() => cancelChapterDownload(chapterId, comicTitle, chapterTitle)}>
+                        取消
+                    </Button>
+                    <Dropdown
+                      menu={{
+                          items: [
+                              { key: 'pause', label: '暂停整本', onClick: () => commands.pauseComic(comicId) },
+                              { key: 'resume', label: '继续整本', onClick: () => commands.resumeComic(comicId) },
+                              {
+                                  key: 'cancel',
+                                  label: '取消整本',
+                                  danger: true,
+                                  onClick: () => cancelComicDownload(comicId, comicTitle),
+                              },
+                          ],
+                      }}>
+                        <Button size="small" type="link">
+                            整本
+                        </Button>
+                    </Dropdown>
                 </div>
               ))}
           </div>
@@ -184,6 +627,28 @@ function DownloadingPane({ className, config, setConfig }: Props) {
     )
 }
 
+function formatElapsed(elapsedSecs: number): string {
+    const minutes = Math.floor(elapsedSecs / 60)
+    const seconds = elapsedSecs % 60
+    return minutes > 0 ? `${minutes}分${seconds}秒` : `${seconds}秒`
+}
+
+// 窗口不在前台时再弹一个系统通知，webview不支持或用户拒绝授权时什么都不做
+function notifySystem(title: string, body: string) {
+    if (!('Notification' in window) || document.hasFocus()) {
+        return
+    }
+    if (Notification.permission === 'granted') {
+        new Notification(title, { body })
+    } else if (Notification.permission !== 'denied') {
+        Notification.requestPermission().then((permission) => {
+            if (permission === 'granted') {
+                new Notification(title, { body })
+            }
+        })
+    }
+}
+
 interface DownloadingProgressProps {
     retryAfter: number
     total: number
@@ -20,7 +20,7 @@ function FavoritePane({ userProfile, setPickedComic, setCurrentTabName }: Props)
       setFavoritePageNum(pageNum)
       const result = await commands.getFavorite(pageNum)
       if (result.status === 'error') {
-        notification.error({ message: '获取收藏失败', description: result.error, duration: 0 })
+        notification.error({ message: '获取收藏失败', description: result.error.message, duration: 0 })
         return
       }
       console.log('getFavourite')
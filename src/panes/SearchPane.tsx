@@ -1,8 +1,10 @@
-import { Comic, commands, SearchResult } from '../bindings.ts'
+import { Comic, commands, SearchFilter, SearchResult, SearchSort } from '../bindings.ts'
 import { CurrentTabName } from '../types.ts'
 import { useState } from 'react'
-import { App as AntdApp, Button, Input, Pagination } from 'antd'
+import { App as AntdApp, AutoComplete, Button, Checkbox, Input, Pagination, Select } from 'antd'
 import ComicCard from '../components/ComicCard.tsx'
+import ErrorDescription from '../components/ErrorDescription.tsx'
+import { isRetryable } from '../utils.ts'
 import isNumeric from 'antd/es/_util/isNumeric'
 
 interface Props {
@@ -11,27 +13,89 @@ interface Props {
 }
 
 function SearchPane({ setPickedComic, setCurrentTabName }: Props) {
-  const { notification } = AntdApp.useApp()
+  const { message, notification } = AntdApp.useApp()
 
   const [searchInput, setSearchInput] = useState<string>('')
   const [comicIdInput, setComicIdInput] = useState<string>('')
   const [searchPageNum, setSearchPageNum] = useState<number>(1)
   const [searchResult, setSearchResult] = useState<SearchResult>()
+  const [searchSort, setSearchSort] = useState<SearchSort>('Update')
+  const [searchHistory, setSearchHistory] = useState<string[]>([])
+  const [searchFilter, setSearchFilter] = useState<SearchFilter>({
+    finishedOnly: false,
+    author: '',
+    titleContainsKeyword: false,
+  })
+  // 作者输入框的内容，按回车或失去焦点时才作为过滤条件，避免每输入一个字就重新搜索
+  const [authorInput, setAuthorInput] = useState<string>('')
 
-  async function search(keyword: string, pageNum: number) {
-    console.log(keyword, pageNum)
+  // 输入框聚焦时展示搜索历史，按输入内容过滤
+  const historyOptions = searchHistory
+    .filter((keyword) => keyword.includes(searchInput.trim()))
+    .map((keyword) => ({ value: keyword, label: keyword }))
+
+  async function loadSearchHistory() {
+    setSearchHistory(await commands.getSearchHistory())
+  }
+
+  async function clearSearchHistory() {
+    const result = await commands.clearSearchHistory()
+    if (result.status === 'error') {
+      notification.error({ message: '清空搜索历史失败', description: result.error.message, duration: 0 })
+      return
+    }
+    setSearchHistory([])
+  }
+
+  const sortOptions: { value: SearchSort; label: string }[] = [
+    { value: 'Update', label: '最新更新' },
+    { value: 'Publish', label: '最近发布' },
+    { value: 'Popularity', label: '人气最旺' },
+    { value: 'Rating', label: '评分最高' },
+  ]
+
+  async function search(
+    keyword: string,
+    pageNum: number,
+    sort: SearchSort = searchSort,
+    filter: SearchFilter = searchFilter,
+  ) {
+    console.log(keyword, pageNum, sort)
     setSearchPageNum(pageNum)
-    const result = await commands.search(keyword, pageNum)
+    const result = await commands.search(keyword, pageNum, sort, filter)
     if (result.status === 'error') {
+      const error = result.error
       notification.error({
+        key: 'search-error',
         message: '搜索失败',
-        description: result.error,
+        description: <ErrorDescription error={error} />,
+        btn: isRetryable(error) && (
+          <Button
+            size="small"
+            onClick={async () => {
+              notification.destroy('search-error')
+              await search(keyword, pageNum, sort, filter)
+            }}>
+            重试
+          </Button>
+        ),
         duration: 0,
       })
       return
     }
     setSearchResult(result.data)
     console.log(result.data)
+    if (result.data.keyword !== keyword) {
+      message.info(`没有找到「${keyword}」，已换成「${result.data.keyword}」搜索`)
+    }
+  }
+
+  // 过滤在后端搜索时进行，修改过滤条件后重新搜索当前页
+  async function applySearchFilter(filter: SearchFilter) {
+    setSearchFilter(filter)
+    if (searchResult !== undefined) {
+      await search(searchResult.keyword, searchResult.current, searchResult.sort, filter)
+    }
   }
 
   function getComicIdFromComicIdInput(): number | undefined {
@@ -62,9 +126,21 @@ function SearchPane({ setPickedComic, setCurrentTabName }: Props) {
 
     const result = await commands.getComic(comicId)
     if (result.status === 'error') {
+      const error = result.error
       notification.error({
+        key: 'get-comic-error',
         message: '获取漫画信息失败',
-        description: result.error,
+        description: <ErrorDescription error={error} />,
+        btn: isRetryable(error) && (
+          <Button
+            size="small"
+            onClick={async () => {
+              notification.destroy('get-comic-error')
+              await pickComic()
+            }}>
+            重试
+          </Button>
+        ),
         duration: 0,
       })
       return
@@ -78,14 +154,41 @@ function SearchPane({ setPickedComic, setCurrentTabName }: Props) {
     <div className="h-full flex flex-col">
       <div className="flex flex-col">
         <div className="flex">
-          <Input
-            prefix="关键词:"
-            size="small"
-            allowClear
+          <AutoComplete
+            className="w-full"
+            options={historyOptions}
             value={searchInput}
-            onChange={(e) => setSearchInput(e.target.value)}
-            onKeyDown={async (e) => {
-              if (e.key === 'Enter') await search(searchInput.trim(), 1)
+            onChange={setSearchInput}
+            onFocus={loadSearchHistory}
+            onSelect={async (keyword: string) => await search(keyword, 1)}
+            dropdownRender={(menu) => (
+              <>
+                {menu}
+                {searchHistory.length > 0 && (
+                  <Button type="link" size="small" onMouseDown={(e) => e.preventDefault()} onClick={clearSearchHistory}>
+                    清空搜索历史
+                  </Button>
+                )}
+              </>
+            )}>
+            <Input
+              prefix="关键词:"
+              size="small"
+              allowClear
+              onKeyDown={async (e) => {
+                if (e.key === 'Enter') await search(searchInput.trim(), 1)
+              }}
+            />
+          </AutoComplete>
+          <Select
+            size="small"
+            className="w-28"
+            options={sortOptions}
+            value={searchSort}
+            onChange={async (sort) => {
+              setSearchSort(sort)
+              // 已经有搜索结果时，换排序方式直接重新搜索第一页
+              if (searchResult) await search(searchResult.keyword, 1, sort)
             }}
           />
           <Button size="small" onClick={() => search(searchInput.trim(), 1)}>
@@ -108,10 +211,52 @@ function SearchPane({ setPickedComic, setCurrentTabName }: Props) {
             直达
           </Button>
         </div>
+        <div className="flex items-center">
+          <Checkbox
+            checked={searchFilter.finishedOnly}
+            onChange={(e) => applySearchFilter({ ...searchFilter, finishedOnly: e.target.checked })}>
+            只看完结
+          </Checkbox>
+          <Checkbox
+            checked={searchFilter.titleContainsKeyword}
+            onChange={(e) => applySearchFilter({ ...searchFilter, titleContainsKeyword: e.target.checked })}>
+            标题含关键词
+          </Checkbox>
+          <Input
+            className="w-48"
+            prefix="作者:"
+            size="small"
+            allowClear
+            value={authorInput}
+            onChange={(e) => setAuthorInput(e.target.value)}
+            onPressEnter={() => applySearchFilter({ ...searchFilter, author: authorInput })}
+            onBlur={async () => {
+              if (authorInput !== searchFilter.author) await applySearchFilter({ ...searchFilter, author: authorInput })
+            }}
+          />
+          {searchResult && searchResult.filteredOut > 0 && (
+            <span className="ml-2 text-gray-500">本页已过滤掉{searchResult.filteredOut}本</span>
+          )}
+        </div>
       </div>
 
       {searchResult && (
         <div className="h-full flex flex-col gap-row-1 overflow-auto p-2">
+          {searchResult.comics.length === 0 && searchResult.suggestion !== null && (
+            <span>
+              没有找到结果，您是不是要找
+              <Button
+                type="link"
+                size="small"
+                onClick={async () => {
+                  const suggestion = searchResult.suggestion ?? ''
+                  setSearchInput(suggestion)
+                  await search(suggestion, 1, searchResult.sort)
+                }}>
+                {searchResult.suggestion}
+              </Button>
+            </span>
+          )}
           <div className="h-full flex flex-col gap-row-2 overflow-auto pr-2 pb-2">
             {searchResult.comics.map((comic) => (
               <ComicCard
@@ -123,6 +268,7 @@ function SearchPane({ setPickedComic, setCurrentTabName }: Props) {
                 comicAuthors={comic.authors}
                 comicGenres={comic.genres}
                 comicLastUpdateTime={comic.updateTime}
+                comicLocalChapterCount={comic.isLocalDownloaded ? comic.localChapterCount : undefined}
                 setPickedComic={setPickedComic}
                 setCurrentTabName={setCurrentTabName}
               />
@@ -130,11 +276,11 @@ function SearchPane({ setPickedComic, setCurrentTabName }: Props) {
           </div>
           <Pagination
             current={searchPageNum}
-            pageSize={10}
-            total={searchResult.total}
+            pageSize={1}
+            total={searchResult.totalPage}
             showSizeChanger={false}
             simple
-            onChange={(pageNum) => search(searchInput.trim(), pageNum)}
+            onChange={(pageNum) => search(searchResult.keyword, pageNum, searchResult.sort)}
           />
         </div>
       )}